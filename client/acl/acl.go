@@ -0,0 +1,128 @@
+// Package acl 在客户端执行服务器下发的访问策略，检查对端连进本机的连接
+package acl
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jinyunx/p2p/client/comm"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pollWait 是长轮询策略变化的等待时间
+const pollWait = 25 * time.Second
+
+// Fetcher 从服务器取本节点的策略，comm.Rendezvous 实现了这个接口
+type Fetcher interface {
+	GetPolicy(ctx context.Context, name, version string, wait time.Duration) (*pb.GetPolicyResp, error)
+}
+
+// Inbound 是本节点作为目的时的规则。拿到策略之前拒绝所有连接
+type Inbound struct {
+	mu      sync.RWMutex
+	loaded  bool
+	version string
+	policy  *pb.NodePolicy
+}
+
+func New() *Inbound {
+	return &Inbound{}
+}
+
+// Set 替换策略，policy 没有 Enforce 时允许所有连接
+func (a *Inbound) Set(version string, policy *pb.NodePolicy) {
+	a.mu.Lock()
+	a.loaded, a.version, a.policy = true, version, policy
+	a.mu.Unlock()
+}
+
+func (a *Inbound) current() (version string, loaded bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.version, a.loaded
+}
+
+// Allow 判断 src 能不能连本机的 port，port 为 0 表示没有端口的包，比如 ICMP，
+// 只有不限端口的规则允许
+func (a *Inbound) Allow(src string, port int) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !a.loaded {
+		return false
+	}
+	if !a.policy.GetEnforce() {
+		return true
+	}
+	for _, r := range a.policy.GetInbound() {
+		if matchSrc(r, src) && matchPort(r, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowPeer 检查没有端口的消息，比如聊天和 ping，同 Allow(src, 0)
+func (a *Inbound) AllowPeer(src string) bool {
+	return a.Allow(src, 0)
+}
+
+func matchSrc(r *pb.PolicyRule, src string) bool {
+	if r.GetAnySrc() {
+		return true
+	}
+	for _, name := range r.GetSrc() {
+		if name == src {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPort(r *pb.PolicyRule, port int) bool {
+	if len(r.GetPorts()) == 0 {
+		return true
+	}
+	for _, p := range r.GetPorts() {
+		if int32(port) >= p.GetFirst() && int32(port) <= p.GetLast() {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch 长轮询服务器上的策略，变化后马上生效，直到 ctx 结束。
+// 服务器不支持策略时当作没有策略
+func (a *Inbound) Watch(ctx context.Context, f Fetcher, name string, logger *slog.Logger) {
+	backoff := comm.Backoff{Min: time.Second, Max: time.Minute}
+	for {
+		version, loaded := a.current()
+		resp, err := f.GetPolicy(ctx, name, version, pollWait)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			logger.Warn("server does not support policies, allowing all peers")
+			a.Set("", &pb.NodePolicy{})
+			return
+		}
+		if err != nil {
+			wait := backoff.Next()
+			logger.Warn("GetPolicy failed", "err", err, "backoff", wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		backoff.Reset()
+		if !loaded || resp.GetVersion() != version {
+			a.Set(resp.GetVersion(), resp.GetPolicy())
+			logger.Info("policy updated", "version", resp.GetVersion(), "enforce", resp.GetPolicy().GetEnforce(), "rules", len(resp.GetPolicy().GetInbound()))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package acl
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+func TestAllow(t *testing.T) {
+	a := New()
+	if a.Allow("x", 22) {
+		t.Fatal("allowed before the policy is loaded")
+	}
+	a.Set("none", &pb.NodePolicy{})
+	if !a.Allow("x", 22) {
+		t.Fatal("denied without enforcement")
+	}
+	a.Set("v1", &pb.NodePolicy{Enforce: true, Inbound: []*pb.PolicyRule{
+		{Src: []string{"admin"}},
+		{AnySrc: true, Ports: []*pb.PortRange{{First: 80, Last: 80}, {First: 8000, Last: 8100}}},
+	}})
+	tests := []struct {
+		src  string
+		port int
+		want bool
+	}{
+		{"admin", 22, true},
+		{"admin", 0, true},
+		{"x", 80, true},
+		{"x", 8100, true},
+		{"x", 22, false},
+		{"x", 0, false},
+	}
+	for _, tt := range tests {
+		if got := a.Allow(tt.src, tt.port); got != tt.want {
+			t.Errorf("Allow(%s, %d) = %v", tt.src, tt.port, got)
+		}
+	}
+}
+
+// fakeFetcher 按顺序返回 resps，用完以后阻塞到 ctx 结束
+type fakeFetcher struct {
+	resps    chan *pb.GetPolicyResp
+	versions chan string
+}
+
+func (f *fakeFetcher) GetPolicy(ctx context.Context, name, version string, wait time.Duration) (*pb.GetPolicyResp, error) {
+	f.versions <- version
+	select {
+	case resp := <-f.resps:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestWatch(t *testing.T) {
+	f := &fakeFetcher{resps: make(chan *pb.GetPolicyResp), versions: make(chan string, 8)}
+	a := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Watch(ctx, f, "me", slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	f.resps <- &pb.GetPolicyResp{Version: "v1", Policy: &pb.NodePolicy{Enforce: true}}
+	f.resps <- &pb.GetPolicyResp{Version: "v2", Policy: &pb.NodePolicy{}}
+	if v := <-f.versions; v != "" {
+		t.Fatalf("first fetch with version %q", v)
+	}
+	if v := <-f.versions; v != "v1" {
+		t.Fatalf("second fetch with version %q", v)
+	}
+	if v := <-f.versions; v != "v2" {
+		t.Fatalf("third fetch with version %q", v)
+	}
+	if !a.Allow("x", 1) {
+		t.Fatal("policy v2 was not applied")
+	}
+}
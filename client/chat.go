@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jinyunx/p2p/client/acl"
+	"github.com/jinyunx/p2p/client/chat"
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// runChat 从标准输入读一行发一条，指定 peer 时单聊，不指定时和同一个网络里的所有节点群聊
+func runChat(args []string) {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	host, _ := os.Hostname()
+	name := fs.String("name", host, "node name shown to other peers")
+	port := fs.Int("port", 0, "local udp port, 0 picks a random one")
+	lan := fs.Bool("lan", true, "discover peers on the local network with multicast beacons")
+	network := fs.String("network", chat.DefaultNetwork, "virtual network to join, only its nodes are chatted with")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] chat [chat flags] servers [peer]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "  without peer, lines are sent to every node in the same network\n")
+		fmt.Fprintf(fs.Output(), "  messages to nodes that cannot be punched are relayed by the server if it runs with -relay\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	servers, err := comm.ResolveServers(fs.Arg(0))
+	if err != nil {
+		fatal("invalid server list", "err", err)
+	}
+	if fs.Arg(1) == *name {
+		fatal("cannot chat with yourself", "peer", *name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rdv, err := comm.NewRendezvous(servers, comm.RendezvousOptions{Logger: logger.With("component", "comm")})
+	if err != nil {
+		fatal("init rendezvous failed", "err", err)
+	}
+	defer rdv.Close()
+	var conf *pb.GetServerConfigResp
+	err = retry(ctx, "get server config", func() error {
+		var err error
+		conf, err = rdv.GetServerConfig(ctx)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	inbound := acl.New()
+	session := chat.New(chat.Options{Target: fs.Arg(1), Network: *network, Allow: inbound.AllowPeer, Logger: logger})
+	node, err := peer.Listen(rdv, peer.Options{
+		Name:       *name,
+		Network:    *network,
+		LocalPort:  *port,
+		Logger:     logger,
+		RelayAfter: relayAfter(conf, rdv.Primary()),
+		OnMessage:  session.OnMessage,
+	})
+	if err != nil {
+		fatal("open peer socket failed", "err", err)
+	}
+	defer node.Close()
+	session.Attach(node)
+	go rdv.Run(ctx)
+
+	err = retry(ctx, "get external address", func() error {
+		_, err := node.Discover(ctx)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if err := retry(ctx, "register", func() error { return node.Register(ctx) }); err != nil {
+		return
+	}
+	go inbound.Watch(ctx, rdv, node.Name(), logger)
+	go node.Keepalive(ctx)
+	go pollPunch(ctx, rdv, node, nil)
+	if *lan {
+		go func() {
+			if err := node.Beacon(ctx); err != nil {
+				logger.Warn("lan discovery disabled", "err", err)
+			}
+		}()
+	}
+	go refreshChat(ctx, rdv, node, session)
+
+	if target := fs.Arg(1); target != "" {
+		fmt.Printf("chatting with %s as %s, type a line and press enter\n", target, *name)
+	} else {
+		fmt.Printf("group chat in %s as %s, type a line and press enter\n", *network, *name)
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				go session.Send(ctx, line)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshChat 定期拉取注册表，对还没通信过的对端请服务器协调打洞
+func refreshChat(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, session *chat.Session) {
+	lastPunch := make(map[string]time.Time)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		nodes, err := rdv.GetNodeInfo(ctx)
+		if err != nil {
+			logger.Warn("GetNodeInfo failed", "err", err)
+		} else {
+			connectPeers(ctx, rdv, node, session.Update(nodes), lastPunch)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
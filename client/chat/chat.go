@@ -0,0 +1,303 @@
+// Package chat 是节点之间的文字聊天：每条消息单独确认，没收到确认就重传，
+// 接收方按消息 id 去重，并用注册表核对发送方的名字
+package chat
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinyunx/p2p/client/peer"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// DefaultNetwork 是没有指定网络的节点所在的网络，和服务器的默认值一致
+const DefaultNetwork = "default"
+
+// Sender 是聊天用到的节点方法，peer.Node 实现了这个接口
+type Sender interface {
+	Name() string
+	Send(name string, msg *pb.PeerMsg) error
+	SendTo(addr *net.UDPAddr, msg *pb.PeerMsg) error
+}
+
+type Options struct {
+	// Target 为空表示群聊，发给同一个网络里的所有其他节点
+	Target string
+	// Network 是本机所在的网络，为空时是 default
+	Network string
+	// Retries 是没收到 ack 时的重传次数，RetryInterval 是重传间隔
+	Retries       int
+	RetryInterval time.Duration
+	// Dedup 是每个对端记住的消息 id 个数，超过后清空重新记
+	Dedup int
+	// Allow 不为 nil 时丢掉不允许的对端发来的消息，不回 ack。
+	// 对端给本机消息的 ack 不检查，策略只允许本机连对端时也能聊天
+	Allow  func(src string) bool
+	Out    io.Writer // 显示消息的地方，默认标准输出
+	Logger *slog.Logger
+}
+
+func (o *Options) setDefaults() {
+	if o.Network == "" {
+		o.Network = DefaultNetwork
+	}
+	if o.Retries <= 0 {
+		o.Retries = 3
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+	if o.Dedup <= 0 {
+		o.Dedup = 1024
+	}
+	if o.Out == nil {
+		o.Out = os.Stdout
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+type Session struct {
+	opts Options
+
+	mu       sync.Mutex
+	node     Sender
+	nextID   uint64
+	pending  map[string]chan struct{} // 对端名字/消息 id -> 收到 ack 时关闭
+	received map[string]map[uint64]bool
+	registry map[string]*pb.NodeInfo // 同一个网络里的节点
+	out      sync.Mutex              // 保证每行输出完整
+}
+
+// New 创建会话，Attach 之前收到的消息都忽略，不回 ack
+func New(opts Options) *Session {
+	opts.setDefaults()
+	return &Session{
+		opts:     opts,
+		nextID:   uint64(time.Now().UnixNano()),
+		pending:  make(map[string]chan struct{}),
+		received: make(map[string]map[uint64]bool),
+		registry: make(map[string]*pb.NodeInfo),
+	}
+}
+
+// Attach 设置收发消息用的节点，节点的 OnMessage 要调用 s.OnMessage
+func (s *Session) Attach(node Sender) {
+	s.mu.Lock()
+	s.node = node
+	s.mu.Unlock()
+}
+
+func (s *Session) sender() Sender {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.node
+}
+
+func (s *Session) printf(format string, args ...any) {
+	s.out.Lock()
+	defer s.out.Unlock()
+	fmt.Fprintf(s.opts.Out, format, args...)
+}
+
+func network(info *pb.NodeInfo) string {
+	if n := info.GetNetwork(); n != "" {
+		return n
+	}
+	return DefaultNetwork
+}
+
+// Update 用注册表刷新对端，只保留本机网络里的节点，返回消息要发给的对端
+func (s *Session) Update(nodes []*pb.NodeInfo) []*pb.NodeInfo {
+	registry := make(map[string]*pb.NodeInfo)
+	for _, info := range nodes {
+		if network(info) == s.opts.Network {
+			registry[info.GetName()] = info
+		}
+	}
+	s.mu.Lock()
+	s.registry = registry
+	s.mu.Unlock()
+
+	var out []*pb.NodeInfo
+	for _, name := range s.Targets() {
+		out = append(out, registry[name])
+	}
+	return out
+}
+
+// Targets 返回消息要发给的对端，群聊时是同一个网络里除自己以外的所有节点
+func (s *Session) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.Target != "" {
+		if _, ok := s.registry[s.opts.Target]; !ok {
+			return nil
+		}
+		return []string{s.opts.Target}
+	}
+	var self string
+	if s.node != nil {
+		self = s.node.Name()
+	}
+	var out []string
+	for name := range s.registry {
+		if name != self {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Send 把一行发给所有目标，每个目标单独等 ack，没收到就重传，全部结束后返回
+func (s *Session) Send(ctx context.Context, text string) {
+	targets := s.Targets()
+	if len(targets) == 0 {
+		if s.opts.Target != "" {
+			s.printf("! %s is not registered\n", s.opts.Target)
+		} else {
+			s.printf("! nobody else is in network %s\n", s.opts.Network)
+		}
+		return
+	}
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+	msg := &pb.PeerChat{Id: id, Text: text, Group: s.opts.Target == ""}
+	var wg sync.WaitGroup
+	for _, name := range targets {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			s.deliver(ctx, name, msg)
+		}(name)
+	}
+	wg.Wait()
+}
+
+// deliver 发给一个对端并等 ack，返回是否送达
+func (s *Session) deliver(ctx context.Context, name string, chat *pb.PeerChat) bool {
+	key := fmt.Sprintf("%s/%d", name, chat.GetId())
+	acked := make(chan struct{})
+	s.mu.Lock()
+	s.pending[key] = acked
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		s.mu.Unlock()
+	}()
+
+	for try := 0; try <= s.opts.Retries; try++ {
+		if node := s.sender(); node != nil {
+			if err := node.Send(name, &pb.PeerMsg{Body: &pb.PeerMsg_Chat{Chat: chat}}); err != nil {
+				s.opts.Logger.Debug("send chat failed", "peer", name, "err", err)
+			}
+		}
+		select {
+		case <-acked:
+			if s.opts.Target == "" {
+				s.printf("  (delivered to %s)\n", name)
+			} else {
+				s.printf("  (delivered)\n")
+			}
+			return true
+		case <-time.After(s.opts.RetryInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	s.printf("! message not delivered to %s\n", name)
+	return false
+}
+
+// OnMessage 在节点的读协程里调用：回 ack、去重后显示收到的消息
+func (s *Session) OnMessage(from peer.Peer, src *net.UDPAddr, msg *pb.PeerMsg) {
+	chat := msg.GetChat()
+	node := s.sender()
+	if chat == nil || node == nil {
+		return
+	}
+	if chat.GetAck() {
+		key := fmt.Sprintf("%s/%d", from.Name, chat.GetId())
+		s.mu.Lock()
+		if acked, ok := s.pending[key]; ok {
+			close(acked)
+			delete(s.pending, key)
+		}
+		s.mu.Unlock()
+		return
+	}
+	if s.opts.Allow != nil && !s.opts.Allow(from.Name) {
+		s.opts.Logger.Debug("chat denied by policy", "peer", from.Name)
+		return
+	}
+	// 重传的消息也要回 ack，前一个 ack 可能丢了
+	ack := &pb.PeerMsg{Body: &pb.PeerMsg_Chat{Chat: &pb.PeerChat{Id: chat.GetId(), Ack: true}}}
+	// 中转来的消息没有源地址，ack 也经 Send 选路
+	var err error
+	if src == nil {
+		err = node.Send(from.Name, ack)
+	} else {
+		err = node.SendTo(src, ack)
+	}
+	if err != nil {
+		s.opts.Logger.Debug("chat ack failed", "peer", from.Name, "err", err)
+	}
+
+	s.mu.Lock()
+	ids, ok := s.received[from.Name]
+	if !ok || len(ids) >= s.opts.Dedup {
+		ids = make(map[uint64]bool)
+		s.received[from.Name] = ids
+	}
+	dup := ids[chat.GetId()]
+	ids[chat.GetId()] = true
+	sender := s.resolve(from.Name, src)
+	s.mu.Unlock()
+	if dup {
+		return
+	}
+	if chat.GetGroup() {
+		s.printf("[%s to all] %s\n", sender, chat.GetText())
+	} else {
+		s.printf("[%s] %s\n", sender, chat.GetText())
+	}
+}
+
+// resolve 用注册表核对发送方：源地址是它注册的候选地址时直接用这个名字，
+// 是别的节点的地址时标出注册的名字，都对不上时在名字后面加问号，
+// 对称型 NAT 的对端就是这种情况。调用时要持有 s.mu
+func (s *Session) resolve(claimed string, src *net.UDPAddr) string {
+	// 中转的消息由服务器核对过发送方
+	if src == nil {
+		return claimed + " (relayed)"
+	}
+	match := func(info *pb.NodeInfo) bool {
+		for _, addr := range peer.Candidates(info) {
+			if addr.String() == src.String() {
+				return true
+			}
+		}
+		return false
+	}
+	if info, ok := s.registry[claimed]; ok && match(info) {
+		return claimed
+	}
+	for name, info := range s.registry {
+		if match(info) {
+			return fmt.Sprintf("%s (claims %s)", name, claimed)
+		}
+	}
+	return claimed + "?"
+}
@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public/netsim"
+	"golang.org/x/net/context"
+)
+
+// output 是可以并发写的输出，测试里读它检查显示的内容
+type output struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *output) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// waitFor 等输出里出现 want
+func waitFor(t *testing.T, o *output, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(o.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output %q does not contain %q", o.String(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type side struct {
+	node    *peer.Node
+	session *Session
+	out     *output
+}
+
+func info(s *side) *pb.NodeInfo {
+	addr := s.node.LocalAddr()
+	return &pb.NodeInfo{Name: s.node.Name(), UdpAddr: &pb.UDPAddr{Ip: addr.IP.String(), Port: int32(addr.Port)}}
+}
+
+// newSide 在模拟网络的主机 h 上创建节点和会话，会话还没 Attach
+func newSide(t *testing.T, h *netsim.Host, name, target string) *side {
+	// 测试不连服务器，只用节点之间的 UDP
+	rdv, err := comm.NewRendezvous([]string{"127.0.0.1:1"}, comm.RendezvousOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdv.Close() })
+	s := &side{out: &output{}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s.session = New(Options{Target: target, RetryInterval: 50 * time.Millisecond, Out: s.out, Logger: logger})
+	s.node, err = peer.Listen(rdv, peer.Options{Name: name, Logger: logger, Net: h, OnMessage: s.session.OnMessage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.node.Close() })
+	return s
+}
+
+// pair 创建互相知道地址的 a 和 b，a 单聊 b
+func pair(t *testing.T) (a, b *side) {
+	n := netsim.New(1, netsim.LinkOptions{Latency: time.Millisecond})
+	a = newSide(t, n.Host("198.51.100.1"), "a", "b")
+	b = newSide(t, n.Host("198.51.100.2"), "b", "")
+	a.node.AddPeer("b", b.node.LocalAddr())
+	b.node.AddPeer("a", a.node.LocalAddr())
+	nodes := []*pb.NodeInfo{info(a), info(b)}
+	a.session.Update(nodes)
+	b.session.Update(nodes)
+	a.session.Attach(a.node)
+	return a, b
+}
+
+func TestSendAck(t *testing.T) {
+	a, b := pair(t)
+	b.session.Attach(b.node)
+	a.session.Send(context.Background(), "hello")
+	waitFor(t, a.out, "(delivered)")
+	waitFor(t, b.out, "[a] hello")
+}
+
+func TestSendRetry(t *testing.T) {
+	a, b := pair(t)
+	// b 晚一点才开始处理消息，前几次发送没有 ack，a 要重传
+	time.AfterFunc(120*time.Millisecond, func() { b.session.Attach(b.node) })
+	a.session.Send(context.Background(), "hello")
+	if got := a.out.String(); !strings.Contains(got, "(delivered)") {
+		t.Fatalf("sender output %q", got)
+	}
+	waitFor(t, b.out, "[a] hello")
+}
+
+func TestSendNotDelivered(t *testing.T) {
+	a, _ := pair(t)
+	a.session.Send(context.Background(), "hello")
+	if got := a.out.String(); !strings.Contains(got, "not delivered to b") {
+		t.Fatalf("sender output %q", got)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	a, b := pair(t)
+	b.session.Attach(b.node)
+	msg := &pb.PeerMsg{Body: &pb.PeerMsg_Chat{Chat: &pb.PeerChat{Id: 7, Text: "once"}}}
+	// 同一条消息收到两次，比如 ack 丢了对方重传，只显示一次
+	b.session.OnMessage(peer.Peer{Name: "a"}, a.node.LocalAddr(), msg)
+	b.session.OnMessage(peer.Peer{Name: "a"}, a.node.LocalAddr(), msg)
+	if got := strings.Count(b.out.String(), "[a] once"); got != 1 {
+		t.Fatalf("shown %d times: %q", got, b.out.String())
+	}
+	// 没注册的地址发来的消息在名字后面加问号
+	b.session.OnMessage(peer.Peer{Name: "a"}, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9}, &pb.PeerMsg{Body: &pb.PeerMsg_Chat{Chat: &pb.PeerChat{Id: 8, Text: "spoof"}}})
+	waitFor(t, b.out, "[a?] spoof")
+}
+
+func TestRelayed(t *testing.T) {
+	a, b := pair(t)
+	b.session.Attach(b.node)
+	acked := make(chan struct{})
+	a.session.mu.Lock()
+	a.session.pending["b/9"] = acked
+	a.session.mu.Unlock()
+	// 经服务器中转的消息没有源地址，ack 按名字发回去
+	b.session.OnMessage(peer.Peer{Name: "a"}, nil, &pb.PeerMsg{Body: &pb.PeerMsg_Chat{Chat: &pb.PeerChat{Id: 9, Text: "via server"}}})
+	waitFor(t, b.out, "[a (relayed)] via server")
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Fatal("relayed message not acked")
+	}
+}
+
+func TestAllow(t *testing.T) {
+	a, b := pair(t)
+	// 策略只允许 b 连 a：a 发给 b 的消息被丢掉，b 发给 a 的消息能收到 ack
+	a.session.opts.Allow = func(src string) bool { return src == "b" }
+	b.session.opts.Allow = func(src string) bool { return false }
+	b.session.opts.Target = "a"
+	b.session.Attach(b.node)
+	a.session.Send(context.Background(), "denied")
+	if got := a.out.String(); !strings.Contains(got, "not delivered to b") {
+		t.Fatalf("sender output %q", got)
+	}
+	b.session.Send(context.Background(), "allowed")
+	waitFor(t, b.out, "(delivered)")
+	waitFor(t, a.out, "[b] allowed")
+	if strings.Contains(b.out.String(), "denied") {
+		t.Fatalf("denied message shown: %q", b.out.String())
+	}
+}
+
+func TestUpdateNetwork(t *testing.T) {
+	s := New(Options{Network: "home", Out: io.Discard})
+	got := s.Update([]*pb.NodeInfo{
+		{Name: "a", Network: "home"},
+		{Name: "b", Network: "work"},
+		{Name: "c"},
+	})
+	if len(got) != 1 || got[0].GetName() != "a" {
+		t.Fatalf("targets %v", got)
+	}
+	// 没有指定网络的节点在 default 网络里
+	s = New(Options{Out: io.Discard})
+	if got := s.Update([]*pb.NodeInfo{{Name: "a", Network: "home"}, {Name: "c"}}); len(got) != 1 || got[0].GetName() != "c" {
+		t.Fatalf("targets %v", got)
+	}
+	// 单聊的对端不在本机网络里就不发
+	s = New(Options{Target: "b", Network: "home", Out: io.Discard})
+	if got := s.Update([]*pb.NodeInfo{{Name: "b", Network: "work"}}); len(got) != 0 {
+		t.Fatalf("targets %v", got)
+	}
+}
@@ -1,56 +1,51 @@
 package comm
 
 import (
-	"log"
 	"net"
-	"strconv"
 	"time"
+
+	"github.com/jinyunx/p2p/public"
 )
 
 func UdpWriteAndRead(address string, lport int, timeout time.Duration, message []byte, buf []byte) (int, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp4", address)
-	if err != nil {
-		log.Println("Invalid server address:", err)
-		return 0, err
-	}
+	return UdpExchange(nil, address, lport, timeout, message, buf)
+}
 
-	ludpAddr, err := net.ResolveUDPAddr("udp4", ":"+strconv.Itoa(lport))
+// UdpExchange 从本地端口 lport 向 address 发一个包并等它的回包，其他地址发来的包忽略。
+// network 为 nil 时用系统的套接字，出错时不打日志，由调用方处理返回的错误
+func UdpExchange(network public.Network, address string, lport int, timeout time.Duration, message []byte, buf []byte) (int, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", address)
 	if err != nil {
-		log.Println("Invalid server address:", err)
 		return 0, err
 	}
 
-	// 创建UDP连接
-	conn, err := net.DialUDP("udp", ludpAddr, udpAddr)
+	// 创建UDP套接字
+	conn, err := public.ListenUDP(network, &net.UDPAddr{Port: lport})
 	if err != nil {
-		log.Println("Error connecting to UDP server:", err)
 		return 0, err
 	}
 	defer conn.Close()
 
 	// 发送消息到服务器
-	_, err = conn.Write(message)
+	_, err = conn.WriteToUDP(message, udpAddr)
 	if err != nil {
-		log.Println("Error sending message:", err)
 		return 0, err
 	}
 
 	// 设置读取超时
 	err = conn.SetReadDeadline(time.Now().Add(timeout))
 	if err != nil {
-		log.Println("Error setting read deadline:", err)
 		return 0, err
 	}
 
 	// 读取服务器响应
-	n, err := conn.Read(buf[0:])
-	if err != nil {
-		if e, ok := err.(net.Error); ok && e.Timeout() {
-			log.Println("Read timeout:", err)
-		} else {
-			log.Println("Error reading response:", err)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return 0, err
+		}
+		if addr.Port == udpAddr.Port && (addr.IP.Equal(udpAddr.IP) || udpAddr.IP.IsUnspecified()) {
+			return n, nil
 		}
-		return 0, err
 	}
-	return n, nil
 }
@@ -0,0 +1,48 @@
+package comm
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jinyunx/p2p/public/netsim"
+)
+
+func TestUdpExchange(t *testing.T) {
+	n := netsim.New(1, netsim.LinkOptions{Latency: time.Millisecond})
+	server, err := n.Host("198.51.100.1").ListenUDP(&net.UDPAddr{Port: 3478})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	stranger, err := n.Host("198.51.100.9").ListenUDP(&net.UDPAddr{Port: 3478})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	// 回包之前先让别的地址发一个包，UdpExchange 应该跳过它
+	go func() {
+		buf := make([]byte, 64)
+		m, addr, err := server.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		stranger.WriteToUDP([]byte("noise"), addr)
+		time.Sleep(5 * time.Millisecond)
+		server.WriteToUDP(buf[:m], addr)
+	}()
+
+	client := n.NAT("203.0.113.1", netsim.NatOptions{Type: netsim.FullCone}).Host("10.0.0.2")
+	buf := make([]byte, 64)
+	m, err := UdpExchange(client, "198.51.100.1:3478", 5000, time.Second, []byte("ping"), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:m]) != "ping" {
+		t.Fatalf("got %q", buf[:m])
+	}
+
+	if _, err := UdpExchange(client, "198.51.100.1:3478", 5000, 50*time.Millisecond, []byte("ping"), buf); err == nil {
+		t.Fatal("expected timeout without a reply")
+	}
+}
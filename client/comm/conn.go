@@ -0,0 +1,79 @@
+package comm
+
+import (
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// serviceConfig 让 grpc 自己重试幂等请求，连接抖动时不用马上切换服务器
+const serviceConfig = `{
+	"methodConfig": [{
+		"name": [
+			{"service": "proto.P2P", "method": "GetExternalIpPort"},
+			{"service": "proto.P2P", "method": "UpdateNode"},
+			{"service": "proto.P2P", "method": "GetNodeInfo"},
+			{"service": "grpc.health.v1.Health", "method": "Check"}
+		],
+		"retryPolicy": {
+			"maxAttempts": 3,
+			"initialBackoff": "0.2s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+
+// 服务端的 keepalive.EnforcementPolicy.MinTime 要比 Time 小，否则会被断开
+const keepaliveTime = 30 * time.Second
+
+func dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		// 默认重连间隔最长两分钟，服务器恢复后等太久
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  time.Second,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   15 * time.Second,
+			},
+			MinConnectTimeout: 5 * time.Second,
+		}),
+	}
+}
+
+// Backoff 计算失败后的等待时间，每次翻倍直到 Max，带 20% 抖动
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+	cur time.Duration
+}
+
+func (b *Backoff) Next() time.Duration {
+	if b.cur == 0 {
+		b.cur = b.Min
+	} else if b.cur < b.Max {
+		b.cur *= 2
+		if b.cur > b.Max {
+			b.cur = b.Max
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(b.cur)/5 + 1))
+	return b.cur - b.cur/10 + jitter
+}
+
+func (b *Backoff) Reset() {
+	b.cur = 0
+}
@@ -0,0 +1,498 @@
+package comm
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrNoServer = errors.New("no rendezvous server available")
+	ErrClosed   = errors.New("rendezvous closed")
+)
+
+// ResolveServers 解析服务器列表，支持逗号分隔的 host[:port]，
+// 或者 srv:_p2p._tcp.example.com 形式的 DNS SRV 记录，端口缺省为 ServerInfo_Port
+func ResolveServers(spec string) ([]string, error) {
+	if name, ok := strings.CutPrefix(spec, "srv:"); ok {
+		_, srvs, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return nil, err
+		}
+		var out []string
+		for _, srv := range srvs {
+			out = append(out, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		if len(out) == 0 {
+			return nil, fmt.Errorf("no SRV records for %s", name)
+		}
+		return out, nil
+	}
+
+	var out []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(item); err != nil {
+			host := strings.TrimSuffix(strings.TrimPrefix(item, "["), "]")
+			item = net.JoinHostPort(host, strconv.Itoa(int(pb.ServerInfo_ServerInfo_Port)))
+		}
+		out = append(out, item)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("empty server list")
+	}
+	return out, nil
+}
+
+type RendezvousOptions struct {
+	Replicas      int           // 同时注册的服务器个数，服务器组成集群时 1 个就够
+	CheckInterval time.Duration // 健康检查间隔
+	CallTimeout   time.Duration // 单次请求超时
+	// OnFailover 在切换主服务器后调用，可以在这里重新探测外网地址
+	OnFailover func(server string)
+	Logger     *slog.Logger
+}
+
+func (o *RendezvousOptions) setDefaults() {
+	if o.Replicas <= 0 {
+		o.Replicas = 1
+	}
+	if o.CheckInterval <= 0 {
+		o.CheckInterval = 10 * time.Second
+	}
+	if o.CallTimeout <= 0 {
+		o.CallTimeout = 5 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+type endpoint struct {
+	addr      string
+	conn      *grpc.ClientConn // 长连接，第一次用到时建立
+	healthy   bool
+	lastErr   error
+	lastCheck time.Time
+}
+
+// Rendezvous 管理多个服务器，请求失败时按顺序切换到下一个健康的服务器，
+// 切换后自动用最近一次的节点信息重新注册
+type Rendezvous struct {
+	opts RendezvousOptions
+
+	mu         sync.Mutex
+	endpoints  []*endpoint
+	primary    int
+	registered *pb.NodeInfo
+	regServers map[string]bool
+	closed     bool
+}
+
+func NewRendezvous(servers []string, opts RendezvousOptions) (*Rendezvous, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoServer
+	}
+	opts.setDefaults()
+	r := &Rendezvous{opts: opts, regServers: make(map[string]bool)}
+	for _, s := range servers {
+		// 没检查过之前先当作可用
+		r.endpoints = append(r.endpoints, &endpoint{addr: s, healthy: true})
+	}
+	return r, nil
+}
+
+// Primary 返回当前使用的服务器地址，UDP 探测也发往这个地址
+func (r *Rendezvous) Primary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.endpoints[r.primary].addr
+}
+
+// Servers 返回所有服务器，主服务器排在最前
+func (r *Rendezvous) Servers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := []string{r.endpoints[r.primary].addr}
+	for i, e := range r.endpoints {
+		if i != r.primary {
+			out = append(out, e.addr)
+		}
+	}
+	return out
+}
+
+// Run 定期做健康检查，直到 ctx 结束
+func (r *Rendezvous) Run(ctx context.Context) {
+	r.CheckAll(ctx)
+	ticker := time.NewTicker(r.opts.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.CheckAll(ctx)
+		}
+	}
+}
+
+// CheckAll 检查所有服务器，主服务器不可用时切换
+func (r *Rendezvous) CheckAll(ctx context.Context) {
+	r.mu.Lock()
+	eps := append([]*endpoint(nil), r.endpoints...)
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(eps))
+	for i, e := range eps {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			errs[i] = r.check(ctx, addr)
+		}(i, e.addr)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	for i, e := range eps {
+		if e.healthy != (errs[i] == nil) {
+			r.opts.Logger.Info("server health changed", "server", e.addr, "healthy", errs[i] == nil, "err", errs[i])
+		}
+		e.healthy = errs[i] == nil
+		e.lastErr = errs[i]
+		e.lastCheck = time.Now()
+	}
+	primaryHealthy := r.endpoints[r.primary].healthy
+	r.mu.Unlock()
+
+	if !primaryHealthy {
+		r.failover(ctx)
+	}
+}
+
+func (r *Rendezvous) check(ctx context.Context, addr string) error {
+	return r.call(ctx, addr, func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("server status %s", resp.GetStatus())
+		}
+		return nil
+	})
+}
+
+// conn 返回到 addr 的长连接，grpc 在后台自动重连
+func (r *Rendezvous) conn(addr string) (*grpc.ClientConn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrClosed
+	}
+	for _, e := range r.endpoints {
+		if e.addr != addr {
+			continue
+		}
+		if e.conn == nil {
+			conn, err := grpc.Dial(addr, dialOptions()...)
+			if err != nil {
+				return nil, err
+			}
+			e.conn = conn
+		}
+		return e.conn, nil
+	}
+	return nil, fmt.Errorf("unknown server %s", addr)
+}
+
+// call 在 addr 上执行一次请求，每次请求都带超时
+func (r *Rendezvous) call(ctx context.Context, addr string, fn func(context.Context, *grpc.ClientConn) error) error {
+	return r.callTimeout(ctx, addr, r.opts.CallTimeout, fn)
+}
+
+func (r *Rendezvous) callTimeout(ctx context.Context, addr string, timeout time.Duration, fn func(context.Context, *grpc.ClientConn) error) error {
+	conn, err := r.conn(addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return fn(ctx, conn)
+}
+
+// Close 关闭所有连接
+func (r *Rendezvous) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, e := range r.endpoints {
+		if e.conn != nil {
+			e.conn.Close()
+			e.conn = nil
+		}
+	}
+	return nil
+}
+
+func (r *Rendezvous) markFailed(addr string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.endpoints {
+		if e.addr == addr {
+			e.healthy = false
+			e.lastErr = err
+		}
+	}
+}
+
+// failover 选下一个健康的服务器作为主服务器，都不健康时按顺序轮换
+func (r *Rendezvous) failover(ctx context.Context) {
+	r.mu.Lock()
+	old := r.endpoints[r.primary].addr
+	next := r.primary
+	for i := 1; i <= len(r.endpoints); i++ {
+		j := (r.primary + i) % len(r.endpoints)
+		if r.endpoints[j].healthy {
+			next = j
+			break
+		}
+	}
+	if next == r.primary && len(r.endpoints) > 1 {
+		next = (r.primary + 1) % len(r.endpoints)
+	}
+	r.primary = next
+	server := r.endpoints[next].addr
+	r.mu.Unlock()
+
+	if server == old {
+		return
+	}
+	r.opts.Logger.Warn("rendezvous failover", "from", old, "to", server)
+	if err := r.reregister(ctx); err != nil {
+		r.opts.Logger.Warn("re-register after failover failed", "server", server, "err", err)
+	}
+	if r.opts.OnFailover != nil {
+		r.opts.OnFailover(server)
+	}
+}
+
+// do 在主服务器上执行请求，失败时切换服务器重试，所有服务器都失败才返回错误
+func (r *Rendezvous) do(ctx context.Context, fn func(context.Context, *grpc.ClientConn) error) error {
+	var lastErr error
+	for i := 0; i < len(r.endpoints); i++ {
+		addr := r.Primary()
+		err := r.call(ctx, addr, fn)
+		if err == nil {
+			return nil
+		}
+		// 调用方自己取消或者超时不是服务器的问题，不换服务器
+		if !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+		r.opts.Logger.Warn("rendezvous request failed", "server", addr, "err", err)
+		r.markFailed(addr, err)
+		r.failover(ctx)
+	}
+	return fmt.Errorf("%w: %v", ErrNoServer, lastErr)
+}
+
+// UpdateNode 在主服务器和另外 Replicas-1 个服务器上注册，至少一个成功即可
+func (r *Rendezvous) UpdateNode(ctx context.Context, info *pb.NodeInfo) error {
+	r.mu.Lock()
+	r.registered = info
+	r.mu.Unlock()
+	return r.reregister(ctx)
+}
+
+func (r *Rendezvous) reregister(ctx context.Context) error {
+	r.mu.Lock()
+	info := r.registered
+	r.mu.Unlock()
+	if info == nil {
+		return nil
+	}
+
+	registered := make(map[string]bool)
+	var lastErr error
+	for _, addr := range r.Servers() {
+		if len(registered) >= r.opts.Replicas {
+			break
+		}
+		err := r.call(ctx, addr, func(ctx context.Context, conn *grpc.ClientConn) error {
+			_, err := pb.NewP2PClient(conn).UpdateNode(ctx, &pb.UpdateNodeReq{NodeInfo: info})
+			return err
+		})
+		if err != nil {
+			lastErr = err
+			r.opts.Logger.Warn("UpdateNode failed", "server", addr, "err", err)
+			if retryable(err) && ctx.Err() == nil {
+				r.markFailed(addr, err)
+			}
+			continue
+		}
+		registered[addr] = true
+	}
+
+	r.mu.Lock()
+	r.regServers = registered
+	r.mu.Unlock()
+	if len(registered) == 0 {
+		return fmt.Errorf("%w: %v", ErrNoServer, lastErr)
+	}
+	// 主服务器注册失败时换一个注册成功的当主服务器
+	if primary := r.Primary(); !registered[primary] {
+		r.setPrimary(registeredFirst(r.Servers(), registered))
+	}
+	return nil
+}
+
+func registeredFirst(servers []string, registered map[string]bool) string {
+	for _, s := range servers {
+		if registered[s] {
+			return s
+		}
+	}
+	return ""
+}
+
+func (r *Rendezvous) setPrimary(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, e := range r.endpoints {
+		if e.addr == addr {
+			r.primary = i
+		}
+	}
+}
+
+// GetNodeInfo 查询节点列表，注册了多个服务器时合并各服务器的结果
+func (r *Rendezvous) GetNodeInfo(ctx context.Context) ([]*pb.NodeInfo, error) {
+	// 服务器配置了策略时按注册的节点名过滤
+	r.mu.Lock()
+	req := &pb.GetNodeInfoReq{Name: r.registered.GetName()}
+	r.mu.Unlock()
+	var nodes []*pb.NodeInfo
+	err := r.do(ctx, func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := pb.NewP2PClient(conn).GetNodeInfo(ctx, req)
+		nodes = resp.GetNodeInfo()
+		return err
+	})
+	if err != nil || r.opts.Replicas <= 1 {
+		return nodes, err
+	}
+
+	seen := make(map[string]bool)
+	for _, n := range nodes {
+		seen[n.GetName()] = true
+	}
+	primary := r.Primary()
+	r.mu.Lock()
+	var others []string
+	for addr := range r.regServers {
+		if addr != primary {
+			others = append(others, addr)
+		}
+	}
+	r.mu.Unlock()
+	for _, addr := range others {
+		r.call(ctx, addr, func(ctx context.Context, conn *grpc.ClientConn) error {
+			resp, err := pb.NewP2PClient(conn).GetNodeInfo(ctx, req)
+			for _, n := range resp.GetNodeInfo() {
+				if !seen[n.GetName()] {
+					seen[n.GetName()] = true
+					nodes = append(nodes, n)
+				}
+			}
+			return err
+		})
+	}
+	return nodes, nil
+}
+
+func (r *Rendezvous) ReportPunch(ctx context.Context, in *pb.ReportPunchReq) error {
+	return r.do(ctx, func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := pb.NewP2PClient(conn).ReportPunch(ctx, in)
+		return err
+	})
+}
+
+// GetServerConfig 查询主服务器的端口配置
+func (r *Rendezvous) GetServerConfig(ctx context.Context) (*pb.GetServerConfigResp, error) {
+	var resp *pb.GetServerConfigResp
+	err := r.do(ctx, func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = pb.NewP2PClient(conn).GetServerConfig(ctx, &pb.GetServerConfigReq{})
+		return err
+	})
+	return resp, err
+}
+
+// RequestPunch 请求和 peer 同时打洞，返回 peer 的注册信息和开始打洞前要等的时间
+func (r *Rendezvous) RequestPunch(ctx context.Context, in *pb.RequestPunchReq) (*pb.RequestPunchResp, error) {
+	var resp *pb.RequestPunchResp
+	err := r.do(ctx, func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = pb.NewP2PClient(conn).RequestPunch(ctx, in)
+		return err
+	})
+	return resp, err
+}
+
+// PollPunch 在主服务器上长轮询发给自己的打洞请求，最多等 wait
+func (r *Rendezvous) PollPunch(ctx context.Context, name string, wait time.Duration) ([]*pb.PunchRequest, error) {
+	addr := r.Primary()
+	var reqs []*pb.PunchRequest
+	err := r.callTimeout(ctx, addr, wait+r.opts.CallTimeout, func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := pb.NewP2PClient(conn).PollPunch(ctx, &pb.PollPunchReq{Name: name, WaitMs: wait.Milliseconds()})
+		reqs = resp.GetRequests()
+		return err
+	})
+	if err != nil && retryable(err) && ctx.Err() == nil {
+		r.markFailed(addr, err)
+		r.failover(ctx)
+	}
+	return reqs, err
+}
+
+// GetPolicy 在主服务器上长轮询本节点的访问策略，version 和服务器一致时最多等 wait
+func (r *Rendezvous) GetPolicy(ctx context.Context, name, version string, wait time.Duration) (*pb.GetPolicyResp, error) {
+	addr := r.Primary()
+	var out *pb.GetPolicyResp
+	err := r.callTimeout(ctx, addr, wait+r.opts.CallTimeout, func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := pb.NewP2PClient(conn).GetPolicy(ctx, &pb.GetPolicyReq{Name: name, Version: version, WaitMs: wait.Milliseconds()})
+		out = resp
+		return err
+	})
+	if err != nil && retryable(err) && ctx.Err() == nil {
+		r.markFailed(addr, err)
+		r.failover(ctx)
+	}
+	return out, err
+}
+
+// retryable 判断是否是服务器不可用或者超时，这类错误才换服务器
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
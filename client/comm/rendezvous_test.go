@@ -0,0 +1,179 @@
+package comm
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type fakeServer struct {
+	pb.UnimplementedP2PServer
+	addr string
+	srv  *grpc.Server
+
+	mu    sync.Mutex
+	nodes map[string]*pb.NodeInfo
+}
+
+func startFake(t *testing.T) *fakeServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeServer{addr: lis.Addr().String(), srv: grpc.NewServer(), nodes: make(map[string]*pb.NodeInfo)}
+	pb.RegisterP2PServer(f.srv, f)
+	healthpb.RegisterHealthServer(f.srv, health.NewServer())
+	go f.srv.Serve(lis)
+	t.Cleanup(f.srv.Stop)
+	return f
+}
+
+func (f *fakeServer) UpdateNode(ctx context.Context, in *pb.UpdateNodeReq) (*pb.UpdateNodeResp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes[in.GetNodeInfo().GetName()] = in.GetNodeInfo()
+	return &pb.UpdateNodeResp{}, nil
+}
+
+func (f *fakeServer) GetNodeInfo(ctx context.Context, in *pb.GetNodeInfoReq) (*pb.GetNodeInfoResp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*pb.NodeInfo
+	for _, n := range f.nodes {
+		out = append(out, n)
+	}
+	return &pb.GetNodeInfoResp{NodeInfo: out}, nil
+}
+
+// GetServerConfig 总是出错，服务器返回的普通错误在客户端是 codes.Unknown
+func (f *fakeServer) GetServerConfig(ctx context.Context, in *pb.GetServerConfigReq) (*pb.GetServerConfigResp, error) {
+	return nil, errors.New("broken config")
+}
+
+func (f *fakeServer) has(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nodes[name] != nil
+}
+
+func TestResolveServers(t *testing.T) {
+	got, err := ResolveServers("a.example.com, 10.0.0.1:6000,,[::1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.example.com:50051", "10.0.0.1:6000", "[::1]:50051"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := ResolveServers(" , "); err == nil {
+		t.Fatal("empty list should fail")
+	}
+}
+
+func TestRendezvousFailover(t *testing.T) {
+	a, b := startFake(t), startFake(t)
+	var failedTo string
+	r, err := NewRendezvous([]string{a.addr, b.addr}, RendezvousOptions{
+		OnFailover: func(server string) { failedTo = server },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := r.UpdateNode(ctx, &pb.NodeInfo{Name: "n1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.has("n1") || b.has("n1") {
+		t.Fatal("n1 should be registered on the primary only")
+	}
+
+	a.srv.Stop()
+	nodes, err := r.GetNodeInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Primary() != b.addr || failedTo != b.addr {
+		t.Fatalf("primary = %s, failover to %q, want %s", r.Primary(), failedTo, b.addr)
+	}
+	// 切换后自动在新服务器上重新注册
+	if len(nodes) != 1 || nodes[0].GetName() != "n1" {
+		t.Fatalf("nodes = %v", nodes)
+	}
+}
+
+func TestRendezvousNoFailover(t *testing.T) {
+	a, b := startFake(t), startFake(t)
+	var failedTo string
+	r, err := NewRendezvous([]string{a.addr, b.addr}, RendezvousOptions{
+		OnFailover: func(server string) { failedTo = server },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 调用方取消的请求和服务器返回的业务错误都不换服务器
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.GetNodeInfo(ctx); err == nil {
+		t.Fatal("canceled request succeeded")
+	}
+	if _, err := r.GetServerConfig(context.Background()); status.Code(err) != codes.Unknown {
+		t.Fatalf("GetServerConfig = %v", err)
+	}
+	if r.Primary() != a.addr || failedTo != "" {
+		t.Fatalf("primary = %s, failover to %q", r.Primary(), failedTo)
+	}
+}
+
+func TestRendezvousReplicas(t *testing.T) {
+	a, b, c := startFake(t), startFake(t), startFake(t)
+	r, err := NewRendezvous([]string{a.addr, b.addr, c.addr}, RendezvousOptions{Replicas: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UpdateNode(context.Background(), &pb.NodeInfo{Name: "n1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.has("n1") || !b.has("n1") || c.has("n1") {
+		t.Fatal("n1 should be registered on exactly two servers")
+	}
+	// 另一个服务器上的节点也能查到
+	c.UpdateNode(context.Background(), &pb.UpdateNodeReq{NodeInfo: &pb.NodeInfo{Name: "n2"}})
+	b.UpdateNode(context.Background(), &pb.UpdateNodeReq{NodeInfo: &pb.NodeInfo{Name: "n3"}})
+	nodes, err := r.GetNodeInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("nodes = %v, want n1 and n3", nodes)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 4 * time.Second}
+	for i, base := range []time.Duration{1, 2, 4, 4} {
+		d := b.Next()
+		base *= time.Second
+		if d < base-base/10 || d > base+base/10 {
+			t.Fatalf("step %d: %v not around %v", i, d, base)
+		}
+	}
+	b.Reset()
+	if d := b.Next(); d > 1100*time.Millisecond {
+		t.Fatalf("after reset: %v", d)
+	}
+}
@@ -0,0 +1,45 @@
+package comm
+
+import (
+	"fmt"
+	"net"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// TCPMapper 从固定的本地端口和服务器保持一条 gRPC 连接。连接一直在，
+// NAT 上这个端口的 TCP 映射就不会回收，对端打洞时连的就是这个映射
+type TCPMapper struct {
+	conn *grpc.ClientConn
+}
+
+// DialTCPMapper 用 dialer 连接 addr，dialer 要绑定本地端口并允许端口复用，
+// 断线重连时也从同一个端口发起
+func DialTCPMapper(addr string, dialer *net.Dialer) (*TCPMapper, error) {
+	opts := append(dialOptions(), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp4", addr)
+	}))
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPMapper{conn: conn}, nil
+}
+
+// ExternalAddr 返回服务器看到的 TCP 源地址
+func (m *TCPMapper) ExternalAddr(ctx context.Context) (*net.TCPAddr, error) {
+	resp, err := pb.NewP2PClient(m.conn).GetExternalIpPort(ctx, &pb.GetExternalIpPortReq{})
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetNetwork() != "tcp" {
+		return nil, fmt.Errorf("unexpected network %q", resp.GetNetwork())
+	}
+	return net.ResolveTCPAddr("tcp", resp.GetAddr())
+}
+
+func (m *TCPMapper) Close() error {
+	return m.conn.Close()
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/stun"
+	"golang.org/x/net/context"
+)
+
+// 诊断的各个步骤，按顺序执行，前一步失败后面的不再做
+const (
+	stepRegistration = "registration"
+	stepSignaling    = "signaling"
+	stepPunching     = "punching"
+	stepHandshake    = "handshake"
+)
+
+type diagStep struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type diagInterface struct {
+	Name  string   `json:"name"`
+	Addrs []string `json:"addrs"`
+}
+
+type diagReflexive struct {
+	Server string  `json:"server"`
+	Addr   string  `json:"addr,omitempty"`
+	RttMs  float64 `json:"rtt_ms,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+type diagCandidate struct {
+	Addr      string  `json:"addr"`
+	Type      string  `json:"type"`
+	Priority  int32   `json:"priority"`
+	Reachable bool    `json:"reachable"`
+	RttMs     float64 `json:"rtt_ms,omitempty"`
+}
+
+type diagReport struct {
+	Time        string            `json:"time"`
+	Node        string            `json:"node"`
+	Peer        string            `json:"peer"`
+	Interfaces  []diagInterface   `json:"interfaces"`
+	NatType     string            `json:"nat_type"`
+	NatBehavior *stun.NatBehavior `json:"nat_behavior,omitempty"`
+	NatError    string            `json:"nat_error,omitempty"`
+	Reflexive   []diagReflexive   `json:"reflexive"`
+	PeerNatType string            `json:"peer_nat_type,omitempty"`
+	Candidates  []diagCandidate   `json:"candidates,omitempty"`
+	// PeerAddrs 是实际收到对端包的源地址，和候选地址不同说明对端是对称型 NAT
+	PeerAddrs  []string   `json:"peer_addrs,omitempty"`
+	Steps      []diagStep `json:"steps"`
+	FailedStep string     `json:"failed_step,omitempty"`
+}
+
+func (r *diagReport) step(name string, err error) bool {
+	s := diagStep{Name: name, OK: err == nil}
+	if err != nil {
+		s.Error = err.Error()
+		if r.FailedStep == "" {
+			r.FailedStep = name
+		}
+	}
+	r.Steps = append(r.Steps, s)
+	return err == nil
+}
+
+// diagProbe 记录发给每个候选地址的时间和收到的回应，算出每个地址的 RTT
+type diagProbe struct {
+	mu       sync.Mutex
+	sent     map[string]time.Time
+	rtt      map[string]time.Duration
+	from     map[string]bool
+	acked    bool
+	received bool
+}
+
+func newDiagProbe() *diagProbe {
+	return &diagProbe{sent: make(map[string]time.Time), rtt: make(map[string]time.Duration), from: make(map[string]bool)}
+}
+
+func (d *diagProbe) onMessage(from string, src *net.UDPAddr, msg *pb.PeerMsg, peerName string) {
+	if from != peerName || msg.GetHello() == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	addr := src.String()
+	d.from[addr] = true
+	d.received = true
+	if !msg.GetHello().GetAck() {
+		return
+	}
+	d.acked = true
+	if sent, ok := d.sent[addr]; ok {
+		if _, done := d.rtt[addr]; !done {
+			d.rtt[addr] = time.Since(sent)
+		}
+	}
+}
+
+// runDiagnose 按注册、信令、打洞、握手的顺序检查和 peer 的连通性，输出诊断报告
+func runDiagnose(args []string) {
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	host, _ := os.Hostname()
+	name := fs.String("name", "diagnose-"+host, "node name used for the test, must differ from the peer")
+	timeout := fs.Duration("timeout", 10*time.Second, "time to wait for the punch and handshake")
+	asJson := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] diagnose [diagnose flags] servers peer\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	servers, err := comm.ResolveServers(fs.Arg(0))
+	if err != nil {
+		fatal("invalid server list", "err", err)
+	}
+	report := diagnose(servers, *name, fs.Arg(1), *timeout)
+	if *asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printDiagnose(report)
+	}
+	if report.FailedStep != "" {
+		os.Exit(1)
+	}
+}
+
+func diagnose(servers []string, name, peerName string, timeout time.Duration) *diagReport {
+	ctx := context.Background()
+	report := &diagReport{Time: time.Now().Format(time.RFC3339), Node: name, Peer: peerName}
+	report.Interfaces = diagInterfaces()
+
+	rdv, err := comm.NewRendezvous(servers, comm.RendezvousOptions{Logger: logger.With("component", "comm")})
+	if err != nil {
+		report.step(stepRegistration, err)
+		return report
+	}
+	defer rdv.Close()
+	probe := newDiagProbe()
+	node, err := peer.Listen(rdv, peer.Options{
+		Name:   name,
+		Logger: logger,
+		OnMessage: func(p peer.Peer, src *net.UDPAddr, msg *pb.PeerMsg) {
+			probe.onMessage(p.Name, src, msg, peerName)
+		},
+	})
+	if err != nil {
+		report.step(stepRegistration, err)
+		return report
+	}
+	defer node.Close()
+
+	// 向每个服务器探测外网地址，不同服务器看到的端口不同说明是对称型 NAT
+	for _, server := range rdv.Servers() {
+		start := time.Now()
+		addr, err := node.Probe(ctx, server)
+		r := diagReflexive{Server: server}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Addr = net.JoinHostPort(addr.GetIp(), strconv.Itoa(int(addr.GetPort())))
+			r.RttMs = ms(time.Since(start))
+		}
+		report.Reflexive = append(report.Reflexive, r)
+	}
+	report.NatType = pb.NatType_NatType_Unknown.String()
+	if b, err := discoverNat(ctx, rdv); err != nil {
+		report.NatError = err.Error()
+	} else {
+		report.NatBehavior = b
+		report.NatType = b.NatType().String()
+		node.SetNatType(b.NatType())
+	}
+
+	info, err := diagRegister(ctx, rdv, node, peerName)
+	if !report.step(stepRegistration, err) {
+		return report
+	}
+	report.PeerNatType = info.GetNatType().String()
+	cands := peer.SortedCandidates(info)
+	for _, c := range cands {
+		report.Candidates = append(report.Candidates, diagCandidate{
+			Addr:     net.JoinHostPort(c.GetUdpAddr().GetIp(), strconv.Itoa(int(c.GetUdpAddr().GetPort()))),
+			Type:     strings.TrimPrefix(c.GetType().String(), "CandidateType_"),
+			Priority: c.GetPriority(),
+		})
+	}
+
+	resp, err := rdv.RequestPunch(ctx, &pb.RequestPunchReq{Name: name, Peer: peerName})
+	if !report.step(stepSignaling, err) {
+		return report
+	}
+	delay := time.Duration(resp.GetDelayMs()) * time.Millisecond
+
+	// 打洞走正常的流程，同时单独给每个候选地址发 hello 测 RTT
+	ctx, cancel := context.WithTimeout(ctx, delay+timeout)
+	defer cancel()
+	punched := make(chan bool, 1)
+	go func() { punched <- node.Punch(ctx, resp.GetPeer(), delay) }()
+	time.Sleep(delay)
+	hello := &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{}}}
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	// 第一个回应之后再发两轮，让其他候选地址也有机会测到
+	extra := 0
+	for done := false; !done; {
+		for _, c := range report.Candidates {
+			addr, err := net.ResolveUDPAddr("udp4", c.Addr)
+			if err != nil {
+				continue
+			}
+			probe.mu.Lock()
+			probe.sent[c.Addr] = time.Now()
+			probe.mu.Unlock()
+			node.SendTo(addr, hello)
+		}
+		select {
+		case <-ticker.C:
+			probe.mu.Lock()
+			if len(probe.rtt) > 0 {
+				extra++
+			}
+			done = extra > 2 || len(probe.rtt) == len(report.Candidates)
+			probe.mu.Unlock()
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	ok := <-punched
+
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	for i, c := range report.Candidates {
+		if rtt, ok := probe.rtt[c.Addr]; ok {
+			report.Candidates[i].Reachable = true
+			report.Candidates[i].RttMs = ms(rtt)
+		}
+	}
+	for addr := range probe.from {
+		report.PeerAddrs = append(report.PeerAddrs, addr)
+	}
+	sort.Strings(report.PeerAddrs)
+	var punchErr error
+	if !ok && !probe.received {
+		punchErr = errors.New("no packet received from peer")
+	}
+	if !report.step(stepPunching, punchErr) {
+		return report
+	}
+	var handshakeErr error
+	if !probe.acked {
+		handshakeErr = errors.New("peer did not acknowledge hello")
+	}
+	report.step(stepHandshake, handshakeErr)
+	return report
+}
+
+// diagRegister 注册本节点并查询对端的注册信息
+func diagRegister(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, peerName string) (*pb.NodeInfo, error) {
+	if _, err := node.Discover(ctx); err != nil {
+		return nil, fmt.Errorf("udp probe failed: %w", err)
+	}
+	if err := node.Register(ctx); err != nil {
+		return nil, fmt.Errorf("register failed: %w", err)
+	}
+	nodes, err := rdv.GetNodeInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("node lookup failed: %w", err)
+	}
+	for _, info := range nodes {
+		if info.GetName() == peerName {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("peer %s is not registered", peerName)
+}
+
+// discoverNat 用主服务器上的 STUN 服务探测 NAT 行为
+func discoverNat(ctx context.Context, rdv *comm.Rendezvous) (*stun.NatBehavior, error) {
+	conf, err := rdv.GetServerConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if conf.GetStunPort() == 0 {
+		return nil, errors.New("stun is disabled on the server")
+	}
+	host, _, err := net.SplitHostPort(rdv.Primary())
+	if err != nil {
+		return nil, err
+	}
+	return stun.DiscoverBehavior(net.JoinHostPort(host, strconv.Itoa(int(conf.GetStunPort()))), stun.BehaviorOptions{Logger: logger.With("component", "stun")})
+}
+
+func diagInterfaces() []diagInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []diagInterface
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		d := diagInterface{Name: ifi.Name}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			d.Addrs = append(d.Addrs, a.String())
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func printDiagnose(r *diagReport) {
+	fmt.Printf("node %s -> peer %s\n\n", r.Node, r.Peer)
+	fmt.Println("interfaces:")
+	for _, ifi := range r.Interfaces {
+		fmt.Printf("  %-10s %s\n", ifi.Name, strings.Join(ifi.Addrs, " "))
+	}
+	fmt.Printf("nat type:   %s\n", r.NatType)
+	if r.NatError != "" {
+		fmt.Printf("            (%s)\n", r.NatError)
+	}
+	fmt.Println("reflexive addresses:")
+	for _, a := range r.Reflexive {
+		if a.Error != "" {
+			fmt.Printf("  %-22s error: %s\n", a.Server, a.Error)
+		} else {
+			fmt.Printf("  %-22s %s (%.1f ms)\n", a.Server, a.Addr, a.RttMs)
+		}
+	}
+	if r.PeerNatType != "" {
+		fmt.Printf("peer nat type: %s\n", r.PeerNatType)
+	}
+	if len(r.Candidates) > 0 {
+		fmt.Println("peer candidates:")
+		for _, c := range r.Candidates {
+			result := "unreachable"
+			if c.Reachable {
+				result = fmt.Sprintf("%.1f ms", c.RttMs)
+			}
+			fmt.Printf("  %-22s %-10s %s\n", c.Addr, c.Type, result)
+		}
+	}
+	if len(r.PeerAddrs) > 0 {
+		fmt.Printf("packets from peer: %s\n", strings.Join(r.PeerAddrs, " "))
+	}
+	fmt.Println("steps:")
+	for _, s := range r.Steps {
+		if s.OK {
+			fmt.Printf("  %-13s ok\n", s.Name)
+		} else {
+			fmt.Printf("  %-13s FAILED: %s\n", s.Name, s.Error)
+		}
+	}
+}
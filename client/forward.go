@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jinyunx/p2p/client/acl"
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
+	"github.com/jinyunx/p2p/client/tunnel"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// forwardSpec 是一条 -L 或 -R 转发，-L 时 listen 是本机端口，-R 时是对端的端口
+type forwardSpec struct {
+	listen int
+	peer   string
+	target int
+}
+
+// parseForward 解析 port:peer:port
+func parseForward(s string) (forwardSpec, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[1] == "" {
+		return forwardSpec{}, fmt.Errorf("invalid forward %q, want port:peer:port", s)
+	}
+	listen, err1 := parsePort(parts[0])
+	target, err2 := parsePort(parts[2])
+	if err1 != nil || err2 != nil {
+		return forwardSpec{}, fmt.Errorf("invalid port in forward %q", s)
+	}
+	return forwardSpec{listen: listen, peer: parts[1], target: target}, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// portList 解析逗号分隔的端口列表
+type portList []int
+
+func (l *portList) String() string {
+	var out []string
+	for _, p := range *l {
+		out = append(out, strconv.Itoa(p))
+	}
+	return strings.Join(out, ",")
+}
+
+func (l *portList) Set(s string) error {
+	for _, f := range strings.Split(s, ",") {
+		port, err := parsePort(strings.TrimSpace(f))
+		if err != nil {
+			return err
+		}
+		*l = append(*l, port)
+	}
+	return nil
+}
+
+type forwardList []forwardSpec
+
+func (l *forwardList) String() string {
+	var out []string
+	for _, f := range *l {
+		out = append(out, fmt.Sprintf("%d:%s:%d", f.listen, f.peer, f.target))
+	}
+	return strings.Join(out, " ")
+}
+
+func (l *forwardList) Set(s string) error {
+	f, err := parseForward(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, f)
+	return nil
+}
+
+// tunnelFlags 是 forward 和 socks5 共用的节点参数
+type tunnelFlags struct {
+	name *string
+	port *int
+	lan  *bool
+}
+
+func addTunnelFlags(fs *flag.FlagSet) *tunnelFlags {
+	host, _ := os.Hostname()
+	return &tunnelFlags{
+		name: fs.String("name", host, "node name"),
+		port: fs.Int("port", 0, "local udp port, 0 picks a random one"),
+		lan:  fs.Bool("lan", true, "discover peers on the local network with multicast beacons"),
+	}
+}
+
+// startTunnel 注册节点并在节点间的通道上建立隧道，和 peers 里的对端保持打通，
+// ctx 结束后隧道和节点都会关闭，注册完成前 ctx 就结束时返回 nil
+func startTunnel(ctx context.Context, servers []string, f *tunnelFlags, opts tunnel.Options, peers []string) *tunnel.Tunnel {
+	rdv, err := comm.NewRendezvous(servers, comm.RendezvousOptions{Logger: logger.With("component", "comm")})
+	if err != nil {
+		fatal("init rendezvous failed", "err", err)
+	}
+	// 隧道要用 node 发消息，只能在 Listen 之后创建，这时读包的协程已经在跑了。
+	// 注册之前对端不知道本机地址，这之前收不到隧道消息
+	var tunPtr atomic.Pointer[tunnel.Tunnel]
+	node, err := peer.Listen(rdv, peer.Options{
+		Name:      *f.name,
+		LocalPort: *f.port,
+		Logger:    logger,
+		OnMessage: func(from peer.Peer, src *net.UDPAddr, msg *pb.PeerMsg) {
+			if s := msg.GetStream(); s != nil {
+				if tun := tunPtr.Load(); tun != nil {
+					tun.Handle(from.Name, s)
+				}
+			}
+		},
+	})
+	if err != nil {
+		fatal("open peer socket failed", "err", err)
+	}
+	inbound := acl.New()
+	opts.Policy = inbound.Allow
+	opts.Logger = logger
+	tun := tunnel.New(node, opts)
+	tunPtr.Store(tun)
+	go func() {
+		<-ctx.Done()
+		tun.Close()
+		node.Close()
+		rdv.Close()
+	}()
+	go rdv.Run(ctx)
+
+	err = retry(ctx, "get external address", func() error {
+		_, err := node.Discover(ctx)
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+	if err := retry(ctx, "register", func() error { return node.Register(ctx) }); err != nil {
+		return nil
+	}
+	logger.Info("registered", "server", rdv.Primary())
+	go inbound.Watch(ctx, rdv, node.Name(), logger)
+	go node.Keepalive(ctx)
+	go pollPunch(ctx, rdv, node, nil)
+	if *f.lan {
+		go func() {
+			if err := node.Beacon(ctx); err != nil {
+				logger.Warn("lan discovery disabled", "err", err)
+			}
+		}()
+	}
+	go keepForwardPeers(ctx, rdv, node, peers)
+	return tun
+}
+
+// ruleList 解析出口规则，可以重复指定
+type ruleList []tunnel.ExitRule
+
+func (l *ruleList) String() string {
+	var out []string
+	for _, r := range *l {
+		out = append(out, r.String())
+	}
+	return strings.Join(out, " ")
+}
+
+func (l *ruleList) Set(s string) error {
+	r, err := tunnel.ParseExitRule(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// runForward 经节点间的通道转发 TCP 连接，-L 把本机端口转到对端，-R 请对端把它的端口转回本机，
+// 指定 -exit-allow 时本机还作为其他节点 socks5 代理的出口
+func runForward(args []string) {
+	fs := flag.NewFlagSet("forward", flag.ExitOnError)
+	tf := addTunnelFlags(fs)
+	var locals, remotes forwardList
+	var allow, allowListen portList
+	var exitAllow, exitDeny ruleList
+	fs.Var(&locals, "L", "localport:peer:remoteport, forward a local port to a port on the peer, can be repeated")
+	fs.Var(&remotes, "R", "remoteport:peer:localport, ask the peer to forward its port to a local port, can be repeated")
+	fs.Var(&allow, "allow", "comma separated local ports that peers may connect to")
+	fs.Var(&allowListen, "allow-listen", "comma separated local ports that peers may ask to listen on with -R")
+	fs.Var(&exitAllow, "exit-allow", "act as socks5 exit for destinations matching cidr[:port,...] or *[:port,...], can be repeated")
+	fs.Var(&exitDeny, "exit-deny", "never connect to destinations matching cidr[:port,...] as exit, checked before -exit-allow")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] forward [forward flags] servers\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "  forwarded ports listen on and connect to 127.0.0.1 only\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	servers, err := comm.ResolveServers(fs.Arg(0))
+	if err != nil {
+		fatal("invalid server list", "err", err)
+	}
+	opts := tunnel.Options{Allow: allow, AllowListen: allowListen}
+	if len(exitAllow) > 0 {
+		opts.Exit = &tunnel.ExitPolicy{Allow: exitAllow, Deny: exitDeny}
+		logger.Info("acting as exit", "allow", exitAllow.String(), "deny", exitDeny.String())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// 先打开本地端口，端口被占用时尽早报错
+	var listeners []net.Listener
+	for _, f := range locals {
+		ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(f.listen)))
+		if err != nil {
+			fatal("listen failed", "port", f.listen, "err", err)
+		}
+		defer ln.Close()
+		listeners = append(listeners, ln)
+	}
+	var peers []string
+	for _, f := range append(locals, remotes...) {
+		peers = append(peers, f.peer)
+	}
+	tun := startTunnel(ctx, servers, tf, opts, peers)
+	if tun == nil {
+		return
+	}
+	if len(allow) > 0 || len(allowListen) > 0 {
+		logger.Info("serving peers", "allow", allow.String(), "allow_listen", allowListen.String())
+	}
+
+	for i, f := range locals {
+		go acceptForward(ctx, tun, listeners[i], f)
+	}
+	for _, f := range remotes {
+		go listenForward(ctx, tun, f)
+	}
+	<-ctx.Done()
+}
+
+// keepForwardPeers 定期查注册表，和转发用到的对端保持打通
+func keepForwardPeers(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	peers := make(map[string]bool)
+	for _, name := range names {
+		peers[name] = true
+	}
+	lastPunch := make(map[string]time.Time)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		nodes, err := rdv.GetNodeInfo(ctx)
+		if err != nil {
+			logger.Warn("GetNodeInfo failed", "err", err)
+		}
+		var targets []*pb.NodeInfo
+		for _, info := range nodes {
+			if peers[info.GetName()] {
+				targets = append(targets, info)
+			}
+		}
+		connectPeers(ctx, rdv, node, targets, lastPunch)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func acceptForward(ctx context.Context, tun *tunnel.Tunnel, ln net.Listener, f forwardSpec) {
+	logger.Info("forwarding", "port", f.listen, "peer", f.peer, "peer_port", f.target)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := tun.Forward(ctx, conn, f.peer, f.target); err != nil {
+				logger.Warn("forward failed", "peer", f.peer, "peer_port", f.target, "err", err)
+			}
+		}()
+	}
+}
+
+// listenForward 请对端监听，对端还没打通时逐步拉长间隔重试
+func listenForward(ctx context.Context, tun *tunnel.Tunnel, f forwardSpec) {
+	backoff := comm.Backoff{Min: time.Second, Max: time.Minute}
+	for {
+		err := tun.Listen(ctx, f.peer, f.listen, f.target)
+		if err == nil {
+			logger.Info("reverse forwarding", "peer", f.peer, "peer_port", f.listen, "port", f.target)
+			return
+		}
+		wait := backoff.Next()
+		logger.Warn("reverse forward failed, retrying", "peer", f.peer, "peer_port", f.listen, "err", err, "backoff", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
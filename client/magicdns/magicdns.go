@@ -0,0 +1,291 @@
+// Package magicdns 是客户端内嵌的 DNS 服务，用节点名回答 <name>.<network>.p2p 的查询，
+// 其他域名转给上游 DNS
+package magicdns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// LookupFunc 返回 network 里名为 name 的节点的地址，节点不存在时 ok 为 false
+type LookupFunc func(name, network string) (ips []net.IP, ok bool)
+
+type Options struct {
+	// Domain 是节点名的后缀，默认 p2p
+	Domain string
+	// Upstream 是转发其他查询的 DNS 服务器 host:port，为空时读 /etc/resolv.conf
+	Upstream []string
+	// TTL 是回答节点地址的 TTL，地址会随注册表变化，不能太长
+	TTL     time.Duration
+	Timeout time.Duration
+	Logger  *slog.Logger
+}
+
+func (o *Options) setDefaults() {
+	if o.Domain == "" {
+		o.Domain = "p2p"
+	}
+	o.Domain = strings.ToLower(strings.Trim(o.Domain, "."))
+	if o.Upstream == nil {
+		o.Upstream = SystemResolvers("/etc/resolv.conf")
+	}
+	if o.TTL <= 0 {
+		o.TTL = 10 * time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+type Server struct {
+	lookup LookupFunc
+	opts   Options
+}
+
+func NewServer(lookup LookupFunc, opts Options) *Server {
+	opts.setDefaults()
+	return &Server{lookup: lookup, opts: opts}
+}
+
+// SystemResolvers 从 resolv.conf 读 nameserver，读不到时返回空
+func SystemResolvers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var out []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			out = append(out, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	return out
+}
+
+// ServeUDP 在 conn 上回答查询，直到 ctx 结束或者 conn 关闭
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.Handle(ctx, req, "udp"); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// ServeTCP 接受 TCP 查询，消息前带两字节长度，客户端收到截断的 UDP 回答后会改用 TCP
+func (s *Server) ServeTCP(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		req, err := readTCP(conn)
+		if err != nil {
+			return
+		}
+		resp := s.Handle(ctx, req, "tcp")
+		if resp == nil || writeTCP(conn, resp) != nil {
+			return
+		}
+	}
+}
+
+func readTCP(r io.Reader) ([]byte, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCP(w io.Writer, msg []byte) error {
+	out := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(out, uint16(len(msg)))
+	copy(out[2:], msg)
+	_, err := w.Write(out)
+	return err
+}
+
+// Handle 回答一个查询，network 是收到查询的传输方式，转发时用同样的方式。
+// 查询解析不了时返回 nil，不回答
+func (s *Server) Handle(ctx context.Context, req []byte, network string) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil || h.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return s.reply(h, nil, dnsmessage.RCodeFormatError, nil)
+	}
+	name, netName, ok := s.split(q.Name.String())
+	if !ok {
+		resp, err := s.forward(ctx, req, network)
+		if err != nil {
+			s.opts.Logger.Debug("dns forward failed", "name", q.Name.String(), "err", err)
+			return s.reply(h, &q, dnsmessage.RCodeServerFailure, nil)
+		}
+		return resp
+	}
+	ips, found := s.lookup(name, netName)
+	if !found {
+		return s.reply(h, &q, dnsmessage.RCodeNameError, nil)
+	}
+	var answers []net.IP
+	for _, ip := range ips {
+		if (q.Type == dnsmessage.TypeA && ip.To4() != nil) || (q.Type == dnsmessage.TypeAAAA && ip.To4() == nil) {
+			answers = append(answers, ip)
+		}
+	}
+	return s.reply(h, &q, dnsmessage.RCodeSuccess, answers)
+}
+
+// split 把 <name>.<network>.<domain>. 拆成节点名和网络名，节点名里可以有点
+func (s *Server) split(fqdn string) (name, network string, ok bool) {
+	rest, found := strings.CutSuffix(strings.ToLower(fqdn), "."+s.opts.Domain+".")
+	if !found {
+		return "", "", false
+	}
+	i := strings.LastIndex(rest, ".")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", true
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// reply 构造本地回答，节点域名下的回答都是权威的
+func (s *Server) reply(h dnsmessage.Header, q *dnsmessage.Question, rcode dnsmessage.RCode, ips []net.IP) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		OpCode:             h.OpCode,
+		Authoritative:      q != nil && rcode != dnsmessage.RCodeServerFailure,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+	if q == nil {
+		out, _ := b.Finish()
+		return out
+	}
+	b.StartQuestions()
+	b.Question(*q)
+	b.StartAnswers()
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: uint32(s.opts.TTL / time.Second)}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			var r dnsmessage.AResource
+			copy(r.A[:], ip4)
+			b.AResource(rh, r)
+		} else {
+			var r dnsmessage.AAAAResource
+			copy(r.AAAA[:], ip.To16())
+			b.AAAAResource(rh, r)
+		}
+	}
+	out, err := b.Finish()
+	if err != nil {
+		s.opts.Logger.Warn("build dns reply failed", "err", err)
+		return nil
+	}
+	return out
+}
+
+// forward 依次问上游服务器，返回第一个回答
+func (s *Server) forward(ctx context.Context, req []byte, network string) ([]byte, error) {
+	if len(s.opts.Upstream) == 0 {
+		return nil, errors.New("no upstream dns server")
+	}
+	var lastErr error
+	for _, addr := range s.opts.Upstream {
+		resp, err := s.exchange(ctx, addr, req, network)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (s *Server) exchange(ctx context.Context, addr string, req []byte, network string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if network == "tcp" {
+		if err := writeTCP(conn, req); err != nil {
+			return nil, err
+		}
+		return readTCP(conn)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 只收 ID 和查询一致的回答
+		if n >= 2 && buf[0] == req[0] && buf[1] == req[1] {
+			return buf[:n], nil
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package magicdns
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testLookup(name, network string) ([]net.IP, bool) {
+	nodes := map[string][]net.IP{
+		"a/default":   {net.ParseIP("100.64.0.1"), net.ParseIP("fd7a::1")},
+		"web.1/lab":   {net.ParseIP("10.0.0.9")},
+		"noaddr/lab":  nil,
+		"mixed/lab":   {net.ParseIP("198.51.100.7")},
+		"upper/other": {net.ParseIP("100.64.0.2")},
+	}
+	ips, ok := nodes[name+"/"+network]
+	return ips, ok
+}
+
+func query(t *testing.T, s *Server, name string, qtype dnsmessage.Type, network string) (dnsmessage.Header, []string) {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET})
+	req, _ := b.Finish()
+	resp := s.Handle(context.Background(), req, network)
+	if resp == nil {
+		t.Fatalf("%s: no reply", name)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.ID != 42 || !msg.Header.Response {
+		t.Fatalf("%s: bad header %+v", name, msg.Header)
+	}
+	var out []string
+	for _, a := range msg.Answers {
+		switch r := a.Body.(type) {
+		case *dnsmessage.AResource:
+			out = append(out, net.IP(r.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			out = append(out, net.IP(r.AAAA[:]).String())
+		}
+	}
+	sort.Strings(out)
+	return msg.Header, out
+}
+
+// fakeUpstream 对所有 A 查询回答 192.0.2.53
+func fakeUpstream(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if msg.Unpack(buf[:n]) != nil {
+				continue
+			}
+			msg.Header.Response = true
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 53}},
+			}}
+			out, _ := msg.Pack()
+			conn.WriteTo(out, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestHandle(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := NewServer(testLookup, Options{Upstream: []string{fakeUpstream(t)}, Logger: logger})
+	tests := []struct {
+		name  string
+		qtype dnsmessage.Type
+		rcode dnsmessage.RCode
+		want  []string
+	}{
+		{"a.default.p2p.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"100.64.0.1"}},
+		{"A.Default.P2P.", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, []string{"fd7a::1"}},
+		{"web.1.lab.p2p.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"10.0.0.9"}},
+		{"noaddr.lab.p2p.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, nil},
+		{"mixed.lab.p2p.", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, nil},
+		{"a.lab.p2p.", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},
+		{"default.p2p.", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},
+		{"example.com.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"192.0.2.53"}},
+	}
+	for _, tt := range tests {
+		h, got := query(t, s, tt.name, tt.qtype, "udp")
+		if h.RCode != tt.rcode || len(got) != len(tt.want) {
+			t.Errorf("%s %v: rcode %v answers %v, want %v %v", tt.name, tt.qtype, h.RCode, got, tt.rcode, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: answers %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+
+	// 上游不可用时回 SERVFAIL
+	s = NewServer(testLookup, Options{Upstream: []string{}, Logger: logger})
+	if h, _ := query(t, s, "example.com.", dnsmessage.TypeA, "udp"); h.RCode != dnsmessage.RCodeServerFailure {
+		t.Fatalf("rcode %v, want SERVFAIL", h.RCode)
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := NewServer(testLookup, Options{Upstream: []string{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeUDP(ctx, pc)
+	go s.ServeTCP(ctx, ln)
+
+	for _, network := range []string{"udp", "tcp"} {
+		r := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, pc.LocalAddr().String())
+			},
+		}
+		addrs, err := r.LookupHost(ctx, "a.default.p2p")
+		sort.Strings(addrs)
+		if err != nil || len(addrs) != 2 || addrs[0] != "100.64.0.1" || addrs[1] != "fd7a::1" {
+			t.Fatalf("%s: LookupHost = %v, %v", network, addrs, err)
+		}
+	}
+}
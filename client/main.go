@@ -1,171 +1,358 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/golang/protobuf/proto"
+	"github.com/jinyunx/p2p/client/acl"
 	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
 	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc"
-	"log"
+	"log/slog"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 )
 
+var logger = slog.Default()
+
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// 子命令，第一个参数不是子命令时按老的 servers name lport 方式运行
+var commands = map[string]func(args []string){
+	"nat":      runNat,
+	"diagnose": runDiagnose,
+	"ping":     runPing,
+	"chat":     runChat,
+	"forward":  runForward,
+	"socks5":   runSocks5,
+	"vpn":      runVPN,
+}
+
 func main() {
-	log.SetFlags(log.Lshortfile | log.LstdFlags)
-	if len(os.Args) != 4 {
-		log.Fatalf("usage:%s ip name lport", os.Args[0])
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	logJson := flag.Bool("log-json", false, "log in JSON format")
+	replicas := flag.Int("replicas", 1, "number of servers to register with")
+	keepalive := flag.Duration("keepalive", 20*time.Second, "interval of NAT keepalive probes to the server")
+	tcpMode := flag.Bool("tcp", false, "also punch tcp with simultaneous open, for networks that block udp")
+	portMap := flag.Bool("portmap", true, "request a udp port mapping from the gateway via PCP, NAT-PMP or UPnP")
+	gateway := flag.String("gateway", "", "gateway for PCP and NAT-PMP, default is the default route")
+	lan := flag.Bool("lan", true, "discover peers on the local network with multicast beacons")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] servers name lport\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] nat [nat flags] stun-server\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] diagnose [diagnose flags] servers peer\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] ping [ping flags] servers peer\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] chat [chat flags] servers [peer]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] forward [-L localport:peer:remoteport] [-R remoteport:peer:localport] servers\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] socks5 [-listen addr] -via peer servers\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] vpn [-dev name] [-dns addr] servers\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  servers is a comma separated host[:port] list or srv:<dns srv name>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	logger, err = public.NewLogger(os.Stderr, *logLevel, *logJson)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		cmd(flag.Args()[1:])
+		return
+	}
+	if flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
 	}
-	ip := os.Args[1]
-	name := os.Args[2]
-	lport, err := strconv.Atoi(os.Args[3])
+	name := flag.Arg(1)
+	lport, err := strconv.Atoi(flag.Arg(2))
 	if err != nil {
-		log.Fatal(err)
+		fatal("invalid lport", "err", err)
 	}
+	logger = logger.With("node", name)
 
-	address := fmt.Sprintf("%s:%d", ip, pb.ServerInfo_ServerInfo_Port)
+	servers, err := comm.ResolveServers(flag.Arg(0))
+	if err != nil {
+		fatal("invalid server list", "err", err)
+	}
+	// 收到退出信号后删除端口映射再退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var node *peer.Node
+	rdv, err := comm.NewRendezvous(servers, comm.RendezvousOptions{
+		Replicas: *replicas,
+		Logger:   logger.With("component", "comm"),
+		// 换了服务器重新探测外网地址，对称型 NAT 下映射会变
+		OnFailover: func(string) {
+			if node != nil {
+				go node.Refresh(ctx)
+			}
+		},
+	})
+	if err != nil {
+		fatal("init rendezvous failed", "err", err)
+	}
+	defer rdv.Close()
 
-	var updAddr pb.UDPAddr
-	getExternalUdp(address, lport, &updAddr)
+	tracker := newPunchTracker()
+	inbound := acl.New()
+	node, err = peer.Listen(rdv, peer.Options{
+		Name:              name,
+		LocalPort:         lport,
+		KeepaliveInterval: *keepalive,
+		Logger:            logger,
+		Allow:             inbound.AllowPeer,
+		OnMessage: func(from peer.Peer, src *net.UDPAddr, msg *pb.PeerMsg) {
+			if hello := msg.GetHello(); hello.GetText() != "" {
+				logger.Info("received", "peer", from.Name, "peer_addr", src.String(), "data", hello.GetText())
+			}
+			onReceived(rdv, name, tracker, src)
+		},
+		OnRaw: func(data []byte, addr *net.UDPAddr) {
+			logger.Info("received", "peer_addr", addr.String(), "data", string(data))
+			onReceived(rdv, name, tracker, addr)
+		},
+	})
+	if err != nil {
+		fatal("open peer socket failed", "err", err)
+	}
+	defer node.Close()
+	go rdv.Run(ctx)
 
-	updateNode(address, name, &updAddr)
+	discoverUdp := func() error {
+		updAddr, err := node.Discover(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("external address", "ip", updAddr.GetIp(), "port", updAddr.GetPort())
+		natType := detectNatType(ctx, rdv, lport, updAddr)
+		// 服务器开了探测端口时采样映射端口，映射随目的地址变化就是对称型
+		pred, err := node.UpdatePrediction(ctx)
+		if err != nil {
+			logger.Warn("port prediction failed", "err", err)
+		} else if pred != nil {
+			logger.Info("symmetric nat detected", "random", pred.GetRandom(), "delta", pred.GetDelta())
+			natType = pb.NatType_NatType_Symmetric
+		}
+		node.SetNatType(natType)
+		return nil
+	}
+	var tcp *tcpSessions
+	if *tcpMode {
+		tcp = newTCPSessions(rdv, node)
+		err := retry(ctx, "get external tcp address", func() error {
+			addr, err := node.DiscoverTCP(ctx)
+			if err != nil {
+				return err
+			}
+			logger.Info("external tcp address", "ip", addr.GetIp(), "port", addr.GetPort())
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		// UDP 可能完全不通，这时只用 TCP
+		if err := discoverUdp(); err != nil {
+			logger.Warn("udp unreachable, use tcp only", "err", err)
+			node.SetNatType(pb.NatType_NatType_UdpBlocked)
+		}
+	} else if retry(ctx, "get external address", discoverUdp) != nil {
+		return
+	}
+	err = retry(ctx, "register", func() error {
+		if err := node.Register(ctx); err != nil {
+			return err
+		}
+		logger.Info("registered", "server", rdv.Primary())
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	go inbound.Watch(ctx, rdv, name, logger)
+	go node.Keepalive(ctx)
+	go node.Measure(ctx)
+	go pollPunch(ctx, rdv, node, tcp)
+	if *lan {
+		go func() {
+			if err := node.Beacon(ctx); err != nil {
+				logger.Warn("lan discovery disabled", "err", err)
+			}
+		}()
+	}
+	mapped := make(chan struct{})
+	go func() {
+		defer close(mapped)
+		if *portMap {
+			runPortMap(ctx, node, *gateway)
+		}
+	}()
 
-	sendToPeer(address, name, lport)
+	go sendToPeer(ctx, rdv, node, tracker, tcp)
+	<-ctx.Done()
+	logger.Info("shutting down")
+	<-mapped
 }
 
-func recvUdp(conn *net.UDPConn) {
+// pollPunch 长轮询服务器转来的打洞请求，按约定的时间向发起方打洞
+func pollPunch(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, tcp *tcpSessions) {
+	backoff := comm.Backoff{Min: time.Second, Max: time.Minute}
 	for {
-		var buf [8 * 1024]byte
-
-		// 读取数据
-		n, addr, err := conn.ReadFromUDP(buf[0:])
-		if err != nil {
-			log.Println(err)
+		reqs, err := rdv.PollPunch(ctx, node.Name(), 25*time.Second)
+		if ctx.Err() != nil {
 			return
 		}
-
-		// 打印接收到的消息
-		log.Println("Received from ", addr, string(buf[:n]))
+		if err != nil {
+			wait := backoff.Next()
+			logger.Warn("PollPunch failed", "err", err, "backoff", wait)
+			if !sleep(ctx, wait) {
+				return
+			}
+			continue
+		}
+		backoff.Reset()
+		for _, req := range reqs {
+			logger.Info("punch requested by peer", "peer", req.GetFrom(), "tcp", req.GetTcp())
+			delay := time.Duration(req.GetDelayMs()) * time.Millisecond
+			switch {
+			case !req.GetTcp():
+				go node.Punch(ctx, req.GetPeer(), delay)
+			case tcp != nil:
+				go tcp.punch(ctx, req.GetPeer(), delay)
+			}
+		}
 	}
 }
 
-func getUdpConn(laddr string) (*net.UDPConn, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp4", laddr)
+// requestPunch 请服务器通知对端同时打洞，对称型 NAT 只靠单向发包打不通
+func requestPunch(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, target string) {
+	resp, err := rdv.RequestPunch(ctx, &pb.RequestPunchReq{Name: node.Name(), Peer: target})
 	if err != nil {
-		log.Println("Invalid address:", err)
-		return nil, err
+		logger.Warn("RequestPunch failed", "peer", target, "err", err)
+		return
 	}
+	go node.Punch(ctx, resp.GetPeer(), time.Duration(resp.GetDelayMs())*time.Millisecond)
+}
 
-	// 创建UDP监听
-	log.Println("Listen udp", laddr)
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		log.Println("Error listening on UDP port:", err)
-		return nil, err
+// retry 一直重试直到成功，间隔逐步加大，ctx 结束时返回 ctx 的错误
+func retry(ctx context.Context, what string, fn func() error) error {
+	backoff := comm.Backoff{Min: time.Second, Max: time.Minute}
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		wait := backoff.Next()
+		logger.Warn(what+" failed, retrying", "err", err, "backoff", wait)
+		if !sleep(ctx, wait) {
+			return ctx.Err()
+		}
 	}
-	return conn, err
 }
 
-func sendToPeer(address string, name string, lport int) {
+// sleep 等待 d，ctx 先结束时返回 false
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	conn, err := getUdpConn(":" + strconv.Itoa(lport))
-	if err != nil {
-		log.Fatalln(err)
+func onReceived(rdv *comm.Rendezvous, name string, tracker *punchTracker, addr *net.UDPAddr) {
+	if peer, elapsed, ok := tracker.received(addr); ok {
+		go reportPunch(rdv, name, peer, true, elapsed)
 	}
-	defer conn.Close()
+}
 
-	go recvUdp(conn)
+// sendToPeer 定期给对端发 hello，tcp 不为 nil 时同时维持一条 TCP 连接
+func sendToPeer(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, tracker *punchTracker, tcp *tcpSessions) {
+	name := node.Name()
 
+	// 服务器都不可用时逐步拉长查询间隔
+	backoff := comm.Backoff{Min: 5 * time.Second, Max: time.Minute}
+	lastPunch := make(map[string]time.Time)
 	for {
+		for _, lost := range tracker.expired() {
+			go reportPunch(rdv, name, lost, false, punchTimeout)
+		}
+
 		var target *pb.NodeInfo = nil
 
-		nodeInfo := getNodeInfo(address)
-		for _, node := range nodeInfo {
-			if node.Name != name {
-				target = node
+		nodeInfo, err := getNodeInfo(ctx, rdv)
+		if err != nil {
+			wait := backoff.Next()
+			logger.Warn("GetNodeInfo failed", "err", err, "backoff", wait)
+			if !sleep(ctx, wait) {
+				return
+			}
+			continue
+		}
+		backoff.Reset()
+		for _, info := range nodeInfo {
+			if info.Name != name {
+				target = info
 				break
 			}
 		}
 		if target == nil {
-			log.Println("no peer found")
-			time.Sleep(5 * time.Second)
+			logger.Info("no peer found")
+			if !sleep(ctx, 5*time.Second) {
+				return
+			}
 			continue
 		}
-
-		peerAddr := fmt.Sprintf("%s:%d", target.UdpAddr.Ip, target.UdpAddr.Port)
-		message := []byte(fmt.Sprintf("hello %s, my name is %s", target.Name, name))
-
-		peerUdpAddr, err := net.ResolveUDPAddr("udp4", peerAddr)
-		if err != nil {
-			log.Println("Invalid server address:", err)
+		if tcp != nil {
+			tcp.maybePunch(ctx, target)
+		}
+		if len(peer.Candidates(target)) == 0 || node.Reflexive() == nil {
+			// 有一方 UDP 不通
+			if !sleep(ctx, 5*time.Second) {
+				return
+			}
 			continue
 		}
+		connectPeers(ctx, rdv, node, []*pb.NodeInfo{target}, lastPunch)
+		p, _ := node.Peer(target.Name)
+		tracker.start(target.Name, p.Addr.String())
 
-		n, err := conn.WriteToUDP(message, peerUdpAddr)
+		message := fmt.Sprintf("hello %s, my name is %s", target.Name, name)
+		err = node.Send(target.Name, &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{Text: message}}})
 		if err != nil {
-			log.Println("Error sending message:", err)
+			logger.Warn("send to peer failed", "peer", target.Name, "peer_addr", p.Addr.String(), "err", err)
 		} else {
-			log.Println("Has send:", n)
+			args := []any{"peer", target.Name, "peer_addr", p.Addr.String()}
+			if st, ok := node.Path(target.Name); ok && st.Received > 0 {
+				args = append(args, "rtt", st.Smoothed, "jitter", st.Jitter, "loss", st.Loss)
+			}
+			logger.Debug("sent to peer", args...)
+		}
+		if !sleep(ctx, 5*time.Second) {
+			return
 		}
-		time.Sleep(5 * time.Second)
-	}
-}
-
-func getNodeInfo(address string) []*pb.NodeInfo {
-	// Set up a connection to the server.
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
-	}
-	defer conn.Close()
-	c := pb.NewP2PClient(conn)
-
-	// Contact the server and print out its response.
-	r, err := c.GetNodeInfo(context.Background(), &pb.GetNodeInfoReq{})
-	if err != nil {
-		log.Fatalf("could not greet: %v", err)
-	}
-	log.Printf("Response: %s", r.String())
-	return r.GetNodeInfo()
-}
-
-func updateNode(address string, name string, updAddr *pb.UDPAddr) {
-	// Set up a connection to the server.
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
-	}
-	defer conn.Close()
-	c := pb.NewP2PClient(conn)
-
-	nodeInfo := &pb.NodeInfo{
-		Name:    name,
-		UdpAddr: updAddr,
-	}
-	// Contact the server and print out its response.
-	r, err := c.UpdateNode(context.Background(), &pb.UpdateNodeReq{
-		NodeInfo: nodeInfo,
-	})
-	if err != nil {
-		log.Fatalf("could not greet: %v", err)
 	}
-	log.Printf("Response: %s", r.String())
 }
 
-func getExternalUdp(address string, lport int, updAddr *pb.UDPAddr) {
-	var buf = make([]byte, 512)
-	message := []byte("Hello UDP server!")
-	n, err := comm.UdpWriteAndRead(address, lport, 5*time.Second, message, buf)
+func getNodeInfo(ctx context.Context, rdv *comm.Rendezvous) ([]*pb.NodeInfo, error) {
+	nodes, err := rdv.GetNodeInfo(ctx)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = proto.Unmarshal(buf[0:n], updAddr)
-	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	log.Println("Server response:", updAddr)
+	logger.Debug("GetNodeInfo", "server", rdv.Primary(), "nodes", len(nodes))
+	return nodes, nil
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+
+	"github.com/jinyunx/p2p/client/peer"
+	"github.com/jinyunx/p2p/client/portmap"
+	"golang.org/x/net/context"
+)
+
+// runPortMap 找到支持端口映射的网关后维持本地 UDP 端口的映射，
+// 映射地址作为高优先级的候选地址注册，ctx 结束时删除映射
+func runPortMap(ctx context.Context, node *peer.Node, gateway string) {
+	opts := portmap.Options{Logger: logger.With("component", "portmap")}
+	if gateway != "" {
+		if opts.Gateway = net.ParseIP(gateway).To4(); opts.Gateway == nil {
+			logger.Warn("invalid gateway address", "gateway", gateway)
+			return
+		}
+	}
+	m, err := portmap.Discover(ctx, opts)
+	if err != nil {
+		logger.Info("port mapping unavailable", "err", err)
+		return
+	}
+	portmap.Maintain(ctx, m, node.LocalAddr().Port, opts, func(mapping *portmap.Mapping) {
+		node.SetPortMapping(mapping.External)
+		if err := node.Register(ctx); err != nil {
+			logger.Warn("register port mapping failed", "err", err)
+		}
+	})
+	node.SetPortMapping(nil)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jinyunx/p2p/stun"
+)
+
+const defaultStunPort = "3478"
+
+type natReport struct {
+	Server   string            `json:"server"`
+	Behavior *stun.NatBehavior `json:"behavior"`
+	NatType  string            `json:"nat_type"`
+	// 映射存活时间，单位秒，Expired 为 0 表示到 -max 都没过期
+	LifetimeAlive   float64 `json:"lifetime_alive_sec,omitempty"`
+	LifetimeExpired float64 `json:"lifetime_expired_sec,omitempty"`
+}
+
+// runNat 探测本机 NAT 的映射、过滤行为，可选测量映射存活时间
+func runNat(args []string) {
+	fs := flag.NewFlagSet("nat", flag.ExitOnError)
+	lifetime := fs.Bool("lifetime", false, "also measure how long an idle udp mapping survives, takes several times -max")
+	min := fs.Duration("min", 5*time.Second, "shortest idle interval to test")
+	max := fs.Duration("max", 5*time.Minute, "longest idle interval to test")
+	precision := fs.Duration("precision", 5*time.Second, "stop the binary search when bounds are this close")
+	timeout := fs.Duration("timeout", 2*time.Second, "timeout of a single stun request")
+	asJson := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] nat [nat flags] stun-server[:port]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	server := fs.Arg(0)
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, defaultStunPort)
+	}
+
+	stunLogger := logger.With("component", "stun")
+	b, err := stun.DiscoverBehavior(server, stun.BehaviorOptions{Timeout: *timeout, Logger: stunLogger})
+	if err != nil {
+		fatal("nat behavior discovery failed", "server", server, "err", err)
+	}
+	report := natReport{Server: server, Behavior: b, NatType: b.NatType().String()}
+	if !*asJson {
+		fmt.Printf("local:     %v\n", b.Local)
+		fmt.Printf("mapped:    %v\n", b.Mapped)
+		fmt.Printf("mapping:   %v\n", b.Mapping)
+		fmt.Printf("filtering: %v\n", b.Filtering)
+		fmt.Printf("nat type:  %v\n", report.NatType)
+		if !b.Complete {
+			fmt.Println("note: server has no alternate ip, address-dependent behavior is not distinguished")
+		}
+	}
+
+	if *lifetime && b.Mapped != nil {
+		res, err := stun.MeasureLifetime(server, stun.LifetimeOptions{
+			Min:       *min,
+			Max:       *max,
+			Precision: *precision,
+			Timeout:   *timeout,
+			Logger:    stunLogger,
+			OnTrial: func(idle time.Duration, alive bool) {
+				logger.Info("lifetime trial", "idle", idle, "alive", alive)
+			},
+		})
+		if err != nil {
+			fatal("lifetime measurement failed", "server", server, "err", err)
+		}
+		report.LifetimeAlive = res.Alive.Seconds()
+		report.LifetimeExpired = res.Expired.Seconds()
+		if !*asJson {
+			switch {
+			case res.Expired == 0:
+				fmt.Printf("lifetime:  at least %v\n", res.Alive)
+			case res.Alive == 0:
+				fmt.Printf("lifetime:  less than %v\n", res.Expired)
+			default:
+				fmt.Printf("lifetime:  between %v and %v\n", res.Alive, res.Expired)
+			}
+		}
+	}
+
+	if *asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+}
@@ -0,0 +1,67 @@
+package peer
+
+import (
+	"net"
+	"sort"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+// 候选地址的优先级，注册的外网地址按 0 算
+const (
+	PriorityPortMapped = 100
+)
+
+// SetPortMapping 设置网关映射出来的地址，下次注册时作为高优先级的候选地址，nil 表示映射已删除
+func (n *Node) SetPortMapping(addr *net.UDPAddr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if addr == nil {
+		n.mapped = nil
+		return
+	}
+	n.mapped = &pb.UDPAddr{Ip: addr.IP.String(), Port: int32(addr.Port)}
+}
+
+// candidates 返回要注册的候选地址，调用时要持有 n.mu
+func (n *Node) candidates() []*pb.Candidate {
+	out := n.hostCandidates()
+	if n.mapped != nil {
+		out = append(out, &pb.Candidate{
+			UdpAddr:  n.mapped,
+			Type:     pb.CandidateType_CandidateType_PortMapped,
+			Priority: PriorityPortMapped,
+		})
+	}
+	return out
+}
+
+// SortedCandidates 返回对端的候选地址加上注册的外网地址，按优先级从高到低，重复的去掉
+func SortedCandidates(info *pb.NodeInfo) []*pb.Candidate {
+	cands := append([]*pb.Candidate(nil), info.GetCandidates()...)
+	if info.GetUdpAddr() != nil {
+		cands = append(cands, &pb.Candidate{UdpAddr: info.GetUdpAddr(), Type: pb.CandidateType_CandidateType_Reflexive})
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].GetPriority() > cands[j].GetPriority() })
+	var out []*pb.Candidate
+	seen := make(map[string]bool)
+	for _, c := range cands {
+		addr, err := toUDPAddr(c.GetUdpAddr())
+		if err != nil || seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		out = append(out, c)
+	}
+	return out
+}
+
+// Candidates 返回对端所有可以尝试的地址，顺序同 SortedCandidates
+func Candidates(info *pb.NodeInfo) []*net.UDPAddr {
+	var out []*net.UDPAddr
+	for _, c := range SortedCandidates(info) {
+		addr, _ := toUDPAddr(c.GetUdpAddr())
+		out = append(out, addr)
+	}
+	return out
+}
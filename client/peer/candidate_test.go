@@ -0,0 +1,25 @@
+package peer
+
+import (
+	"testing"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+func TestCandidates(t *testing.T) {
+	info := &pb.NodeInfo{
+		Name:    "b",
+		UdpAddr: &pb.UDPAddr{Ip: "198.51.100.1", Port: 40000},
+		Candidates: []*pb.Candidate{
+			{UdpAddr: &pb.UDPAddr{Ip: "198.51.100.1", Port: 40000}, Type: pb.CandidateType_CandidateType_Reflexive},
+			{UdpAddr: &pb.UDPAddr{Ip: "203.0.113.7", Port: 42000}, Type: pb.CandidateType_CandidateType_PortMapped, Priority: PriorityPortMapped},
+		},
+	}
+	got := Candidates(info)
+	if len(got) != 2 || got[0].String() != "203.0.113.7:42000" || got[1].String() != "198.51.100.1:40000" {
+		t.Fatalf("Candidates = %v", got)
+	}
+	if got := Candidates(&pb.NodeInfo{Name: "c"}); len(got) != 0 {
+		t.Fatalf("Candidates without address = %v", got)
+	}
+}
@@ -0,0 +1,225 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+var probeMessage = []byte("Hello UDP server!")
+
+// probeReply 把服务器的回包交给等待中的 Probe
+func (n *Node) probeReply(data []byte, addr *net.UDPAddr) bool {
+	n.mu.Lock()
+	ch, ok := n.probes[addr.String()]
+	n.mu.Unlock()
+	if !ok {
+		return false
+	}
+	var reply pb.UDPAddr
+	if err := proto.Unmarshal(data, &reply); err != nil || reply.GetPort() == 0 {
+		return false
+	}
+	select {
+	case ch <- &reply:
+	default:
+	}
+	return true
+}
+
+// Probe 从本地端口向服务器发一个探测包，返回服务器看到的外网地址
+func (n *Node) Probe(ctx context.Context, server string) (*pb.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	key := addr.String()
+	ch := make(chan *pb.UDPAddr, 1)
+	n.mu.Lock()
+	n.probes[key] = ch
+	n.servers[server] = addr
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		if n.probes[key] == ch {
+			delete(n.probes, key)
+		}
+		n.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, n.opts.ProbeTimeout)
+	defer cancel()
+	if _, err := n.conn.WriteToUDP(probeMessage, addr); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Discover 依次探测各个服务器，直到有一个回应
+func (n *Node) Discover(ctx context.Context) (*pb.UDPAddr, error) {
+	var lastErr error = errors.New("no server")
+	for _, server := range n.rdv.Servers() {
+		reply, err := n.Probe(ctx, server)
+		if err != nil {
+			n.opts.Logger.Warn("udp probe failed", "server", server, "err", err)
+			lastErr = err
+			continue
+		}
+		n.opts.Logger.Debug("external address", "server", server, "ip", reply.GetIp(), "port", reply.GetPort())
+		n.mu.Lock()
+		n.reflexive = reply
+		n.reflFrom = server
+		n.mu.Unlock()
+		return reply, nil
+	}
+	return nil, lastErr
+}
+
+// Reflexive 返回最近一次探测到的外网地址
+func (n *Node) Reflexive() *pb.UDPAddr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.reflexive
+}
+
+func (n *Node) SetNatType(t pb.NatType) {
+	n.mu.Lock()
+	n.natType = t
+	n.mu.Unlock()
+}
+
+// Register 用当前的外网地址注册到服务器
+func (n *Node) Register(ctx context.Context) error {
+	nets := localNets()
+	n.mu.Lock()
+	n.nets = nets
+	info := &pb.NodeInfo{
+		Name:           n.opts.Name,
+		Network:        n.opts.Network,
+		UdpAddr:        n.reflexive,
+		NatType:        n.natType,
+		PortPrediction: n.prediction,
+		TcpAddr:        n.tcpAddr,
+		Candidates:     n.candidates(),
+	}
+	n.mu.Unlock()
+	if info.UdpAddr == nil && info.TcpAddr == nil && len(info.Candidates) == 0 {
+		return errors.New("external address unknown")
+	}
+	return n.rdv.UpdateNode(ctx, info)
+}
+
+// Keepalive 定期向主服务器发探测包，既保持 NAT 映射不过期，
+// 也能发现外网地址变化，变化后重新注册并通知已知的对端
+func (n *Node) Keepalive(ctx context.Context) {
+	ticker := time.NewTicker(n.opts.KeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh 探测一次外网地址，地址变化时重新注册并通知对端，注册失败的下次再试
+func (n *Node) Refresh(ctx context.Context) {
+	tcpChanged := n.refreshTCP(ctx)
+	reply, udpChanged := n.refreshUDP(ctx)
+	n.mu.Lock()
+	again := n.reregister
+	n.mu.Unlock()
+	if !tcpChanged && !udpChanged && !again {
+		return
+	}
+	err := n.Register(ctx)
+	if err != nil {
+		n.opts.Logger.Warn("re-register failed", "err", err)
+	}
+	n.mu.Lock()
+	n.reregister = err != nil
+	n.mu.Unlock()
+	if udpChanged {
+		n.notifyPeers(reply)
+	}
+}
+
+func (n *Node) refreshUDP(ctx context.Context) (*pb.UDPAddr, bool) {
+	n.mu.Lock()
+	old, oldFrom := n.reflexive, n.reflFrom
+	n.mu.Unlock()
+
+	reply, err := n.Discover(ctx)
+	if err != nil {
+		if old != nil {
+			n.opts.Logger.Warn("keepalive probe failed", "err", err)
+		}
+		return nil, false
+	}
+	n.mu.Lock()
+	from := n.reflFrom
+	n.mu.Unlock()
+	if old != nil && sameAddr(old, reply) {
+		return reply, false
+	}
+	if n.Symmetric() {
+		if _, err := n.UpdatePrediction(ctx); err != nil {
+			n.opts.Logger.Warn("port prediction failed", "err", err)
+		}
+	}
+	// 对称型 NAT 对不同服务器的映射不同，换了服务器时也要重新注册
+	n.opts.Logger.Info("external address changed",
+		"old_ip", old.GetIp(), "old_port", old.GetPort(), "old_server", oldFrom,
+		"ip", reply.GetIp(), "port", reply.GetPort(), "server", from)
+	return reply, true
+}
+
+// refreshTCP 开了 TCP 打洞时顺便查一次 TCP 地址，也让连接保持活跃
+func (n *Node) refreshTCP(ctx context.Context) bool {
+	n.mu.Lock()
+	old := n.tcpAddr
+	n.mu.Unlock()
+	if old == nil {
+		return false
+	}
+	addr, err := n.DiscoverTCP(ctx)
+	if err != nil {
+		n.opts.Logger.Warn("tcp keepalive failed", "err", err)
+		return false
+	}
+	if sameAddr(old, addr) {
+		return false
+	}
+	n.opts.Logger.Info("external tcp address changed",
+		"old_ip", old.GetIp(), "old_port", old.GetPort(), "ip", addr.GetIp(), "port", addr.GetPort())
+	return true
+}
+
+func (n *Node) notifyPeers(addr *pb.UDPAddr) {
+	msg := &pb.PeerMsg{Body: &pb.PeerMsg_AddrChanged{AddrChanged: &pb.PeerAddrChanged{UdpAddr: addr}}}
+	for _, p := range n.Peers() {
+		if p.Addr == nil {
+			continue
+		}
+		if err := n.SendTo(p.Addr, msg); err != nil {
+			n.opts.Logger.Warn("notify peer failed", "peer", p.Name, "err", err)
+		}
+	}
+}
+
+func sameAddr(a, b *pb.UDPAddr) bool {
+	return a.GetIp() == b.GetIp() && a.GetPort() == b.GetPort()
+}
@@ -0,0 +1,190 @@
+package peer
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// PriorityHost 低于注册的外网地址，不在同一个局域网的对端不会先去连内网地址
+const PriorityHost = -10
+
+type lanPeer struct {
+	addr *net.UDPAddr
+	seen time.Time
+}
+
+// lanProbe 是向信标源地址发出的探测，按源地址索引
+type lanProbe struct {
+	name  string
+	nonce uint64
+	sent  time.Time
+}
+
+// localNets 返回本机已启用的非回环 IPv4 网段
+func localNets() []*net.IPNet {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []*net.IPNet
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				out = append(out, ipnet)
+			}
+		}
+	}
+	return out
+}
+
+// hostCandidates 返回本机各个网卡地址加本地端口，调用时要持有 n.mu
+func (n *Node) hostCandidates() []*pb.Candidate {
+	var out []*pb.Candidate
+	port := int32(n.LocalAddr().Port)
+	for _, ipnet := range n.nets {
+		out = append(out, &pb.Candidate{
+			UdpAddr:  &pb.UDPAddr{Ip: ipnet.IP.String(), Port: port},
+			Type:     pb.CandidateType_CandidateType_Host,
+			Priority: PriorityHost,
+		})
+	}
+	return out
+}
+
+// onLink 判断 ip 是否在本机直连的网段里，调用时要持有 n.mu
+func (n *Node) onLink(ip net.IP) bool {
+	for _, ipnet := range n.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lanAddr 返回对端还有效的局域网地址，调用时要持有 n.mu
+func (n *Node) lanAddr(name string) *net.UDPAddr {
+	if l, ok := n.lan[name]; ok && time.Since(l.seen) < 3*n.opts.LanInterval {
+		return l.addr
+	}
+	return nil
+}
+
+// Beacon 定期向局域网组播自己的名字，同时收听别人的信标，
+// 发现同一网段的对端后直接用内网地址通信，不依赖路由器的回环 NAT
+func (n *Node) Beacon(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", n.opts.LanGroup)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-n.done:
+		}
+		conn.Close()
+	}()
+	go n.readBeacons(conn)
+
+	msg, err := proto.Marshal(&pb.PeerMsg{From: n.opts.Name, Body: &pb.PeerMsg_LanBeacon{LanBeacon: &pb.LanBeacon{}}})
+	if err != nil {
+		return err
+	}
+	beacon := append(append([]byte(nil), magic...), msg...)
+	ticker := time.NewTicker(n.opts.LanInterval)
+	defer ticker.Stop()
+	for {
+		// 从主套接字发出，收到的一方看到的源地址就是要连的地址
+		if _, err := n.conn.WriteToUDP(beacon, group); err != nil {
+			n.opts.Logger.Debug("lan beacon failed", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		case <-n.done:
+			return nil
+		}
+	}
+}
+
+func (n *Node) readBeacons(conn *net.UDPConn) {
+	buf := make([]byte, 1500)
+	for {
+		size, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var msg pb.PeerMsg
+		data := buf[:size]
+		if !bytes.HasPrefix(data, magic) ||
+			proto.Unmarshal(data[len(magic):], &msg) != nil || msg.GetLanBeacon() == nil {
+			continue
+		}
+		if msg.GetFrom() == "" || msg.GetFrom() == n.opts.Name {
+			continue
+		}
+		n.lanSeen(msg.GetFrom(), addr)
+	}
+}
+
+// lanSeen 收到对端的信标后向信标的源地址发一个带随机数的 hello，
+// 信标谁都能发，收到带回随机数的 ack 才用这个地址，见 lanConfirm
+func (n *Node) lanSeen(name string, addr *net.UDPAddr) {
+	nonce := rand.Uint64() | 1
+	now := time.Now()
+	n.mu.Lock()
+	for key, pr := range n.lanProbes {
+		if now.Sub(pr.sent) > 3*n.opts.LanInterval {
+			delete(n.lanProbes, key)
+		}
+	}
+	n.lanProbes[addr.String()] = &lanProbe{name: name, nonce: nonce, sent: now}
+	n.mu.Unlock()
+
+	hello := &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{Nonce: nonce}}}
+	if err := n.SendTo(addr, hello); err != nil {
+		n.opts.Logger.Debug("lan hello failed", "peer", name, "err", err)
+	}
+}
+
+// lanConfirm 处理局域网探测的 ack：名字和随机数都对得上时改用这个地址直连，
+// 之前经公网通信过的对端也切过来，还不认识的对端就新建一个
+func (n *Node) lanConfirm(name string, addr *net.UDPAddr, nonce uint64) {
+	n.mu.Lock()
+	pr, ok := n.lanProbes[addr.String()]
+	if !ok || pr.name != name || pr.nonce != nonce {
+		n.mu.Unlock()
+		return
+	}
+	delete(n.lanProbes, addr.String())
+	p, ok := n.peers[name]
+	if !ok {
+		p = &Peer{Name: name}
+		n.peers[name] = p
+	}
+	p.Addr = addr
+	p.LastSeen = time.Now()
+	_, known := n.lan[name]
+	n.lan[name] = &lanPeer{addr: addr, seen: p.LastSeen}
+	n.mu.Unlock()
+	if !known {
+		n.opts.Logger.Info("lan peer discovered", "peer", name, "peer_addr", addr.String())
+	}
+}
@@ -0,0 +1,165 @@
+package peer
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jinyunx/p2p/client/comm"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+func newLanNode(t *testing.T, f *fakeServer, name, group string) *Node {
+	rdv, err := comm.NewRendezvous([]string{f.addr}, comm.RendezvousOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdv.Close() })
+	n, err := Listen(rdv, Options{
+		Name:        name,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		LanGroup:    group,
+		LanInterval: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func TestBeacon(t *testing.T) {
+	if len(localNets()) == 0 {
+		t.Skip("no non-loopback interface")
+	}
+	f := startFake(t)
+	// 每次用不同的组播端口，避免和同时跑的其他进程串
+	free, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	free.Close()
+	group := net.JoinHostPort("239.255.77.77", strconv.Itoa(free.LocalAddr().(*net.UDPAddr).Port))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := newLanNode(t, f, "a", group)
+	b := newLanNode(t, f, "b", group)
+	for _, n := range []*Node{a, b} {
+		go func(n *Node) {
+			if err := n.Beacon(ctx); err != nil {
+				t.Log(err)
+			}
+		}(n)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	var p Peer
+	for time.Now().Before(deadline) {
+		if p, _ = b.Peer("a"); p.Addr != nil && !p.LastSeen.IsZero() {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if p.Addr == nil {
+		t.Skip("multicast not available")
+	}
+	if p.Addr.Port != a.LocalAddr().Port || p.Addr.IP.IsLoopback() {
+		t.Fatalf("lan address = %v", p.Addr)
+	}
+	if p.LastSeen.IsZero() {
+		t.Fatal("no direct hello after discovery")
+	}
+
+	// 经别的路径来的包不改变局域网地址，服务器查到的地址也不覆盖
+	if err := a.SendTo(loopback(b), &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{}}}); err != nil {
+		t.Fatal(err)
+	}
+	b.AddPeer("a", &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 40000})
+	time.Sleep(100 * time.Millisecond)
+	if got, _ := b.Peer("a"); got.Addr.String() != p.Addr.String() {
+		t.Fatalf("peer address = %v, want lan address %v", got.Addr, p.Addr)
+	}
+}
+
+func TestHostCandidates(t *testing.T) {
+	if len(localNets()) == 0 {
+		t.Skip("no non-loopback interface")
+	}
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	if _, err := a.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Register(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	info := f.nodes["a"]
+	f.mu.Unlock()
+	var hosts int
+	for _, c := range info.GetCandidates() {
+		if c.GetType() == pb.CandidateType_CandidateType_Host {
+			hosts++
+			if int(c.GetUdpAddr().GetPort()) != a.LocalAddr().Port || c.GetPriority() >= 0 {
+				t.Fatalf("unexpected host candidate %v", c)
+			}
+		}
+	}
+	if hosts == 0 {
+		t.Fatal("no host candidates registered")
+	}
+	// 外网地址排在内网地址前面
+	if got := Candidates(info); got[0].String() != loopback(a).String() {
+		t.Fatalf("first candidate = %v", got[0])
+	}
+}
+
+func TestLanConfirm(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	b := newNode(t, f, "b", nil)
+	c := newNode(t, f, "c", nil)
+	// 测试里把回环地址当作直连网段
+	_, loop, _ := net.ParseCIDR("127.0.0.0/8")
+	b.mu.Lock()
+	b.nets = []*net.IPNet{loop}
+	b.mu.Unlock()
+	wait := func() { time.Sleep(100 * time.Millisecond) }
+
+	// c 的地址冒充 a 的信标，c 回 ack 时名字对不上，不采用
+	b.lanSeen("a", loopback(c))
+	wait()
+	if p, ok := b.Peer("a"); ok && p.Addr != nil {
+		t.Fatalf("spoofed beacon set a to %v", p.Addr)
+	}
+	b.lanSeen("a", loopback(a))
+	wait()
+	b.mu.Lock()
+	lan := b.lanAddr("a")
+	b.mu.Unlock()
+	if lan == nil || lan.String() != loopback(a).String() {
+		t.Fatalf("lan address after round trip = %v", lan)
+	}
+
+	// 已经经公网通信过的对端，局域网确认以后切到内网地址
+	public := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: a.LocalAddr().Port}
+	b.mu.Lock()
+	delete(b.lan, "a")
+	b.peers["a"] = &Peer{Name: "a", Addr: public, LastSeen: time.Now()}
+	b.mu.Unlock()
+	b.lanSeen("a", loopback(a))
+	wait()
+	if p, _ := b.Peer("a"); p.Addr.String() != loopback(a).String() {
+		t.Fatalf("peer address after lan confirm = %v", p.Addr)
+	}
+	b.mu.Lock()
+	lan = b.lanAddr("a")
+	b.mu.Unlock()
+	if lan == nil || lan.String() != loopback(a).String() {
+		t.Fatalf("lan address after switch = %v", lan)
+	}
+}
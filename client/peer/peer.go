@@ -0,0 +1,321 @@
+package peer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jinyunx/p2p/client/comm"
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+)
+
+// magic 是节点间消息的前缀，用来和服务器回包、旧版客户端的文本区分开
+var magic = []byte("P2PM")
+
+var ErrUnknownPeer = errors.New("unknown peer")
+
+type Options struct {
+	Name              string
+	Network           string // 注册到的虚拟网络，为空表示服务器的 default 网络
+	LocalPort         int
+	KeepaliveInterval time.Duration // 向服务器发探测包的间隔，要小于 NAT 映射的超时
+	ProbeTimeout      time.Duration
+	Logger            *slog.Logger
+	// LanGroup 是局域网信标的组播地址，LanInterval 是信标间隔
+	LanGroup    string
+	LanInterval time.Duration
+	// PingInterval 是 Measure 探测路径质量的间隔
+	PingInterval time.Duration
+	// Net 为 nil 时用系统的套接字，测试时可以换成模拟网络
+	Net public.Network
+
+	// OnMessage 在读协程里调用，不能阻塞太久。src 是这个包的源地址，
+	// 局域网直连可用时可能和 from.Addr 不同，经服务器中转的消息 src 为 nil
+	OnMessage func(from Peer, src *net.UDPAddr, msg *pb.PeerMsg)
+	// OnRaw 收到不带前缀的数据时调用，旧版客户端发的是纯文本
+	OnRaw func(data []byte, addr *net.UDPAddr)
+	// Allow 不为 nil 时检查对端能不能连本机：不允许的对端发来的 ping 不回应，
+	// 消息不交给 OnMessage。打洞的 hello 确认、地址变更和本机 ping 的回应不受限制，
+	// 单向允许时发起方也要能打通
+	Allow func(src string) bool
+	// RelayAfter 大于 0 时，Send 对这么久没收到直连包的对端改走服务器中转，
+	// 本机也接收服务器转来的消息，见 relay.go。直连打通后自动切回直连
+	RelayAfter time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.KeepaliveInterval <= 0 {
+		o.KeepaliveInterval = 20 * time.Second
+	}
+	if o.ProbeTimeout <= 0 {
+		o.ProbeTimeout = 3 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+	if o.LanGroup == "" {
+		o.LanGroup = "239.255.77.77:47777"
+	}
+	if o.LanInterval <= 0 {
+		o.LanInterval = 10 * time.Second
+	}
+	if o.PingInterval <= 0 {
+		o.PingInterval = 5 * time.Second
+	}
+}
+
+// Peer 是通信过的对端，Addr 随对端通知或者来包地址更新
+type Peer struct {
+	Name     string
+	Addr     *net.UDPAddr
+	LastSeen time.Time
+}
+
+// Node 持有本地唯一的 UDP 套接字，服务器探测、保活和节点间通信都走这个端口，
+// 这样服务器看到的外网地址就是对端要打的地址
+type Node struct {
+	opts Options
+	rdv  *comm.Rendezvous
+	conn public.PacketConn
+
+	mu        sync.Mutex
+	peers     map[string]*Peer
+	probes    map[string]chan *pb.UDPAddr // 服务器 UDP 地址 -> 等待回包
+	servers   map[string]*net.UDPAddr     // 探测过的服务器 -> UDP 地址，中继包只收这些地址发来的
+	reflexive *pb.UDPAddr
+	reflFrom  string // 探测到 reflexive 的服务器
+	natType   pb.NatType
+	// prediction 是对称型 NAT 的端口预测，随注册信息告诉对端
+	prediction *pb.PortPrediction
+	serverConf *pb.GetServerConfigResp
+	mapped     *pb.UDPAddr // 网关端口映射出来的地址
+	// tcpMapper 维持 TCP 映射的服务器连接，只在 TCP 打洞时使用
+	tcpMapper *comm.TCPMapper
+	tcpAddr   *pb.UDPAddr
+	// reregister 表示地址变化后重新注册失败了，下次保活时再试。
+	// 服务器在旧地址的记录过期前会拒绝新 IP 的注册
+	reregister bool
+	// nets 是本机直连的网段，lan 是信标发现并且探测确认过的对端内网地址，
+	// lanProbes 是还在等 ack 的探测，见 lan.go
+	nets      []*net.IPNet
+	lan       map[string]*lanPeer
+	lanProbes map[string]*lanProbe
+	// paths 是 ping 测出的每个对端的路径质量
+	paths map[string]*pathState
+
+	done chan struct{}
+}
+
+func Listen(rdv *comm.Rendezvous, opts Options) (*Node, error) {
+	opts.setDefaults()
+	conn, err := public.ListenUDP(opts.Net, &net.UDPAddr{Port: opts.LocalPort})
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		opts:      opts,
+		rdv:       rdv,
+		conn:      conn,
+		peers:     make(map[string]*Peer),
+		probes:    make(map[string]chan *pb.UDPAddr),
+		servers:   make(map[string]*net.UDPAddr),
+		nets:      localNets(),
+		lan:       make(map[string]*lanPeer),
+		lanProbes: make(map[string]*lanProbe),
+		paths:     make(map[string]*pathState),
+		done:      make(chan struct{}),
+	}
+	n.opts.Logger.Info("listen udp", "addr", conn.LocalAddr().String())
+	go n.readLoop()
+	return n, nil
+}
+
+func (n *Node) Name() string {
+	return n.opts.Name
+}
+
+func (n *Node) LocalAddr() *net.UDPAddr {
+	return n.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (n *Node) Close() error {
+	select {
+	case <-n.done:
+		return nil
+	default:
+	}
+	close(n.done)
+	n.mu.Lock()
+	if n.tcpMapper != nil {
+		n.tcpMapper.Close()
+	}
+	n.mu.Unlock()
+	return n.conn.Close()
+}
+
+func (n *Node) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		size, addr, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.done:
+			default:
+				n.opts.Logger.Warn("udp read failed", "err", err)
+			}
+			return
+		}
+		n.dispatch(buf[:size], addr)
+	}
+}
+
+func (n *Node) dispatch(data []byte, addr *net.UDPAddr) {
+	if n.probeReply(data, addr) {
+		return
+	}
+	if bytes.HasPrefix(data, relayMagic) {
+		n.relayed(data[len(relayMagic):], addr)
+		return
+	}
+	if !bytes.HasPrefix(data, magic) {
+		if n.opts.OnRaw != nil {
+			n.opts.OnRaw(data, addr)
+		}
+		return
+	}
+	var msg pb.PeerMsg
+	if err := proto.Unmarshal(data[len(magic):], &msg); err != nil {
+		n.opts.Logger.Debug("invalid peer message", "peer_addr", addr.String(), "err", err)
+		return
+	}
+	p := n.seen(msg.GetFrom(), addr)
+	if ac := msg.GetAddrChanged(); ac != nil {
+		if ua, err := toUDPAddr(ac.GetUdpAddr()); err == nil {
+			n.opts.Logger.Info("peer address changed", "peer", p.Name, "peer_addr", ua.String())
+			n.mu.Lock()
+			// 外网地址变了不影响局域网直连
+			if n.lanAddr(p.Name) == nil {
+				p.Addr = ua
+			}
+			n.mu.Unlock()
+		}
+	}
+	allowed := n.opts.Allow == nil || n.opts.Allow(p.Name)
+	if ping := msg.GetPing(); ping != nil && (allowed || ping.GetPong()) {
+		n.onPing(p.Name, addr, ping)
+	}
+	// 打洞包要回一个确认，对方才知道洞打通了
+	if h := msg.GetHello(); h != nil && !h.GetAck() {
+		ack := &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{Ack: true, Nonce: h.GetNonce()}}}
+		if err := n.SendTo(addr, ack); err != nil {
+			n.opts.Logger.Debug("hello ack failed", "peer", p.Name, "err", err)
+		}
+	} else if h != nil && h.GetNonce() != 0 {
+		n.lanConfirm(p.Name, addr, h.GetNonce())
+	}
+	if n.opts.OnMessage != nil && allowed {
+		n.mu.Lock()
+		from := *p
+		n.mu.Unlock()
+		n.opts.OnMessage(from, addr, &msg)
+	}
+}
+
+// seen 记录对端最近一次的来包地址，对端换了地址也能继续通信
+func (n *Node) seen(name string, addr *net.UDPAddr) *Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	p, ok := n.peers[name]
+	if !ok {
+		p = &Peer{Name: name}
+		n.peers[name] = p
+	}
+	verified := !p.LastSeen.IsZero() && p.Addr != nil
+	p.LastSeen = time.Now()
+	if l := n.lanAddr(name); l != nil {
+		// 局域网直连还通时，经公网绕回来的包不改变对端地址
+		if l.String() == addr.String() {
+			n.lan[name].seen = p.LastSeen
+		}
+		return p
+	}
+	// 没有确认过的局域网地址不替换已经通信过的地址，见 lanConfirm
+	if verified && n.onLink(addr.IP) && p.Addr.String() != addr.String() {
+		return p
+	}
+	p.Addr = addr
+	return p
+}
+
+// AddPeer 记录从服务器查到的对端地址，已经收到过对端的包或者在局域网里发现过对端时不覆盖
+func (n *Node) AddPeer(name string, addr *net.UDPAddr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if p, ok := n.peers[name]; ok && (!p.LastSeen.IsZero() || n.lanAddr(name) != nil) {
+		return
+	}
+	n.peers[name] = &Peer{Name: name, Addr: addr}
+}
+
+func (n *Node) Peer(name string) (Peer, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	p, ok := n.peers[name]
+	if !ok {
+		return Peer{}, false
+	}
+	return *p, true
+}
+
+// Peers 返回按名字排序的对端列表
+func (n *Node) Peers() []Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]Peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Send 给已知的对端发消息，From 自动填成本节点名字。
+// 开了 RelayAfter 时，还没打通或者直连断了的对端经服务器中转
+func (n *Node) Send(name string, msg *pb.PeerMsg) error {
+	p, ok := n.Peer(name)
+	if n.opts.RelayAfter > 0 && (!ok || p.Addr == nil || time.Since(p.LastSeen) > n.opts.RelayAfter) {
+		return n.Relay(name, msg)
+	}
+	return n.sendDirect(name, msg)
+}
+
+// sendDirect 发到对端当前的地址，不经服务器中转
+func (n *Node) sendDirect(name string, msg *pb.PeerMsg) error {
+	p, ok := n.Peer(name)
+	if !ok || p.Addr == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, name)
+	}
+	return n.SendTo(p.Addr, msg)
+}
+
+func (n *Node) SendTo(addr *net.UDPAddr, msg *pb.PeerMsg) error {
+	msg.From = n.opts.Name
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(append(append([]byte(nil), magic...), b...), addr)
+	return err
+}
+
+func toUDPAddr(a *pb.UDPAddr) (*net.UDPAddr, error) {
+	return net.ResolveUDPAddr("udp4", net.JoinHostPort(a.GetIp(), strconv.Itoa(int(a.GetPort()))))
+}
@@ -0,0 +1,224 @@
+package peer
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jinyunx/p2p/client/comm"
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// fakeServer 在同一个端口上提供 gRPC 注册和 UDP 地址回显，回显的端口可以改
+type fakeServer struct {
+	pb.UnimplementedP2PServer
+	addr    string
+	mapPort atomic.Int32 // 非 0 时回显这个端口，模拟 NAT 映射变化
+
+	mu    sync.Mutex
+	nodes map[string]*pb.NodeInfo
+}
+
+func startFake(t *testing.T) *fakeServer {
+	return startFakeOn(t, nil)
+}
+
+// startFakeOn 的 UDP 回显开在 network 上，gRPC 仍然走本机 TCP
+func startFakeOn(t *testing.T, network public.Network) *fakeServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeServer{addr: lis.Addr().String(), nodes: make(map[string]*pb.NodeInfo)}
+	s := grpc.NewServer()
+	pb.RegisterP2PServer(s, f)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	uaddr, _ := net.ResolveUDPAddr("udp4", f.addr)
+	uconn, err := public.ListenUDP(network, uaddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { uconn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			size, addr, err := uconn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if to := f.relayTarget(buf[:size]); to != nil {
+				uconn.WriteToUDP(buf[:size], to)
+				continue
+			}
+			port := int32(addr.Port)
+			if p := f.mapPort.Load(); p != 0 {
+				port = p
+			}
+			b, _ := proto.Marshal(&pb.UDPAddr{Ip: addr.IP.String(), Port: port})
+			uconn.WriteToUDP(b, addr)
+		}
+	}()
+	return f
+}
+
+func (f *fakeServer) UpdateNode(ctx context.Context, in *pb.UpdateNodeReq) (*pb.UpdateNodeResp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes[in.GetNodeInfo().GetName()] = in.GetNodeInfo()
+	return &pb.UpdateNodeResp{}, nil
+}
+
+// relayTarget 返回中继包目标注册的地址，不核对来源
+func (f *fakeServer) relayTarget(data []byte) *net.UDPAddr {
+	var pkt pb.RelayPacket
+	if !bytes.HasPrefix(data, relayMagic) || proto.Unmarshal(data[len(relayMagic):], &pkt) != nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.nodes[pkt.GetTo()].GetUdpAddr()
+	if a == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: net.ParseIP(a.GetIp()), Port: int(a.GetPort())}
+}
+
+func (f *fakeServer) port(name string) int32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nodes[name].GetUdpAddr().GetPort()
+}
+
+func newNode(t *testing.T, f *fakeServer, name string, onMsg func(Peer, *net.UDPAddr, *pb.PeerMsg)) *Node {
+	return newNodeOpts(t, f, Options{Name: name, OnMessage: onMsg})
+}
+
+func newNodeOpts(t *testing.T, f *fakeServer, opts Options) *Node {
+	rdv, err := comm.NewRendezvous([]string{f.addr}, comm.RendezvousOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdv.Close() })
+	opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	n, err := Listen(rdv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func loopback(n *Node) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: n.LocalAddr().Port}
+}
+
+func TestAddressChange(t *testing.T) {
+	f := startFake(t)
+	ctx := context.Background()
+
+	got := make(chan *pb.PeerMsg, 10)
+	a := newNode(t, f, "a", nil)
+	b := newNode(t, f, "b", func(p Peer, src *net.UDPAddr, msg *pb.PeerMsg) { got <- msg })
+
+	if _, err := a.Discover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if f.port("a") != int32(a.LocalAddr().Port) {
+		t.Fatalf("registered port = %d", f.port("a"))
+	}
+	a.AddPeer("b", loopback(b))
+	b.AddPeer("a", loopback(a))
+
+	// 映射没变时不重新注册也不通知对端
+	a.Refresh(ctx)
+	select {
+	case msg := <-got:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	f.mapPort.Store(40000)
+	a.Refresh(ctx)
+	if f.port("a") != 40000 {
+		t.Fatalf("registered port = %d, want 40000", f.port("a"))
+	}
+	select {
+	case msg := <-got:
+		if msg.GetFrom() != "a" || msg.GetAddrChanged().GetUdpAddr().GetPort() != 40000 {
+			t.Fatalf("unexpected message %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer not notified")
+	}
+	if p, _ := b.Peer("a"); p.Addr.Port != 40000 {
+		t.Fatalf("peer address = %v", p.Addr)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	f := startFake(t)
+	got := make(chan string, 10)
+	b := newNodeOpts(t, f, Options{
+		Name: "b",
+		OnMessage: func(p Peer, src *net.UDPAddr, msg *pb.PeerMsg) {
+			if msg.GetHello() != nil {
+				got <- p.Name
+			}
+		},
+		Allow: func(src string) bool { return src == "a" },
+	})
+	a := newNode(t, f, "a", nil)
+	c := newNode(t, f, "c", nil)
+	for _, n := range []*Node{a, c} {
+		n.AddPeer("b", loopback(b))
+		b.AddPeer(n.Name(), loopback(n))
+	}
+	hello := &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{Text: "hi"}}}
+	c.Send("b", hello)
+	a.Send("b", hello)
+	if from := <-got; from != "a" {
+		t.Fatalf("message from %s delivered", from)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := a.Ping(ctx, "b"); err != nil {
+		t.Fatalf("allowed ping: %v", err)
+	}
+	if _, err := c.Ping(ctx, "b"); err != ErrPingTimeout {
+		t.Fatalf("denied ping: %v", err)
+	}
+	// b 自己发起的 ping 收得到 c 的回应
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := b.Ping(ctx, "c"); err != nil {
+		t.Fatalf("ping from b: %v", err)
+	}
+	select {
+	case from := <-got:
+		t.Fatalf("unexpected message from %s", from)
+	default:
+	}
+}
+
+func TestSendUnknownPeer(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	if err := a.Send("nobody", &pb.PeerMsg{}); err == nil {
+		t.Fatal("send to unknown peer should fail")
+	}
+}
@@ -0,0 +1,209 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// pingWindow 是计算丢包率用的最近探测次数
+const pingWindow = 100
+
+var ErrPingTimeout = errors.New("ping timeout")
+
+// PathStats 是到一个对端的路径质量，RTT 统计只算收到 pong 的探测
+type PathStats struct {
+	Sent     int
+	Received int
+	Last     time.Duration
+	Min      time.Duration
+	Max      time.Duration
+	Smoothed time.Duration // 和 TCP 的 SRTT 一样按 1/8 平滑
+	Jitter   time.Duration // RFC 3550 的算法，相邻两次 RTT 之差按 1/16 平滑
+	Loss     float64       // 最近 pingWindow 次探测的丢包率
+	Updated  time.Time
+}
+
+type pendingPing struct {
+	sent      time.Time
+	timestamp int64
+	reply     chan time.Duration
+}
+
+type pathState struct {
+	stats   PathStats
+	seq     uint32
+	pending map[uint32]*pendingPing
+	results []bool // 最近的探测结果，true 表示收到了 pong
+}
+
+// record 记一次探测结果，ok 为 false 时 rtt 不用
+func (s *pathState) record(ok bool, rtt time.Duration) {
+	s.results = append(s.results, ok)
+	if len(s.results) > pingWindow {
+		s.results = s.results[len(s.results)-pingWindow:]
+	}
+	lost := 0
+	for _, r := range s.results {
+		if !r {
+			lost++
+		}
+	}
+	st := &s.stats
+	st.Loss = float64(lost) / float64(len(s.results))
+	st.Updated = time.Now()
+	if !ok {
+		return
+	}
+	if st.Received == 0 {
+		st.Min, st.Max, st.Smoothed = rtt, rtt, rtt
+	} else {
+		d := rtt - st.Last
+		if d < 0 {
+			d = -d
+		}
+		st.Jitter += (d - st.Jitter) / 16
+		st.Smoothed += (rtt - st.Smoothed) / 8
+		st.Min = min(st.Min, rtt)
+		st.Max = max(st.Max, rtt)
+	}
+	st.Received++
+	st.Last = rtt
+}
+
+// path 返回对端的路径状态，没有就新建，调用时要持有 n.mu
+func (n *Node) path(name string) *pathState {
+	s, ok := n.paths[name]
+	if !ok {
+		s = &pathState{pending: make(map[uint32]*pendingPing)}
+		n.paths[name] = s
+	}
+	return s
+}
+
+// Ping 给对端发一个探测包并等待 pong，ctx 超时算作丢包
+func (n *Node) Ping(ctx context.Context, name string) (time.Duration, error) {
+	now := time.Now()
+	pp := &pendingPing{sent: now, timestamp: now.UnixNano(), reply: make(chan time.Duration, 1)}
+	n.mu.Lock()
+	s := n.path(name)
+	s.seq++
+	seq := s.seq
+	s.pending[seq] = pp
+	n.mu.Unlock()
+
+	msg := &pb.PeerMsg{Body: &pb.PeerMsg_Ping{Ping: &pb.PeerPing{Seq: seq, Timestamp: pp.timestamp}}}
+	// ping 测的是直连路径，不走中继
+	if err := n.sendDirect(name, msg); err != nil {
+		n.mu.Lock()
+		delete(s.pending, seq)
+		n.mu.Unlock()
+		return 0, err
+	}
+	n.mu.Lock()
+	s.stats.Sent++
+	n.mu.Unlock()
+
+	select {
+	case rtt := <-pp.reply:
+		return rtt, nil
+	case <-ctx.Done():
+	case <-n.done:
+		return 0, net.ErrClosed
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// pong 可能正好在超时的同时到达
+	select {
+	case rtt := <-pp.reply:
+		return rtt, nil
+	default:
+	}
+	delete(s.pending, seq)
+	if ctx.Err() == context.Canceled {
+		s.stats.Sent--
+		return 0, ctx.Err()
+	}
+	s.record(false, 0)
+	return 0, ErrPingTimeout
+}
+
+// onPing 回应对端的探测，或者把收到的 pong 记到路径统计里
+func (n *Node) onPing(name string, addr *net.UDPAddr, ping *pb.PeerPing) {
+	if !ping.GetPong() {
+		pong := &pb.PeerMsg{Body: &pb.PeerMsg_Ping{Ping: &pb.PeerPing{
+			Seq: ping.GetSeq(), Timestamp: ping.GetTimestamp(), Pong: true,
+		}}}
+		if err := n.SendTo(addr, pong); err != nil {
+			n.opts.Logger.Debug("pong failed", "peer", name, "err", err)
+		}
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s, ok := n.paths[name]
+	if !ok {
+		return
+	}
+	pp, ok := s.pending[ping.GetSeq()]
+	// 超时以后才到的 pong 已经算作丢包，时间戳对不上的是重启前的探测
+	if !ok || pp.timestamp != ping.GetTimestamp() {
+		return
+	}
+	delete(s.pending, ping.GetSeq())
+	rtt := time.Since(pp.sent)
+	s.record(true, rtt)
+	pp.reply <- rtt
+}
+
+// Path 返回到对端的路径质量，还没探测过时 ok 为 false
+func (n *Node) Path(name string) (PathStats, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s, ok := n.paths[name]
+	if !ok {
+		return PathStats{}, false
+	}
+	return s.stats, true
+}
+
+// Paths 返回所有探测过的对端的路径质量
+func (n *Node) Paths() map[string]PathStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make(map[string]PathStats, len(n.paths))
+	for name, s := range n.paths {
+		out[name] = s.stats
+	}
+	return out
+}
+
+// Measure 按 PingInterval 持续探测所有通信过的对端，结果通过 Path 查询
+func (n *Node) Measure(ctx context.Context) {
+	ticker := time.NewTicker(n.opts.PingInterval)
+	defer ticker.Stop()
+	for {
+		for _, p := range n.Peers() {
+			if p.Addr == nil || p.LastSeen.IsZero() {
+				continue
+			}
+			go func(name string) {
+				pctx, cancel := context.WithTimeout(ctx, n.opts.ProbeTimeout)
+				defer cancel()
+				if _, err := n.Ping(pctx, name); err != nil && ctx.Err() == nil {
+					n.opts.Logger.Debug("ping failed", "peer", name, "err", err)
+				}
+			}(p.Name)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-n.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestPing(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	b := newNode(t, f, "b", nil)
+	a.AddPeer("b", loopback(b))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := a.Ping(ctx, "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, ok := a.Path("b")
+	if !ok || st.Sent != 3 || st.Received != 3 || st.Loss != 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+	if st.Min <= 0 || st.Min > st.Max || st.Smoothed <= 0 {
+		t.Fatalf("rtt stats = %+v", st)
+	}
+	// b 只回 pong，不统计
+	if _, ok := b.Path("a"); ok {
+		t.Fatal("responder should have no path stats")
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	b := newNode(t, f, "b", nil)
+	a.AddPeer("b", loopback(b))
+	b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := a.Ping(ctx, "b"); err != ErrPingTimeout {
+		t.Fatalf("err = %v, want ErrPingTimeout", err)
+	}
+	if st, _ := a.Path("b"); st.Sent != 1 || st.Received != 0 || st.Loss != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestPathRecord(t *testing.T) {
+	var s pathState
+	for _, ms := range []int{10, 20, 10, 20} {
+		s.record(true, time.Duration(ms)*time.Millisecond)
+	}
+	s.record(false, 0)
+	st := s.stats
+	if st.Received != 4 || st.Min != 10*time.Millisecond || st.Max != 20*time.Millisecond {
+		t.Fatalf("stats = %+v", st)
+	}
+	if st.Loss != 0.2 {
+		t.Fatalf("loss = %v, want 0.2", st.Loss)
+	}
+	// 每次相差 10ms，抖动从 0 开始逐步逼近 10ms
+	if st.Jitter <= 0 || st.Jitter >= 10*time.Millisecond {
+		t.Fatalf("jitter = %v", st.Jitter)
+	}
+
+	for i := 0; i < pingWindow; i++ {
+		s.record(true, 10*time.Millisecond)
+	}
+	if s.stats.Loss != 0 || len(s.results) != pingWindow {
+		t.Fatalf("loss = %v, window = %d", s.stats.Loss, len(s.results))
+	}
+}
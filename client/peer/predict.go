@@ -0,0 +1,128 @@
+package peer
+
+import (
+	"net"
+	"sort"
+	"strconv"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// PredictCount 是预测范围覆盖的端口数，也是对端最多喷发的探测包数
+const PredictCount = 64
+
+// SamplePorts 从本地端口依次探测 host 的各个端口，返回每次看到的外网端口。
+// 对称型 NAT 每个目的地址分配一个新映射，采样结果可以看出分配规律
+func (n *Node) SamplePorts(ctx context.Context, host string, ports []int) []int {
+	var samples []int
+	for _, port := range ports {
+		reply, err := n.Probe(ctx, net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			n.opts.Logger.Debug("port sample failed", "port", port, "err", err)
+			continue
+		}
+		samples = append(samples, int(reply.GetPort()))
+	}
+	return samples
+}
+
+// PredictPorts 根据采样推算下一个映射的端口范围，映射端口不变时返回 nil。
+// 相邻差值一致时按等差外推，否则按随机分配处理，给出采样覆盖的范围
+func PredictPorts(samples []int, count int) *pb.PortPrediction {
+	if len(samples) < 2 {
+		return nil
+	}
+	same := true
+	for _, s := range samples[1:] {
+		if s != samples[0] {
+			same = false
+		}
+	}
+	if same {
+		return nil
+	}
+
+	deltas := make([]int, 0, len(samples)-1)
+	for i := 1; i < len(samples); i++ {
+		deltas = append(deltas, samples[i]-samples[i-1])
+	}
+	sorted := append([]int(nil), deltas...)
+	sort.Ints(sorted)
+	delta := sorted[len(sorted)/2]
+	// 大多数差值和中位数接近就认为是顺序分配，偶尔被别的连接插队也不影响
+	close := 0
+	for _, d := range deltas {
+		if abs(d-delta) <= 2 {
+			close++
+		}
+	}
+	last := samples[len(samples)-1]
+	if delta != 0 && abs(delta) <= 64 && close*2 > len(deltas) {
+		// 下一个映射大约在 last+delta，插队的连接会让它往后偏，多留一些余量
+		first, end := last+delta, last+delta*count
+		if delta < 0 {
+			first, end = end, first
+		}
+		return &pb.PortPrediction{Delta: int32(delta), Ranges: []*pb.PortRange{clampRange(first, end)}}
+	}
+
+	lo, hi := samples[0], samples[0]
+	for _, s := range samples {
+		lo, hi = min(lo, s), max(hi, s)
+	}
+	return &pb.PortPrediction{Random: true, Ranges: []*pb.PortRange{clampRange(lo, hi)}}
+}
+
+func clampRange(first, last int) *pb.PortRange {
+	return &pb.PortRange{First: int32(max(first, 1024)), Last: int32(min(last, 65535))}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// UpdatePrediction 重新采样并更新要注册的端口预测，NAT 不是对称型时清空
+func (n *Node) UpdatePrediction(ctx context.Context) (*pb.PortPrediction, error) {
+	n.mu.Lock()
+	conf := n.serverConf
+	n.mu.Unlock()
+	if conf == nil {
+		var err error
+		if conf, err = n.rdv.GetServerConfig(ctx); err != nil {
+			return nil, err
+		}
+		n.mu.Lock()
+		n.serverConf = conf
+		n.mu.Unlock()
+	}
+	if len(conf.GetProbePorts()) == 0 {
+		return nil, nil
+	}
+	host, port, err := net.SplitHostPort(n.rdv.Primary())
+	if err != nil {
+		return nil, err
+	}
+	mainPort, _ := strconv.Atoi(port)
+	ports := []int{mainPort}
+	for _, p := range conf.GetProbePorts() {
+		ports = append(ports, int(p))
+	}
+	samples := n.SamplePorts(ctx, host, ports)
+	pred := PredictPorts(samples, PredictCount)
+	n.opts.Logger.Debug("port prediction", "samples", samples, "prediction", pred.String())
+	n.mu.Lock()
+	n.prediction = pred
+	n.mu.Unlock()
+	return pred, nil
+}
+
+// Symmetric 返回本地 NAT 是否按目的地址分配映射
+func (n *Node) Symmetric() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.prediction != nil || n.natType == pb.NatType_NatType_Symmetric
+}
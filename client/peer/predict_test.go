@@ -0,0 +1,62 @@
+package peer
+
+import (
+	"net"
+	"testing"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+func TestPredictPorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int
+		want    *pb.PortPrediction
+	}{
+		{"cone", []int{40000, 40000, 40000}, nil},
+		{"too few", []int{40000}, nil},
+		{"sequential", []int{40000, 40001, 40002, 40003},
+			&pb.PortPrediction{Delta: 1, Ranges: []*pb.PortRange{{First: 40004, Last: 40067}}}},
+		{"sequential with gap", []int{40000, 40002, 40007, 40009, 40011},
+			&pb.PortPrediction{Delta: 2, Ranges: []*pb.PortRange{{First: 40013, Last: 40139}}}},
+		{"descending", []int{40010, 40009, 40008},
+			&pb.PortPrediction{Delta: -1, Ranges: []*pb.PortRange{{First: 39944, Last: 40007}}}},
+		{"random", []int{51234, 40001, 61000, 45000},
+			&pb.PortPrediction{Random: true, Ranges: []*pb.PortRange{{First: 40001, Last: 61000}}}},
+		{"clamped", []int{65530, 65531, 65532},
+			&pb.PortPrediction{Delta: 1, Ranges: []*pb.PortRange{{First: 65533, Last: 65535}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PredictPorts(tt.samples, 64)
+			if got.String() != tt.want.String() {
+				t.Fatalf("PredictPorts(%v) = %v, want %v", tt.samples, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPunchPredictedPort(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	b := newNode(t, f, "b", nil)
+
+	// 注册的端口已经失效，真实端口落在预测范围里
+	port := b.LocalAddr().Port
+	info := &pb.NodeInfo{
+		Name:    "b",
+		UdpAddr: &pb.UDPAddr{Ip: "127.0.0.1", Port: int32(port - 3)},
+		PortPrediction: &pb.PortPrediction{
+			Delta:  1,
+			Ranges: []*pb.PortRange{{First: int32(port - 2), Last: int32(port + 2)}},
+		},
+	}
+	if !a.Punch(context.Background(), info, 0) {
+		t.Fatal("punch failed")
+	}
+	p, _ := a.Peer("b")
+	if !p.Addr.IP.Equal(net.IPv4(127, 0, 0, 1)) || p.Addr.Port != port {
+		t.Fatalf("peer address = %v, want port %d", p.Addr, port)
+	}
+}
@@ -0,0 +1,81 @@
+package peer
+
+import (
+	"net"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+const (
+	punchRounds   = 5
+	punchInterval = 500 * time.Millisecond
+	// maxSpray 限制每轮向预测端口发的包数，范围太大时只打前面一段
+	maxSpray = 500
+	// sprayPace 是相邻两个喷发包的间隔，避免瞬间大量新建映射被 NAT 限速
+	sprayPace = time.Millisecond
+)
+
+// Punch 按服务器协调的时间向对端的各个候选地址打洞，对端是对称型 NAT 时
+// 同时打它预测的端口，打洞期间收到对端的包就返回 true
+func (n *Node) Punch(ctx context.Context, info *pb.NodeInfo, delay time.Duration) bool {
+	targets := Candidates(info)
+	if len(targets) == 0 {
+		n.opts.Logger.Warn("peer has no udp address", "peer", info.GetName())
+		return false
+	}
+	start := time.Now()
+	n.AddPeer(info.GetName(), targets[0])
+	if addr, err := toUDPAddr(info.GetUdpAddr()); err == nil && info.GetUdpAddr() != nil {
+		targets = append(targets, sprayTargets(addr, info.GetPortPrediction())[1:]...)
+	}
+	logger := n.opts.Logger.With("peer", info.GetName(), "peer_addr", targets[0].String())
+	logger.Info("punch start", "delay", delay, "targets", len(targets))
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return false
+	case <-n.done:
+		return false
+	}
+	hello := &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{}}}
+	for round := 0; round < punchRounds; round++ {
+		for i, target := range targets {
+			if err := n.SendTo(target, hello); err != nil {
+				logger.Debug("punch send failed", "target", target.String(), "err", err)
+			}
+			if i > 0 {
+				time.Sleep(sprayPace)
+			}
+		}
+		select {
+		case <-time.After(punchInterval):
+		case <-ctx.Done():
+			return false
+		case <-n.done:
+			return false
+		}
+		if p, ok := n.Peer(info.GetName()); ok && p.LastSeen.After(start) {
+			logger.Info("punch succeeded", "round", round+1, "seen_addr", p.Addr.String())
+			return true
+		}
+	}
+	logger.Info("punch failed", "rounds", punchRounds)
+	return false
+}
+
+// sprayTargets 返回注册地址和对端 IP 上预测的端口，注册地址排在第一个
+func sprayTargets(addr *net.UDPAddr, pred *pb.PortPrediction) []*net.UDPAddr {
+	targets := []*net.UDPAddr{addr}
+	for _, r := range pred.GetRanges() {
+		for port := int(r.GetFirst()); port <= int(r.GetLast()) && len(targets) <= maxSpray; port++ {
+			if port == addr.Port {
+				continue
+			}
+			targets = append(targets, &net.UDPAddr{IP: addr.IP, Port: port})
+		}
+	}
+	return targets
+}
@@ -0,0 +1,68 @@
+package peer
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jinyunx/p2p/client/comm"
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public/netsim"
+	"golang.org/x/net/context"
+)
+
+// simNode 在模拟网络的主机 h 上创建节点，探测出外网地址
+func simNode(t *testing.T, f *fakeServer, h *netsim.Host, name string) *Node {
+	rdv, err := comm.NewRendezvous([]string{f.addr}, comm.RendezvousOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdv.Close() })
+	n, err := Listen(rdv, Options{
+		Name:   name,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Net:    h,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	if _, err := n.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPunchSimulated(t *testing.T) {
+	cases := []struct {
+		a, b netsim.NatType
+		want bool
+	}{
+		{netsim.FullCone, netsim.Symmetric, true},
+		{netsim.PortRestricted, netsim.PortRestricted, true},
+		{netsim.PortRestricted, netsim.Symmetric, false},
+		{netsim.Symmetric, netsim.Symmetric, false},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.a.String()+"-"+c.b.String(), func(t *testing.T) {
+			t.Parallel()
+			n := netsim.New(1, netsim.LinkOptions{Latency: 5 * time.Millisecond, Jitter: 5 * time.Millisecond})
+			// 服务器的 UDP 回显和 gRPC 同一个地址，gRPC 走真实的本机 TCP
+			f := startFakeOn(t, n.Host("127.0.0.1"))
+			a := simNode(t, f, n.NAT("203.0.113.1", netsim.NatOptions{Type: c.a}).Host("10.0.0.2"), "a")
+			b := simNode(t, f, n.NAT("203.0.113.2", netsim.NatOptions{Type: c.b}).Host("10.0.0.2"), "b")
+
+			ctx := context.Background()
+			res := make(chan bool, 2)
+			go func() { res <- a.Punch(ctx, &pb.NodeInfo{Name: "b", UdpAddr: b.Reflexive()}, 0) }()
+			go func() { res <- b.Punch(ctx, &pb.NodeInfo{Name: "a", UdpAddr: a.Reflexive()}, 0) }()
+			for i := 0; i < 2; i++ {
+				if got := <-res; got != c.want {
+					t.Fatalf("punch = %v, want %v", got, c.want)
+				}
+			}
+		})
+	}
+}
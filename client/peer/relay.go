@@ -0,0 +1,80 @@
+package peer
+
+import (
+	"errors"
+	"net"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+// relayMagic 是经服务器中转的包的前缀，见 pb.RelayPacket
+var relayMagic = []byte("P2PR")
+
+var ErrNoRelay = errors.New("no relay server, external address unknown")
+
+// Relay 经服务器把消息转给对端。服务器只转发从本机注册的外网地址发出的包，
+// 所以发给探测到这个地址的服务器，注册之前不能中转
+func (n *Node) Relay(name string, msg *pb.PeerMsg) error {
+	n.mu.Lock()
+	server := n.servers[n.reflFrom]
+	n.mu.Unlock()
+	if server == nil {
+		return ErrNoRelay
+	}
+	msg.From = n.opts.Name
+	inner, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	b, err := proto.Marshal(&pb.RelayPacket{From: n.opts.Name, To: name, Msg: inner})
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(append(append([]byte(nil), relayMagic...), b...), server)
+	return err
+}
+
+// relayed 处理服务器转来的消息。发送方的名字以服务器核对过的 RelayPacket.From 为准，
+// 包里的 From 不算数。中继路径不更新对端地址，也不处理打洞、ping 这些直连路径上的消息，
+// 只交给 OnMessage，src 为 nil
+func (n *Node) relayed(data []byte, addr *net.UDPAddr) {
+	if n.opts.RelayAfter <= 0 || !n.isServer(addr) {
+		return
+	}
+	var pkt pb.RelayPacket
+	if err := proto.Unmarshal(data, &pkt); err != nil || pkt.GetTo() != n.opts.Name {
+		n.opts.Logger.Debug("invalid relay packet", "server", addr.String(), "err", err)
+		return
+	}
+	var msg pb.PeerMsg
+	if err := proto.Unmarshal(pkt.GetMsg(), &msg); err != nil {
+		n.opts.Logger.Debug("invalid relayed message", "peer", pkt.GetFrom(), "err", err)
+		return
+	}
+	if msg.GetHello() != nil || msg.GetPing() != nil || msg.GetAddrChanged() != nil {
+		return
+	}
+	from := pkt.GetFrom()
+	if n.opts.OnMessage == nil || (n.opts.Allow != nil && !n.opts.Allow(from)) {
+		return
+	}
+	msg.From = from
+	p, ok := n.Peer(from)
+	if !ok {
+		p = Peer{Name: from}
+	}
+	n.opts.OnMessage(p, nil, &msg)
+}
+
+// isServer 判断 addr 是不是探测过的服务器
+func (n *Node) isServer(addr *net.UDPAddr) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, s := range n.servers {
+		if s.IP.Equal(addr.IP) && s.Port == addr.Port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+func TestRelay(t *testing.T) {
+	f := startFake(t)
+	ctx := context.Background()
+	type delivery struct {
+		from Peer
+		src  *net.UDPAddr
+		msg  *pb.PeerMsg
+	}
+	got := make(chan delivery, 10)
+	onMsg := func(p Peer, src *net.UDPAddr, msg *pb.PeerMsg) {
+		if msg.GetHello() == nil {
+			got <- delivery{p, src, msg}
+		}
+	}
+	a := newNodeOpts(t, f, Options{Name: "a", RelayAfter: time.Minute})
+	b := newNodeOpts(t, f, Options{Name: "b", RelayAfter: time.Minute, OnMessage: onMsg})
+	c := newNodeOpts(t, f, Options{Name: "c", OnMessage: onMsg})
+	packet := &pb.PeerMsg{Body: &pb.PeerMsg_Packet{Packet: []byte{0x45}}}
+	if err := a.Send("b", packet); err != ErrNoRelay {
+		t.Fatalf("send before discover: %v", err)
+	}
+	for _, n := range []*Node{a, b, c} {
+		if _, err := n.Discover(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Register(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a 还没和 b 打通，包经服务器转给 b
+	if err := a.Send("b", packet); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-got:
+		if d.from.Name != "a" || d.src != nil || len(d.msg.GetPacket()) != 1 {
+			t.Fatalf("relayed %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("relayed packet not delivered")
+	}
+	if p, ok := b.Peer("a"); ok && !p.LastSeen.IsZero() {
+		t.Fatalf("relay updated peer %+v", p)
+	}
+
+	// 没开中继的节点不收服务器转来的包
+	if err := a.Relay("c", packet); err != nil {
+		t.Fatal(err)
+	}
+	// 打通以后走直连
+	a.AddPeer("b", loopback(b))
+	b.AddPeer("a", loopback(a))
+	b.SendTo(loopback(a), &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{}}})
+	deadline := time.Now().Add(time.Second)
+	for p, _ := a.Peer("b"); p.LastSeen.IsZero(); p, _ = a.Peer("b") {
+		if time.Now().After(deadline) {
+			t.Fatal("direct path not seen")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := a.Send("b", packet); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-got:
+		if d.from.Name != "a" || d.src.String() != loopback(a).String() {
+			t.Fatalf("direct %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("direct packet not delivered")
+	}
+	select {
+	case d := <-got:
+		t.Fatalf("unexpected delivery %+v", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,197 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jinyunx/p2p/client/comm"
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"golang.org/x/net/context"
+)
+
+const (
+	// tcpPunchTimeout 是开始打洞后持续尝试连接的时间
+	tcpPunchTimeout = 10 * time.Second
+	tcpDialTimeout  = time.Second
+	tcpDialInterval = 200 * time.Millisecond
+	maxTCPMsg       = 64 * 1024
+)
+
+var ErrPunchTimeout = errors.New("punch timed out")
+
+// tcpDialer 从和 UDP 同号的本地 TCP 端口向外连接
+func (n *Node) tcpDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		LocalAddr: &net.TCPAddr{Port: n.LocalAddr().Port},
+		Timeout:   timeout,
+		Control:   public.ReuseControl,
+	}
+}
+
+// DiscoverTCP 从本地 TCP 端口连上主服务器，返回服务器看到的外网 TCP 地址。
+// 这条连接之后一直保留，用来维持 NAT 上的 TCP 映射
+func (n *Node) DiscoverTCP(ctx context.Context) (*pb.UDPAddr, error) {
+	n.mu.Lock()
+	mapper := n.tcpMapper
+	n.mu.Unlock()
+	if mapper == nil {
+		var err error
+		mapper, err = comm.DialTCPMapper(n.rdv.Primary(), n.tcpDialer(n.opts.ProbeTimeout))
+		if err != nil {
+			return nil, err
+		}
+		n.mu.Lock()
+		n.tcpMapper = mapper
+		n.mu.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.opts.ProbeTimeout)
+	defer cancel()
+	addr, err := mapper.ExternalAddr(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tcpAddr := &pb.UDPAddr{Ip: addr.IP.String(), Port: int32(addr.Port)}
+	n.opts.Logger.Debug("external tcp address", "addr", addr.String())
+	n.mu.Lock()
+	n.tcpAddr = tcpAddr
+	n.mu.Unlock()
+	return tcpAddr, nil
+}
+
+// PunchTCP 按服务器协调的时间和对端同时发起 TCP 连接。本地端口同时在监听，
+// 对端的 SYN 先到时从监听端口接受，返回的连接已经用 hello 确认过对端名字
+func (n *Node) PunchTCP(ctx context.Context, info *pb.NodeInfo, delay time.Duration) (net.Conn, error) {
+	if info.GetTcpAddr() == nil {
+		return nil, fmt.Errorf("peer %s has no tcp address", info.GetName())
+	}
+	raddr := net.JoinHostPort(info.GetTcpAddr().GetIp(), strconv.Itoa(int(info.GetTcpAddr().GetPort())))
+	logger := n.opts.Logger.With("peer", info.GetName(), "peer_addr", raddr)
+
+	ctx, cancel := context.WithTimeout(ctx, delay+tcpPunchTimeout)
+	defer cancel()
+	lc := net.ListenConfig{Control: public.ReuseControl}
+	ln, err := lc.Listen(ctx, "tcp4", n.tcpDialer(0).LocalAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	won := make(chan net.Conn, 1)
+	offer := func(c net.Conn) {
+		select {
+		case won <- c:
+			cancel()
+		default:
+			c.Close()
+		}
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if !c.RemoteAddr().(*net.TCPAddr).IP.Equal(net.ParseIP(info.GetTcpAddr().GetIp())) {
+				c.Close()
+				continue
+			}
+			offer(c)
+		}
+	}()
+
+	logger.Info("tcp punch start", "delay", delay)
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+	dialer := n.tcpDialer(tcpDialTimeout)
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		c, err := dialer.DialContext(ctx, "tcp4", raddr)
+		if err == nil {
+			offer(c)
+			break
+		}
+		logger.Debug("tcp connect failed", "attempt", attempt, "err", err)
+		select {
+		case <-time.After(tcpDialInterval):
+		case <-ctx.Done():
+		}
+	}
+
+	var conn net.Conn
+	select {
+	case conn = <-won:
+	default:
+		logger.Info("tcp punch failed")
+		return nil, ErrPunchTimeout
+	}
+	if err := n.tcpHello(conn, info.GetName()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	logger.Info("tcp punch succeeded", "local", conn.LocalAddr().String(), "remote", conn.RemoteAddr().String())
+	return conn, nil
+}
+
+// tcpHello 双方互发 hello，确认连上的是要找的节点
+func (n *Node) tcpHello(conn net.Conn, name string) error {
+	conn.SetDeadline(time.Now().Add(n.opts.ProbeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := n.WriteMsg(conn, &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{}}}); err != nil {
+		return err
+	}
+	msg, err := ReadMsg(conn)
+	if err != nil {
+		return err
+	}
+	if msg.GetFrom() != name {
+		return fmt.Errorf("connected to %q, want %q", msg.GetFrom(), name)
+	}
+	return nil
+}
+
+// WriteMsg 在 TCP 连接上发一条消息，格式是 "P2PM"、4 字节长度和消息体
+func (n *Node) WriteMsg(w io.Writer, msg *pb.PeerMsg) error {
+	msg.From = n.opts.Name
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, len(magic)+4, len(magic)+4+len(b))
+	copy(buf, magic)
+	binary.BigEndian.PutUint32(buf[len(magic):], uint32(len(b)))
+	_, err = w.Write(append(buf, b...))
+	return err
+}
+
+// ReadMsg 从 TCP 连接读一条 WriteMsg 发的消息
+func ReadMsg(r io.Reader) (*pb.PeerMsg, error) {
+	head := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head[:len(magic)], magic) {
+		return nil, errors.New("invalid peer message")
+	}
+	size := binary.BigEndian.Uint32(head[len(magic):])
+	if size > maxTCPMsg {
+		return nil, fmt.Errorf("peer message too large: %d", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var msg pb.PeerMsg
+	if err := proto.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
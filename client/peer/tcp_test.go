@@ -0,0 +1,76 @@
+package peer
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+	grpcpeer "google.golang.org/grpc/peer"
+)
+
+func (f *fakeServer) GetExternalIpPort(ctx context.Context, in *pb.GetExternalIpPortReq) (*pb.GetExternalIpPortResp, error) {
+	p, _ := grpcpeer.FromContext(ctx)
+	return &pb.GetExternalIpPortResp{Addr: p.Addr.String(), Network: p.Addr.Network()}, nil
+}
+
+func TestDiscoverTCP(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	addr, err := a.DiscoverTCP(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(addr.GetPort()) != a.LocalAddr().Port {
+		t.Fatalf("tcp port = %d, want %d", addr.GetPort(), a.LocalAddr().Port)
+	}
+}
+
+func TestPunchTCP(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	b := newNode(t, f, "b", nil)
+	info := func(n *Node) *pb.NodeInfo {
+		return &pb.NodeInfo{Name: n.Name(), TcpAddr: &pb.UDPAddr{Ip: "127.0.0.1", Port: int32(n.LocalAddr().Port)}}
+	}
+
+	var wg sync.WaitGroup
+	conns := make([]net.Conn, 2)
+	errs := make([]error, 2)
+	for i, pair := range [][2]*Node{{a, b}, {b, a}} {
+		wg.Add(1)
+		go func(i int, self, other *Node) {
+			defer wg.Done()
+			conns[i], errs[i] = self.PunchTCP(context.Background(), info(other), 100*time.Millisecond)
+		}(i, pair[0], pair[1])
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("punch %d: %v", i, err)
+		}
+		defer conns[i].Close()
+	}
+
+	msg := &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{Text: "over tcp"}}}
+	if err := a.WriteMsg(conns[0], msg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadMsg(conns[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetFrom() != "a" || got.GetHello().GetText() != "over tcp" {
+		t.Fatalf("unexpected message %v", got)
+	}
+}
+
+func TestPunchTCPNoAddress(t *testing.T) {
+	f := startFake(t)
+	a := newNode(t, f, "a", nil)
+	if _, err := a.PunchTCP(context.Background(), &pb.NodeInfo{Name: "b"}, 0); err == nil {
+		t.Fatal("punch without tcp address should fail")
+	}
+}
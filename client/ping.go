@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jinyunx/p2p/client/acl"
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// runPing 和对端打洞后持续 ping，退出时打印 RTT、抖动和丢包统计
+func runPing(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	host, _ := os.Hostname()
+	name := fs.String("name", "ping-"+host, "node name used for the test, must differ from the peer")
+	count := fs.Int("c", 0, "stop after this many pings, 0 means until interrupted")
+	interval := fs.Duration("i", time.Second, "interval between pings")
+	timeout := fs.Duration("W", 2*time.Second, "time to wait for each pong")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] ping [ping flags] servers peer\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	servers, err := comm.ResolveServers(fs.Arg(0))
+	if err != nil {
+		fatal("invalid server list", "err", err)
+	}
+	peerName := fs.Arg(1)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rdv, err := comm.NewRendezvous(servers, comm.RendezvousOptions{Logger: logger.With("component", "comm")})
+	if err != nil {
+		fatal("init rendezvous failed", "err", err)
+	}
+	defer rdv.Close()
+	inbound := acl.New()
+	node, err := peer.Listen(rdv, peer.Options{Name: *name, Logger: logger, Allow: inbound.AllowPeer})
+	if err != nil {
+		fatal("open peer socket failed", "err", err)
+	}
+	defer node.Close()
+
+	info, err := diagRegister(ctx, rdv, node, peerName)
+	if err != nil {
+		fatal("registration failed", "err", err)
+	}
+	go inbound.Watch(ctx, rdv, *name, logger)
+	resp, err := rdv.RequestPunch(ctx, &pb.RequestPunchReq{Name: *name, Peer: peerName})
+	if err != nil {
+		fatal("RequestPunch failed", "peer", peerName, "err", err)
+	}
+	if !node.Punch(ctx, resp.GetPeer(), time.Duration(resp.GetDelayMs())*time.Millisecond) {
+		fatal("punch failed", "peer", peerName)
+	}
+	p, _ := node.Peer(info.GetName())
+	fmt.Printf("PING %s (%s)\n", peerName, p.Addr)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for seq := 1; *count == 0 || seq <= *count; seq++ {
+		pctx, cancel := context.WithTimeout(ctx, *timeout)
+		rtt, err := node.Ping(pctx, peerName)
+		cancel()
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			fmt.Printf("seq=%d %v\n", seq, err)
+		} else {
+			p, _ := node.Peer(peerName)
+			fmt.Printf("seq=%d addr=%s rtt=%.2f ms\n", seq, p.Addr, ms(rtt))
+		}
+		if *count != 0 && seq == *count {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	st, _ := node.Path(peerName)
+	fmt.Printf("--- %s ping statistics ---\n", peerName)
+	loss := 0.0
+	if st.Sent > 0 {
+		loss = float64(st.Sent-st.Received) / float64(st.Sent) * 100
+	}
+	fmt.Printf("%d sent, %d received, %.1f%% loss\n", st.Sent, st.Received, loss)
+	if st.Received > 0 {
+		fmt.Printf("rtt min/srtt/max/jitter = %.2f/%.2f/%.2f/%.2f ms\n",
+			ms(st.Min), ms(st.Smoothed), ms(st.Max), ms(st.Jitter))
+	}
+	if st.Received == 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,23 @@
+package portmap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	0000A8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth0	00000000	0100A8C0	0003	0	0	0	00000000	0	0	0
+`
+	ip, err := parseRoutes(strings.NewReader(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "192.168.0.1" {
+		t.Fatalf("gateway = %v", ip)
+	}
+	if _, err := parseRoutes(strings.NewReader("Iface\tDestination\tGateway\n")); err == nil {
+		t.Fatal("want error without default route")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package portmap
+
+import (
+	"errors"
+	"net"
+)
+
+// DefaultGateway 在其他平台上没有实现，要用 Options.Gateway 指定
+func DefaultGateway() (net.IP, error) {
+	return nil, errors.New("default gateway lookup not supported on this platform")
+}
@@ -0,0 +1,94 @@
+package portmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+	"golang.org/x/net/context"
+)
+
+// NAT-PMP，RFC 6886
+const (
+	natpmpVersion     = 0
+	natpmpOpAddr      = 0
+	natpmpOpMapUDP    = 1
+	natpmpResponseBit = 128
+)
+
+type natpmp struct {
+	net public.Network
+	gw  *net.UDPAddr
+}
+
+func discoverNATPMP(ctx context.Context, network public.Network, gw *net.UDPAddr) (Mapper, error) {
+	c := &natpmp{net: network, gw: gw}
+	if _, err := c.externalIP(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *natpmp) Protocol() string {
+	return "nat-pmp"
+}
+
+func (c *natpmp) request(ctx context.Context, req []byte, size int) ([]byte, error) {
+	op := req[1] | natpmpResponseBit
+	resp, err := roundTrip(ctx, c.net, c.gw, req, func(b []byte) bool {
+		return len(b) >= 4 && b[0] == natpmpVersion && b[1] == op
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:]); code != 0 {
+		return nil, fmt.Errorf("nat-pmp result code %d", code)
+	}
+	if len(resp) < size {
+		return nil, fmt.Errorf("short nat-pmp response: %d bytes", len(resp))
+	}
+	return resp, nil
+}
+
+func (c *natpmp) externalIP(ctx context.Context) (net.IP, error) {
+	resp, err := c.request(ctx, []byte{natpmpVersion, natpmpOpAddr}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(append([]byte(nil), resp[8:12]...)), nil
+}
+
+func (c *natpmp) mapUDP(ctx context.Context, internal, external int, lifetime time.Duration) ([]byte, error) {
+	req := make([]byte, 12)
+	req[0] = natpmpVersion
+	req[1] = natpmpOpMapUDP
+	binary.BigEndian.PutUint16(req[4:], uint16(internal))
+	binary.BigEndian.PutUint16(req[6:], uint16(external))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	return c.request(ctx, req, 16)
+}
+
+func (c *natpmp) Map(ctx context.Context, internalPort int, lifetime time.Duration) (*Mapping, error) {
+	ip, err := c.externalIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.mapUDP(ctx, internalPort, internalPort, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{
+		Protocol:     c.Protocol(),
+		InternalPort: internalPort,
+		External:     &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(resp[10:]))},
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(resp[12:])) * time.Second,
+	}, nil
+}
+
+// Unmap 有效期和外部端口都填 0 表示删除
+func (c *natpmp) Unmap(ctx context.Context, m *Mapping) error {
+	_, err := c.mapUDP(ctx, m.InternalPort, 0, 0)
+	return err
+}
@@ -0,0 +1,118 @@
+package portmap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+	"golang.org/x/net/context"
+)
+
+// PCP，RFC 6887
+const (
+	pcpVersion     = 2
+	pcpOpAnnounce  = 0
+	pcpOpMap       = 1
+	pcpResponseBit = 0x80
+	pcpHeaderSize  = 24
+	pcpMapSize     = 36
+	protocolUDP    = 17
+)
+
+type pcp struct {
+	net    public.Network
+	gw     *net.UDPAddr
+	client net.IP
+	// nonce 标识本客户端的映射，续约和删除要带同一个
+	nonce [12]byte
+}
+
+func discoverPCP(ctx context.Context, network public.Network, gw *net.UDPAddr) (Mapper, error) {
+	ip, err := localIP(network, gw.IP)
+	if err != nil {
+		return nil, err
+	}
+	c := &pcp{net: network, gw: gw, client: ip}
+	if _, err := rand.Read(c.nonce[:]); err != nil {
+		return nil, err
+	}
+	if _, err := c.request(ctx, pcpOpAnnounce, 0, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *pcp) Protocol() string {
+	return "pcp"
+}
+
+// request 发一个 PCP 请求，返回回包里 opcode 相关的部分和有效期
+func (c *pcp) request(ctx context.Context, op byte, lifetime time.Duration, payload []byte) ([]byte, error) {
+	req := make([]byte, pcpHeaderSize, pcpHeaderSize+len(payload))
+	req[0] = pcpVersion
+	req[1] = op
+	binary.BigEndian.PutUint32(req[4:], uint32(lifetime/time.Second))
+	copy(req[8:24], c.client.To16())
+	req = append(req, payload...)
+
+	resp, err := roundTrip(ctx, c.net, c.gw, req, func(b []byte) bool {
+		// 只支持 NAT-PMP 的网关会回一个版本号为 0 的错误
+		return len(b) >= 4 && (b[0] == pcpVersion && b[1] == op|pcpResponseBit || b[0] == natpmpVersion)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp[0] != pcpVersion {
+		return nil, fmt.Errorf("gateway does not support pcp")
+	}
+	if code := resp[3]; code != 0 {
+		return nil, fmt.Errorf("pcp result code %d", code)
+	}
+	if len(resp) < pcpHeaderSize+len(payload) {
+		return nil, fmt.Errorf("short pcp response: %d bytes", len(resp))
+	}
+	return resp, nil
+}
+
+func (c *pcp) mapRequest(ctx context.Context, internalPort, externalPort int, lifetime time.Duration) ([]byte, error) {
+	payload := make([]byte, pcpMapSize)
+	copy(payload, c.nonce[:])
+	payload[12] = protocolUDP
+	binary.BigEndian.PutUint16(payload[16:], uint16(internalPort))
+	binary.BigEndian.PutUint16(payload[18:], uint16(externalPort))
+	copy(payload[20:], net.IPv4zero.To16())
+	resp, err := c.request(ctx, pcpOpMap, lifetime, payload)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(resp[pcpHeaderSize:pcpHeaderSize+12], c.nonce[:]) {
+		return nil, fmt.Errorf("pcp nonce mismatch")
+	}
+	return resp, nil
+}
+
+func (c *pcp) Map(ctx context.Context, internalPort int, lifetime time.Duration) (*Mapping, error) {
+	resp, err := c.mapRequest(ctx, internalPort, internalPort, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	body := resp[pcpHeaderSize:]
+	return &Mapping{
+		Protocol:     c.Protocol(),
+		InternalPort: internalPort,
+		External: &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), body[20:36]...)).To4(),
+			Port: int(binary.BigEndian.Uint16(body[18:])),
+		},
+		Lifetime: time.Duration(binary.BigEndian.Uint32(resp[4:])) * time.Second,
+	}, nil
+}
+
+func (c *pcp) Unmap(ctx context.Context, m *Mapping) error {
+	_, err := c.mapRequest(ctx, m.InternalPort, 0, 0)
+	return err
+}
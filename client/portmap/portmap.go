@@ -0,0 +1,211 @@
+// Package portmap 通过网关的 PCP、NAT-PMP 或 UPnP IGD 接口申请端口映射，
+// 映射成功后对端直接连映射出来的地址，不用打洞
+package portmap
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+	"golang.org/x/net/context"
+)
+
+var ErrNoGateway = errors.New("no port mapping gateway found")
+
+// Mapping 是网关上的一个 UDP 端口映射
+type Mapping struct {
+	Protocol     string // "pcp"、"nat-pmp" 或 "upnp"
+	InternalPort int
+	External     *net.UDPAddr
+	Lifetime     time.Duration // 0 表示网关只支持永久映射
+}
+
+// Mapper 是一种端口映射协议的客户端
+type Mapper interface {
+	Protocol() string
+	// Map 申请或续约 internalPort 的映射，lifetime 是希望的有效期
+	Map(ctx context.Context, internalPort int, lifetime time.Duration) (*Mapping, error)
+	// Unmap 删除映射
+	Unmap(ctx context.Context, m *Mapping) error
+}
+
+type Options struct {
+	// Gateway 为空时用默认路由的网关
+	Gateway net.IP
+	// GatewayPort 是 PCP 和 NAT-PMP 的服务端口，默认 5351，测试时改
+	GatewayPort int
+	// SSDPAddr 是 UPnP 发现请求的目的地址，默认 239.255.255.250:1900
+	SSDPAddr string
+	// Timeout 是每种协议发现的超时
+	Timeout  time.Duration
+	Lifetime time.Duration
+	Logger   *slog.Logger
+	// Network 为 nil 时用系统的套接字
+	Network public.Network
+}
+
+func (o *Options) setDefaults() {
+	if o.GatewayPort == 0 {
+		o.GatewayPort = 5351
+	}
+	if o.SSDPAddr == "" {
+		o.SSDPAddr = "239.255.255.250:1900"
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Lifetime <= 0 {
+		// RFC 6886 建议的默认值
+		o.Lifetime = 2 * time.Hour
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+// Discover 同时探测 PCP、NAT-PMP 和 UPnP，按这个顺序返回第一个可用的
+func Discover(ctx context.Context, opts Options) (Mapper, error) {
+	opts.setDefaults()
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	type probe struct {
+		protocol string
+		discover func(context.Context) (Mapper, error)
+	}
+	var probes []probe
+	gw := opts.Gateway
+	if gw == nil {
+		var err error
+		if gw, err = DefaultGateway(); err != nil {
+			opts.Logger.Debug("default gateway unknown", "err", err)
+		}
+	}
+	if gw != nil {
+		gaddr := &net.UDPAddr{IP: gw, Port: opts.GatewayPort}
+		probes = append(probes,
+			probe{"pcp", func(ctx context.Context) (Mapper, error) { return discoverPCP(ctx, opts.Network, gaddr) }},
+			probe{"nat-pmp", func(ctx context.Context) (Mapper, error) { return discoverNATPMP(ctx, opts.Network, gaddr) }})
+	}
+	probes = append(probes, probe{"upnp", func(ctx context.Context) (Mapper, error) { return discoverUPnP(ctx, opts.Network, opts.SSDPAddr) }})
+
+	found := make([]Mapper, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p probe) {
+			defer wg.Done()
+			m, err := p.discover(ctx)
+			if err != nil {
+				opts.Logger.Debug("port mapping probe failed", "protocol", p.protocol, "err", err)
+				return
+			}
+			found[i] = m
+		}(i, p)
+	}
+	wg.Wait()
+	for _, m := range found {
+		if m != nil {
+			return m, nil
+		}
+	}
+	return nil, ErrNoGateway
+}
+
+// Maintain 申请 port 的映射并在有效期过半时续约，映射地址变化时调用 onChange，
+// ctx 结束后删除映射再返回
+func Maintain(ctx context.Context, m Mapper, port int, opts Options, onChange func(*Mapping)) {
+	opts.setDefaults()
+	logger := opts.Logger.With("protocol", m.Protocol(), "port", port)
+	var cur *Mapping
+	retry := 5 * time.Second
+	for {
+		mapCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		next, err := m.Map(mapCtx, port, opts.Lifetime)
+		cancel()
+		wait := retry
+		if err != nil {
+			logger.Warn("port mapping failed", "err", err)
+			retry = min(retry*2, 5*time.Minute)
+		} else {
+			retry = 5 * time.Second
+			if cur == nil || cur.External.String() != next.External.String() {
+				logger.Info("port mapped", "external", next.External.String(), "lifetime", next.Lifetime)
+				onChange(next)
+			}
+			cur = next
+			wait = cur.Lifetime / 2
+			if wait <= 0 {
+				// 永久映射也定期检查一下，网关重启后映射会丢
+				wait = opts.Lifetime / 2
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			if cur != nil {
+				unmapCtx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+				if err := m.Unmap(unmapCtx, cur); err != nil {
+					logger.Warn("remove port mapping failed", "err", err)
+				} else {
+					logger.Info("port mapping removed", "external", cur.External.String())
+				}
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+// localIP 返回发往 dst 时使用的本地地址
+func localIP(network public.Network, dst net.IP) (net.IP, error) {
+	c, err := public.DialUDP(network, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// roundTrip 向网关发请求并等待回包，按 RFC 6886 从 250ms 开始成倍重发，
+// accept 返回 false 的回包忽略
+func roundTrip(ctx context.Context, network public.Network, gw *net.UDPAddr, req []byte, accept func([]byte) bool) ([]byte, error) {
+	conn, err := public.DialUDP(network, gw)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	buf := make([]byte, 1100)
+	interval := 250 * time.Millisecond
+	for {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(interval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				// 网关没开这个端口时会收到 ICMP 端口不可达
+				return nil, err
+			}
+			if accept(buf[:n]) {
+				return buf[:n], nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		interval *= 2
+	}
+}
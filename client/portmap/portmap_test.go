@@ -0,0 +1,379 @@
+package portmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+	"github.com/jinyunx/p2p/public/netsim"
+	"golang.org/x/net/context"
+)
+
+var externalIP = net.IPv4(203, 0, 113, 7).To4()
+
+// fakeGateway 同时实现 NAT-PMP 和 PCP，pcp 为 false 时像老路由器一样只认 NAT-PMP
+type fakeGateway struct {
+	conn public.PacketConn
+	pcp  bool
+
+	mu       sync.Mutex
+	mappings map[int]int // 内部端口 -> 外部端口
+}
+
+func startGateway(t *testing.T, pcp bool) *fakeGateway {
+	return startGatewayOn(t, nil, net.IPv4(127, 0, 0, 1), pcp)
+}
+
+// startGatewayOn 在 network 的 ip 上启动网关
+func startGatewayOn(t *testing.T, network public.Network, ip net.IP, pcp bool) *fakeGateway {
+	conn, err := public.ListenUDP(network, &net.UDPAddr{IP: ip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	g := &fakeGateway{conn: conn, pcp: pcp, mappings: make(map[int]int)}
+	go g.serve()
+	return g
+}
+
+func (g *fakeGateway) port() int {
+	return g.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (g *fakeGateway) mapped(internal int) (int, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ext, ok := g.mappings[internal]
+	return ext, ok
+}
+
+func (g *fakeGateway) update(internal, external int, lifetime uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if lifetime == 0 {
+		delete(g.mappings, internal)
+	} else {
+		g.mappings[internal] = external
+	}
+}
+
+func (g *fakeGateway) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if resp := g.handle(buf[:n]); resp != nil {
+			g.conn.WriteToUDP(resp, addr)
+		}
+	}
+}
+
+func (g *fakeGateway) handle(req []byte) []byte {
+	switch {
+	case req[0] == natpmpVersion && req[1] == natpmpOpAddr:
+		resp := make([]byte, 12)
+		resp[1] = natpmpOpAddr | natpmpResponseBit
+		copy(resp[8:], externalIP)
+		return resp
+	case req[0] == natpmpVersion && req[1] == natpmpOpMapUDP:
+		internal := int(binary.BigEndian.Uint16(req[4:]))
+		lifetime := binary.BigEndian.Uint32(req[8:])
+		g.update(internal, internal+1000, lifetime)
+		resp := make([]byte, 16)
+		resp[1] = natpmpOpMapUDP | natpmpResponseBit
+		binary.BigEndian.PutUint16(resp[8:], uint16(internal))
+		binary.BigEndian.PutUint16(resp[10:], uint16(internal+1000))
+		binary.BigEndian.PutUint32(resp[12:], lifetime)
+		return resp
+	case req[0] == pcpVersion && !g.pcp:
+		// RFC 6887 第 9 节：NAT-PMP 服务器回 UNSUPP_VERSION
+		return []byte{natpmpVersion, req[1] | natpmpResponseBit, 0, 1}
+	case req[0] == pcpVersion:
+		resp := make([]byte, len(req))
+		copy(resp, req)
+		resp[1] |= pcpResponseBit
+		resp[3] = 0
+		copy(resp[8:24], make([]byte, 16))
+		if req[1] == pcpOpMap {
+			body := resp[pcpHeaderSize:]
+			internal := int(binary.BigEndian.Uint16(body[16:]))
+			g.update(internal, internal+2000, binary.BigEndian.Uint32(req[4:]))
+			binary.BigEndian.PutUint16(body[18:], uint16(internal+2000))
+			copy(body[20:], externalIP.To16())
+		}
+		return resp
+	}
+	return nil
+}
+
+// closedPort 返回一个没人监听的本地 UDP 端口
+func closedPort(t *testing.T) int {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+func testOptions(t *testing.T, gatewayPort int, ssdp string) Options {
+	if ssdp == "" {
+		ssdp = fmt.Sprintf("127.0.0.1:%d", closedPort(t))
+	}
+	return Options{
+		Gateway:     net.IPv4(127, 0, 0, 1),
+		GatewayPort: gatewayPort,
+		SSDPAddr:    ssdp,
+		Timeout:     500 * time.Millisecond,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func checkMapping(t *testing.T, m Mapper, protocol string, internal, external int) *Mapping {
+	t.Helper()
+	if m.Protocol() != protocol {
+		t.Fatalf("protocol = %s, want %s", m.Protocol(), protocol)
+	}
+	mapping, err := m.Map(context.Background(), internal, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &net.UDPAddr{IP: externalIP, Port: external}
+	if mapping.External.String() != want.String() {
+		t.Fatalf("external = %v, want %v", mapping.External, want)
+	}
+	return mapping
+}
+
+func TestPCP(t *testing.T) {
+	g := startGateway(t, true)
+	m, err := Discover(context.Background(), testOptions(t, g.port(), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := checkMapping(t, m, "pcp", 40000, 42000)
+	if mapping.Lifetime != time.Hour {
+		t.Fatalf("lifetime = %v", mapping.Lifetime)
+	}
+	if err := m.Unmap(context.Background(), mapping); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mapped(40000); ok {
+		t.Fatal("mapping not removed")
+	}
+}
+
+func TestPCPSimulated(t *testing.T) {
+	n := netsim.New(1, netsim.LinkOptions{Latency: time.Millisecond})
+	nat := n.NAT("203.0.113.7", netsim.NatOptions{})
+	gw := nat.Host("10.0.0.1")
+	g := startGatewayOn(t, gw, gw.IP(), true)
+	opts := testOptions(t, g.port(), "239.255.255.250:1900")
+	opts.Gateway = gw.IP()
+	opts.Network = nat.Host("10.0.0.2")
+	m, err := Discover(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMapping(t, m, "pcp", 40000, 42000)
+	if ip := m.(*pcp).client; !ip.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("pcp client address = %v", ip)
+	}
+}
+
+func TestNATPMPFallback(t *testing.T) {
+	g := startGateway(t, false)
+	m, err := Discover(context.Background(), testOptions(t, g.port(), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := checkMapping(t, m, "nat-pmp", 40000, 41000)
+	if err := m.Unmap(context.Background(), mapping); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mapped(40000); ok {
+		t.Fatal("mapping not removed")
+	}
+}
+
+func TestNoGateway(t *testing.T) {
+	_, err := Discover(context.Background(), testOptions(t, closedPort(t), ""))
+	if err != ErrNoGateway {
+		t.Fatalf("err = %v, want ErrNoGateway", err)
+	}
+}
+
+// fakeIGD 是 SSDP 应答加上 HTTP 的设备描述和 SOAP 控制接口
+type fakeIGD struct {
+	ssdp *net.UDPConn
+	http *httptest.Server
+
+	mu        sync.Mutex
+	mappings  map[string]string // 外部端口 -> 内部地址
+	permanent bool              // 只接受永久映射
+}
+
+const igdDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+func startIGD(t *testing.T, permanent bool) *fakeIGD {
+	g := &fakeIGD{mappings: make(map[string]string), permanent: permanent}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, igdDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", g.control)
+	g.http = httptest.NewServer(mux)
+	t.Cleanup(g.http.Close)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	g.ssdp = conn
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+				continue
+			}
+			resp := "HTTP/1.1 200 OK\r\n" +
+				"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+				"LOCATION: " + g.http.URL + "/desc.xml\r\n\r\n"
+			conn.WriteToUDP([]byte(resp), addr)
+		}
+	}()
+	return g
+}
+
+func (g *fakeIGD) control(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	_, action, _ = strings.Cut(action, "#")
+	args, err := parseSOAP(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out string
+	switch action {
+	case "GetExternalIPAddress":
+		out = "<NewExternalIPAddress>" + externalIP.String() + "</NewExternalIPAddress>"
+	case "AddPortMapping":
+		if g.permanent && args["NewLeaseDuration"] != "0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+				`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+				`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode>`+
+				`<errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError>`+
+				`</detail></s:Fault></s:Body></s:Envelope>`)
+			return
+		}
+		g.mappings[args["NewExternalPort"]] = args["NewInternalClient"] + ":" + args["NewInternalPort"]
+	case "DeletePortMapping":
+		delete(g.mappings, args["NewExternalPort"])
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+		`<u:`+action+`Response xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">`+out+
+		`</u:`+action+`Response></s:Body></s:Envelope>`)
+}
+
+func (g *fakeIGD) mapping(port string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.mappings[port]
+}
+
+func TestUPnP(t *testing.T) {
+	for _, permanent := range []bool{false, true} {
+		t.Run(fmt.Sprintf("permanent=%v", permanent), func(t *testing.T) {
+			g := startIGD(t, permanent)
+			m, err := Discover(context.Background(), testOptions(t, closedPort(t), g.ssdp.LocalAddr().String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mapping := checkMapping(t, m, "upnp", 40000, 40000)
+			if got := g.mapping("40000"); got != "127.0.0.1:40000" {
+				t.Fatalf("gateway mapping = %q", got)
+			}
+			if permanent != (mapping.Lifetime == 0) {
+				t.Fatalf("lifetime = %v", mapping.Lifetime)
+			}
+			if err := m.Unmap(context.Background(), mapping); err != nil {
+				t.Fatal(err)
+			}
+			if got := g.mapping("40000"); got != "" {
+				t.Fatalf("mapping not removed: %q", got)
+			}
+		})
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	g := startGateway(t, true)
+	opts := testOptions(t, g.port(), "")
+	m, err := Discover(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan *Mapping, 1)
+	done := make(chan struct{})
+	go func() {
+		Maintain(ctx, m, 40000, opts, func(m *Mapping) { changed <- m })
+		close(done)
+	}()
+	select {
+	case mapping := <-changed:
+		if mapping.External.Port != 42000 {
+			t.Fatalf("external = %v", mapping.External)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no mapping")
+	}
+	cancel()
+	<-done
+	if _, ok := g.mapped(40000); ok {
+		t.Fatal("mapping not removed on exit")
+	}
+}
@@ -0,0 +1,284 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+	"golang.org/x/net/context"
+)
+
+// UPnP IGD，只用 WANIPConnection / WANPPPConnection 的三个动作
+var igdServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// errOnlyPermanent 对应 UPnP 错误码 725，网关只支持永久映射
+const errOnlyPermanent = 725
+
+type upnp struct {
+	control string // SOAP 控制地址
+	service string
+	client  net.IP // 网关看到的本机地址
+}
+
+func discoverUPnP(ctx context.Context, network public.Network, ssdpAddr string) (Mapper, error) {
+	location, err := ssdpSearch(ctx, network, ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	control, service, err := fetchControl(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(control)
+	if err != nil {
+		return nil, err
+	}
+	host, err := net.ResolveIPAddr("ip4", u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	ip, err := localIP(network, host.IP)
+	if err != nil {
+		return nil, err
+	}
+	return &upnp{control: control, service: service, client: ip}, nil
+}
+
+// ssdpSearch 发 M-SEARCH 找 IGD，返回设备描述的地址
+func ssdpSearch(ctx context.Context, network public.Network, ssdpAddr string) (string, error) {
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	conn, err := public.ListenUDP(network, nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(req), addr); err != nil {
+		return "", err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(d)
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findService 在设备树里找第一个 IGD 连接服务
+func (d *upnpDevice) findService(serviceType string) string {
+	for _, s := range d.Services {
+		if s.ServiceType == serviceType {
+			return s.ControlURL
+		}
+	}
+	for i := range d.Devices {
+		if c := d.Devices[i].findService(serviceType); c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// fetchControl 读设备描述，返回 SOAP 控制地址和服务类型
+func fetchControl(ctx context.Context, location string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc); err != nil {
+		return "", "", fmt.Errorf("invalid device description: %w", err)
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if desc.URLBase != "" {
+		if base, err = url.Parse(desc.URLBase); err != nil {
+			return "", "", err
+		}
+	}
+	for _, service := range igdServices {
+		if c := desc.Device.findService(service); c != "" {
+			ref, err := url.Parse(c)
+			if err != nil {
+				return "", "", err
+			}
+			return base.ResolveReference(ref).String(), service, nil
+		}
+	}
+	return "", "", errors.New("no wan connection service in device description")
+}
+
+type soapError struct {
+	Code        int
+	Description string
+}
+
+func (e *soapError) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.Code, e.Description)
+}
+
+// call 调用一个 SOAP 动作，返回响应里的参数
+func (c *upnp) call(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + c.service + `">`)
+	for _, a := range args {
+		body.WriteString("<" + a[0] + ">")
+		xml.EscapeText(&body, []byte(a[1]))
+		body.WriteString("</" + a[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.control, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+c.service+"#"+action+`"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := parseSOAP(io.LimitReader(resp.Body, 1<<20))
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("upnp %s: %s", action, resp.Status)
+	}
+	return out, err
+}
+
+// parseSOAP 把响应体里的叶子元素收集起来，出错时返回 soapError
+func parseSOAP(r io.Reader) (map[string]string, error) {
+	out := make(map[string]string)
+	dec := xml.NewDecoder(r)
+	var name string
+	var text []byte
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name, text = t.Name.Local, nil
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			if name == t.Name.Local {
+				out[name] = strings.TrimSpace(string(text))
+			}
+			name = ""
+		}
+	}
+	if code, ok := out["errorCode"]; ok {
+		n, _ := strconv.Atoi(code)
+		return nil, &soapError{Code: n, Description: out["errorDescription"]}
+	}
+	return out, nil
+}
+
+func (c *upnp) Protocol() string {
+	return "upnp"
+}
+
+func (c *upnp) Map(ctx context.Context, internalPort int, lifetime time.Duration) (*Mapping, error) {
+	res, err := c.call(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(res["NewExternalIPAddress"]).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external ip %q", res["NewExternalIPAddress"])
+	}
+	add := func(lifetime time.Duration) error {
+		_, err := c.call(ctx, "AddPortMapping", [][2]string{
+			{"NewRemoteHost", ""},
+			{"NewExternalPort", strconv.Itoa(internalPort)},
+			{"NewProtocol", "UDP"},
+			{"NewInternalPort", strconv.Itoa(internalPort)},
+			{"NewInternalClient", c.client.String()},
+			{"NewEnabled", "1"},
+			{"NewPortMappingDescription", "p2p"},
+			{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+		})
+		return err
+	}
+	err = add(lifetime)
+	var se *soapError
+	if errors.As(err, &se) && se.Code == errOnlyPermanent {
+		lifetime = 0
+		err = add(0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{
+		Protocol:     c.Protocol(),
+		InternalPort: internalPort,
+		External:     &net.UDPAddr{IP: ip, Port: internalPort},
+		Lifetime:     lifetime,
+	}, nil
+}
+
+func (c *upnp) Unmap(ctx context.Context, m *Mapping) error {
+	_, err := c.call(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(m.External.Port)},
+		{"NewProtocol", "UDP"},
+	})
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	punchTimeout = 30 * time.Second
+	// punchStale 内没收到对端的包就请服务器协调打洞，间隔至少 punchRetry
+	punchStale = 30 * time.Second
+	punchRetry = 15 * time.Second
+)
+
+// relayAfter 返回 peer.Options.RelayAfter：服务器转发中继包时，
+// 打洞没成功或者直连断了的对端经服务器中转，打通以后切回直连
+func relayAfter(conf *pb.GetServerConfigResp, server string) time.Duration {
+	if !conf.GetRelay() {
+		logger.Info("server does not relay, nodes that cannot be punched are unreachable", "server", server)
+		return 0
+	}
+	return punchStale
+}
+
+// connectPeers 把注册表里对端的地址告诉节点，punchStale 内没收到包的请服务器协调打洞，
+// lastPunch 记录每个对端上次请求打洞的时间
+func connectPeers(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, targets []*pb.NodeInfo, lastPunch map[string]time.Time) {
+	for _, info := range targets {
+		name := info.GetName()
+		cands := peer.Candidates(info)
+		if len(cands) == 0 {
+			continue
+		}
+		node.AddPeer(name, cands[0])
+		p, _ := node.Peer(name)
+		if time.Since(p.LastSeen) > punchStale && time.Since(lastPunch[name]) > punchRetry {
+			lastPunch[name] = time.Now()
+			go requestPunch(ctx, rdv, node, name)
+		}
+	}
+}
+
+type punchState struct {
+	addr     string
+	start    time.Time
+	reported bool
+}
+
+// punchTracker 记录每个对端的打洞开始时间，收到对端数据或超时后上报一次结果
+type punchTracker struct {
+	mu    sync.Mutex
+	peers map[string]*punchState
+}
+
+func newPunchTracker() *punchTracker {
+	return &punchTracker{peers: make(map[string]*punchState)}
+}
+
+func (t *punchTracker) start(name string, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.peers[name]; ok && p.addr == addr {
+		return
+	}
+	t.peers[name] = &punchState{addr: addr, start: time.Now()}
+}
+
+func (t *punchTracker) received(addr *net.UDPAddr) (string, time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, p := range t.peers {
+		if p.addr == addr.String() && !p.reported {
+			p.reported = true
+			return name, time.Since(p.start), true
+		}
+	}
+	return "", 0, false
+}
+
+func (t *punchTracker) expired() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []string
+	for name, p := range t.peers {
+		if !p.reported && time.Since(p.start) > punchTimeout {
+			p.reported = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func reportPunch(rdv *comm.Rendezvous, name string, peer string, success bool, elapsed time.Duration) {
+	err := rdv.ReportPunch(context.Background(), &pb.ReportPunchReq{
+		Name:      name,
+		Peer:      peer,
+		Success:   success,
+		ElapsedMs: elapsed.Milliseconds(),
+	})
+	if err != nil {
+		logger.Warn("ReportPunch failed", "peer", peer, "err", err)
+	}
+}
+
+// detectNatType 返回注册时上报的 NAT 类型，服务器按它统计 nodes_by_nat_type。
+// 服务器开了 STUN 时用 RFC 5780 探测的结果，STUN 不通时退回 guessNatType
+func detectNatType(ctx context.Context, rdv *comm.Rendezvous, lport int, reflexive *pb.UDPAddr) pb.NatType {
+	b, err := discoverNat(ctx, rdv)
+	if err == nil && b.Mapped != nil {
+		return b.NatType()
+	}
+	logger.Debug("nat behavior discovery failed, guessing from the reflexive address", "err", err)
+	return guessNatType(lport, reflexive)
+}
+
+// guessNatType 只能区分公网直连，完整的探测需要 STUN 服务器配合
+func guessNatType(lport int, updAddr *pb.UDPAddr) pb.NatType {
+	if int(updAddr.GetPort()) != lport {
+		return pb.NatType_NatType_Unknown
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return pb.NatType_NatType_Unknown
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.String() == updAddr.GetIp() {
+			return pb.NatType_NatType_Open
+		}
+	}
+	return pb.NatType_NatType_Unknown
+}
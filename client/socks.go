@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/socks5"
+	"github.com/jinyunx/p2p/client/tunnel"
+	"golang.org/x/net/context"
+)
+
+// runSocks5 在本机开 SOCKS5 代理，连接经过 via 节点出去。via 要用
+// forward -exit-allow 开启出口，目标是否允许由它的规则决定
+func runSocks5(args []string) {
+	fs := flag.NewFlagSet("socks5", flag.ExitOnError)
+	tf := addTunnelFlags(fs)
+	listen := fs.String("listen", "127.0.0.1:1080", "address of the local socks5 server")
+	via := fs.String("via", "", "peer used as the egress, it must run forward with -exit-allow")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] socks5 [socks5 flags] -via peer servers\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *via == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	servers, err := comm.ResolveServers(fs.Arg(0))
+	if err != nil {
+		fatal("invalid server list", "err", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fatal("listen failed", "addr", *listen, "err", err)
+	}
+	tun := startTunnel(ctx, servers, tf, tunnel.Options{}, []string{*via})
+	if tun == nil {
+		return
+	}
+	server := &socks5.Server{
+		Connect: func(ctx context.Context, conn net.Conn, dest string, ready func(error) error) error {
+			return tun.Connect(ctx, conn, *via, dest, ready)
+		},
+		ReplyCode: socksReply,
+		Logger:    logger,
+	}
+	logger.Info("socks5 proxy", "addr", ln.Addr().String(), "via", *via)
+	if err := server.Serve(ctx, ln); err != nil {
+		fatal("socks5 server failed", "err", err)
+	}
+}
+
+// socksReply 把隧道的错误转成 SOCKS5 回复码
+func socksReply(err error) byte {
+	switch {
+	case errors.Is(err, tunnel.ErrRefused):
+		return socks5.ReplyNotAllowed
+	case errors.Is(err, tunnel.ErrUnreachable):
+		return socks5.ReplyHostUnreachable
+	default:
+		return socks5.ReplyFailure
+	}
+}
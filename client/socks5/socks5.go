@@ -0,0 +1,173 @@
+// Package socks5 实现 SOCKS5 服务端（RFC 1928）的 CONNECT 命令，
+// 只支持不认证，不支持 BIND 和 UDP ASSOCIATE
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	version            = 5
+	methodNone         = 0
+	methodNoAcceptable = 0xff
+	cmdConnect         = 1
+	atypIPv4           = 1
+	atypDomain         = 3
+	atypIPv6           = 4
+)
+
+// 回复码
+const (
+	ReplySucceeded           = 0
+	ReplyFailure             = 1
+	ReplyNotAllowed          = 2
+	ReplyNetworkUnreachable  = 3
+	ReplyHostUnreachable     = 4
+	ReplyConnectionRefused   = 5
+	ReplyCommandNotSupported = 7
+	ReplyAddressNotSupported = 8
+)
+
+// ConnectFunc 连接 dest 并在 conn 和目标之间转发，连接结束后返回。
+// 连上目标或者失败时要先用结果调用 ready，服务端在 ready 里回复客户端
+type ConnectFunc func(ctx context.Context, conn net.Conn, dest string, ready func(error) error) error
+
+type Server struct {
+	Connect ConnectFunc
+	// ReplyCode 把连接失败的错误转成回复码，为 nil 时都回 ReplyFailure
+	ReplyCode func(error) byte
+	// HandshakeTimeout 是读完客户端请求的超时
+	HandshakeTimeout time.Duration
+	Logger           *slog.Logger
+}
+
+// Serve 接受连接直到 ctx 结束或者 ln 关闭
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(ctx, conn)
+	}
+}
+
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	dest, err := handshake(conn)
+	if err != nil {
+		logger.Debug("socks5 handshake failed", "client", conn.RemoteAddr().String(), "err", err)
+		conn.Close()
+		return
+	}
+	ready := func(err error) error {
+		code := byte(ReplySucceeded)
+		if err != nil {
+			code = ReplyFailure
+			if s.ReplyCode != nil {
+				code = s.ReplyCode(err)
+			}
+		}
+		conn.SetDeadline(time.Time{})
+		return writeReply(conn, code)
+	}
+	if err := s.Connect(ctx, conn, dest, ready); err != nil {
+		logger.Info("socks5 connect failed", "client", conn.RemoteAddr().String(), "dest", dest, "err", err)
+	}
+	conn.Close()
+}
+
+// handshake 协商认证方式并读取 CONNECT 请求，返回目标的 host:port
+func handshake(conn net.Conn) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != version {
+		return "", fmt.Errorf("unsupported version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	if !slices.Contains(methods, methodNone) {
+		conn.Write([]byte{version, methodNoAcceptable})
+		return "", errors.New("client requires authentication")
+	}
+	if _, err := conn.Write([]byte{version, methodNone}); err != nil {
+		return "", err
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != version {
+		return "", fmt.Errorf("unsupported version %d", req[0])
+	}
+	if req[1] != cmdConnect {
+		writeReply(conn, ReplyCommandNotSupported)
+		return "", fmt.Errorf("unsupported command %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		writeReply(conn, ReplyAddressNotSupported)
+		return "", fmt.Errorf("unsupported address type %d", req[3])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// writeReply 回复客户端，绑定地址填全 0，经隧道转发时本地地址没有意义
+func writeReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{version, code, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
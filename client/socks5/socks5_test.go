@@ -0,0 +1,106 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/proxy"
+)
+
+var errDenied = errors.New("denied")
+
+// startServer 起一个 SOCKS5 服务，目标是 deny 时拒绝，其他的直接连本机的 backend
+func startServer(t *testing.T, backend string, dests chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Connect: func(ctx context.Context, conn net.Conn, dest string, ready func(error) error) error {
+			dests <- dest
+			if strings.HasPrefix(dest, "deny") {
+				ready(errDenied)
+				return errDenied
+			}
+			target, err := net.Dial("tcp", backend)
+			if rerr := ready(err); err != nil || rerr != nil {
+				return err
+			}
+			defer target.Close()
+			go io.Copy(target, conn)
+			io.Copy(conn, target)
+			return nil
+		},
+		ReplyCode: func(err error) byte {
+			if errors.Is(err, errDenied) {
+				return ReplyNotAllowed
+			}
+			return ReplyFailure
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx, ln)
+	return ln.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+
+	dests := make(chan string, 2)
+	dialer, err := proxy.SOCKS5("tcp", startServer(t, backend.Addr().String(), dests), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := <-dests; got != "example.com:80" {
+		t.Fatalf("dest = %q", got)
+	}
+	data, _ := io.ReadAll(conn)
+	if string(data) != "hello" {
+		t.Fatalf("read %q", data)
+	}
+
+	if _, err := dialer.Dial("tcp", "deny.example.com:443"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("err = %v, want not allowed", err)
+	}
+}
+
+func TestAuthRequired(t *testing.T) {
+	conn, err := net.Dial("tcp", startServer(t, "", make(chan string, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 只提供用户名密码认证
+	conn.Write([]byte{5, 1, 2})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != methodNoAcceptable {
+		t.Fatalf("reply = %v", reply)
+	}
+}
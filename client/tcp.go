@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/peer"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// tcpSessions 记录已经打通的 TCP 连接，同一个对端只保留一条
+type tcpSessions struct {
+	rdv  *comm.Rendezvous
+	node *peer.Node
+
+	mu        sync.Mutex
+	active    map[string]bool
+	lastPunch map[string]time.Time
+}
+
+func newTCPSessions(rdv *comm.Rendezvous, node *peer.Node) *tcpSessions {
+	return &tcpSessions{
+		rdv:       rdv,
+		node:      node,
+		active:    make(map[string]bool),
+		lastPunch: make(map[string]time.Time),
+	}
+}
+
+// maybePunch 和 target 还没有 TCP 连接时请服务器协调双方同时连接
+func (s *tcpSessions) maybePunch(ctx context.Context, target *pb.NodeInfo) {
+	if target.GetTcpAddr() == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.active[target.Name] || time.Since(s.lastPunch[target.Name]) < punchRetry {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPunch[target.Name] = time.Now()
+	s.mu.Unlock()
+
+	go func() {
+		resp, err := s.rdv.RequestPunch(ctx, &pb.RequestPunchReq{Name: s.node.Name(), Peer: target.Name, Tcp: true})
+		if err != nil {
+			logger.Warn("RequestPunch failed", "peer", target.Name, "tcp", true, "err", err)
+			return
+		}
+		s.punch(ctx, resp.GetPeer(), time.Duration(resp.GetDelayMs())*time.Millisecond)
+	}()
+}
+
+// punch 打通后在连接上每隔几秒发一次 hello，直到连接断开
+func (s *tcpSessions) punch(ctx context.Context, info *pb.NodeInfo, delay time.Duration) {
+	name := info.GetName()
+	s.mu.Lock()
+	if s.active[name] {
+		s.mu.Unlock()
+		return
+	}
+	s.active[name] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.active, name)
+		s.mu.Unlock()
+	}()
+
+	conn, err := s.node.PunchTCP(ctx, info, delay)
+	if err != nil {
+		logger.Warn("tcp punch failed", "peer", name, "err", err)
+		return
+	}
+	defer conn.Close()
+	go s.send(conn, name)
+	s.receive(conn, name)
+}
+
+func (s *tcpSessions) send(conn net.Conn, name string) {
+	for {
+		text := fmt.Sprintf("hello %s over tcp, my name is %s", name, s.node.Name())
+		msg := &pb.PeerMsg{Body: &pb.PeerMsg_Hello{Hello: &pb.PeerHello{Text: text}}}
+		if err := s.node.WriteMsg(conn, msg); err != nil {
+			return
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (s *tcpSessions) receive(conn net.Conn, name string) {
+	for {
+		msg, err := peer.ReadMsg(conn)
+		if err != nil {
+			logger.Info("tcp connection closed", "peer", name, "err", err)
+			return
+		}
+		if text := msg.GetHello().GetText(); text != "" {
+			logger.Info("received", "peer", name, "peer_addr", conn.RemoteAddr().String(), "tcp", true, "data", text)
+		}
+	}
+}
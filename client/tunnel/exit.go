@@ -0,0 +1,151 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// errDenied 表示出口策略不允许连这个目标
+var errDenied = errors.New("destination denied by exit policy")
+
+// ExitRule 匹配目标地址，Net 为 nil 匹配所有地址，Ports 为空匹配所有端口
+type ExitRule struct {
+	Net   *net.IPNet
+	Ports []int
+}
+
+func (r ExitRule) match(ip net.IP, port int) bool {
+	if r.Net != nil && !r.Net.Contains(ip) {
+		return false
+	}
+	return len(r.Ports) == 0 || slices.Contains(r.Ports, port)
+}
+
+func (r ExitRule) String() string {
+	s := "*"
+	if r.Net != nil {
+		s = r.Net.String()
+		if r.Net.IP.To4() == nil {
+			s = "[" + s + "]"
+		}
+	}
+	if len(r.Ports) > 0 {
+		var ports []string
+		for _, p := range r.Ports {
+			ports = append(ports, strconv.Itoa(p))
+		}
+		s += ":" + strings.Join(ports, ",")
+	}
+	return s
+}
+
+// ParseExitRule 解析 addr[:port,port...]，addr 是 CIDR、单个 IP 或者表示任意地址的 *，
+// IPv6 地址要放在方括号里，比如 [2001:db8::/32]:443
+func ParseExitRule(s string) (ExitRule, error) {
+	addr, ports := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return ExitRule{}, fmt.Errorf("invalid exit rule %q", s)
+		}
+		rest := s[end+1:]
+		if rest != "" && !strings.HasPrefix(rest, ":") {
+			return ExitRule{}, fmt.Errorf("invalid exit rule %q", s)
+		}
+		addr, ports = s[1:end], strings.TrimPrefix(rest, ":")
+	} else if i := strings.Index(s, ":"); i >= 0 {
+		addr, ports = s[:i], s[i+1:]
+	}
+	var r ExitRule
+	switch {
+	case addr == "*":
+	case strings.Contains(addr, "/"):
+		_, ipnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return ExitRule{}, fmt.Errorf("invalid exit rule %q: %w", s, err)
+		}
+		r.Net = ipnet
+	default:
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return ExitRule{}, fmt.Errorf("invalid exit rule %q: bad address", s)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		r.Net = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	if ports != "" {
+		for _, f := range strings.Split(ports, ",") {
+			port, err := strconv.Atoi(f)
+			if err != nil || port <= 0 || port > 65535 {
+				return ExitRule{}, fmt.Errorf("invalid exit rule %q: bad port %q", s, f)
+			}
+			r.Ports = append(r.Ports, port)
+		}
+	}
+	return r, nil
+}
+
+// ExitPolicy 决定作为出口时替对端连哪些目标
+type ExitPolicy struct {
+	Allow []ExitRule
+	Deny  []ExitRule
+}
+
+// Permit 先看 Deny 再看 Allow，都不匹配时拒绝。本机的回环和未指定地址总是拒绝，
+// 本机的服务要通过 Options.Allow 按端口开放
+func (p *ExitPolicy) Permit(ip net.IP, port int) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return false
+	}
+	for _, r := range p.Deny {
+		if r.match(ip, port) {
+			return false
+		}
+	}
+	for _, r := range p.Allow {
+		if r.match(ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialExit 在本机解析 dest，按出口策略逐个检查解析出的地址再连接，
+// 对端不能用域名绕过策略
+func (t *Tunnel) dialExit(dest string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.opts.DialTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	err = errDenied
+	for _, a := range addrs {
+		if !t.opts.Exit.Permit(a.IP, port) {
+			continue
+		}
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(a.IP.String(), portStr)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,137 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseExitRule(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"*", "*"},
+		{"*:80,443", "*:80,443"},
+		{"10.0.0.0/8", "10.0.0.0/8"},
+		{"192.168.1.7:22", "192.168.1.7/32:22"},
+		{"[2001:db8::/32]:443", "[2001:db8::/32]:443"},
+		{"[::1]", "[::1/128]"},
+	} {
+		r, err := ParseExitRule(tc.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		if r.String() != tc.want {
+			t.Fatalf("%s: got %s, want %s", tc.in, r, tc.want)
+		}
+	}
+	for _, in := range []string{"", "10.0.0.0/33", "1.2.3.4:0", "1.2.3.4:http", "[::1", "[::1]80"} {
+		if _, err := ParseExitRule(in); err == nil {
+			t.Fatalf("%q should be invalid", in)
+		}
+	}
+}
+
+func TestExitPolicy(t *testing.T) {
+	rule := func(s string) ExitRule {
+		r, err := ParseExitRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	p := &ExitPolicy{
+		Allow: []ExitRule{rule("*:80,443"), rule("10.0.0.0/8")},
+		Deny:  []ExitRule{rule("10.1.0.0/16")},
+	}
+	for _, tc := range []struct {
+		ip   string
+		port int
+		want bool
+	}{
+		{"8.8.8.8", 443, true},
+		{"8.8.8.8", 22, false},
+		{"10.2.3.4", 22, true},
+		{"10.1.3.4", 80, false}, // deny 优先
+		{"127.0.0.1", 80, false},
+		{"0.0.0.0", 80, false},
+	} {
+		if got := p.Permit(net.ParseIP(tc.ip), tc.port); got != tc.want {
+			t.Fatalf("%s:%d = %v, want %v", tc.ip, tc.port, got, tc.want)
+		}
+	}
+}
+
+// localIP 返回本机一个非回环的 IPv4 地址，出口策略不允许连回环地址
+func localIP(t *testing.T) net.IP {
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP
+		}
+	}
+	t.Skip("no non-loopback address")
+	return nil
+}
+
+func TestConnect(t *testing.T) {
+	ip := localIP(t)
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hi"))
+			conn.Close()
+		}
+	}()
+	dest := ln.Addr().String()
+	exit := &ExitPolicy{Allow: []ExitRule{{Net: &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}}}}
+
+	// 没有开出口的节点拒绝
+	noExit, _ := newPair(t, 0, Options{}, Options{})
+	_, done := forwardOnceWith(t, func(c net.Conn) error {
+		return noExit.Connect(context.Background(), c, "b", dest, nil)
+	})
+	if err := <-done; !errors.Is(err, ErrRefused) {
+		t.Fatalf("err = %v, want ErrRefused", err)
+	}
+
+	a, _ := newPair(t, 0, Options{}, Options{Exit: exit})
+	var ready error = errors.New("not called")
+	conn, done := forwardOnceWith(t, func(c net.Conn) error {
+		return a.Connect(context.Background(), c, "b", dest, func(err error) error { ready = err; return nil })
+	})
+	buf := make([]byte, 2)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "hi" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	conn.Close()
+	<-done
+	if ready != nil {
+		t.Fatalf("ready called with %v", ready)
+	}
+
+	// 策略外的目标被拒绝，连不上的目标返回 ErrUnreachable
+	for dest, want := range map[string]error{
+		"127.0.0.1:1":                           ErrRefused,
+		net.JoinHostPort(ip.String(), "1"):      ErrUnreachable,
+		"no-such-host.invalid:80":               ErrUnreachable,
+		net.JoinHostPort("192.0.2.255", "8080"): ErrRefused,
+	} {
+		_, done := forwardOnceWith(t, func(c net.Conn) error {
+			return a.Connect(context.Background(), c, "b", dest, nil)
+		})
+		if err := <-done; !errors.Is(err, want) {
+			t.Fatalf("%s: err = %v, want %v", dest, err, want)
+		}
+	}
+}
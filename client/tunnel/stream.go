@@ -0,0 +1,325 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+const (
+	minRTO = 50 * time.Millisecond
+	maxRTO = 5 * time.Second
+)
+
+type segment struct {
+	msg   *pb.PeerStream
+	sent  time.Time
+	tries int
+}
+
+// stream 是隧道里的一条连接。发送方向最多有 Window 个没确认的分段，
+// 接收方向把乱序的分段暂存起来，按序交给写协程
+type stream struct {
+	t        *Tunnel
+	key      streamKey
+	accepted chan error    // 对端 Accept 或 Reset 的结果
+	in       chan []byte   // 按序收到的数据，收到 fin 后关闭
+	done     chan struct{} // 流结束时关闭
+	doneOnce sync.Once
+
+	mu       sync.Mutex
+	cond     *sync.Cond // 发送窗口有空位或者流出错时通知
+	accept   bool
+	nextSeq  uint32
+	unacked  map[uint32]*segment
+	finSent  bool
+	srtt     time.Duration
+	rto      time.Duration
+	recvNext uint32
+	reorder  map[uint32]*pb.PeerStream
+	finRecv  bool
+	written  bool // 收到的数据都写完了
+	err      error
+}
+
+func newStream(t *Tunnel, key streamKey) *stream {
+	s := &stream{
+		t:        t,
+		key:      key,
+		accepted: make(chan error, 1),
+		in:       make(chan []byte, t.opts.Window),
+		done:     make(chan struct{}),
+		unacked:  make(map[uint32]*segment),
+		rto:      t.opts.RTO,
+		reorder:  make(map[uint32]*pb.PeerStream),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *stream) msg(kind pb.StreamKind) *pb.PeerStream {
+	return &pb.PeerStream{Id: s.key.id, Opener: s.key.opened, Kind: kind}
+}
+
+func (s *stream) isAccepted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accept
+}
+
+func (s *stream) onAccept() {
+	s.mu.Lock()
+	s.accept = true
+	s.mu.Unlock()
+	notify(s.accepted, nil)
+}
+
+func (s *stream) onReset(reason string, unreachable bool) {
+	err := fmt.Errorf("%w: %s", ErrRefused, reason)
+	if unreachable {
+		err = fmt.Errorf("%w: %s", ErrUnreachable, reason)
+	}
+	notify(s.accepted, err)
+	s.abort(err)
+}
+
+// bridge 在 conn 和流之间双向转发，两个方向都结束或者出错后关闭 conn
+func (s *stream) bridge(conn net.Conn) error {
+	go s.writeLoop(conn)
+	go s.readLoop(conn)
+	<-s.done
+	conn.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// readLoop 把 conn 读到的数据分段发给对端，读完后发 fin
+func (s *stream) readLoop(conn net.Conn) {
+	buf := make([]byte, segmentSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if s.write(append([]byte(nil), buf[:n]...), false) != nil {
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			s.write(nil, true)
+			return
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+// writeLoop 把对端按序发来的数据写到 conn，收到 fin 后关掉 conn 的写方向
+func (s *stream) writeLoop(conn net.Conn) {
+	for {
+		select {
+		case data, ok := <-s.in:
+			if !ok {
+				if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+				}
+				s.mu.Lock()
+				s.written = true
+				s.checkDone()
+				s.mu.Unlock()
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				s.fail(err)
+				return
+			}
+			// in 满的时候留在 reorder 里的分段现在可以交出去了
+			s.mu.Lock()
+			s.deliver()
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// write 等发送窗口有空位后发出一个分段
+func (s *stream) write(data []byte, fin bool) error {
+	s.mu.Lock()
+	for len(s.unacked) >= s.t.opts.Window && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		s.mu.Unlock()
+		return s.err
+	}
+	m := s.msg(pb.StreamKind_StreamKind_Data)
+	m.Seq, m.Data, m.Fin = s.nextSeq, data, fin
+	s.nextSeq++
+	s.unacked[m.Seq] = &segment{msg: m, sent: time.Now()}
+	s.finSent = s.finSent || fin
+	s.mu.Unlock()
+	s.t.send(s.key.peer, m)
+	return nil
+}
+
+func (s *stream) onData(m *pb.PeerStream) {
+	s.mu.Lock()
+	window := uint32(s.t.opts.Window)
+	if !s.finRecv && m.GetSeq() >= s.recvNext && m.GetSeq() < s.recvNext+window {
+		s.reorder[m.GetSeq()] = m
+		s.deliver()
+	}
+	ack := s.msg(pb.StreamKind_StreamKind_Ack)
+	ack.Ack = s.recvNext
+	s.mu.Unlock()
+	// 重复的分段也要确认，前一个确认可能丢了
+	s.t.send(s.key.peer, ack)
+}
+
+// deliver 把 reorder 里连续的分段放进 in，in 满了就先停下，调用时要持有 s.mu
+func (s *stream) deliver() {
+	for !s.finRecv {
+		m, ok := s.reorder[s.recvNext]
+		if !ok {
+			return
+		}
+		if m.GetFin() {
+			s.finRecv = true
+			close(s.in)
+		} else {
+			select {
+			case s.in <- m.GetData():
+			default:
+				return
+			}
+		}
+		delete(s.reorder, s.recvNext)
+		s.recvNext++
+	}
+}
+
+func (s *stream) onAck(ack uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for seq, seg := range s.unacked {
+		if seq >= ack {
+			continue
+		}
+		// 重传过的分段不知道确认的是哪一次，不用来估计 RTT
+		if seg.tries == 0 {
+			s.sampleRTT(time.Since(seg.sent))
+		}
+		delete(s.unacked, seq)
+	}
+	s.cond.Broadcast()
+	s.checkDone()
+}
+
+// sampleRTT 按 TCP 的方式平滑 RTT，重传超时取两倍，调用时要持有 s.mu
+func (s *stream) sampleRTT(rtt time.Duration) {
+	if s.srtt == 0 {
+		s.srtt = rtt
+	} else {
+		s.srtt += (rtt - s.srtt) / 8
+	}
+	s.rto = min(max(2*s.srtt, minRTO), maxRTO)
+}
+
+// retransmit 重发超时没确认的分段，超时时间按重传次数加倍
+func (s *stream) retransmit(now time.Time) {
+	s.mu.Lock()
+	var resend []*pb.PeerStream
+	failed := false
+	for _, seg := range s.unacked {
+		if now.Sub(seg.sent) < min(s.rto<<seg.tries, maxRTO) {
+			continue
+		}
+		if seg.tries >= s.t.opts.Retries {
+			failed = true
+			break
+		}
+		seg.tries++
+		seg.sent = now
+		resend = append(resend, seg.msg)
+	}
+	s.mu.Unlock()
+	if failed {
+		s.fail(ErrTimeout)
+		return
+	}
+	for _, m := range resend {
+		s.t.send(s.key.peer, m)
+	}
+}
+
+// checkDone 两个方向都结束时关闭流，调用时要持有 s.mu
+func (s *stream) checkDone() {
+	if s.finSent && len(s.unacked) == 0 && s.written {
+		s.finish(nil)
+	}
+}
+
+// fail 因为本地原因断开，通知对端
+func (s *stream) fail(err error) {
+	if s.abort(err) {
+		m := s.msg(pb.StreamKind_StreamKind_Reset)
+		m.Error = err.Error()
+		s.t.send(s.key.peer, m)
+	}
+}
+
+// refuse 在 Accept 之前因为连不上目标或者被策略拒绝而断开
+func (s *stream) refuse(err error) {
+	if s.abort(err) {
+		m := s.msg(pb.StreamKind_StreamKind_Reset)
+		m.Error = err.Error()
+		m.Unreachable = !errors.Is(err, errDenied)
+		s.t.send(s.key.peer, m)
+	}
+}
+
+// abort 断开流，返回是不是这次断开的
+func (s *stream) abort(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.isDone() {
+		return false
+	}
+	s.finish(err)
+	notify(s.accepted, err)
+	return true
+}
+
+// finish 调用时要持有 s.mu
+func (s *stream) finish(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.doneOnce.Do(func() {
+		close(s.done)
+		go s.t.remove(s)
+	})
+}
+
+func (s *stream) isDone() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
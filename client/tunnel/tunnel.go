@@ -0,0 +1,512 @@
+// Package tunnel 在节点间的 UDP 通道上转发 TCP 连接，类似 ssh -L/-R。
+// 每条 TCP 连接对应一条流，流里的数据分段编号，按累计确认和超时重传保证可靠有序
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// segmentSize 是每个分段的数据长度，加上 UDP 和 protobuf 的头不超过常见的 MTU
+const segmentSize = 1200
+
+var (
+	ErrRefused = errors.New("refused by peer")
+	// ErrUnreachable 表示对端同意了连接但是连不上目标
+	ErrUnreachable = errors.New("unreachable from peer")
+	ErrTimeout     = errors.New("tunnel timed out")
+	ErrClosed      = errors.New("tunnel closed")
+)
+
+// Sender 把消息发给指定名字的对端，peer.Node 实现了这个接口
+type Sender interface {
+	Send(name string, msg *pb.PeerMsg) error
+}
+
+type Options struct {
+	// Allow 是对端可以连的本机端口，只能连 127.0.0.1 上的这些端口
+	Allow []int
+	// AllowListen 是对端可以要求本机监听的端口，用于对端的反向转发
+	AllowListen []int
+	// Exit 不为 nil 时本机作为出口，替对端连接策略允许的任意目标
+	Exit *ExitPolicy
+	// Policy 不为 nil 时还要它允许对端连本机的端口，出口流量检查目标端口，
+	// 本机要求对端监听的反向转发不检查
+	Policy func(peer string, port int) bool
+	// Window 是每条流没确认的最大分段数
+	Window int
+	// RTO 是还没测出 RTT 时的重传超时，Retries 次重传都没确认就断开
+	RTO     time.Duration
+	Retries int
+	// DialTimeout 是连本机端口的超时
+	DialTimeout time.Duration
+	// ListenTimeout 内对端没有刷新监听请求就关掉监听
+	ListenTimeout time.Duration
+	Logger        *slog.Logger
+}
+
+func (o *Options) setDefaults() {
+	if o.Window <= 0 {
+		o.Window = 64
+	}
+	if o.RTO <= 0 {
+		o.RTO = 300 * time.Millisecond
+	}
+	if o.Retries <= 0 {
+		o.Retries = 8
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.ListenTimeout <= 0 {
+		o.ListenTimeout = 2 * time.Minute
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+type streamKey struct {
+	peer   string
+	id     uint32
+	opened bool // 本节点发起的流
+}
+
+type reverseKey struct {
+	peer string
+	port int
+}
+
+// remoteListener 是对端要求本机监听的端口
+type remoteListener struct {
+	peer      string
+	port      int
+	ln        net.Listener
+	refreshed time.Time
+}
+
+type Tunnel struct {
+	opts   Options
+	sender Sender
+
+	mu      sync.Mutex
+	nextID  uint32
+	streams map[streamKey]*stream
+	// waits 是等待对端回应 Listen 的请求
+	waits map[uint32]chan error
+	// reverse 是本节点要求对端监听的端口到本机端口的对应
+	reverse   map[reverseKey]int
+	listeners map[int]*remoteListener
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func New(sender Sender, opts Options) *Tunnel {
+	opts.setDefaults()
+	t := &Tunnel{
+		opts:      opts,
+		sender:    sender,
+		streams:   make(map[streamKey]*stream),
+		waits:     make(map[uint32]chan error),
+		reverse:   make(map[reverseKey]int),
+		listeners: make(map[int]*remoteListener),
+		done:      make(chan struct{}),
+	}
+	go t.timerLoop()
+	return t
+}
+
+// Close 断开所有流，关掉替对端监听的端口
+func (t *Tunnel) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.mu.Lock()
+		streams := make([]*stream, 0, len(t.streams))
+		for _, s := range t.streams {
+			streams = append(streams, s)
+		}
+		for port, l := range t.listeners {
+			l.ln.Close()
+			delete(t.listeners, port)
+		}
+		t.mu.Unlock()
+		for _, s := range streams {
+			s.fail(ErrClosed)
+		}
+	})
+	return nil
+}
+
+// Handle 处理对端发来的流消息，在节点的读协程里调用，不会阻塞
+func (t *Tunnel) Handle(from string, m *pb.PeerStream) {
+	switch m.GetKind() {
+	case pb.StreamKind_StreamKind_Open:
+		t.onOpen(from, m)
+		return
+	case pb.StreamKind_StreamKind_Listen:
+		t.onListen(from, m)
+		return
+	case pb.StreamKind_StreamKind_Unlisten:
+		t.onUnlisten(from, m)
+		return
+	}
+
+	key := streamKey{peer: from, id: m.GetId(), opened: !m.GetOpener()}
+	t.mu.Lock()
+	s, ok := t.streams[key]
+	wait := t.waits[m.GetId()]
+	t.mu.Unlock()
+	if !ok {
+		switch {
+		case !key.opened:
+		case m.GetKind() == pb.StreamKind_StreamKind_Accept && wait != nil:
+			notify(wait, nil)
+			return
+		case m.GetKind() == pb.StreamKind_StreamKind_Reset && wait != nil:
+			notify(wait, fmt.Errorf("%w: %s", ErrRefused, m.GetError()))
+			return
+		}
+		// 流已经正常结束，对端没收到 fin 的确认
+		if m.GetKind() == pb.StreamKind_StreamKind_Data && m.GetFin() {
+			t.send(from, &pb.PeerStream{Id: key.id, Opener: key.opened, Kind: pb.StreamKind_StreamKind_Ack, Ack: m.GetSeq() + 1})
+			return
+		}
+		// 对端还以为流存在，让它断开
+		if m.GetKind() == pb.StreamKind_StreamKind_Data {
+			t.send(from, &pb.PeerStream{Id: key.id, Opener: key.opened, Kind: pb.StreamKind_StreamKind_Reset, Error: "unknown stream"})
+		}
+		return
+	}
+	switch m.GetKind() {
+	case pb.StreamKind_StreamKind_Accept:
+		s.onAccept()
+	case pb.StreamKind_StreamKind_Data:
+		s.onData(m)
+	case pb.StreamKind_StreamKind_Ack:
+		s.onAck(m.GetAck())
+	case pb.StreamKind_StreamKind_Reset:
+		s.onReset(m.GetError(), m.GetUnreachable())
+	}
+}
+
+// Forward 通过对端连它本机的 port，在 conn 和对端连接之间转发数据，连接结束后返回
+func (t *Tunnel) Forward(ctx context.Context, conn net.Conn, peer string, port int) error {
+	open := &pb.PeerStream{Opener: true, Kind: pb.StreamKind_StreamKind_Open, Port: uint32(port)}
+	return t.open(ctx, conn, peer, open, nil)
+}
+
+// Connect 经对端出口连 dest，连接结束后返回。对端回应后先用结果调用 ready，
+// 比如回复 SOCKS5 客户端，成功时再开始转发。对端拒绝时返回 ErrRefused，
+// 连不上目标时返回 ErrUnreachable
+func (t *Tunnel) Connect(ctx context.Context, conn net.Conn, peer, dest string, ready func(error) error) error {
+	open := &pb.PeerStream{Opener: true, Kind: pb.StreamKind_StreamKind_Open, Dest: dest}
+	return t.open(ctx, conn, peer, open, ready)
+}
+
+func (t *Tunnel) open(ctx context.Context, conn net.Conn, peer string, open *pb.PeerStream, ready func(error) error) error {
+	t.mu.Lock()
+	t.nextID++
+	s := t.newStream(streamKey{peer: peer, id: t.nextID, opened: true})
+	t.mu.Unlock()
+	open.Id = s.key.id
+	err := t.request(ctx, peer, open, s.accepted)
+	if ready != nil {
+		if rerr := ready(err); err == nil {
+			err = rerr
+		}
+	}
+	if err != nil {
+		s.fail(err)
+		conn.Close()
+		return err
+	}
+	return s.bridge(conn)
+}
+
+// Listen 请对端在它本机的 remotePort 上监听，连进来的连接转到本机的 localPort，
+// 在 ctx 结束前定期刷新请求，结束时通知对端关掉监听
+func (t *Tunnel) Listen(ctx context.Context, peer string, remotePort, localPort int) error {
+	rk := reverseKey{peer: peer, port: remotePort}
+	reply := make(chan error, 1)
+	t.mu.Lock()
+	if _, ok := t.reverse[rk]; ok {
+		t.mu.Unlock()
+		return fmt.Errorf("port %d on %s is already forwarded", remotePort, peer)
+	}
+	t.reverse[rk] = localPort
+	t.nextID++
+	id := t.nextID
+	t.waits[id] = reply
+	t.mu.Unlock()
+
+	listen := &pb.PeerStream{Id: id, Opener: true, Kind: pb.StreamKind_StreamKind_Listen, Port: uint32(remotePort)}
+	err := t.request(ctx, peer, listen, reply)
+	if err != nil {
+		t.mu.Lock()
+		delete(t.reverse, rk)
+		delete(t.waits, id)
+		t.mu.Unlock()
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(t.opts.ListenTimeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.send(peer, listen)
+			case <-ctx.Done():
+				t.send(peer, &pb.PeerStream{Id: id, Opener: true, Kind: pb.StreamKind_StreamKind_Unlisten, Port: uint32(remotePort)})
+			case <-t.done:
+			}
+			if ctx.Err() != nil || t.closed() {
+				t.mu.Lock()
+				delete(t.reverse, rk)
+				delete(t.waits, id)
+				t.mu.Unlock()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// request 重发 msg 直到 reply 里有对端的回应
+func (t *Tunnel) request(ctx context.Context, peer string, msg *pb.PeerStream, reply chan error) error {
+	ticker := time.NewTicker(t.opts.RTO)
+	defer ticker.Stop()
+	for try := 0; try <= t.opts.Retries; try++ {
+		t.send(peer, msg)
+		select {
+		case err := <-reply:
+			return err
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.done:
+			return ErrClosed
+		}
+	}
+	return ErrTimeout
+}
+
+func (t *Tunnel) onOpen(from string, m *pb.PeerStream) {
+	key := streamKey{peer: from, id: m.GetId()}
+	t.mu.Lock()
+	if s, ok := t.streams[key]; ok {
+		t.mu.Unlock()
+		// 对端没收到 Accept，还在重发 Open
+		if s.isAccepted() {
+			t.send(from, s.msg(pb.StreamKind_StreamKind_Accept))
+		}
+		return
+	}
+	port := int(m.GetPort())
+	var err error
+	if m.GetDest() != "" {
+		if t.opts.Exit == nil {
+			err = errors.New("exit is not enabled")
+		}
+	} else if m.GetReverse() {
+		var ok bool
+		if port, ok = t.reverse[reverseKey{peer: from, port: port}]; !ok {
+			err = fmt.Errorf("port %d is not forwarded", m.GetPort())
+		}
+	} else if !slices.Contains(t.opts.Allow, port) {
+		err = fmt.Errorf("port %d is not allowed", port)
+	}
+	if err == nil && !m.GetReverse() && !t.permit(from, m) {
+		err = errors.New("denied by policy")
+	}
+	if err != nil {
+		t.mu.Unlock()
+		t.opts.Logger.Info("tunnel refused", "peer", from, "port", m.GetPort(), "dest", m.GetDest(), "reverse", m.GetReverse(), "err", err)
+		t.send(from, &pb.PeerStream{Id: key.id, Kind: pb.StreamKind_StreamKind_Reset, Error: err.Error()})
+		return
+	}
+	s := t.newStream(key)
+	t.mu.Unlock()
+
+	go func() {
+		target := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		var conn net.Conn
+		var err error
+		if m.GetDest() != "" {
+			target = m.GetDest()
+			conn, err = t.dialExit(target)
+		} else {
+			conn, err = net.DialTimeout("tcp", target, t.opts.DialTimeout)
+		}
+		if err != nil {
+			t.opts.Logger.Info("tunnel dial failed", "peer", from, "target", target, "err", err)
+			s.refuse(err)
+			return
+		}
+		t.opts.Logger.Debug("tunnel opened", "peer", from, "target", target)
+		s.onAccept()
+		t.send(from, s.msg(pb.StreamKind_StreamKind_Accept))
+		if err := s.bridge(conn); err != nil {
+			t.opts.Logger.Debug("tunnel closed", "peer", from, "target", target, "err", err)
+		}
+	}()
+}
+
+// permit 用 Options.Policy 检查对端发起的流，出口流按目标端口检查
+func (t *Tunnel) permit(from string, m *pb.PeerStream) bool {
+	if t.opts.Policy == nil {
+		return true
+	}
+	port := int(m.GetPort())
+	if m.GetDest() != "" {
+		_, p, err := net.SplitHostPort(m.GetDest())
+		if port, err = strconv.Atoi(p); err != nil {
+			return false
+		}
+	}
+	return t.opts.Policy(from, port)
+}
+
+func (t *Tunnel) onListen(from string, m *pb.PeerStream) {
+	port := int(m.GetPort())
+	reply := &pb.PeerStream{Id: m.GetId(), Kind: pb.StreamKind_StreamKind_Accept}
+	refuse := func(err error) {
+		t.opts.Logger.Info("tunnel listen refused", "peer", from, "port", port, "err", err)
+		reply.Kind, reply.Error = pb.StreamKind_StreamKind_Reset, err.Error()
+		t.send(from, reply)
+	}
+	if !slices.Contains(t.opts.AllowListen, port) {
+		refuse(fmt.Errorf("listening on port %d is not allowed", port))
+		return
+	}
+	if t.opts.Policy != nil && !t.opts.Policy(from, port) {
+		refuse(errors.New("denied by policy"))
+		return
+	}
+	t.mu.Lock()
+	if l, ok := t.listeners[port]; ok {
+		if l.peer != from {
+			t.mu.Unlock()
+			refuse(fmt.Errorf("port %d is forwarded to another peer", port))
+			return
+		}
+		l.refreshed = time.Now()
+		t.mu.Unlock()
+		t.send(from, reply)
+		return
+	}
+	// 只监听回环地址，和 ssh -R 的默认行为一样
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.mu.Unlock()
+		refuse(err)
+		return
+	}
+	l := &remoteListener{peer: from, port: port, ln: ln, refreshed: time.Now()}
+	t.listeners[port] = l
+	t.mu.Unlock()
+	t.opts.Logger.Info("tunnel listening for peer", "peer", from, "port", port)
+	t.send(from, reply)
+	go t.acceptLoop(l)
+}
+
+func (t *Tunnel) onUnlisten(from string, m *pb.PeerStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if l, ok := t.listeners[int(m.GetPort())]; ok && l.peer == from {
+		l.ln.Close()
+		delete(t.listeners, l.port)
+	}
+}
+
+func (t *Tunnel) acceptLoop(l *remoteListener) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			t.opts.Logger.Info("tunnel stopped listening for peer", "peer", l.peer, "port", l.port)
+			return
+		}
+		go func() {
+			open := &pb.PeerStream{Opener: true, Kind: pb.StreamKind_StreamKind_Open, Port: uint32(l.port), Reverse: true}
+			if err := t.open(context.Background(), conn, l.peer, open, nil); err != nil {
+				t.opts.Logger.Debug("reverse tunnel closed", "peer", l.peer, "port", l.port, "err", err)
+			}
+		}()
+	}
+}
+
+// timerLoop 重传超时的分段，关掉对端不再刷新的监听
+func (t *Tunnel) timerLoop() {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.done:
+			return
+		}
+		now := time.Now()
+		t.mu.Lock()
+		streams := make([]*stream, 0, len(t.streams))
+		for _, s := range t.streams {
+			streams = append(streams, s)
+		}
+		for port, l := range t.listeners {
+			if now.Sub(l.refreshed) > t.opts.ListenTimeout {
+				l.ln.Close()
+				delete(t.listeners, port)
+			}
+		}
+		t.mu.Unlock()
+		for _, s := range streams {
+			s.retransmit(now)
+		}
+	}
+}
+
+func (t *Tunnel) closed() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// newStream 调用时要持有 t.mu
+func (t *Tunnel) newStream(key streamKey) *stream {
+	s := newStream(t, key)
+	t.streams[key] = s
+	return s
+}
+
+func (t *Tunnel) remove(s *stream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.streams[s.key] == s {
+		delete(t.streams, s.key)
+	}
+}
+
+func (t *Tunnel) send(peer string, m *pb.PeerStream) {
+	if err := t.sender.Send(peer, &pb.PeerMsg{Body: &pb.PeerMsg_Stream{Stream: m}}); err != nil {
+		t.opts.Logger.Debug("tunnel send failed", "peer", peer, "err", err)
+	}
+}
+
+// notify 写入只需要第一个结果的回应通道
+func notify(ch chan error, err error) {
+	select {
+	case ch <- err:
+	default:
+	}
+}
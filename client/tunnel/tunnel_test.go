@@ -0,0 +1,211 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// lossyLink 模拟两个节点之间的 UDP 通道，按比例丢包，队列满了也丢
+type lossyLink struct {
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	loss  float64
+	peers map[string]chan *pb.PeerMsg
+}
+
+type linkSender struct {
+	link *lossyLink
+	name string
+}
+
+func (s *linkSender) Send(name string, msg *pb.PeerMsg) error {
+	l := s.link
+	l.mu.Lock()
+	drop := l.rnd.Float64() < l.loss
+	ch := l.peers[name]
+	l.mu.Unlock()
+	if ch == nil {
+		return errors.New("unknown peer")
+	}
+	msg.From = s.name
+	if !drop {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+// newPair 返回名字为 a 和 b、通过 lossyLink 连接的两个隧道
+func newPair(t *testing.T, loss float64, optsA, optsB Options) (*Tunnel, *Tunnel) {
+	link := &lossyLink{rnd: rand.New(rand.NewSource(1)), loss: loss, peers: make(map[string]chan *pb.PeerMsg)}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	optsA.Logger, optsB.Logger = logger, logger
+	optsA.RTO, optsB.RTO = 50*time.Millisecond, 50*time.Millisecond
+	optsA.Retries, optsB.Retries = 20, 20
+	a := New(&linkSender{link: link, name: "a"}, optsA)
+	b := New(&linkSender{link: link, name: "b"}, optsB)
+	for name, tun := range map[string]*Tunnel{"a": a, "b": b} {
+		ch := make(chan *pb.PeerMsg, 256)
+		link.peers[name] = ch
+		go func(tun *Tunnel) {
+			for msg := range ch {
+				tun.Handle(msg.GetFrom(), msg.GetStream())
+			}
+		}(tun)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// echoServer 把收到的数据原样发回，读完后关闭写方向
+func echoServer(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// echo 发出 size 字节再读回来，检查内容一致
+func echo(t *testing.T, conn net.Conn, size int) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(2)).Read(data)
+	go func() {
+		conn.Write(data)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("echoed %d bytes, want %d", len(got), len(data))
+	}
+}
+
+// forwardOnce 在本地开一个端口，把连进来的连接经隧道转到对端的 port
+func forwardOnce(t *testing.T, tun *Tunnel, port int) (net.Conn, chan error) {
+	return forwardOnceWith(t, func(conn net.Conn) error {
+		return tun.Forward(context.Background(), conn, "b", port)
+	})
+}
+
+// forwardOnceWith 在本地开一个端口，连进来的连接交给 fn，返回客户端连接和 fn 的结果
+func forwardOnceWith(t *testing.T, fn func(net.Conn) error) (net.Conn, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- fn(conn)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, done
+}
+
+func TestForward(t *testing.T) {
+	port := echoServer(t)
+	a, _ := newPair(t, 0.1, Options{}, Options{Allow: []int{port}})
+	conn, done := forwardOnce(t, a, port)
+	echo(t, conn, 300*1024)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("forward did not finish")
+	}
+}
+
+func TestForwardNotAllowed(t *testing.T) {
+	port := echoServer(t)
+	a, _ := newPair(t, 0, Options{}, Options{})
+	_, done := forwardOnce(t, a, port)
+	if err := <-done; !errors.Is(err, ErrRefused) {
+		t.Fatalf("err = %v, want ErrRefused", err)
+	}
+}
+
+func TestForwardDeniedByPolicy(t *testing.T) {
+	port := echoServer(t)
+	policy := func(peer string, p int) bool { return peer == "a" && p != port }
+	a, _ := newPair(t, 0, Options{}, Options{Allow: []int{port}, Policy: policy})
+	_, done := forwardOnce(t, a, port)
+	if err := <-done; !errors.Is(err, ErrRefused) {
+		t.Fatalf("err = %v, want ErrRefused", err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	port := echoServer(t)
+	remote := freePort(t)
+	a, _ := newPair(t, 0.1, Options{}, Options{AllowListen: []int{remote}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := a.Listen(ctx, "b", remote, port); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(remote)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echo(t, conn, 64*1024)
+
+	// 不允许监听的端口被拒绝
+	if err := a.Listen(ctx, "b", freePort(t), port); !errors.Is(err, ErrRefused) {
+		t.Fatalf("err = %v, want ErrRefused", err)
+	}
+}
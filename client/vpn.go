@@ -0,0 +1,332 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jinyunx/p2p/client/acl"
+	"github.com/jinyunx/p2p/client/comm"
+	"github.com/jinyunx/p2p/client/magicdns"
+	"github.com/jinyunx/p2p/client/peer"
+	"github.com/jinyunx/p2p/client/vpn"
+	pb "github.com/jinyunx/p2p/proto"
+	"golang.org/x/net/context"
+)
+
+// vpnRefresh 是查注册表更新路由的间隔
+const vpnRefresh = 5 * time.Second
+
+// runVPN 创建 TUN 网卡，用服务器分配的虚拟 IP 和其他节点组成三层网络
+func runVPN(args []string) {
+	fs := flag.NewFlagSet("vpn", flag.ExitOnError)
+	tf := addTunnelFlags(fs)
+	network := fs.String("network", "default", "virtual network to join")
+	devName := fs.String("dev", "p2p0", "tun device name")
+	mtu := fs.Int("mtu", 1280, "tun device mtu, packets are sent in one udp datagram each")
+	dnsAddr := fs.String("dns", "", "serve magic dns for <name>.<network>.p2p on this address, e.g. 127.0.0.1:53")
+	dnsUpstream := fs.String("dns-upstream", "", "comma separated upstream dns servers for other names, default from /etc/resolv.conf")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] vpn [vpn flags] servers\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "  needs CAP_NET_ADMIN, every registered node with a virtual ip is reachable through the tun device\n")
+		fmt.Fprintf(fs.Output(), "  packets to nodes that cannot be punched are relayed by the server if it runs with -relay\n")
+		fmt.Fprintf(fs.Output(), "  with -dns, point the system resolver or a .p2p stub zone at that address to use peer names\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	servers, err := comm.ResolveServers(fs.Arg(0))
+	if err != nil {
+		fatal("invalid server list", "err", err)
+	}
+	cfg := vpnConfig{network: *network, dev: *devName, mtu: *mtu, dns: *dnsAddr, dnsUpstream: *dnsUpstream}
+	// 出错时先返回，让网卡和节点的 defer 关掉再退出
+	if err := serveVPN(servers, tf, cfg); err != nil {
+		fatal("vpn failed", "err", err)
+	}
+}
+
+// vpnConfig 是 vpn 子命令的参数
+type vpnConfig struct {
+	network     string
+	dev         string
+	mtu         int
+	dns         string
+	dnsUpstream string
+}
+
+// serveVPN 运行到收到退出信号为止，注册完成前收到信号时返回 nil
+func serveVPN(servers []string, tf *tunnelFlags, cfg vpnConfig) error {
+	dev, err := vpn.OpenTun(cfg.dev)
+	if err != nil {
+		return fmt.Errorf("open tun: %w", err)
+	}
+	defer dev.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rdv, err := comm.NewRendezvous(servers, comm.RendezvousOptions{Logger: logger.With("component", "comm")})
+	if err != nil {
+		return fmt.Errorf("init rendezvous: %w", err)
+	}
+	defer rdv.Close()
+	var conf *pb.GetServerConfigResp
+	err = retry(ctx, "get server config", func() error {
+		var err error
+		conf, err = rdv.GetServerConfig(ctx)
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+	var vnet *pb.VirtualNetwork
+	for _, v := range conf.GetVirtualNets() {
+		if v.GetName() == cfg.network {
+			vnet = v
+		}
+	}
+	if vnet == nil {
+		return fmt.Errorf("server %s has no virtual network %q", rdv.Primary(), cfg.network)
+	}
+	// 路由要用 node 发包，只能在 Listen 之后创建，这时读包的协程已经在跑了
+	var routerPtr atomic.Pointer[vpn.Router]
+	node, err := peer.Listen(rdv, peer.Options{
+		Name:       *tf.name,
+		Network:    cfg.network,
+		LocalPort:  *tf.port,
+		Logger:     logger,
+		RelayAfter: relayAfter(conf, rdv.Primary()),
+		OnMessage: func(from peer.Peer, src *net.UDPAddr, msg *pb.PeerMsg) {
+			if pkt := msg.GetPacket(); pkt != nil {
+				if router := routerPtr.Load(); router != nil {
+					router.Handle(from.Name, pkt)
+				}
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("open peer socket: %w", err)
+	}
+	defer node.Close()
+	inbound := acl.New()
+	router := vpn.NewRouter(dev, node, vpn.Options{Allow: inbound.Allow, Logger: logger})
+	routerPtr.Store(router)
+	go rdv.Run(ctx)
+
+	err = retry(ctx, "get external address", func() error {
+		addr, err := node.Discover(ctx)
+		if err == nil {
+			node.SetNatType(detectNatType(ctx, rdv, node.LocalAddr().Port, addr))
+		}
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+	if err := retry(ctx, "register", func() error { return node.Register(ctx) }); err != nil {
+		return nil
+	}
+	logger.Info("registered", "server", rdv.Primary(), "network", vnet.GetName(), "ipv4", vnet.GetIpv4(), "ipv6", vnet.GetIpv6())
+	go inbound.Watch(ctx, rdv, node.Name(), logger)
+	go node.Keepalive(ctx)
+	go pollPunch(ctx, rdv, node, nil)
+	if *tf.lan {
+		go func() {
+			if err := node.Beacon(ctx); err != nil {
+				logger.Warn("lan discovery disabled", "err", err)
+			}
+		}()
+	}
+	go func() {
+		if err := router.Run(); err != nil {
+			logger.Error("tun read failed", "err", err)
+			stop()
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		dev.Close()
+	}()
+	dir := newDirectory(node, vnet.GetName())
+	if cfg.dns != "" {
+		if err := serveMagicDNS(ctx, cfg.dns, cfg.dnsUpstream, dir.lookup); err != nil {
+			return err
+		}
+	}
+	return refreshVPN(ctx, rdv, node, router, dev, vnet, dir, cfg.mtu)
+}
+
+// serveMagicDNS 在 addr 上同时监听 UDP 和 TCP，上游里去掉自己，避免查询转回自己
+func serveMagicDNS(ctx context.Context, addr string, upstream string, lookup magicdns.LookupFunc) error {
+	opts := magicdns.Options{Logger: logger}
+	if upstream != "" {
+		opts.Upstream = []string{}
+		for _, u := range strings.Split(upstream, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				if _, _, err := net.SplitHostPort(u); err != nil {
+					u = net.JoinHostPort(u, "53")
+				}
+				opts.Upstream = append(opts.Upstream, u)
+			}
+		}
+	} else {
+		opts.Upstream = magicdns.SystemResolvers("/etc/resolv.conf")
+	}
+	opts.Upstream = slices.DeleteFunc(opts.Upstream, func(u string) bool { return u == addr })
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("listen dns: %w", err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("listen dns: %w", err)
+	}
+	srv := magicdns.NewServer(lookup, opts)
+	go func() {
+		if err := srv.ServeUDP(ctx, pc); err != nil {
+			logger.Error("dns server stopped", "err", err)
+		}
+	}()
+	go func() {
+		if err := srv.ServeTCP(ctx, ln); err != nil {
+			logger.Error("dns server stopped", "err", err)
+		}
+	}()
+	logger.Info("serving magic dns", "addr", addr, "upstream", opts.Upstream)
+	return nil
+}
+
+// directory 保存最近一次查到的注册表，给 magic DNS 查节点地址
+type directory struct {
+	node    *peer.Node
+	network string // 本机所在的网络，这个网络里的节点用虚拟 IP 回答
+
+	mu    sync.Mutex
+	nodes map[string]*pb.NodeInfo // 小写的 name/network -> 节点
+}
+
+func newDirectory(node *peer.Node, network string) *directory {
+	return &directory{node: node, network: network, nodes: make(map[string]*pb.NodeInfo)}
+}
+
+func (d *directory) set(nodes []*pb.NodeInfo) {
+	m := make(map[string]*pb.NodeInfo)
+	for _, info := range nodes {
+		m[strings.ToLower(info.GetName()+"/"+info.GetNetwork())] = info
+	}
+	d.mu.Lock()
+	d.nodes = m
+	d.mu.Unlock()
+}
+
+// lookup 同一个网络里有虚拟 IP 的节点回答虚拟 IP，其他节点回答当前最好的候选地址：
+// 最近通信过的用正在用的地址，否则用注册表里优先级最高的候选
+func (d *directory) lookup(name, network string) ([]net.IP, bool) {
+	d.mu.Lock()
+	info, ok := d.nodes[strings.ToLower(name+"/"+network)]
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	var ips []net.IP
+	if info.GetNetwork() == d.network {
+		for _, s := range []string{info.GetVirtualIp(), info.GetVirtualIp6()} {
+			if ip := net.ParseIP(s); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) > 0 {
+			return ips, true
+		}
+	}
+	if p, ok := d.node.Peer(info.GetName()); ok && p.Addr != nil && time.Since(p.LastSeen) < punchStale {
+		return []net.IP{p.Addr.IP}, true
+	}
+	if cands := peer.Candidates(info); len(cands) > 0 {
+		return []net.IP{cands[0].IP}, true
+	}
+	return nil, true
+}
+
+// refreshVPN 定期查注册表：拿到本机的虚拟 IP 后配置网卡，按节点的加入和离开更新路由，
+// 和同一个网络里有虚拟 IP 的节点保持打通，ctx 结束时返回 nil，配置网卡失败时返回错误
+func refreshVPN(ctx context.Context, rdv *comm.Rendezvous, node *peer.Node, router *vpn.Router, dev *vpn.Tun, vnet *pb.VirtualNetwork, dir *directory, mtu int) error {
+	var configured []string
+	lastPunch := make(map[string]time.Time)
+	ticker := time.NewTicker(vpnRefresh)
+	defer ticker.Stop()
+	for {
+		nodes, err := rdv.GetNodeInfo(ctx)
+		if err != nil {
+			logger.Warn("GetNodeInfo failed", "err", err)
+		}
+		var targets []*pb.NodeInfo
+		for _, info := range nodes {
+			if info.GetNetwork() != vnet.GetName() {
+				continue
+			}
+			if info.GetName() != node.Name() {
+				if info.GetVirtualIp() != "" || info.GetVirtualIp6() != "" {
+					targets = append(targets, info)
+				}
+				continue
+			}
+			ips := []string{info.GetVirtualIp(), info.GetVirtualIp6()}
+			if slices.Equal(ips, configured) {
+				continue
+			}
+			addrs := vpnAddrs(vnet, ips)
+			if len(addrs) == 0 {
+				continue
+			}
+			if err := dev.Configure(addrs, mtu); err != nil {
+				return fmt.Errorf("configure tun %s: %w", dev.Name(), err)
+			}
+			configured = ips
+			logger.Info("vpn up", "dev", dev.Name(), "network", vnet.GetName(), "ipv4", ips[0], "ipv6", ips[1])
+		}
+		if err == nil {
+			dir.set(nodes)
+			joined, left := router.Update(nodes, node.Name())
+			for _, name := range joined {
+				ips, _ := router.Lookup(name)
+				logger.Info("peer joined", "peer", name, "virtual_ips", ips)
+			}
+			for _, name := range left {
+				logger.Info("peer left", "peer", name)
+			}
+		}
+		connectPeers(ctx, rdv, node, targets, lastPunch)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// vpnAddrs 把本机的虚拟 IP 和所在网段的掩码配在一起
+func vpnAddrs(vnet *pb.VirtualNetwork, ips []string) []*net.IPNet {
+	var out []*net.IPNet
+	for i, cidr := range []string{vnet.GetIpv4(), vnet.GetIpv6()} {
+		ip := net.ParseIP(ips[i])
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if ip == nil || err != nil {
+			continue
+		}
+		out = append(out, &net.IPNet{IP: ip, Mask: ipnet.Mask})
+	}
+	return out
+}
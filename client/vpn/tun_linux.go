@@ -0,0 +1,139 @@
+package vpn
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Tun 是 Linux 的 TUN 网卡，读写的是不带包信息头的 IP 包
+type Tun struct {
+	file *os.File
+	name string
+}
+
+// OpenTun 创建名为 name 的 TUN 网卡，name 为空时由内核命名，需要 CAP_NET_ADMIN
+func OpenTun(name string) (*Tun, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open /dev/net/tun: %w", err)
+	}
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("create tun %q: %w", name, err)
+	}
+	// 非阻塞的 fd 交给运行时的 poller，Close 能打断阻塞中的 Read
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &Tun{file: os.NewFile(uintptr(fd), "/dev/net/tun"), name: ifr.Name()}, nil
+}
+
+func (t *Tun) Name() string {
+	return t.name
+}
+
+func (t *Tun) Read(b []byte) (int, error) {
+	return t.file.Read(b)
+}
+
+func (t *Tun) Write(b []byte) (int, error) {
+	return t.file.Write(b)
+}
+
+func (t *Tun) Close() error {
+	return t.file.Close()
+}
+
+// Configure 设置 MTU、启用网卡并加上地址，addrs 里的 IP 是本机地址，掩码是整个虚拟网段，
+// 内核会自动加上到网段的路由。IPv6 地址是追加的，已经存在时忽略
+func (t *Tun) Configure(addrs []*net.IPNet, mtu int) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq(t.name)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint32(uint32(mtu))
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFMTU, ifr); err != nil {
+		return fmt.Errorf("set mtu: %w", err)
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("get flags: %w", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP | unix.IFF_RUNNING)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("set flags: %w", err)
+	}
+	for _, a := range addrs {
+		if ip4 := a.IP.To4(); ip4 != nil {
+			err = t.setInet4(fd, ip4, a.Mask)
+		} else {
+			err = t.addInet6(a)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tun) setInet4(fd int, ip net.IP, mask net.IPMask) error {
+	ifr, err := unix.NewIfreq(t.name)
+	if err != nil {
+		return err
+	}
+	if err := ifr.SetInet4Addr(ip); err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFADDR, ifr); err != nil {
+		return fmt.Errorf("set address %s: %w", ip, err)
+	}
+	if err := ifr.SetInet4Addr(mask); err != nil {
+		return fmt.Errorf("invalid netmask %s", mask)
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFNETMASK, ifr); err != nil {
+		return fmt.Errorf("set netmask: %w", err)
+	}
+	return nil
+}
+
+// in6Ifreq 对应内核的 struct in6_ifreq
+type in6Ifreq struct {
+	addr      [16]byte
+	prefixlen uint32
+	ifindex   int32
+}
+
+func (t *Tun) addInet6(a *net.IPNet) error {
+	iface, err := net.InterfaceByName(t.name)
+	if err != nil {
+		return err
+	}
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ones, _ := a.Mask.Size()
+	req := in6Ifreq{prefixlen: uint32(ones), ifindex: int32(iface.Index)}
+	copy(req.addr[:], a.IP.To16())
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCSIFADDR, uintptr(unsafe.Pointer(&req)))
+	if errno != 0 && errno != unix.EEXIST {
+		return fmt.Errorf("add address %s: %w", a.IP, errno)
+	}
+	return nil
+}
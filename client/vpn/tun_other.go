@@ -0,0 +1,37 @@
+//go:build !linux
+
+package vpn
+
+import (
+	"errors"
+	"net"
+)
+
+var errUnsupported = errors.New("tun device not supported on this platform")
+
+// Tun 在其他平台上没有实现
+type Tun struct{}
+
+func OpenTun(name string) (*Tun, error) {
+	return nil, errUnsupported
+}
+
+func (t *Tun) Name() string {
+	return ""
+}
+
+func (t *Tun) Read(b []byte) (int, error) {
+	return 0, errUnsupported
+}
+
+func (t *Tun) Write(b []byte) (int, error) {
+	return 0, errUnsupported
+}
+
+func (t *Tun) Close() error {
+	return nil
+}
+
+func (t *Tun) Configure(addrs []*net.IPNet, mtu int) error {
+	return errUnsupported
+}
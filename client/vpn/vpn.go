@@ -0,0 +1,289 @@
+// Package vpn 把节点间打通的 UDP 通道组成一个三层网络，每个节点用服务器分配的虚拟 IP，
+// 本机 TUN 网卡上的 IP 包按目的虚拟 IP 发给对应的节点。走哪条路径由 Sender 决定，
+// peer.Node 开了 RelayAfter 时，还没打通或者打不通的节点之间经服务器中转
+package vpn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+// Sender 按节点名发送消息，peer.Node 实现了这个接口
+type Sender interface {
+	Send(name string, msg *pb.PeerMsg) error
+}
+
+type Options struct {
+	// Allow 不为 nil 时对端发来的包要它允许目的端口，port 为 0 表示 ICMP 等没有端口的包。
+	// 本机发起的连接的回包不受限制
+	Allow func(peer string, port int) bool
+	// FlowTimeout 内没有出去的包，本机发起的连接就不再放行回包
+	FlowTimeout time.Duration
+	Logger      *slog.Logger
+}
+
+func (o *Options) setDefaults() {
+	if o.FlowTimeout <= 0 {
+		o.FlowTimeout = 5 * time.Minute
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+// Router 在 TUN 网卡和对端之间转发 IP 包
+type Router struct {
+	dev    io.ReadWriter
+	sender Sender
+	opts   Options
+
+	mu     sync.RWMutex
+	self   []string            // 本机的虚拟 IP
+	routes map[string]string   // 虚拟 IP -> 节点名
+	addrs  map[string][]string // 节点名 -> 虚拟 IP
+
+	flowMu    sync.Mutex
+	flows     map[flowKey]time.Time // 本机发起的连接 -> 最后一次发包的时间
+	lastSweep time.Time
+}
+
+// flowKey 是从本机看的一条连接，proto 是 IP 协议号
+type flowKey struct {
+	proto         uint8
+	peer          string // 对端的虚拟 IP
+	local, remote uint16
+}
+
+func NewRouter(dev io.ReadWriter, sender Sender, opts Options) *Router {
+	opts.setDefaults()
+	return &Router{
+		dev:    dev,
+		sender: sender,
+		opts:   opts,
+		routes: make(map[string]string),
+		addrs:  make(map[string][]string),
+		flows:  make(map[flowKey]time.Time),
+	}
+}
+
+// Update 用注册表里和本机同一个网络的节点重建路由，self 是本机的节点名，
+// 返回新加入、离开和地址变了的节点
+func (r *Router) Update(nodes []*pb.NodeInfo, self string) (joined, left []string) {
+	var network string
+	for _, info := range nodes {
+		if info.GetName() == self {
+			network = info.GetNetwork()
+		}
+	}
+	routes := make(map[string]string)
+	addrs := make(map[string][]string)
+	var selfIPs []string
+	for _, info := range nodes {
+		if info.GetNetwork() != network {
+			continue
+		}
+		var ips []string
+		for _, s := range []string{info.GetVirtualIp(), info.GetVirtualIp6()} {
+			if ip := net.ParseIP(s); ip != nil {
+				ips = append(ips, ip.String())
+			}
+		}
+		if len(ips) == 0 {
+			continue
+		}
+		if info.GetName() == self {
+			selfIPs = ips
+			continue
+		}
+		for _, ip := range ips {
+			routes[ip] = info.GetName()
+		}
+		addrs[info.GetName()] = ips
+	}
+
+	r.mu.Lock()
+	for name, ips := range addrs {
+		if !slices.Equal(r.addrs[name], ips) {
+			joined = append(joined, name)
+		}
+	}
+	for name := range r.addrs {
+		if _, ok := addrs[name]; !ok {
+			left = append(left, name)
+		}
+	}
+	r.self, r.routes, r.addrs = selfIPs, routes, addrs
+	r.mu.Unlock()
+	sort.Strings(joined)
+	sort.Strings(left)
+	return joined, left
+}
+
+// Lookup 返回同一个网络里节点的虚拟 IP
+func (r *Router) Lookup(name string) ([]net.IP, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ips, ok := r.addrs[name]
+	if !ok {
+		return nil, false
+	}
+	var out []net.IP
+	for _, ip := range ips {
+		out = append(out, net.ParseIP(ip))
+	}
+	return out, true
+}
+
+// Run 从网卡读包发给目的虚拟 IP 对应的节点，网卡关闭后返回
+func (r *Router) Run() error {
+	buf := make([]byte, 65535)
+	for {
+		n, err := r.dev.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		pkt := buf[:n]
+		_, dst, ok := packetAddrs(pkt)
+		if !ok {
+			continue
+		}
+		r.mu.RLock()
+		name, ok := r.routes[dst.String()]
+		r.mu.RUnlock()
+		if !ok {
+			// 广播、组播和没有节点的地址都丢掉
+			continue
+		}
+		if r.opts.Allow != nil {
+			proto, sport, dport := packetPorts(pkt)
+			r.track(flowKey{proto: proto, peer: dst.String(), local: sport, remote: dport})
+		}
+		if err := r.sender.Send(name, &pb.PeerMsg{Body: &pb.PeerMsg_Packet{Packet: pkt}}); err != nil {
+			r.opts.Logger.Debug("send packet failed", "peer", name, "dst", dst.String(), "err", err)
+		}
+	}
+}
+
+// Handle 把对端发来的包写进网卡。源地址必须是对端自己的虚拟 IP，目的地址必须是本机，
+// 防止对端冒用别人的地址或者借本机转发
+func (r *Router) Handle(from string, pkt []byte) {
+	src, dst, ok := packetAddrs(pkt)
+	if !ok {
+		return
+	}
+	r.mu.RLock()
+	ok = slices.Contains(r.addrs[from], src.String()) && slices.Contains(r.self, dst.String())
+	r.mu.RUnlock()
+	if !ok {
+		r.opts.Logger.Debug("drop packet from peer", "peer", from, "src", src.String(), "dst", dst.String())
+		return
+	}
+	if r.opts.Allow != nil {
+		proto, sport, dport := packetPorts(pkt)
+		if !r.opts.Allow(from, int(dport)) && !r.established(flowKey{proto: proto, peer: src.String(), local: dport, remote: sport}) {
+			r.opts.Logger.Debug("packet denied by policy", "peer", from, "proto", proto, "port", dport)
+			return
+		}
+	}
+	if _, err := r.dev.Write(pkt); err != nil {
+		r.opts.Logger.Warn("write packet failed", "err", err)
+	}
+}
+
+// track 记下本机发出的包，顺便清理过期的连接
+func (r *Router) track(k flowKey) {
+	now := time.Now()
+	r.flowMu.Lock()
+	defer r.flowMu.Unlock()
+	r.flows[k] = now
+	if now.Sub(r.lastSweep) < r.opts.FlowTimeout {
+		return
+	}
+	r.lastSweep = now
+	for k, t := range r.flows {
+		if now.Sub(t) > r.opts.FlowTimeout {
+			delete(r.flows, k)
+		}
+	}
+}
+
+// established 判断 k 是不是本机最近发起的连接
+func (r *Router) established(k flowKey) bool {
+	r.flowMu.Lock()
+	defer r.flowMu.Unlock()
+	t, ok := r.flows[k]
+	return ok && time.Since(t) <= r.opts.FlowTimeout
+}
+
+// packetAddrs 从 IPv4 或 IPv6 包头取源地址和目的地址
+func packetAddrs(pkt []byte) (src, dst net.IP, ok bool) {
+	if len(pkt) == 0 {
+		return nil, nil, false
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return nil, nil, false
+		}
+		return net.IP(pkt[12:16]), net.IP(pkt[16:20]), true
+	case 6:
+		if len(pkt) < 40 {
+			return nil, nil, false
+		}
+		return net.IP(pkt[8:24]), net.IP(pkt[24:40]), true
+	}
+	return nil, nil, false
+}
+
+// packetPorts 取 TCP 和 UDP 包的协议号和端口，其他协议和后续分片的端口是 0
+func packetPorts(pkt []byte) (proto uint8, sport, dport uint16) {
+	var off int
+	switch pkt[0] >> 4 {
+	case 4:
+		off = int(pkt[0]&0x0f) * 4
+		proto = pkt[9]
+		if binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+			return proto, 0, 0
+		}
+	case 6:
+		proto, off = pkt[6], 40
+		// 跳过逐跳、路由、目的选项和分片扩展头
+		for {
+			if proto == 44 {
+				if len(pkt) < off+8 {
+					return proto, 0, 0
+				}
+				frag := binary.BigEndian.Uint16(pkt[off+2:off+4]) &^ 7
+				proto, off = pkt[off], off+8
+				if frag != 0 {
+					return proto, 0, 0
+				}
+				continue
+			}
+			if proto != 0 && proto != 43 && proto != 60 {
+				break
+			}
+			if len(pkt) < off+2 {
+				return proto, 0, 0
+			}
+			proto, off = pkt[off], off+(int(pkt[off+1])+1)*8
+		}
+	}
+	if (proto != 6 && proto != 17) || len(pkt) < off+4 {
+		return proto, 0, 0
+	}
+	return proto, binary.BigEndian.Uint16(pkt[off : off+2]), binary.BigEndian.Uint16(pkt[off+2 : off+4])
+}
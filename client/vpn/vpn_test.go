@@ -0,0 +1,197 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+// chanDev 模拟 TUN 网卡，in 是内核发出的包，out 是写进内核的包
+type chanDev struct {
+	in  chan []byte
+	out chan []byte
+}
+
+func newChanDev() *chanDev {
+	return &chanDev{in: make(chan []byte, 16), out: make(chan []byte, 16)}
+}
+
+func (d *chanDev) Read(b []byte) (int, error) {
+	pkt, ok := <-d.in
+	if !ok {
+		return 0, os.ErrClosed
+	}
+	return copy(b, pkt), nil
+}
+
+func (d *chanDev) Write(b []byte) (int, error) {
+	d.out <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+// loopSender 把包直接交给对端的 Router
+type loopSender struct {
+	name  string
+	peers map[string]*Router
+}
+
+func (s *loopSender) Send(name string, msg *pb.PeerMsg) error {
+	if r, ok := s.peers[name]; ok {
+		r.Handle(s.name, append([]byte(nil), msg.GetPacket()...))
+	}
+	return nil
+}
+
+func ipv4Packet(src, dst string) []byte {
+	pkt := make([]byte, 28)
+	pkt[0] = 0x45
+	copy(pkt[12:16], net.ParseIP(src).To4())
+	copy(pkt[16:20], net.ParseIP(dst).To4())
+	return pkt
+}
+
+func ipv6Packet(src, dst string) []byte {
+	pkt := make([]byte, 48)
+	pkt[0] = 0x60
+	copy(pkt[8:24], net.ParseIP(src))
+	copy(pkt[24:40], net.ParseIP(dst))
+	return pkt
+}
+
+func TestRouter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	peers := make(map[string]*Router)
+	devA, devB := newChanDev(), newChanDev()
+	a := NewRouter(devA, &loopSender{name: "a", peers: peers}, Options{Logger: logger})
+	b := NewRouter(devB, &loopSender{name: "b", peers: peers}, Options{Logger: logger})
+	peers["a"], peers["b"] = a, b
+	nodes := []*pb.NodeInfo{
+		{Name: "a", Network: "default", VirtualIp: "100.64.0.1", VirtualIp6: "fd00::1"},
+		{Name: "b", Network: "default", VirtualIp: "100.64.0.2", VirtualIp6: "fd00::2"},
+		{Name: "c", Network: "default", VirtualIp: "100.64.0.3"},
+		{Name: "other", Network: "office", VirtualIp: "100.64.0.4"},
+		{Name: "old", Network: "default"},
+	}
+	joined, left := a.Update(nodes, "a")
+	if !reflect.DeepEqual(joined, []string{"b", "c"}) || left != nil {
+		t.Fatalf("joined %v left %v", joined, left)
+	}
+	b.Update(nodes, "b")
+	go a.Run()
+	defer close(devA.in)
+
+	pkt := ipv4Packet("100.64.0.1", "100.64.0.2")
+	devA.in <- pkt
+	select {
+	case got := <-devB.out:
+		if !reflect.DeepEqual(got, pkt) {
+			t.Fatalf("got %x, want %x", got, pkt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("packet not delivered")
+	}
+
+	pkt = ipv6Packet("fd00::1", "fd00::2")
+	devA.in <- pkt
+	select {
+	case got := <-devB.out:
+		if !reflect.DeepEqual(got, pkt) {
+			t.Fatalf("got %x, want %x", got, pkt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ipv6 packet not delivered")
+	}
+
+	// 冒用别人的源地址、目的不是本机的包都被丢掉
+	b.Handle("a", ipv4Packet("100.64.0.3", "100.64.0.2"))
+	b.Handle("a", ipv4Packet("100.64.0.1", "100.64.0.3"))
+	b.Handle("unknown", ipv4Packet("100.64.0.1", "100.64.0.2"))
+	select {
+	case got := <-devB.out:
+		t.Fatalf("unexpected packet %x", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	joined, left = a.Update(nodes[:2], "a")
+	if joined != nil || !reflect.DeepEqual(left, []string{"c"}) {
+		t.Fatalf("joined %v left %v", joined, left)
+	}
+	if _, ok := a.Lookup("c"); ok {
+		t.Fatal("route to c not removed")
+	}
+}
+
+// withPorts 把测试包改成 TCP 包
+func withPorts(pkt []byte, sport, dport uint16) []byte {
+	off := 20
+	if pkt[0]>>4 == 6 {
+		pkt[6], off = 6, 40
+	} else {
+		pkt[9] = 6
+	}
+	binary.BigEndian.PutUint16(pkt[off:], sport)
+	binary.BigEndian.PutUint16(pkt[off+2:], dport)
+	return pkt
+}
+
+func TestRouterPolicy(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	peers := make(map[string]*Router)
+	devA, devB := newChanDev(), newChanDev()
+	// b 只允许 a 连 22 端口，a 不允许任何入站连接
+	a := NewRouter(devA, &loopSender{name: "a", peers: peers}, Options{Logger: logger, Allow: func(string, int) bool { return false }})
+	b := NewRouter(devB, &loopSender{name: "b", peers: peers}, Options{Logger: logger, Allow: func(peer string, port int) bool { return peer == "a" && port == 22 }})
+	peers["a"], peers["b"] = a, b
+	nodes := []*pb.NodeInfo{
+		{Name: "a", Network: "default", VirtualIp: "100.64.0.1", VirtualIp6: "fd00::1"},
+		{Name: "b", Network: "default", VirtualIp: "100.64.0.2", VirtualIp6: "fd00::2"},
+	}
+	a.Update(nodes, "a")
+	b.Update(nodes, "b")
+	go a.Run()
+	defer close(devA.in)
+	go b.Run()
+	defer close(devB.in)
+
+	expect := func(dev *chanDev, want []byte) {
+		t.Helper()
+		select {
+		case got := <-dev.out:
+			if want == nil {
+				t.Fatalf("unexpected packet %x", got)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %x, want %x", got, want)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want != nil {
+				t.Fatal("packet not delivered")
+			}
+		}
+	}
+
+	syn := withPorts(ipv4Packet("100.64.0.1", "100.64.0.2"), 40000, 22)
+	devA.in <- syn
+	expect(devB, syn)
+	// a 发起的连接的回包能回来，b 主动连 a 不行
+	reply := withPorts(ipv4Packet("100.64.0.2", "100.64.0.1"), 22, 40000)
+	devB.in <- reply
+	expect(devA, reply)
+	devB.in <- withPorts(ipv4Packet("100.64.0.2", "100.64.0.1"), 22, 40001)
+	expect(devA, nil)
+
+	devA.in <- withPorts(ipv6Packet("fd00::1", "fd00::2"), 40000, 80)
+	expect(devB, nil)
+	devA.in <- ipv4Packet("100.64.0.1", "100.64.0.2")
+	expect(devB, nil)
+	v6 := withPorts(ipv6Packet("fd00::1", "fd00::2"), 40000, 22)
+	devA.in <- v6
+	expect(devB, v6)
+}
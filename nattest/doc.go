@@ -0,0 +1,11 @@
+// Package nattest 是穿透 NAT 的集成测试，用 Linux 网络命名空间和 iptables 搭出
+// 全锥型、地址限制型、端口限制型和对称型 NAT，在里面跑服务器和两个客户端，
+// 检查每种组合是直连、靠对端新映射的端口（端口预测）打通，还是只能走中继。
+//
+// 测试要 root、ip 和 iptables，缺任何一个都会跳过，-short 时也跳过：
+//
+//	sudo go test ./nattest -v
+//
+// 服务器带 -relay 启动。需要中继的组合打不通，测试再用两个聊天客户端
+// 检查消息能经服务器中转送达
+package nattest
@@ -0,0 +1,234 @@
+package nattest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// NAT 类型，和 iptables 规则一一对应
+const (
+	fullCone       = "FullCone"
+	restricted     = "Restricted"
+	portRestricted = "PortRestricted"
+	symmetric      = "Symmetric"
+)
+
+// 地址规划：wan 命名空间里的网桥是公网，服务器的两个地址和两个 NAT 网关的公网地址
+// 在同一个网段，服务器回包的源地址不会因为出口不同而变。两侧各有一台内网主机，
+// 内网网段不同，避免一侧的内网候选地址在另一侧碰巧可达
+const (
+	serverIP    = "198.51.100.254"
+	serverAltIP = "198.51.100.253"
+)
+
+type side struct {
+	wanIP  string // NAT 网关的公网地址
+	lanNet string
+	lanGw  string
+	hostIP string
+}
+
+var sides = [2]side{
+	{wanIP: "198.51.100.1", lanNet: "10.1.0.0/24", lanGw: "10.1.0.1", hostIP: "10.1.0.2"},
+	{wanIP: "198.51.100.2", lanNet: "10.2.0.0/24", lanGw: "10.2.0.1", hostIP: "10.2.0.2"},
+}
+
+var (
+	buildOnce sync.Once
+	binDir    string
+	buildErr  error
+)
+
+// binaries 编译一次服务器和客户端，所有组合共用
+func binaries(t *testing.T) string {
+	buildOnce.Do(func() {
+		binDir, buildErr = os.MkdirTemp("", "nattest")
+		if buildErr != nil {
+			return
+		}
+		for name, pkg := range map[string]string{"srv": "github.com/jinyunx/p2p/server", "cli": "github.com/jinyunx/p2p/client"} {
+			out, err := exec.Command("go", "build", "-o", filepath.Join(binDir, name), pkg).CombinedOutput()
+			if err != nil {
+				buildErr = fmt.Errorf("build %s: %v\n%s", pkg, err, out)
+				return
+			}
+		}
+	})
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	return binDir
+}
+
+// requireTools 检查 root 和需要的命令，在临时命名空间里试一下 nat 表，内核不支持时跳过
+func requireTools(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping namespace test in short mode")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to create network namespaces")
+	}
+	for _, tool := range []string{"ip", "iptables"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	ns := fmt.Sprintf("p2pnat%d-probe", os.Getpid())
+	if out, err := exec.Command("ip", "netns", "add", ns).CombinedOutput(); err != nil {
+		t.Skipf("cannot create network namespace: %v %s", err, out)
+	}
+	defer exec.Command("ip", "netns", "del", ns).Run()
+	if out, err := exec.Command("ip", "netns", "exec", ns, "iptables", "-t", "nat", "-L").CombinedOutput(); err != nil {
+		t.Skipf("iptables nat table unavailable: %v %s", err, out)
+	}
+}
+
+// lab 是一个组合用到的五个命名空间：wan、两个 NAT 网关和两台主机
+type lab struct {
+	t      *testing.T
+	prefix string
+	procs  []*exec.Cmd
+	stdins []io.WriteCloser
+}
+
+func (l *lab) ns(name string) string {
+	return l.prefix + name
+}
+
+// sh 在宿主机上执行命令，失败时结束测试
+func (l *lab) sh(args ...string) {
+	l.t.Helper()
+	if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		l.t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// in 在命名空间里执行命令
+func (l *lab) in(ns string, args ...string) {
+	l.t.Helper()
+	l.sh(append([]string{"ip", "netns", "exec", l.ns(ns)}, args...)...)
+}
+
+// link 用一对 veth 连接两个命名空间，地址为空时不配地址
+func (l *lab) link(a, aIf, aAddr, b, bIf, bAddr string) {
+	l.t.Helper()
+	l.sh("ip", "link", "add", aIf, "netns", l.ns(a), "type", "veth", "peer", "name", bIf, "netns", l.ns(b))
+	if aAddr != "" {
+		l.in(a, "ip", "addr", "add", aAddr, "dev", aIf)
+	}
+	l.in(a, "ip", "link", "set", aIf, "up")
+	l.in(b, "ip", "addr", "add", bAddr, "dev", bIf)
+	l.in(b, "ip", "link", "set", bIf, "up")
+}
+
+// newLab 搭出拓扑，nats[i] 是第 i 侧网关的 NAT 类型，测试结束后删掉命名空间和进程
+func newLab(t *testing.T, id int, nats [2]string) *lab {
+	l := &lab{t: t, prefix: fmt.Sprintf("p2pnat%d-%d-", os.Getpid(), id)}
+	names := []string{"wan", "nat0", "nat1", "host0", "host1"}
+	t.Cleanup(func() {
+		for _, p := range l.procs {
+			p.Process.Kill()
+			p.Wait()
+		}
+		for _, name := range names {
+			exec.Command("ip", "netns", "del", l.ns(name)).Run()
+		}
+	})
+	for _, name := range names {
+		l.sh("ip", "netns", "add", l.ns(name))
+		l.in(name, "ip", "link", "set", "lo", "up")
+	}
+	l.in("wan", "ip", "link", "add", "br0", "type", "bridge")
+	l.in("wan", "ip", "addr", "add", serverIP+"/24", "dev", "br0")
+	l.in("wan", "ip", "addr", "add", serverAltIP+"/24", "dev", "br0")
+	l.in("wan", "ip", "link", "set", "br0", "up")
+	for i, s := range sides {
+		nat, host := fmt.Sprintf("nat%d", i), fmt.Sprintf("host%d", i)
+		l.link("wan", nat, "", nat, "wan0", s.wanIP+"/24")
+		l.in("wan", "ip", "link", "set", nat, "master", "br0")
+		l.link(nat, "lan0", s.lanGw+"/24", host, "eth0", s.hostIP+"/24")
+		l.in(nat, "ip", "route", "add", "default", "via", serverIP)
+		l.in(host, "ip", "route", "add", "default", "via", s.lanGw)
+		l.in(nat, "sysctl", "-qw", "net.ipv4.ip_forward=1")
+		l.natRules(nat, nats[i], s.hostIP)
+	}
+	return l
+}
+
+// natRules 在网关上配置 NAT。Linux 的 MASQUERADE 尽量保留源端口，映射和目的无关，
+// 回包只认完全一致的五元组，就是端口限制型；全锥型再把所有进来的 UDP 转给主机，
+// 地址限制型在此基础上只放行主机发过包的 IP；对称型每个连接随机分配端口
+func (l *lab) natRules(ns, nat, host string) {
+	l.t.Helper()
+	ipt := func(args ...string) { l.in(ns, append([]string{"iptables"}, args...)...) }
+	switch nat {
+	case fullCone, restricted:
+		ipt("-t", "nat", "-A", "POSTROUTING", "-o", "wan0", "-j", "MASQUERADE")
+		ipt("-t", "nat", "-A", "PREROUTING", "-i", "wan0", "-p", "udp", "-j", "DNAT", "--to-destination", host)
+		if nat == restricted {
+			ipt("-A", "FORWARD", "-i", "lan0", "-o", "wan0", "-m", "recent", "--name", "peers", "--rdest", "--set")
+			ipt("-A", "FORWARD", "-i", "wan0", "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT")
+			ipt("-A", "FORWARD", "-i", "wan0", "-m", "recent", "--name", "peers", "--rsource", "--rcheck", "-j", "ACCEPT")
+			ipt("-A", "FORWARD", "-i", "wan0", "-j", "DROP")
+		}
+	case portRestricted:
+		ipt("-t", "nat", "-A", "POSTROUTING", "-o", "wan0", "-j", "MASQUERADE")
+	case symmetric:
+		ipt("-t", "nat", "-A", "POSTROUTING", "-o", "wan0", "-j", "MASQUERADE", "--random-fully")
+	default:
+		l.t.Fatalf("unknown nat type %s", nat)
+	}
+}
+
+// logBuffer 收集后台进程的输出，进程还在写的时候也能读
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// start 在命名空间里后台运行程序，输出收集到 out，返回程序的标准输入
+func (l *lab) start(ns string, out *logBuffer, args ...string) io.Writer {
+	l.t.Helper()
+	cmd := exec.Command("ip", append([]string{"netns", "exec", l.ns(ns)}, args...)...)
+	cmd.Stdout, cmd.Stderr = out, out
+	// 聊天客户端读到标准输入结束就退出，给它一个不会结束的管道，
+	// 写端要留着，被回收时会关掉
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	l.stdins = append(l.stdins, stdin)
+	if err := cmd.Start(); err != nil {
+		l.t.Fatal(err)
+	}
+	l.procs = append(l.procs, cmd)
+	return stdin
+}
+
+// output 在命名空间里运行程序直到结束，返回标准输出，退出码不为 0 不算错
+func (l *lab) output(ns string, args ...string) ([]byte, []byte) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("ip", append([]string{"netns", "exec", l.ns(ns)}, args...)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.Run()
+	return stdout.Bytes(), stderr.Bytes()
+}
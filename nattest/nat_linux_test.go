@@ -0,0 +1,169 @@
+package nattest
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 打通的方式
+const (
+	direct     = "direct"     // 对端注册的候选地址回应了
+	prediction = "prediction" // 只从注册时没有的端口收到对端的包，对称型 NAT 的新映射
+	relay      = "relay"      // 打不通，经服务器中转
+)
+
+// report 是 diagnose -json 输出里用到的部分
+type report struct {
+	NatType     string `json:"nat_type"`
+	PeerNatType string `json:"peer_nat_type"`
+	Candidates  []struct {
+		Addr      string `json:"addr"`
+		Reachable bool   `json:"reachable"`
+	} `json:"candidates"`
+	PeerAddrs []string `json:"peer_addrs"`
+	Steps     []struct {
+		Name  string `json:"name"`
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	} `json:"steps"`
+	FailedStep string `json:"failed_step"`
+}
+
+func (r *report) ok(step string) bool {
+	for _, s := range r.Steps {
+		if s.Name == step {
+			return s.OK
+		}
+	}
+	return false
+}
+
+// classify 按诊断报告判断打通的方式
+func classify(r *report) string {
+	if !r.ok("punching") {
+		return relay
+	}
+	for _, c := range r.Candidates {
+		if c.Reachable {
+			return direct
+		}
+	}
+	registered := make(map[string]bool)
+	for _, c := range r.Candidates {
+		registered[c.Addr] = true
+	}
+	for _, addr := range r.PeerAddrs {
+		if registered[addr] {
+			return direct
+		}
+	}
+	return prediction
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`{"steps": [{"name": "registration", "ok": true}, {"name": "punching", "ok": false}]}`, relay},
+		{`{"candidates": [{"addr": "203.0.113.1:4000", "reachable": true}], "steps": [{"name": "punching", "ok": true}]}`, direct},
+		{`{"candidates": [{"addr": "203.0.113.1:4000"}], "peer_addrs": ["203.0.113.1:4000"], "steps": [{"name": "punching", "ok": true}]}`, direct},
+		{`{"candidates": [{"addr": "203.0.113.1:4000"}], "peer_addrs": ["203.0.113.1:31337"], "steps": [{"name": "punching", "ok": true}]}`, prediction},
+	}
+	for _, tt := range tests {
+		var r report
+		if err := json.Unmarshal([]byte(tt.in), &r); err != nil {
+			t.Fatal(err)
+		}
+		if got := classify(&r); got != tt.want {
+			t.Errorf("classify(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// expected 是两侧 NAT 组合的预期结果，和顺序无关。
+// 锥型之间双方同时打洞就能直连；对称型一侧打过去的包换了端口，对面只按 IP 过滤时
+// 能收到并回给这个新端口；对面按端口过滤时要猜中随机端口，基本不可能，只能中继
+var expected = map[[2]string]string{
+	{fullCone, fullCone}:             direct,
+	{fullCone, restricted}:           direct,
+	{fullCone, portRestricted}:       direct,
+	{fullCone, symmetric}:            prediction,
+	{restricted, restricted}:         direct,
+	{restricted, portRestricted}:     direct,
+	{restricted, symmetric}:          prediction,
+	{portRestricted, portRestricted}: direct,
+	{portRestricted, symmetric}:      relay,
+	{symmetric, symmetric}:           relay,
+}
+
+func TestTraversal(t *testing.T) {
+	requireTools(t)
+	bin := binaries(t)
+	id := 0
+	for pair, want := range expected {
+		id++
+		pair, want, id := pair, want, id
+		t.Run(pair[0]+"-"+pair[1], func(t *testing.T) {
+			t.Parallel()
+			got, r, logs := traverse(t, bin, id, pair, want == relay)
+			t.Logf("nat %s/%s detected as %s/%s, peer addrs %v", pair[0], pair[1], r.NatType, r.PeerNatType, r.PeerAddrs)
+			if got != want {
+				t.Errorf("%s <-> %s: %s, want %s\n%s", pair[0], pair[1], got, want, logs)
+			}
+		})
+	}
+}
+
+// traverse 在 host1 上跑聊天客户端作为对端，在 host0 上跑 diagnose 对它打洞，
+// checkRelay 时再检查聊天消息能经服务器中转
+func traverse(t *testing.T, bin string, id int, nats [2]string, checkRelay bool) (string, *report, string) {
+	l := newLab(t, id, nats)
+	var srvLog, peerLog logBuffer
+	l.start("wan", &srvLog, filepath.Join(bin, "srv"), "-stun-ip", serverIP, "-stun-alt-ip", serverAltIP, "-udp-rate", "0", "-rpc-rate", "0", "-relay")
+	l.start("host1", &peerLog, filepath.Join(bin, "cli"), "chat", "-name", "b", "-lan=false", serverIP)
+
+	var r report
+	var stderr []byte
+	// 服务器和对端启动要一点时间，对端还没注册时重试
+	for try := 0; try < 10; try++ {
+		time.Sleep(time.Second)
+		var out []byte
+		out, stderr = l.output("host0", filepath.Join(bin, "cli"), "diagnose", "-json", "-name", "a", "-timeout", "8s", serverIP, "b")
+		r = report{}
+		if err := json.Unmarshal(out, &r); err != nil {
+			t.Fatalf("bad diagnose output: %v\n%s\n%s", err, out, stderr)
+		}
+		if r.ok("registration") {
+			break
+		}
+	}
+	if !r.ok("registration") {
+		t.Fatalf("registration failed: %+v\nserver:\n%s\npeer:\n%s", r.Steps, srvLog.String(), peerLog.String())
+	}
+	logs := fmt.Sprintf("diagnose:\n%s\npeer:\n%s", stderr, peerLog.String())
+	if checkRelay {
+		relayChat(t, l, bin, &peerLog)
+	}
+	return classify(&r), &r, logs
+}
+
+// relayChat 在 host0 上跑另一个聊天客户端给 b 发消息，打不通时消息经服务器中转，
+// b 显示的发送方带 (relayed)
+func relayChat(t *testing.T, l *lab, bin string, peerLog *logBuffer) {
+	var chatLog logBuffer
+	stdin := l.start("host0", &chatLog, filepath.Join(bin, "cli"), "chat", "-name", "a", "-lan=false", serverIP, "b")
+	// 注册和拉到 b 的注册信息要一点时间，之前发的消息会提示 b 没注册
+	for try := 0; try < 20; try++ {
+		time.Sleep(time.Second)
+		if strings.Contains(peerLog.String(), "[a (relayed)] over relay") {
+			return
+		}
+		fmt.Fprintf(stdin, "over relay %d\n", try)
+	}
+	t.Errorf("relayed message not delivered\nsender:\n%s\npeer:\n%s", chatLog.String(), peerLog.String())
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/server/guard"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, c pb.AdminClient, args []string) error
+}
+
+var commands = map[string]command{
+	"list":       {"list [-prefix p] [-ip ip] [-nat type,...] [-limit n] [-all]", listNodes},
+	"get":        {"get <name>", getNode},
+	"kick":       {"kick <name>", kickNode},
+	"ban-name":   {"ban-name <name>", banName(false)},
+	"unban-name": {"unban-name <name>", banName(true)},
+	"ban-ip":     {"ban-ip <ip|cidr>", banIP(false)},
+	"unban-ip":   {"unban-ip <ip|cidr>", banIP(true)},
+	"drain":      {"drain on|off", drain},
+	"stats":      {"stats", stats},
+	"addrs":      {"addrs [-network n]", listAddresses},
+	"reserve":    {"reserve [-network n] <name> <ip>", reserveAddress},
+	"release":    {"release [-network n] <name>", releaseAddress},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] <command> [args]\n\ncommands:\n", os.Args[0])
+	for _, name := range []string{"list", "get", "kick", "ban-name", "unban-name", "ban-ip", "unban-ip", "drain", "stats", "addrs", "reserve", "release"} {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(out, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:50052", "admin api address")
+	tokenFile := flag.String("token-file", "", "file holding the admin token, P2P_ADMIN_TOKEN is used if empty")
+	timeout := flag.Duration("timeout", 5*time.Second, "request timeout")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	token := os.Getenv("P2P_ADMIN_TOKEN")
+	if *tokenFile != "" {
+		b, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			fatal(err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	conn, err := grpc.Dial(*addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(guard.TokenCredentials(token)))
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := cmd.run(ctx, pb.NewAdminClient(conn), flag.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "usage: %s %s\n", os.Args[0], cmd.usage)
+			os.Exit(2)
+		}
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+func oneArg(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", flag.ErrHelp
+	}
+	return args[0], nil
+}
+
+func parseNatTypes(s string) ([]pb.NatType, error) {
+	var types []pb.NatType
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		found := false
+		for v, name := range pb.NatType_name {
+			if strings.EqualFold(strings.TrimPrefix(name, "NatType_"), item) {
+				types = append(types, pb.NatType(v))
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown nat type %q", item)
+		}
+	}
+	return types, nil
+}
+
+func natTypeName(t pb.NatType) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "NatType_"))
+}
+
+func printNodes(w *tabwriter.Writer, nodes []*pb.AdminNodeInfo) {
+	for _, n := range nodes {
+		info := n.GetNodeInfo()
+		fmt.Fprintf(w, "%s\t%s:%d\t%s\t%s\t%s\t%s\n",
+			info.GetName(),
+			info.GetUdpAddr().GetIp(), info.GetUdpAddr().GetPort(),
+			natTypeName(info.GetNatType()),
+			virtualIPs(info.GetNetwork(), info.GetVirtualIp(), info.GetVirtualIp6()),
+			n.GetSourceIp(),
+			time.Unix(n.GetUpdatedAt(), 0).Format(time.RFC3339))
+	}
+}
+
+func listNodes(ctx context.Context, c pb.AdminClient, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only nodes whose name has this prefix")
+	ip := fs.String("ip", "", "only nodes registered from this source ip")
+	nat := fs.String("nat", "", "comma separated nat types, e.g. symmetric,fullcone")
+	limit := fs.Int("limit", 100, "page size")
+	all := fs.Bool("all", false, "fetch all pages")
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	natTypes, err := parseNatTypes(*nat)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUDP ADDR\tNAT\tVIRTUAL IP\tSOURCE IP\tUPDATED")
+	req := &pb.ListNodesReq{
+		NamePrefix: *prefix,
+		SourceIp:   *ip,
+		NatTypes:   natTypes,
+		PageSize:   int32(*limit),
+	}
+	var total int32
+	for {
+		resp, err := c.ListNodes(ctx, req)
+		if err != nil {
+			return err
+		}
+		printNodes(w, resp.GetNodes())
+		total = resp.GetTotal()
+		if !*all || resp.GetNextPageToken() == "" {
+			if resp.GetNextPageToken() != "" {
+				fmt.Fprintf(w, "... more nodes, use -all to list them\n")
+			}
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	w.Flush()
+	fmt.Printf("total %d\n", total)
+	return nil
+}
+
+func getNode(ctx context.Context, c pb.AdminClient, args []string) error {
+	name, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetNode(ctx, &pb.GetNodeReq{Name: name})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUDP ADDR\tNAT\tVIRTUAL IP\tSOURCE IP\tUPDATED")
+	printNodes(w, []*pb.AdminNodeInfo{resp.GetNode()})
+	return w.Flush()
+}
+
+func kickNode(ctx context.Context, c pb.AdminClient, args []string) error {
+	name, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	if _, err := c.KickNode(ctx, &pb.KickNodeReq{Name: name}); err != nil {
+		return err
+	}
+	fmt.Printf("kicked %s\n", name)
+	return nil
+}
+
+func banName(unban bool) func(context.Context, pb.AdminClient, []string) error {
+	return func(ctx context.Context, c pb.AdminClient, args []string) error {
+		name, err := oneArg(args)
+		if err != nil {
+			return err
+		}
+		resp, err := c.BanName(ctx, &pb.BanNameReq{Name: name, Unban: unban})
+		if err != nil {
+			return err
+		}
+		if unban {
+			fmt.Printf("unbanned %s\n", name)
+		} else {
+			fmt.Printf("banned %s, kicked %d node(s)\n", name, resp.GetKicked())
+		}
+		return nil
+	}
+}
+
+func banIP(unban bool) func(context.Context, pb.AdminClient, []string) error {
+	return func(ctx context.Context, c pb.AdminClient, args []string) error {
+		cidr, err := oneArg(args)
+		if err != nil {
+			return err
+		}
+		resp, err := c.BanIP(ctx, &pb.BanIPReq{Cidr: cidr, Unban: unban})
+		if err != nil {
+			return err
+		}
+		if unban {
+			fmt.Printf("unbanned %s\n", cidr)
+		} else {
+			fmt.Printf("banned %s, kicked %d node(s)\n", cidr, resp.GetKicked())
+		}
+		return nil
+	}
+}
+
+func drain(ctx context.Context, c pb.AdminClient, args []string) error {
+	arg, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	var on bool
+	switch arg {
+	case "on":
+		on = true
+	case "off":
+	default:
+		return flag.ErrHelp
+	}
+	if _, err := c.DrainServer(ctx, &pb.DrainServerReq{Drain: on}); err != nil {
+		return err
+	}
+	fmt.Printf("drain %s\n", arg)
+	return nil
+}
+
+func stats(ctx context.Context, c pb.AdminClient, args []string) error {
+	if len(args) != 0 {
+		return flag.ErrHelp
+	}
+	s, err := c.GetStats(ctx, &pb.GetStatsReq{})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "started\t%s\n", time.Unix(s.GetStartedAt(), 0).Format(time.RFC3339))
+	fmt.Fprintf(w, "draining\t%v\n", s.GetDraining())
+	fmt.Fprintf(w, "nodes\t%d\n", s.GetNodes())
+	fmt.Fprintf(w, "registrations rejected\t%d\n", s.GetRegistrationsRejected())
+	fmt.Fprintf(w, "udp received/sent/dropped\t%d/%d/%d\n", s.GetUdpReceived(), s.GetUdpSent(), s.GetUdpDropped())
+	fmt.Fprintf(w, "udp dropped rate/acl\t%d/%d\n", s.GetUdpRateDropped(), s.GetUdpAclDropped())
+	fmt.Fprintf(w, "rpc dropped rate/acl\t%d/%d\n", s.GetRpcRateDropped(), s.GetRpcAclDropped())
+	fmt.Fprintf(w, "banned names\t%s\n", strings.Join(s.GetBannedNames(), ","))
+	fmt.Fprintf(w, "banned cidrs\t%s\n", strings.Join(s.GetBannedCidrs(), ","))
+	return w.Flush()
+}
+
+// virtualIPs 把节点的虚拟地址显示成 network:ipv4,ipv6，没有地址时显示 -
+func virtualIPs(network string, ips ...string) string {
+	var out []string
+	for _, ip := range ips {
+		if ip != "" {
+			out = append(out, ip)
+		}
+	}
+	if len(out) == 0 {
+		return "-"
+	}
+	return network + ":" + strings.Join(out, ",")
+}
+
+func listAddresses(ctx context.Context, c pb.AdminClient, args []string) error {
+	fs := flag.NewFlagSet("addrs", flag.ContinueOnError)
+	network := fs.String("network", "", "only this virtual network")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return flag.ErrHelp
+	}
+	resp, err := c.ListAddresses(ctx, &pb.ListAddressesReq{Network: *network})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NETWORK\tNAME\tIPV4\tIPV6\tSTATIC")
+	for _, a := range resp.GetAddresses() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\n", a.GetNetwork(), a.GetName(), a.GetIpv4(), a.GetIpv6(), a.GetStatic())
+	}
+	return w.Flush()
+}
+
+func reserveAddress(ctx context.Context, c pb.AdminClient, args []string) error {
+	fs := flag.NewFlagSet("reserve", flag.ContinueOnError)
+	network := fs.String("network", "", "virtual network, default if empty")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		return flag.ErrHelp
+	}
+	resp, err := c.ReserveAddress(ctx, &pb.ReserveAddressReq{Network: *network, Name: fs.Arg(0), Ip: fs.Arg(1)})
+	if err != nil {
+		return err
+	}
+	a := resp.GetAddress()
+	fmt.Printf("reserved %s for %s in %s\n", fs.Arg(1), a.GetName(), a.GetNetwork())
+	return nil
+}
+
+func releaseAddress(ctx context.Context, c pb.AdminClient, args []string) error {
+	fs := flag.NewFlagSet("release", flag.ContinueOnError)
+	network := fs.String("network", "", "virtual network, default if empty")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return flag.ErrHelp
+	}
+	if _, err := c.ReleaseAddress(ctx, &pb.ReleaseAddressReq{Network: *network, Name: fs.Arg(0)}); err != nil {
+		return err
+	}
+	fmt.Printf("released %s\n", fs.Arg(0))
+	return nil
+}
@@ -0,0 +1,448 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.19.4
+// source: admin.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Admin_ListNodes_FullMethodName      = "/proto.Admin/ListNodes"
+	Admin_GetNode_FullMethodName        = "/proto.Admin/GetNode"
+	Admin_KickNode_FullMethodName       = "/proto.Admin/KickNode"
+	Admin_BanName_FullMethodName        = "/proto.Admin/BanName"
+	Admin_BanIP_FullMethodName          = "/proto.Admin/BanIP"
+	Admin_DrainServer_FullMethodName    = "/proto.Admin/DrainServer"
+	Admin_GetStats_FullMethodName       = "/proto.Admin/GetStats"
+	Admin_ListAddresses_FullMethodName  = "/proto.Admin/ListAddresses"
+	Admin_ReserveAddress_FullMethodName = "/proto.Admin/ReserveAddress"
+	Admin_ReleaseAddress_FullMethodName = "/proto.Admin/ReleaseAddress"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	ListNodes(ctx context.Context, in *ListNodesReq, opts ...grpc.CallOption) (*ListNodesResp, error)
+	GetNode(ctx context.Context, in *GetNodeReq, opts ...grpc.CallOption) (*GetNodeResp, error)
+	KickNode(ctx context.Context, in *KickNodeReq, opts ...grpc.CallOption) (*KickNodeResp, error)
+	BanName(ctx context.Context, in *BanNameReq, opts ...grpc.CallOption) (*BanNameResp, error)
+	BanIP(ctx context.Context, in *BanIPReq, opts ...grpc.CallOption) (*BanIPResp, error)
+	// 停止接受新注册，便于下线前让客户端迁移
+	DrainServer(ctx context.Context, in *DrainServerReq, opts ...grpc.CallOption) (*DrainServerResp, error)
+	GetStats(ctx context.Context, in *GetStatsReq, opts ...grpc.CallOption) (*GetStatsResp, error)
+	ListAddresses(ctx context.Context, in *ListAddressesReq, opts ...grpc.CallOption) (*ListAddressesResp, error)
+	// 给节点预留固定的虚拟地址
+	ReserveAddress(ctx context.Context, in *ReserveAddressReq, opts ...grpc.CallOption) (*ReserveAddressResp, error)
+	// 释放节点的虚拟地址，在线的节点马上换一个新地址
+	ReleaseAddress(ctx context.Context, in *ReleaseAddressReq, opts ...grpc.CallOption) (*ReleaseAddressResp, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListNodes(ctx context.Context, in *ListNodesReq, opts ...grpc.CallOption) (*ListNodesResp, error) {
+	out := new(ListNodesResp)
+	err := c.cc.Invoke(ctx, Admin_ListNodes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetNode(ctx context.Context, in *GetNodeReq, opts ...grpc.CallOption) (*GetNodeResp, error) {
+	out := new(GetNodeResp)
+	err := c.cc.Invoke(ctx, Admin_GetNode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) KickNode(ctx context.Context, in *KickNodeReq, opts ...grpc.CallOption) (*KickNodeResp, error) {
+	out := new(KickNodeResp)
+	err := c.cc.Invoke(ctx, Admin_KickNode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) BanName(ctx context.Context, in *BanNameReq, opts ...grpc.CallOption) (*BanNameResp, error) {
+	out := new(BanNameResp)
+	err := c.cc.Invoke(ctx, Admin_BanName_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) BanIP(ctx context.Context, in *BanIPReq, opts ...grpc.CallOption) (*BanIPResp, error) {
+	out := new(BanIPResp)
+	err := c.cc.Invoke(ctx, Admin_BanIP_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DrainServer(ctx context.Context, in *DrainServerReq, opts ...grpc.CallOption) (*DrainServerResp, error) {
+	out := new(DrainServerResp)
+	err := c.cc.Invoke(ctx, Admin_DrainServer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetStats(ctx context.Context, in *GetStatsReq, opts ...grpc.CallOption) (*GetStatsResp, error) {
+	out := new(GetStatsResp)
+	err := c.cc.Invoke(ctx, Admin_GetStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListAddresses(ctx context.Context, in *ListAddressesReq, opts ...grpc.CallOption) (*ListAddressesResp, error) {
+	out := new(ListAddressesResp)
+	err := c.cc.Invoke(ctx, Admin_ListAddresses_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ReserveAddress(ctx context.Context, in *ReserveAddressReq, opts ...grpc.CallOption) (*ReserveAddressResp, error) {
+	out := new(ReserveAddressResp)
+	err := c.cc.Invoke(ctx, Admin_ReserveAddress_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ReleaseAddress(ctx context.Context, in *ReleaseAddressReq, opts ...grpc.CallOption) (*ReleaseAddressResp, error) {
+	out := new(ReleaseAddressResp)
+	err := c.cc.Invoke(ctx, Admin_ReleaseAddress_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	ListNodes(context.Context, *ListNodesReq) (*ListNodesResp, error)
+	GetNode(context.Context, *GetNodeReq) (*GetNodeResp, error)
+	KickNode(context.Context, *KickNodeReq) (*KickNodeResp, error)
+	BanName(context.Context, *BanNameReq) (*BanNameResp, error)
+	BanIP(context.Context, *BanIPReq) (*BanIPResp, error)
+	// 停止接受新注册，便于下线前让客户端迁移
+	DrainServer(context.Context, *DrainServerReq) (*DrainServerResp, error)
+	GetStats(context.Context, *GetStatsReq) (*GetStatsResp, error)
+	ListAddresses(context.Context, *ListAddressesReq) (*ListAddressesResp, error)
+	// 给节点预留固定的虚拟地址
+	ReserveAddress(context.Context, *ReserveAddressReq) (*ReserveAddressResp, error)
+	// 释放节点的虚拟地址，在线的节点马上换一个新地址
+	ReleaseAddress(context.Context, *ReleaseAddressReq) (*ReleaseAddressResp, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) ListNodes(context.Context, *ListNodesReq) (*ListNodesResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNodes not implemented")
+}
+func (UnimplementedAdminServer) GetNode(context.Context, *GetNodeReq) (*GetNodeResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNode not implemented")
+}
+func (UnimplementedAdminServer) KickNode(context.Context, *KickNodeReq) (*KickNodeResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KickNode not implemented")
+}
+func (UnimplementedAdminServer) BanName(context.Context, *BanNameReq) (*BanNameResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BanName not implemented")
+}
+func (UnimplementedAdminServer) BanIP(context.Context, *BanIPReq) (*BanIPResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BanIP not implemented")
+}
+func (UnimplementedAdminServer) DrainServer(context.Context, *DrainServerReq) (*DrainServerResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainServer not implemented")
+}
+func (UnimplementedAdminServer) GetStats(context.Context, *GetStatsReq) (*GetStatsResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedAdminServer) ListAddresses(context.Context, *ListAddressesReq) (*ListAddressesResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAddresses not implemented")
+}
+func (UnimplementedAdminServer) ReserveAddress(context.Context, *ReserveAddressReq) (*ReserveAddressResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReserveAddress not implemented")
+}
+func (UnimplementedAdminServer) ReleaseAddress(context.Context, *ReleaseAddressReq) (*ReleaseAddressResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseAddress not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNodesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListNodes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListNodes(ctx, req.(*ListNodesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetNode(ctx, req.(*GetNodeReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_KickNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KickNodeReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).KickNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_KickNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).KickNode(ctx, req.(*KickNodeReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_BanName_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BanNameReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).BanName(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_BanName_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).BanName(ctx, req.(*BanNameReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_BanIP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BanIPReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).BanIP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_BanIP_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).BanIP(ctx, req.(*BanIPReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DrainServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainServerReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DrainServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_DrainServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DrainServer(ctx, req.(*DrainServerReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetStats(ctx, req.(*GetStatsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListAddresses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAddressesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListAddresses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListAddresses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListAddresses(ctx, req.(*ListAddressesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ReserveAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReserveAddressReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ReserveAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ReserveAddress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ReserveAddress(ctx, req.(*ReserveAddressReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ReleaseAddress_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseAddressReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ReleaseAddress(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ReleaseAddress_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ReleaseAddress(ctx, req.(*ReleaseAddressReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListNodes",
+			Handler:    _Admin_ListNodes_Handler,
+		},
+		{
+			MethodName: "GetNode",
+			Handler:    _Admin_GetNode_Handler,
+		},
+		{
+			MethodName: "KickNode",
+			Handler:    _Admin_KickNode_Handler,
+		},
+		{
+			MethodName: "BanName",
+			Handler:    _Admin_BanName_Handler,
+		},
+		{
+			MethodName: "BanIP",
+			Handler:    _Admin_BanIP_Handler,
+		},
+		{
+			MethodName: "DrainServer",
+			Handler:    _Admin_DrainServer_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Admin_GetStats_Handler,
+		},
+		{
+			MethodName: "ListAddresses",
+			Handler:    _Admin_ListAddresses_Handler,
+		},
+		{
+			MethodName: "ReserveAddress",
+			Handler:    _Admin_ReserveAddress_Handler,
+		},
+		{
+			MethodName: "ReleaseAddress",
+			Handler:    _Admin_ReleaseAddress_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
@@ -1,3 +1,3 @@
 protoc --go_out=. --go_opt=paths=source_relative \
     --go-grpc_out=. --go-grpc_opt=paths=source_relative \
-    p2p.proto
\ No newline at end of file
+    p2p.proto admin.proto cluster.proto
\ No newline at end of file
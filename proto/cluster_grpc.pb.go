@@ -0,0 +1,189 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.19.4
+// source: cluster.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Cluster_Push_FullMethodName         = "/proto.Cluster/Push"
+	Cluster_Pull_FullMethodName         = "/proto.Cluster/Pull"
+	Cluster_DeliverPunch_FullMethodName = "/proto.Cluster/DeliverPunch"
+)
+
+// ClusterClient is the client API for Cluster service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ClusterClient interface {
+	// 推送本地最近的修改
+	Push(ctx context.Context, in *PushReq, opts ...grpc.CallOption) (*PushResp, error)
+	// 分页拉取对方的全量记录，用于补齐丢失的推送
+	Pull(ctx context.Context, in *PullReq, opts ...grpc.CallOption) (*PullResp, error)
+	// 转发打洞请求，被连接方可能在别的服务器上轮询
+	DeliverPunch(ctx context.Context, in *PunchRequest, opts ...grpc.CallOption) (*DeliverPunchResp, error)
+}
+
+type clusterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewClusterClient(cc grpc.ClientConnInterface) ClusterClient {
+	return &clusterClient{cc}
+}
+
+func (c *clusterClient) Push(ctx context.Context, in *PushReq, opts ...grpc.CallOption) (*PushResp, error) {
+	out := new(PushResp)
+	err := c.cc.Invoke(ctx, Cluster_Push_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterClient) Pull(ctx context.Context, in *PullReq, opts ...grpc.CallOption) (*PullResp, error) {
+	out := new(PullResp)
+	err := c.cc.Invoke(ctx, Cluster_Pull_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterClient) DeliverPunch(ctx context.Context, in *PunchRequest, opts ...grpc.CallOption) (*DeliverPunchResp, error) {
+	out := new(DeliverPunchResp)
+	err := c.cc.Invoke(ctx, Cluster_DeliverPunch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ClusterServer is the server API for Cluster service.
+// All implementations must embed UnimplementedClusterServer
+// for forward compatibility
+type ClusterServer interface {
+	// 推送本地最近的修改
+	Push(context.Context, *PushReq) (*PushResp, error)
+	// 分页拉取对方的全量记录，用于补齐丢失的推送
+	Pull(context.Context, *PullReq) (*PullResp, error)
+	// 转发打洞请求，被连接方可能在别的服务器上轮询
+	DeliverPunch(context.Context, *PunchRequest) (*DeliverPunchResp, error)
+	mustEmbedUnimplementedClusterServer()
+}
+
+// UnimplementedClusterServer must be embedded to have forward compatible implementations.
+type UnimplementedClusterServer struct {
+}
+
+func (UnimplementedClusterServer) Push(context.Context, *PushReq) (*PushResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+func (UnimplementedClusterServer) Pull(context.Context, *PullReq) (*PullResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pull not implemented")
+}
+func (UnimplementedClusterServer) DeliverPunch(context.Context, *PunchRequest) (*DeliverPunchResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeliverPunch not implemented")
+}
+func (UnimplementedClusterServer) mustEmbedUnimplementedClusterServer() {}
+
+// UnsafeClusterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ClusterServer will
+// result in compilation errors.
+type UnsafeClusterServer interface {
+	mustEmbedUnimplementedClusterServer()
+}
+
+func RegisterClusterServer(s grpc.ServiceRegistrar, srv ClusterServer) {
+	s.RegisterService(&Cluster_ServiceDesc, srv)
+}
+
+func _Cluster_Push_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServer).Push(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Cluster_Push_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServer).Push(ctx, req.(*PushReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Cluster_Pull_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PullReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServer).Pull(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Cluster_Pull_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServer).Pull(ctx, req.(*PullReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Cluster_DeliverPunch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PunchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServer).DeliverPunch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Cluster_DeliverPunch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServer).DeliverPunch(ctx, req.(*PunchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Cluster_ServiceDesc is the grpc.ServiceDesc for Cluster service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Cluster_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Cluster",
+	HandlerType: (*ClusterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Push",
+			Handler:    _Cluster_Push_Handler,
+		},
+		{
+			MethodName: "Pull",
+			Handler:    _Cluster_Pull_Handler,
+		},
+		{
+			MethodName: "DeliverPunch",
+			Handler:    _Cluster_DeliverPunch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "cluster.proto",
+}
@@ -66,6 +66,180 @@ func (ServerInfo) EnumDescriptor() ([]byte, []int) {
 	return file_p2p_proto_rawDescGZIP(), []int{0}
 }
 
+type NatType int32
+
+const (
+	NatType_NatType_Unknown           NatType = 0
+	NatType_NatType_Open              NatType = 1
+	NatType_NatType_FullCone          NatType = 2
+	NatType_NatType_Restricted        NatType = 3
+	NatType_NatType_PortRestricted    NatType = 4
+	NatType_NatType_Symmetric         NatType = 5
+	NatType_NatType_SymmetricFirewall NatType = 6
+	NatType_NatType_UdpBlocked        NatType = 7
+)
+
+// Enum value maps for NatType.
+var (
+	NatType_name = map[int32]string{
+		0: "NatType_Unknown",
+		1: "NatType_Open",
+		2: "NatType_FullCone",
+		3: "NatType_Restricted",
+		4: "NatType_PortRestricted",
+		5: "NatType_Symmetric",
+		6: "NatType_SymmetricFirewall",
+		7: "NatType_UdpBlocked",
+	}
+	NatType_value = map[string]int32{
+		"NatType_Unknown":           0,
+		"NatType_Open":              1,
+		"NatType_FullCone":          2,
+		"NatType_Restricted":        3,
+		"NatType_PortRestricted":    4,
+		"NatType_Symmetric":         5,
+		"NatType_SymmetricFirewall": 6,
+		"NatType_UdpBlocked":        7,
+	}
+)
+
+func (x NatType) Enum() *NatType {
+	p := new(NatType)
+	*p = x
+	return p
+}
+
+func (x NatType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (NatType) Descriptor() protoreflect.EnumDescriptor {
+	return file_p2p_proto_enumTypes[1].Descriptor()
+}
+
+func (NatType) Type() protoreflect.EnumType {
+	return &file_p2p_proto_enumTypes[1]
+}
+
+func (x NatType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use NatType.Descriptor instead.
+func (NatType) EnumDescriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{1}
+}
+
+type CandidateType int32
+
+const (
+	CandidateType_CandidateType_Host       CandidateType = 0 // 本地网卡地址，同一局域网内直连
+	CandidateType_CandidateType_Reflexive  CandidateType = 1 // 服务器看到的外网地址
+	CandidateType_CandidateType_PortMapped CandidateType = 2 // 网关端口映射出来的地址，不用打洞
+)
+
+// Enum value maps for CandidateType.
+var (
+	CandidateType_name = map[int32]string{
+		0: "CandidateType_Host",
+		1: "CandidateType_Reflexive",
+		2: "CandidateType_PortMapped",
+	}
+	CandidateType_value = map[string]int32{
+		"CandidateType_Host":       0,
+		"CandidateType_Reflexive":  1,
+		"CandidateType_PortMapped": 2,
+	}
+)
+
+func (x CandidateType) Enum() *CandidateType {
+	p := new(CandidateType)
+	*p = x
+	return p
+}
+
+func (x CandidateType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CandidateType) Descriptor() protoreflect.EnumDescriptor {
+	return file_p2p_proto_enumTypes[2].Descriptor()
+}
+
+func (CandidateType) Type() protoreflect.EnumType {
+	return &file_p2p_proto_enumTypes[2]
+}
+
+func (x CandidateType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CandidateType.Descriptor instead.
+func (CandidateType) EnumDescriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{2}
+}
+
+type StreamKind int32
+
+const (
+	StreamKind_StreamKind_Open     StreamKind = 0 // 请对端连它本机的 port，reverse 时 port 是本节点要求对端监听的端口
+	StreamKind_StreamKind_Accept   StreamKind = 1 // 回应 Open 或 Listen，表示已经连上或者开始监听
+	StreamKind_StreamKind_Data     StreamKind = 2
+	StreamKind_StreamKind_Ack      StreamKind = 3 // ack 是期待的下一个 seq
+	StreamKind_StreamKind_Reset    StreamKind = 4 // 立即关闭，error 是原因
+	StreamKind_StreamKind_Listen   StreamKind = 5 // 请对端在它本机的 port 上监听，连进来的连接用 reverse 的 Open 转回来
+	StreamKind_StreamKind_Unlisten StreamKind = 6
+)
+
+// Enum value maps for StreamKind.
+var (
+	StreamKind_name = map[int32]string{
+		0: "StreamKind_Open",
+		1: "StreamKind_Accept",
+		2: "StreamKind_Data",
+		3: "StreamKind_Ack",
+		4: "StreamKind_Reset",
+		5: "StreamKind_Listen",
+		6: "StreamKind_Unlisten",
+	}
+	StreamKind_value = map[string]int32{
+		"StreamKind_Open":     0,
+		"StreamKind_Accept":   1,
+		"StreamKind_Data":     2,
+		"StreamKind_Ack":      3,
+		"StreamKind_Reset":    4,
+		"StreamKind_Listen":   5,
+		"StreamKind_Unlisten": 6,
+	}
+)
+
+func (x StreamKind) Enum() *StreamKind {
+	p := new(StreamKind)
+	*p = x
+	return p
+}
+
+func (x StreamKind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StreamKind) Descriptor() protoreflect.EnumDescriptor {
+	return file_p2p_proto_enumTypes[3].Descriptor()
+}
+
+func (StreamKind) Type() protoreflect.EnumType {
+	return &file_p2p_proto_enumTypes[3]
+}
+
+func (x StreamKind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StreamKind.Descriptor instead.
+func (StreamKind) EnumDescriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{3}
+}
+
 type GetExternalIpPortReq struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -222,17 +396,17 @@ func (x *UDPAddr) GetZone() string {
 	return ""
 }
 
-type NodeInfo struct {
+type PortRange struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Name    string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	UdpAddr *UDPAddr `protobuf:"bytes,2,opt,name=udp_addr,json=udpAddr,proto3" json:"udp_addr,omitempty"`
+	First int32 `protobuf:"varint,1,opt,name=first,proto3" json:"first,omitempty"`
+	Last  int32 `protobuf:"varint,2,opt,name=last,proto3" json:"last,omitempty"`
 }
 
-func (x *NodeInfo) Reset() {
-	*x = NodeInfo{}
+func (x *PortRange) Reset() {
+	*x = PortRange{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_p2p_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -240,13 +414,13 @@ func (x *NodeInfo) Reset() {
 	}
 }
 
-func (x *NodeInfo) String() string {
+func (x *PortRange) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*NodeInfo) ProtoMessage() {}
+func (*PortRange) ProtoMessage() {}
 
-func (x *NodeInfo) ProtoReflect() protoreflect.Message {
+func (x *PortRange) ProtoReflect() protoreflect.Message {
 	mi := &file_p2p_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -258,35 +432,38 @@ func (x *NodeInfo) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use NodeInfo.ProtoReflect.Descriptor instead.
-func (*NodeInfo) Descriptor() ([]byte, []int) {
+// Deprecated: Use PortRange.ProtoReflect.Descriptor instead.
+func (*PortRange) Descriptor() ([]byte, []int) {
 	return file_p2p_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *NodeInfo) GetName() string {
+func (x *PortRange) GetFirst() int32 {
 	if x != nil {
-		return x.Name
+		return x.First
 	}
-	return ""
+	return 0
 }
 
-func (x *NodeInfo) GetUdpAddr() *UDPAddr {
+func (x *PortRange) GetLast() int32 {
 	if x != nil {
-		return x.UdpAddr
+		return x.Last
 	}
-	return nil
+	return 0
 }
 
-type UpdateNodeReq struct {
+// 对称型 NAT 的端口预测，对端打洞时向这些端口喷发探测包
+type PortPrediction struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	NodeInfo *NodeInfo `protobuf:"bytes,1,opt,name=node_info,json=nodeInfo,proto3" json:"node_info,omitempty"`
+	Delta  int32        `protobuf:"varint,1,opt,name=delta,proto3" json:"delta,omitempty"`   // 相邻两次映射的端口差，随机分配时为 0
+	Random bool         `protobuf:"varint,2,opt,name=random,proto3" json:"random,omitempty"` // 端口随机分配，只能在范围内随机撞
+	Ranges []*PortRange `protobuf:"bytes,3,rep,name=ranges,proto3" json:"ranges,omitempty"`
 }
 
-func (x *UpdateNodeReq) Reset() {
-	*x = UpdateNodeReq{}
+func (x *PortPrediction) Reset() {
+	*x = PortPrediction{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_p2p_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -294,13 +471,13 @@ func (x *UpdateNodeReq) Reset() {
 	}
 }
 
-func (x *UpdateNodeReq) String() string {
+func (x *PortPrediction) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UpdateNodeReq) ProtoMessage() {}
+func (*PortPrediction) ProtoMessage() {}
 
-func (x *UpdateNodeReq) ProtoReflect() protoreflect.Message {
+func (x *PortPrediction) ProtoReflect() protoreflect.Message {
 	mi := &file_p2p_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -312,26 +489,45 @@ func (x *UpdateNodeReq) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UpdateNodeReq.ProtoReflect.Descriptor instead.
-func (*UpdateNodeReq) Descriptor() ([]byte, []int) {
+// Deprecated: Use PortPrediction.ProtoReflect.Descriptor instead.
+func (*PortPrediction) Descriptor() ([]byte, []int) {
 	return file_p2p_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *UpdateNodeReq) GetNodeInfo() *NodeInfo {
+func (x *PortPrediction) GetDelta() int32 {
 	if x != nil {
-		return x.NodeInfo
+		return x.Delta
+	}
+	return 0
+}
+
+func (x *PortPrediction) GetRandom() bool {
+	if x != nil {
+		return x.Random
+	}
+	return false
+}
+
+func (x *PortPrediction) GetRanges() []*PortRange {
+	if x != nil {
+		return x.Ranges
 	}
 	return nil
 }
 
-type UpdateNodeResp struct {
+// 除 udp_addr 以外对端可以尝试的地址
+type Candidate struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	UdpAddr  *UDPAddr      `protobuf:"bytes,1,opt,name=udp_addr,json=udpAddr,proto3" json:"udp_addr,omitempty"`
+	Type     CandidateType `protobuf:"varint,2,opt,name=type,proto3,enum=proto.CandidateType" json:"type,omitempty"`
+	Priority int32         `protobuf:"varint,3,opt,name=priority,proto3" json:"priority,omitempty"` // 越大越优先，udp_addr 按 0 算
 }
 
-func (x *UpdateNodeResp) Reset() {
-	*x = UpdateNodeResp{}
+func (x *Candidate) Reset() {
+	*x = Candidate{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_p2p_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -339,13 +535,13 @@ func (x *UpdateNodeResp) Reset() {
 	}
 }
 
-func (x *UpdateNodeResp) String() string {
+func (x *Candidate) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UpdateNodeResp) ProtoMessage() {}
+func (*Candidate) ProtoMessage() {}
 
-func (x *UpdateNodeResp) ProtoReflect() protoreflect.Message {
+func (x *Candidate) ProtoReflect() protoreflect.Message {
 	mi := &file_p2p_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -357,19 +553,50 @@ func (x *UpdateNodeResp) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UpdateNodeResp.ProtoReflect.Descriptor instead.
-func (*UpdateNodeResp) Descriptor() ([]byte, []int) {
+// Deprecated: Use Candidate.ProtoReflect.Descriptor instead.
+func (*Candidate) Descriptor() ([]byte, []int) {
 	return file_p2p_proto_rawDescGZIP(), []int{5}
 }
 
-type GetNodeInfoReq struct {
+func (x *Candidate) GetUdpAddr() *UDPAddr {
+	if x != nil {
+		return x.UdpAddr
+	}
+	return nil
+}
+
+func (x *Candidate) GetType() CandidateType {
+	if x != nil {
+		return x.Type
+	}
+	return CandidateType_CandidateType_Host
+}
+
+func (x *Candidate) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+type NodeInfo struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	Name           string          `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	UdpAddr        *UDPAddr        `protobuf:"bytes,2,opt,name=udp_addr,json=udpAddr,proto3" json:"udp_addr,omitempty"`
+	NatType        NatType         `protobuf:"varint,3,opt,name=nat_type,json=natType,proto3,enum=proto.NatType" json:"nat_type,omitempty"` // 客户端自己探测到的 NAT 类型
+	PortPrediction *PortPrediction `protobuf:"bytes,4,opt,name=port_prediction,json=portPrediction,proto3" json:"port_prediction,omitempty"`
+	TcpAddr        *UDPAddr        `protobuf:"bytes,5,opt,name=tcp_addr,json=tcpAddr,proto3" json:"tcp_addr,omitempty"` // 服务器从 gRPC 连接看到的外网 TCP 地址，没开 TCP 打洞时为空
+	Candidates     []*Candidate    `protobuf:"bytes,6,rep,name=candidates,proto3" json:"candidates,omitempty"`
+	VirtualIp      string          `protobuf:"bytes,7,opt,name=virtual_ip,json=virtualIp,proto3" json:"virtual_ip,omitempty"`    // 服务器分配的虚拟 IPv4，VPN 模式下用，客户端填的值会被覆盖
+	Network        string          `protobuf:"bytes,8,opt,name=network,proto3" json:"network,omitempty"`                         // 节点所在的网络，为空表示 default
+	VirtualIp6     string          `protobuf:"bytes,9,opt,name=virtual_ip6,json=virtualIp6,proto3" json:"virtual_ip6,omitempty"` // 服务器分配的虚拟 IPv6
 }
 
-func (x *GetNodeInfoReq) Reset() {
-	*x = GetNodeInfoReq{}
+func (x *NodeInfo) Reset() {
+	*x = NodeInfo{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_p2p_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -377,13 +604,13 @@ func (x *GetNodeInfoReq) Reset() {
 	}
 }
 
-func (x *GetNodeInfoReq) String() string {
+func (x *NodeInfo) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetNodeInfoReq) ProtoMessage() {}
+func (*NodeInfo) ProtoMessage() {}
 
-func (x *GetNodeInfoReq) ProtoReflect() protoreflect.Message {
+func (x *NodeInfo) ProtoReflect() protoreflect.Message {
 	mi := &file_p2p_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -395,21 +622,84 @@ func (x *GetNodeInfoReq) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetNodeInfoReq.ProtoReflect.Descriptor instead.
-func (*GetNodeInfoReq) Descriptor() ([]byte, []int) {
+// Deprecated: Use NodeInfo.ProtoReflect.Descriptor instead.
+func (*NodeInfo) Descriptor() ([]byte, []int) {
 	return file_p2p_proto_rawDescGZIP(), []int{6}
 }
 
-type GetNodeInfoResp struct {
+func (x *NodeInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetUdpAddr() *UDPAddr {
+	if x != nil {
+		return x.UdpAddr
+	}
+	return nil
+}
+
+func (x *NodeInfo) GetNatType() NatType {
+	if x != nil {
+		return x.NatType
+	}
+	return NatType_NatType_Unknown
+}
+
+func (x *NodeInfo) GetPortPrediction() *PortPrediction {
+	if x != nil {
+		return x.PortPrediction
+	}
+	return nil
+}
+
+func (x *NodeInfo) GetTcpAddr() *UDPAddr {
+	if x != nil {
+		return x.TcpAddr
+	}
+	return nil
+}
+
+func (x *NodeInfo) GetCandidates() []*Candidate {
+	if x != nil {
+		return x.Candidates
+	}
+	return nil
+}
+
+func (x *NodeInfo) GetVirtualIp() string {
+	if x != nil {
+		return x.VirtualIp
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *NodeInfo) GetVirtualIp6() string {
+	if x != nil {
+		return x.VirtualIp6
+	}
+	return ""
+}
+
+type UpdateNodeReq struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	NodeInfo []*NodeInfo `protobuf:"bytes,1,rep,name=node_info,json=nodeInfo,proto3" json:"node_info,omitempty"`
+	NodeInfo *NodeInfo `protobuf:"bytes,1,opt,name=node_info,json=nodeInfo,proto3" json:"node_info,omitempty"`
 }
 
-func (x *GetNodeInfoResp) Reset() {
-	*x = GetNodeInfoResp{}
+func (x *UpdateNodeReq) Reset() {
+	*x = UpdateNodeReq{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_p2p_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -417,13 +707,13 @@ func (x *GetNodeInfoResp) Reset() {
 	}
 }
 
-func (x *GetNodeInfoResp) String() string {
+func (x *UpdateNodeReq) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetNodeInfoResp) ProtoMessage() {}
+func (*UpdateNodeReq) ProtoMessage() {}
 
-func (x *GetNodeInfoResp) ProtoReflect() protoreflect.Message {
+func (x *UpdateNodeReq) ProtoReflect() protoreflect.Message {
 	mi := &file_p2p_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -435,70 +725,1904 @@ func (x *GetNodeInfoResp) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetNodeInfoResp.ProtoReflect.Descriptor instead.
-func (*GetNodeInfoResp) Descriptor() ([]byte, []int) {
+// Deprecated: Use UpdateNodeReq.ProtoReflect.Descriptor instead.
+func (*UpdateNodeReq) Descriptor() ([]byte, []int) {
 	return file_p2p_proto_rawDescGZIP(), []int{7}
 }
 
-func (x *GetNodeInfoResp) GetNodeInfo() []*NodeInfo {
+func (x *UpdateNodeReq) GetNodeInfo() *NodeInfo {
 	if x != nil {
 		return x.NodeInfo
 	}
 	return nil
 }
 
-var File_p2p_proto protoreflect.FileDescriptor
+type UpdateNodeResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
 
-var file_p2p_proto_rawDesc = []byte{
-	0x0a, 0x09, 0x70, 0x32, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x22, 0x16, 0x0a, 0x14, 0x47, 0x65, 0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61,
-	0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x22, 0x45, 0x0a, 0x15, 0x47, 0x65,
-	0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x52,
-	0x65, 0x73, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x64, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f,
-	0x72, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72,
-	0x6b, 0x22, 0x41, 0x0a, 0x07, 0x55, 0x44, 0x50, 0x41, 0x64, 0x64, 0x72, 0x12, 0x0e, 0x0a, 0x02,
-	0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x70, 0x12, 0x12, 0x0a, 0x04,
-	0x70, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x70, 0x6f, 0x72, 0x74,
-	0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
-	0x7a, 0x6f, 0x6e, 0x65, 0x22, 0x49, 0x0a, 0x08, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f,
-	0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
-	0x6e, 0x61, 0x6d, 0x65, 0x12, 0x29, 0x0a, 0x08, 0x75, 0x64, 0x70, 0x5f, 0x61, 0x64, 0x64, 0x72,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55,
-	0x44, 0x50, 0x41, 0x64, 0x64, 0x72, 0x52, 0x07, 0x75, 0x64, 0x70, 0x41, 0x64, 0x64, 0x72, 0x22,
-	0x3d, 0x0a, 0x0d, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71,
-	0x12, 0x2c, 0x0a, 0x09, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x6f, 0x64, 0x65,
-	0x49, 0x6e, 0x66, 0x6f, 0x52, 0x08, 0x6e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x22, 0x10,
-	0x0a, 0x0e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x73, 0x70,
-	0x22, 0x10, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52,
-	0x65, 0x71, 0x22, 0x3f, 0x0a, 0x0f, 0x47, 0x65, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66,
-	0x6f, 0x52, 0x65, 0x73, 0x70, 0x12, 0x2c, 0x0a, 0x09, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x69, 0x6e,
-	0x66, 0x6f, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x08, 0x6e, 0x6f, 0x64, 0x65, 0x49,
-	0x6e, 0x66, 0x6f, 0x2a, 0x38, 0x0a, 0x0a, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x6e, 0x66,
-	0x6f, 0x12, 0x13, 0x0a, 0x0f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x5f,
-	0x4e, 0x6f, 0x6e, 0x65, 0x10, 0x00, 0x12, 0x15, 0x0a, 0x0f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72,
-	0x49, 0x6e, 0x66, 0x6f, 0x5f, 0x50, 0x6f, 0x72, 0x74, 0x10, 0x83, 0x87, 0x03, 0x32, 0xd4, 0x01,
-	0x0a, 0x03, 0x50, 0x32, 0x50, 0x12, 0x50, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x45, 0x78, 0x74, 0x65,
-	0x72, 0x6e, 0x61, 0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1b, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x49, 0x70,
-	0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x1a, 0x1c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e,
-	0x47, 0x65, 0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72,
-	0x74, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x3b, 0x0a, 0x0a, 0x55, 0x70, 0x64, 0x61, 0x74,
-	0x65, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x14, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55, 0x70,
-	0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x1a, 0x15, 0x2e, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65,
-	0x73, 0x70, 0x22, 0x00, 0x12, 0x3e, 0x0a, 0x0b, 0x47, 0x65, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x49,
-	0x6e, 0x66, 0x6f, 0x12, 0x15, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x4e,
-	0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65, 0x71, 0x1a, 0x16, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65,
-	0x73, 0x70, 0x22, 0x00, 0x42, 0x0a, 0x5a, 0x08, 0x2e, 0x2f, 0x3b, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+func (x *UpdateNodeResp) Reset() {
+	*x = UpdateNodeResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
 
-var (
-	file_p2p_proto_rawDescOnce sync.Once
+func (x *UpdateNodeResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateNodeResp) ProtoMessage() {}
+
+func (x *UpdateNodeResp) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateNodeResp.ProtoReflect.Descriptor instead.
+func (*UpdateNodeResp) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{8}
+}
+
+type GetNodeInfoReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // 调用方的节点名，配置了策略时只返回它能连的节点
+}
+
+func (x *GetNodeInfoReq) Reset() {
+	*x = GetNodeInfoReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetNodeInfoReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetNodeInfoReq) ProtoMessage() {}
+
+func (x *GetNodeInfoReq) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetNodeInfoReq.ProtoReflect.Descriptor instead.
+func (*GetNodeInfoReq) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetNodeInfoReq) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type GetNodeInfoResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	NodeInfo []*NodeInfo `protobuf:"bytes,1,rep,name=node_info,json=nodeInfo,proto3" json:"node_info,omitempty"`
+}
+
+func (x *GetNodeInfoResp) Reset() {
+	*x = GetNodeInfoResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetNodeInfoResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetNodeInfoResp) ProtoMessage() {}
+
+func (x *GetNodeInfoResp) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetNodeInfoResp.ProtoReflect.Descriptor instead.
+func (*GetNodeInfoResp) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetNodeInfoResp) GetNodeInfo() []*NodeInfo {
+	if x != nil {
+		return x.NodeInfo
+	}
+	return nil
+}
+
+type ReportPunchReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name      string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Peer      string `protobuf:"bytes,2,opt,name=peer,proto3" json:"peer,omitempty"`
+	Success   bool   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
+	ElapsedMs int64  `protobuf:"varint,4,opt,name=elapsed_ms,json=elapsedMs,proto3" json:"elapsed_ms,omitempty"` // 从开始打洞到收到对端数据的耗时
+}
+
+func (x *ReportPunchReq) Reset() {
+	*x = ReportPunchReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReportPunchReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportPunchReq) ProtoMessage() {}
+
+func (x *ReportPunchReq) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportPunchReq.ProtoReflect.Descriptor instead.
+func (*ReportPunchReq) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ReportPunchReq) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ReportPunchReq) GetPeer() string {
+	if x != nil {
+		return x.Peer
+	}
+	return ""
+}
+
+func (x *ReportPunchReq) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *ReportPunchReq) GetElapsedMs() int64 {
+	if x != nil {
+		return x.ElapsedMs
+	}
+	return 0
+}
+
+type ReportPunchResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ReportPunchResp) Reset() {
+	*x = ReportPunchResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReportPunchResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportPunchResp) ProtoMessage() {}
+
+func (x *ReportPunchResp) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportPunchResp.ProtoReflect.Descriptor instead.
+func (*ReportPunchResp) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{12}
+}
+
+type GetServerConfigReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *GetServerConfigReq) Reset() {
+	*x = GetServerConfigReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetServerConfigReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetServerConfigReq) ProtoMessage() {}
+
+func (x *GetServerConfigReq) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetServerConfigReq.ProtoReflect.Descriptor instead.
+func (*GetServerConfigReq) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{13}
+}
+
+type GetServerConfigResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ProbePorts  []int32           `protobuf:"varint,1,rep,packed,name=probe_ports,json=probePorts,proto3" json:"probe_ports,omitempty"` // 额外的 UDP 地址回显端口，用于端口预测采样
+	StunPort    int32             `protobuf:"varint,2,opt,name=stun_port,json=stunPort,proto3" json:"stun_port,omitempty"`
+	StunAltPort int32             `protobuf:"varint,3,opt,name=stun_alt_port,json=stunAltPort,proto3" json:"stun_alt_port,omitempty"`
+	VirtualNets []*VirtualNetwork `protobuf:"bytes,4,rep,name=virtual_nets,json=virtualNets,proto3" json:"virtual_nets,omitempty"` // 为空表示不分配虚拟 IP
+	Relay       bool              `protobuf:"varint,5,opt,name=relay,proto3" json:"relay,omitempty"`                               // 主 UDP 端口转发 RelayPacket，为 false 时中继包直接丢弃
+}
+
+func (x *GetServerConfigResp) Reset() {
+	*x = GetServerConfigResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetServerConfigResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetServerConfigResp) ProtoMessage() {}
+
+func (x *GetServerConfigResp) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetServerConfigResp.ProtoReflect.Descriptor instead.
+func (*GetServerConfigResp) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *GetServerConfigResp) GetProbePorts() []int32 {
+	if x != nil {
+		return x.ProbePorts
+	}
+	return nil
+}
+
+func (x *GetServerConfigResp) GetStunPort() int32 {
+	if x != nil {
+		return x.StunPort
+	}
+	return 0
+}
+
+func (x *GetServerConfigResp) GetStunAltPort() int32 {
+	if x != nil {
+		return x.StunAltPort
+	}
+	return 0
+}
+
+func (x *GetServerConfigResp) GetVirtualNets() []*VirtualNetwork {
+	if x != nil {
+		return x.VirtualNets
+	}
+	return nil
+}
+
+func (x *GetServerConfigResp) GetRelay() bool {
+	if x != nil {
+		return x.Relay
+	}
+	return false
+}
+
+// 一个网络的虚拟地址段
+type VirtualNetwork struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Ipv4 string `protobuf:"bytes,2,opt,name=ipv4,proto3" json:"ipv4,omitempty"` // CIDR，为空表示不分配 IPv4
+	Ipv6 string `protobuf:"bytes,3,opt,name=ipv6,proto3" json:"ipv6,omitempty"` // CIDR，为空表示不分配 IPv6
+}
+
+func (x *VirtualNetwork) Reset() {
+	*x = VirtualNetwork{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VirtualNetwork) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VirtualNetwork) ProtoMessage() {}
+
+func (x *VirtualNetwork) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VirtualNetwork.ProtoReflect.Descriptor instead.
+func (*VirtualNetwork) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *VirtualNetwork) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *VirtualNetwork) GetIpv4() string {
+	if x != nil {
+		return x.Ipv4
+	}
+	return ""
+}
+
+func (x *VirtualNetwork) GetIpv6() string {
+	if x != nil {
+		return x.Ipv6
+	}
+	return ""
+}
+
+// 服务器转给被连接方的打洞请求
+type PunchRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	From    string    `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To      string    `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	Peer    *NodeInfo `protobuf:"bytes,3,opt,name=peer,proto3" json:"peer,omitempty"`                       // 发起方的注册信息
+	DelayMs int64     `protobuf:"varint,4,opt,name=delay_ms,json=delayMs,proto3" json:"delay_ms,omitempty"` // 收到后等这么久再开始打洞，双方同时开始
+	Tcp     bool      `protobuf:"varint,5,opt,name=tcp,proto3" json:"tcp,omitempty"`                        // 用 TCP 同时打开代替 UDP
+}
+
+func (x *PunchRequest) Reset() {
+	*x = PunchRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PunchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PunchRequest) ProtoMessage() {}
+
+func (x *PunchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PunchRequest.ProtoReflect.Descriptor instead.
+func (*PunchRequest) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *PunchRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *PunchRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *PunchRequest) GetPeer() *NodeInfo {
+	if x != nil {
+		return x.Peer
+	}
+	return nil
+}
+
+func (x *PunchRequest) GetDelayMs() int64 {
+	if x != nil {
+		return x.DelayMs
+	}
+	return 0
+}
+
+func (x *PunchRequest) GetTcp() bool {
+	if x != nil {
+		return x.Tcp
+	}
+	return false
+}
+
+type RequestPunchReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Peer string `protobuf:"bytes,2,opt,name=peer,proto3" json:"peer,omitempty"`
+	Tcp  bool   `protobuf:"varint,3,opt,name=tcp,proto3" json:"tcp,omitempty"`
+}
+
+func (x *RequestPunchReq) Reset() {
+	*x = RequestPunchReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RequestPunchReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestPunchReq) ProtoMessage() {}
+
+func (x *RequestPunchReq) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestPunchReq.ProtoReflect.Descriptor instead.
+func (*RequestPunchReq) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *RequestPunchReq) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *RequestPunchReq) GetPeer() string {
+	if x != nil {
+		return x.Peer
+	}
+	return ""
+}
+
+func (x *RequestPunchReq) GetTcp() bool {
+	if x != nil {
+		return x.Tcp
+	}
+	return false
+}
+
+type RequestPunchResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Peer    *NodeInfo `protobuf:"bytes,1,opt,name=peer,proto3" json:"peer,omitempty"`
+	DelayMs int64     `protobuf:"varint,2,opt,name=delay_ms,json=delayMs,proto3" json:"delay_ms,omitempty"`
+}
+
+func (x *RequestPunchResp) Reset() {
+	*x = RequestPunchResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RequestPunchResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestPunchResp) ProtoMessage() {}
+
+func (x *RequestPunchResp) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestPunchResp.ProtoReflect.Descriptor instead.
+func (*RequestPunchResp) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *RequestPunchResp) GetPeer() *NodeInfo {
+	if x != nil {
+		return x.Peer
+	}
+	return nil
+}
+
+func (x *RequestPunchResp) GetDelayMs() int64 {
+	if x != nil {
+		return x.DelayMs
+	}
+	return 0
+}
+
+type PollPunchReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name   string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	WaitMs int64  `protobuf:"varint,2,opt,name=wait_ms,json=waitMs,proto3" json:"wait_ms,omitempty"` // 没有请求时最多等这么久
+}
+
+func (x *PollPunchReq) Reset() {
+	*x = PollPunchReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PollPunchReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PollPunchReq) ProtoMessage() {}
+
+func (x *PollPunchReq) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PollPunchReq.ProtoReflect.Descriptor instead.
+func (*PollPunchReq) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *PollPunchReq) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PollPunchReq) GetWaitMs() int64 {
+	if x != nil {
+		return x.WaitMs
+	}
+	return 0
+}
+
+type PollPunchResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Requests []*PunchRequest `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+}
+
+func (x *PollPunchResp) Reset() {
+	*x = PollPunchResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[20]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PollPunchResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PollPunchResp) ProtoMessage() {}
+
+func (x *PollPunchResp) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[20]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PollPunchResp.ProtoReflect.Descriptor instead.
+func (*PollPunchResp) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *PollPunchResp) GetRequests() []*PunchRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+// 编译后的一条入站规则，允许 src 里的节点连本节点的 ports
+type PolicyRule struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Src    []string     `protobuf:"bytes,1,rep,name=src,proto3" json:"src,omitempty"`
+	AnySrc bool         `protobuf:"varint,2,opt,name=any_src,json=anySrc,proto3" json:"any_src,omitempty"` // 允许所有节点
+	Ports  []*PortRange `protobuf:"bytes,3,rep,name=ports,proto3" json:"ports,omitempty"`                  // 为空表示所有端口
+}
+
+func (x *PolicyRule) Reset() {
+	*x = PolicyRule{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[21]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PolicyRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PolicyRule) ProtoMessage() {}
+
+func (x *PolicyRule) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[21]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PolicyRule.ProtoReflect.Descriptor instead.
+func (*PolicyRule) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *PolicyRule) GetSrc() []string {
+	if x != nil {
+		return x.Src
+	}
+	return nil
+}
+
+func (x *PolicyRule) GetAnySrc() bool {
+	if x != nil {
+		return x.AnySrc
+	}
+	return false
+}
+
+func (x *PolicyRule) GetPorts() []*PortRange {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+// 服务器下发给一个节点的访问策略
+type NodePolicy struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Enforce bool          `protobuf:"varint,1,opt,name=enforce,proto3" json:"enforce,omitempty"` // false 表示服务器没有配置策略，全部允许
+	Inbound []*PolicyRule `protobuf:"bytes,2,rep,name=inbound,proto3" json:"inbound,omitempty"`
+}
+
+func (x *NodePolicy) Reset() {
+	*x = NodePolicy{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[22]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NodePolicy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodePolicy) ProtoMessage() {}
+
+func (x *NodePolicy) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[22]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodePolicy.ProtoReflect.Descriptor instead.
+func (*NodePolicy) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *NodePolicy) GetEnforce() bool {
+	if x != nil {
+		return x.Enforce
+	}
+	return false
+}
+
+func (x *NodePolicy) GetInbound() []*PolicyRule {
+	if x != nil {
+		return x.Inbound
+	}
+	return nil
+}
+
+type GetPolicyReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name    string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"` // 客户端已有的版本，和服务器相同时等到策略变化或者超时
+	WaitMs  int64  `protobuf:"varint,3,opt,name=wait_ms,json=waitMs,proto3" json:"wait_ms,omitempty"`
+}
+
+func (x *GetPolicyReq) Reset() {
+	*x = GetPolicyReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[23]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetPolicyReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPolicyReq) ProtoMessage() {}
+
+func (x *GetPolicyReq) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[23]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPolicyReq.ProtoReflect.Descriptor instead.
+func (*GetPolicyReq) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *GetPolicyReq) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GetPolicyReq) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *GetPolicyReq) GetWaitMs() int64 {
+	if x != nil {
+		return x.WaitMs
+	}
+	return 0
+}
+
+type GetPolicyResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version string      `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Policy  *NodePolicy `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`
+}
+
+func (x *GetPolicyResp) Reset() {
+	*x = GetPolicyResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[24]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetPolicyResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPolicyResp) ProtoMessage() {}
+
+func (x *GetPolicyResp) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[24]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPolicyResp.ProtoReflect.Descriptor instead.
+func (*GetPolicyResp) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *GetPolicyResp) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *GetPolicyResp) GetPolicy() *NodePolicy {
+	if x != nil {
+		return x.Policy
+	}
+	return nil
+}
+
+// 节点之间直接收发的消息，发送时前面加 4 字节的 "P2PM"
+type PeerHello struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Text  string `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	Ack   bool   `protobuf:"varint,2,opt,name=ack,proto3" json:"ack,omitempty"`     // 收到不带 ack 的 hello 要回一个带 ack 的
+	Nonce uint64 `protobuf:"varint,3,opt,name=nonce,proto3" json:"nonce,omitempty"` // 局域网探测的随机数，ack 原样带回
+}
+
+func (x *PeerHello) Reset() {
+	*x = PeerHello{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[25]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PeerHello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerHello) ProtoMessage() {}
+
+func (x *PeerHello) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[25]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerHello.ProtoReflect.Descriptor instead.
+func (*PeerHello) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *PeerHello) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *PeerHello) GetAck() bool {
+	if x != nil {
+		return x.Ack
+	}
+	return false
+}
+
+func (x *PeerHello) GetNonce() uint64 {
+	if x != nil {
+		return x.Nonce
+	}
+	return 0
+}
+
+// 外网地址变化后通知已知的对端
+type PeerAddrChanged struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UdpAddr *UDPAddr `protobuf:"bytes,1,opt,name=udp_addr,json=udpAddr,proto3" json:"udp_addr,omitempty"`
+}
+
+func (x *PeerAddrChanged) Reset() {
+	*x = PeerAddrChanged{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[26]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PeerAddrChanged) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerAddrChanged) ProtoMessage() {}
+
+func (x *PeerAddrChanged) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[26]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerAddrChanged.ProtoReflect.Descriptor instead.
+func (*PeerAddrChanged) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *PeerAddrChanged) GetUdpAddr() *UDPAddr {
+	if x != nil {
+		return x.UdpAddr
+	}
+	return nil
+}
+
+// 局域网内组播的信标，收到的一方用来包的源地址直连发送方
+type LanBeacon struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *LanBeacon) Reset() {
+	*x = LanBeacon{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[27]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LanBeacon) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LanBeacon) ProtoMessage() {}
+
+func (x *LanBeacon) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[27]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LanBeacon.ProtoReflect.Descriptor instead.
+func (*LanBeacon) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{27}
+}
+
+// 测路径质量的探测包，收到不带 pong 的要原样带回 seq 和 timestamp
+type PeerPing struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Seq       uint32 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Timestamp int64  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // 发送方的时间，unix 纳秒
+	Pong      bool   `protobuf:"varint,3,opt,name=pong,proto3" json:"pong,omitempty"`
+}
+
+func (x *PeerPing) Reset() {
+	*x = PeerPing{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[28]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PeerPing) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerPing) ProtoMessage() {}
+
+func (x *PeerPing) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[28]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerPing.ProtoReflect.Descriptor instead.
+func (*PeerPing) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *PeerPing) GetSeq() uint32 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *PeerPing) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *PeerPing) GetPong() bool {
+	if x != nil {
+		return x.Pong
+	}
+	return false
+}
+
+// 聊天消息，收到后回一个带同样 id 的 ack，重传的消息按 id 去重
+type PeerChat struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id    uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Text  string `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	Group bool   `protobuf:"varint,3,opt,name=group,proto3" json:"group,omitempty"` // 群发给所有节点的消息
+	Ack   bool   `protobuf:"varint,4,opt,name=ack,proto3" json:"ack,omitempty"`
+}
+
+func (x *PeerChat) Reset() {
+	*x = PeerChat{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[29]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PeerChat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerChat) ProtoMessage() {}
+
+func (x *PeerChat) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[29]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerChat.ProtoReflect.Descriptor instead.
+func (*PeerChat) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *PeerChat) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *PeerChat) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *PeerChat) GetGroup() bool {
+	if x != nil {
+		return x.Group
+	}
+	return false
+}
+
+func (x *PeerChat) GetAck() bool {
+	if x != nil {
+		return x.Ack
+	}
+	return false
+}
+
+// 隧道里的一条 TCP 连接，数据按 seq 可靠有序传输。两边各自分配 id，
+// opener 表示发送方是不是这条流的发起方
+type PeerStream struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id          uint32     `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Opener      bool       `protobuf:"varint,2,opt,name=opener,proto3" json:"opener,omitempty"`
+	Kind        StreamKind `protobuf:"varint,3,opt,name=kind,proto3,enum=proto.StreamKind" json:"kind,omitempty"`
+	Seq         uint32     `protobuf:"varint,4,opt,name=seq,proto3" json:"seq,omitempty"`
+	Data        []byte     `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`
+	Fin         bool       `protobuf:"varint,6,opt,name=fin,proto3" json:"fin,omitempty"` // 发送方不会再写了
+	Ack         uint32     `protobuf:"varint,7,opt,name=ack,proto3" json:"ack,omitempty"`
+	Port        uint32     `protobuf:"varint,8,opt,name=port,proto3" json:"port,omitempty"`
+	Reverse     bool       `protobuf:"varint,9,opt,name=reverse,proto3" json:"reverse,omitempty"`
+	Error       string     `protobuf:"bytes,10,opt,name=error,proto3" json:"error,omitempty"`
+	Dest        string     `protobuf:"bytes,11,opt,name=dest,proto3" json:"dest,omitempty"`                // 不为空时请对端作为出口连这个 host:port，不用 port
+	Unreachable bool       `protobuf:"varint,12,opt,name=unreachable,proto3" json:"unreachable,omitempty"` // Reset 是因为连不上目标，而不是被拒绝
+}
+
+func (x *PeerStream) Reset() {
+	*x = PeerStream{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[30]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PeerStream) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerStream) ProtoMessage() {}
+
+func (x *PeerStream) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[30]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerStream.ProtoReflect.Descriptor instead.
+func (*PeerStream) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *PeerStream) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *PeerStream) GetOpener() bool {
+	if x != nil {
+		return x.Opener
+	}
+	return false
+}
+
+func (x *PeerStream) GetKind() StreamKind {
+	if x != nil {
+		return x.Kind
+	}
+	return StreamKind_StreamKind_Open
+}
+
+func (x *PeerStream) GetSeq() uint32 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *PeerStream) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *PeerStream) GetFin() bool {
+	if x != nil {
+		return x.Fin
+	}
+	return false
+}
+
+func (x *PeerStream) GetAck() uint32 {
+	if x != nil {
+		return x.Ack
+	}
+	return 0
+}
+
+func (x *PeerStream) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *PeerStream) GetReverse() bool {
+	if x != nil {
+		return x.Reverse
+	}
+	return false
+}
+
+func (x *PeerStream) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *PeerStream) GetDest() string {
+	if x != nil {
+		return x.Dest
+	}
+	return ""
+}
+
+func (x *PeerStream) GetUnreachable() bool {
+	if x != nil {
+		return x.Unreachable
+	}
+	return false
+}
+
+type PeerMsg struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	From string `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	// Types that are assignable to Body:
+	//	*PeerMsg_Hello
+	//	*PeerMsg_AddrChanged
+	//	*PeerMsg_LanBeacon
+	//	*PeerMsg_Ping
+	//	*PeerMsg_Chat
+	//	*PeerMsg_Stream
+	//	*PeerMsg_Packet
+	Body isPeerMsg_Body `protobuf_oneof:"body"`
+}
+
+func (x *PeerMsg) Reset() {
+	*x = PeerMsg{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[31]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PeerMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PeerMsg) ProtoMessage() {}
+
+func (x *PeerMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[31]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PeerMsg.ProtoReflect.Descriptor instead.
+func (*PeerMsg) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *PeerMsg) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (m *PeerMsg) GetBody() isPeerMsg_Body {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func (x *PeerMsg) GetHello() *PeerHello {
+	if x, ok := x.GetBody().(*PeerMsg_Hello); ok {
+		return x.Hello
+	}
+	return nil
+}
+
+func (x *PeerMsg) GetAddrChanged() *PeerAddrChanged {
+	if x, ok := x.GetBody().(*PeerMsg_AddrChanged); ok {
+		return x.AddrChanged
+	}
+	return nil
+}
+
+func (x *PeerMsg) GetLanBeacon() *LanBeacon {
+	if x, ok := x.GetBody().(*PeerMsg_LanBeacon); ok {
+		return x.LanBeacon
+	}
+	return nil
+}
+
+func (x *PeerMsg) GetPing() *PeerPing {
+	if x, ok := x.GetBody().(*PeerMsg_Ping); ok {
+		return x.Ping
+	}
+	return nil
+}
+
+func (x *PeerMsg) GetChat() *PeerChat {
+	if x, ok := x.GetBody().(*PeerMsg_Chat); ok {
+		return x.Chat
+	}
+	return nil
+}
+
+func (x *PeerMsg) GetStream() *PeerStream {
+	if x, ok := x.GetBody().(*PeerMsg_Stream); ok {
+		return x.Stream
+	}
+	return nil
+}
+
+func (x *PeerMsg) GetPacket() []byte {
+	if x, ok := x.GetBody().(*PeerMsg_Packet); ok {
+		return x.Packet
+	}
+	return nil
+}
+
+type isPeerMsg_Body interface {
+	isPeerMsg_Body()
+}
+
+type PeerMsg_Hello struct {
+	Hello *PeerHello `protobuf:"bytes,2,opt,name=hello,proto3,oneof"`
+}
+
+type PeerMsg_AddrChanged struct {
+	AddrChanged *PeerAddrChanged `protobuf:"bytes,3,opt,name=addr_changed,json=addrChanged,proto3,oneof"`
+}
+
+type PeerMsg_LanBeacon struct {
+	LanBeacon *LanBeacon `protobuf:"bytes,4,opt,name=lan_beacon,json=lanBeacon,proto3,oneof"`
+}
+
+type PeerMsg_Ping struct {
+	Ping *PeerPing `protobuf:"bytes,5,opt,name=ping,proto3,oneof"`
+}
+
+type PeerMsg_Chat struct {
+	Chat *PeerChat `protobuf:"bytes,6,opt,name=chat,proto3,oneof"`
+}
+
+type PeerMsg_Stream struct {
+	Stream *PeerStream `protobuf:"bytes,7,opt,name=stream,proto3,oneof"`
+}
+
+type PeerMsg_Packet struct {
+	Packet []byte `protobuf:"bytes,8,opt,name=packet,proto3,oneof"` // VPN 模式下转发的 IP 包
+}
+
+func (*PeerMsg_Hello) isPeerMsg_Body() {}
+
+func (*PeerMsg_AddrChanged) isPeerMsg_Body() {}
+
+func (*PeerMsg_LanBeacon) isPeerMsg_Body() {}
+
+func (*PeerMsg_Ping) isPeerMsg_Body() {}
+
+func (*PeerMsg_Chat) isPeerMsg_Body() {}
+
+func (*PeerMsg_Stream) isPeerMsg_Body() {}
+
+func (*PeerMsg_Packet) isPeerMsg_Body() {}
+
+// 打不通直连时经服务器中转的节点消息，前面加 4 字节的 "P2PR"。
+// 服务器核对 from 是从它注册的 UDP 地址发出的，再原样转给 to 注册的地址
+type RelayPacket struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	From string `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To   string `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	Msg  []byte `protobuf:"bytes,3,opt,name=msg,proto3" json:"msg,omitempty"` // 序列化的 PeerMsg
+}
+
+func (x *RelayPacket) Reset() {
+	*x = RelayPacket{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_p2p_proto_msgTypes[32]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RelayPacket) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RelayPacket) ProtoMessage() {}
+
+func (x *RelayPacket) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_proto_msgTypes[32]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RelayPacket.ProtoReflect.Descriptor instead.
+func (*RelayPacket) Descriptor() ([]byte, []int) {
+	return file_p2p_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *RelayPacket) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *RelayPacket) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *RelayPacket) GetMsg() []byte {
+	if x != nil {
+		return x.Msg
+	}
+	return nil
+}
+
+var File_p2p_proto protoreflect.FileDescriptor
+
+var file_p2p_proto_rawDesc = []byte{
+	0x0a, 0x09, 0x70, 0x32, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x22, 0x16, 0x0a, 0x14, 0x47, 0x65, 0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61,
+	0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x22, 0x45, 0x0a, 0x15, 0x47, 0x65,
+	0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x52,
+	0x65, 0x73, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x64, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f,
+	0x72, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72,
+	0x6b, 0x22, 0x41, 0x0a, 0x07, 0x55, 0x44, 0x50, 0x41, 0x64, 0x64, 0x72, 0x12, 0x0e, 0x0a, 0x02,
+	0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x70, 0x12, 0x12, 0x0a, 0x04,
+	0x70, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x70, 0x6f, 0x72, 0x74,
+	0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
+	0x7a, 0x6f, 0x6e, 0x65, 0x22, 0x35, 0x0a, 0x09, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x61, 0x6e, 0x67,
+	0x65, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x69, 0x72, 0x73, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x05, 0x66, 0x69, 0x72, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6c, 0x61, 0x73, 0x74, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x6c, 0x61, 0x73, 0x74, 0x22, 0x68, 0x0a, 0x0e, 0x50,
+	0x6f, 0x72, 0x74, 0x50, 0x72, 0x65, 0x64, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a,
+	0x05, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x64, 0x65,
+	0x6c, 0x74, 0x61, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x61, 0x6e, 0x64, 0x6f, 0x6d, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x06, 0x72, 0x61, 0x6e, 0x64, 0x6f, 0x6d, 0x12, 0x28, 0x0a, 0x06, 0x72,
+	0x61, 0x6e, 0x67, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x06, 0x72,
+	0x61, 0x6e, 0x67, 0x65, 0x73, 0x22, 0x7c, 0x0a, 0x09, 0x43, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61,
+	0x74, 0x65, 0x12, 0x29, 0x0a, 0x08, 0x75, 0x64, 0x70, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55, 0x44, 0x50,
+	0x41, 0x64, 0x64, 0x72, 0x52, 0x07, 0x75, 0x64, 0x70, 0x41, 0x64, 0x64, 0x72, 0x12, 0x28, 0x0a,
+	0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x14, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x2e, 0x43, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x54, 0x79, 0x70,
+	0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x69, 0x6f, 0x72,
+	0x69, 0x74, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x70, 0x72, 0x69, 0x6f, 0x72,
+	0x69, 0x74, 0x79, 0x22, 0xeb, 0x02, 0x0a, 0x08, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f,
+	0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x12, 0x29, 0x0a, 0x08, 0x75, 0x64, 0x70, 0x5f, 0x61, 0x64, 0x64, 0x72,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55,
+	0x44, 0x50, 0x41, 0x64, 0x64, 0x72, 0x52, 0x07, 0x75, 0x64, 0x70, 0x41, 0x64, 0x64, 0x72, 0x12,
+	0x29, 0x0a, 0x08, 0x6e, 0x61, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x61, 0x74, 0x54, 0x79, 0x70,
+	0x65, 0x52, 0x07, 0x6e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x3e, 0x0a, 0x0f, 0x70, 0x6f,
+	0x72, 0x74, 0x5f, 0x70, 0x72, 0x65, 0x64, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x6f, 0x72, 0x74,
+	0x50, 0x72, 0x65, 0x64, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0e, 0x70, 0x6f, 0x72, 0x74,
+	0x50, 0x72, 0x65, 0x64, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x29, 0x0a, 0x08, 0x74, 0x63,
+	0x70, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55, 0x44, 0x50, 0x41, 0x64, 0x64, 0x72, 0x52, 0x07, 0x74, 0x63,
+	0x70, 0x41, 0x64, 0x64, 0x72, 0x12, 0x30, 0x0a, 0x0a, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61,
+	0x74, 0x65, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2e, 0x43, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x52, 0x0a, 0x63, 0x61, 0x6e,
+	0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x76, 0x69, 0x72, 0x74, 0x75,
+	0x61, 0x6c, 0x5f, 0x69, 0x70, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x76, 0x69, 0x72,
+	0x74, 0x75, 0x61, 0x6c, 0x49, 0x70, 0x12, 0x18, 0x0a, 0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72,
+	0x6b, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b,
+	0x12, 0x1f, 0x0a, 0x0b, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x5f, 0x69, 0x70, 0x36, 0x18,
+	0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x49, 0x70,
+	0x36, 0x22, 0x3d, 0x0a, 0x0d, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52,
+	0x65, 0x71, 0x12, 0x2c, 0x0a, 0x09, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x6f,
+	0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x08, 0x6e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f,
+	0x22, 0x10, 0x0a, 0x0e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65,
+	0x73, 0x70, 0x22, 0x24, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66,
+	0x6f, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x3f, 0x0a, 0x0f, 0x47, 0x65, 0x74, 0x4e,
+	0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65, 0x73, 0x70, 0x12, 0x2c, 0x0a, 0x09, 0x6e,
+	0x6f, 0x64, 0x65, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52,
+	0x08, 0x6e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x22, 0x71, 0x0a, 0x0e, 0x52, 0x65, 0x70,
+	0x6f, 0x72, 0x74, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x6e,
+	0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x70, 0x65, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70,
+	0x65, 0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x1d, 0x0a,
+	0x0a, 0x65, 0x6c, 0x61, 0x70, 0x73, 0x65, 0x64, 0x5f, 0x6d, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x09, 0x65, 0x6c, 0x61, 0x70, 0x73, 0x65, 0x64, 0x4d, 0x73, 0x22, 0x11, 0x0a, 0x0f,
+	0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x22,
+	0x14, 0x0a, 0x12, 0x47, 0x65, 0x74, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66,
+	0x69, 0x67, 0x52, 0x65, 0x71, 0x22, 0xc7, 0x01, 0x0a, 0x13, 0x47, 0x65, 0x74, 0x53, 0x65, 0x72,
+	0x76, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x65, 0x73, 0x70, 0x12, 0x1f, 0x0a,
+	0x0b, 0x70, 0x72, 0x6f, 0x62, 0x65, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x05, 0x52, 0x0a, 0x70, 0x72, 0x6f, 0x62, 0x65, 0x50, 0x6f, 0x72, 0x74, 0x73, 0x12, 0x1b,
+	0x0a, 0x09, 0x73, 0x74, 0x75, 0x6e, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x08, 0x73, 0x74, 0x75, 0x6e, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x22, 0x0a, 0x0d, 0x73,
+	0x74, 0x75, 0x6e, 0x5f, 0x61, 0x6c, 0x74, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x0b, 0x73, 0x74, 0x75, 0x6e, 0x41, 0x6c, 0x74, 0x50, 0x6f, 0x72, 0x74, 0x12,
+	0x38, 0x0a, 0x0c, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x5f, 0x6e, 0x65, 0x74, 0x73, 0x18,
+	0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x56, 0x69,
+	0x72, 0x74, 0x75, 0x61, 0x6c, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x52, 0x0b, 0x76, 0x69,
+	0x72, 0x74, 0x75, 0x61, 0x6c, 0x4e, 0x65, 0x74, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x72, 0x65, 0x6c,
+	0x61, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x22,
+	0x4c, 0x0a, 0x0e, 0x56, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72,
+	0x6b, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x69, 0x70, 0x76, 0x34, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x69, 0x70, 0x76, 0x34, 0x12, 0x12, 0x0a, 0x04, 0x69, 0x70, 0x76,
+	0x36, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x69, 0x70, 0x76, 0x36, 0x22, 0x84, 0x01,
+	0x0a, 0x0c, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12,
+	0x0a, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x66, 0x72,
+	0x6f, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x74, 0x6f, 0x12, 0x23, 0x0a, 0x04, 0x70, 0x65, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66,
+	0x6f, 0x52, 0x04, 0x70, 0x65, 0x65, 0x72, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x65, 0x6c, 0x61, 0x79,
+	0x5f, 0x6d, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x64, 0x65, 0x6c, 0x61, 0x79,
+	0x4d, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x63, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x03, 0x74, 0x63, 0x70, 0x22, 0x4b, 0x0a, 0x0f, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x50,
+	0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x70,
+	0x65, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x65, 0x65, 0x72, 0x12,
+	0x10, 0x0a, 0x03, 0x74, 0x63, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x74, 0x63,
+	0x70, 0x22, 0x52, 0x0a, 0x10, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x50, 0x75, 0x6e, 0x63,
+	0x68, 0x52, 0x65, 0x73, 0x70, 0x12, 0x23, 0x0a, 0x04, 0x70, 0x65, 0x65, 0x72, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x6f, 0x64, 0x65,
+	0x49, 0x6e, 0x66, 0x6f, 0x52, 0x04, 0x70, 0x65, 0x65, 0x72, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x65,
+	0x6c, 0x61, 0x79, 0x5f, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x64, 0x65,
+	0x6c, 0x61, 0x79, 0x4d, 0x73, 0x22, 0x3b, 0x0a, 0x0c, 0x50, 0x6f, 0x6c, 0x6c, 0x50, 0x75, 0x6e,
+	0x63, 0x68, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x77, 0x61, 0x69,
+	0x74, 0x5f, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x77, 0x61, 0x69, 0x74,
+	0x4d, 0x73, 0x22, 0x40, 0x0a, 0x0d, 0x50, 0x6f, 0x6c, 0x6c, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52,
+	0x65, 0x73, 0x70, 0x12, 0x2f, 0x0a, 0x08, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x75,
+	0x6e, 0x63, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x52, 0x08, 0x72, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x73, 0x22, 0x5f, 0x0a, 0x0a, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x75,
+	0x6c, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x72, 0x63, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x03, 0x73, 0x72, 0x63, 0x12, 0x17, 0x0a, 0x07, 0x61, 0x6e, 0x79, 0x5f, 0x73, 0x72, 0x63, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x61, 0x6e, 0x79, 0x53, 0x72, 0x63, 0x12, 0x26, 0x0a,
+	0x05, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x05,
+	0x70, 0x6f, 0x72, 0x74, 0x73, 0x22, 0x53, 0x0a, 0x0a, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x6f, 0x6c,
+	0x69, 0x63, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x6e, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x65, 0x6e, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x12, 0x2b, 0x0a,
+	0x07, 0x69, 0x6e, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x75, 0x6c,
+	0x65, 0x52, 0x07, 0x69, 0x6e, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x22, 0x55, 0x0a, 0x0c, 0x47, 0x65,
+	0x74, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
+	0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x17, 0x0a, 0x07, 0x77, 0x61, 0x69, 0x74,
+	0x5f, 0x6d, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x77, 0x61, 0x69, 0x74, 0x4d,
+	0x73, 0x22, 0x54, 0x0a, 0x0d, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65,
+	0x73, 0x70, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x29, 0x0a, 0x06,
+	0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52,
+	0x06, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x22, 0x47, 0x0a, 0x09, 0x50, 0x65, 0x65, 0x72, 0x48,
+	0x65, 0x6c, 0x6c, 0x6f, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x78, 0x74, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x63, 0x6b, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x61, 0x63, 0x6b, 0x12, 0x14, 0x0a, 0x05, 0x6e, 0x6f,
+	0x6e, 0x63, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x6e, 0x6f, 0x6e, 0x63, 0x65,
+	0x22, 0x3c, 0x0a, 0x0f, 0x50, 0x65, 0x65, 0x72, 0x41, 0x64, 0x64, 0x72, 0x43, 0x68, 0x61, 0x6e,
+	0x67, 0x65, 0x64, 0x12, 0x29, 0x0a, 0x08, 0x75, 0x64, 0x70, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55, 0x44,
+	0x50, 0x41, 0x64, 0x64, 0x72, 0x52, 0x07, 0x75, 0x64, 0x70, 0x41, 0x64, 0x64, 0x72, 0x22, 0x0b,
+	0x0a, 0x09, 0x4c, 0x61, 0x6e, 0x42, 0x65, 0x61, 0x63, 0x6f, 0x6e, 0x22, 0x4e, 0x0a, 0x08, 0x50,
+	0x65, 0x65, 0x72, 0x50, 0x69, 0x6e, 0x67, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x6e, 0x67, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x70, 0x6f, 0x6e, 0x67, 0x22, 0x56, 0x0a, 0x08, 0x50,
+	0x65, 0x65, 0x72, 0x43, 0x68, 0x61, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x78, 0x74, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x67,
+	0x72, 0x6f, 0x75, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75,
+	0x70, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x63, 0x6b, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03,
+	0x61, 0x63, 0x6b, 0x22, 0x9f, 0x02, 0x0a, 0x0a, 0x50, 0x65, 0x65, 0x72, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x70, 0x65, 0x6e, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x06, 0x6f, 0x70, 0x65, 0x6e, 0x65, 0x72, 0x12, 0x25, 0x0a, 0x04, 0x6b, 0x69,
+	0x6e, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x4b, 0x69, 0x6e, 0x64, 0x52, 0x04, 0x6b, 0x69, 0x6e,
+	0x64, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03,
+	0x73, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x69, 0x6e, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x66, 0x69, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x63, 0x6b,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x61, 0x63, 0x6b, 0x12, 0x12, 0x0a, 0x04, 0x70,
+	0x6f, 0x72, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x12,
+	0x18, 0x0a, 0x07, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x07, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72,
+	0x6f, 0x72, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x12,
+	0x12, 0x0a, 0x04, 0x64, 0x65, 0x73, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x64,
+	0x65, 0x73, 0x74, 0x12, 0x20, 0x0a, 0x0b, 0x75, 0x6e, 0x72, 0x65, 0x61, 0x63, 0x68, 0x61, 0x62,
+	0x6c, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x75, 0x6e, 0x72, 0x65, 0x61, 0x63,
+	0x68, 0x61, 0x62, 0x6c, 0x65, 0x22, 0xd4, 0x02, 0x0a, 0x07, 0x50, 0x65, 0x65, 0x72, 0x4d, 0x73,
+	0x67, 0x12, 0x12, 0x0a, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x66, 0x72, 0x6f, 0x6d, 0x12, 0x28, 0x0a, 0x05, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x65, 0x65,
+	0x72, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x48, 0x00, 0x52, 0x05, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x12,
+	0x3b, 0x0a, 0x0c, 0x61, 0x64, 0x64, 0x72, 0x5f, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x65,
+	0x65, 0x72, 0x41, 0x64, 0x64, 0x72, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x48, 0x00, 0x52,
+	0x0b, 0x61, 0x64, 0x64, 0x72, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x64, 0x12, 0x31, 0x0a, 0x0a,
+	0x6c, 0x61, 0x6e, 0x5f, 0x62, 0x65, 0x61, 0x63, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x61, 0x6e, 0x42, 0x65, 0x61, 0x63,
+	0x6f, 0x6e, 0x48, 0x00, 0x52, 0x09, 0x6c, 0x61, 0x6e, 0x42, 0x65, 0x61, 0x63, 0x6f, 0x6e, 0x12,
+	0x25, 0x0a, 0x04, 0x70, 0x69, 0x6e, 0x67, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x65, 0x65, 0x72, 0x50, 0x69, 0x6e, 0x67, 0x48, 0x00,
+	0x52, 0x04, 0x70, 0x69, 0x6e, 0x67, 0x12, 0x25, 0x0a, 0x04, 0x63, 0x68, 0x61, 0x74, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x65, 0x65,
+	0x72, 0x43, 0x68, 0x61, 0x74, 0x48, 0x00, 0x52, 0x04, 0x63, 0x68, 0x61, 0x74, 0x12, 0x2b, 0x0a,
+	0x06, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x65, 0x65, 0x72, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x48, 0x00, 0x52, 0x06, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x18, 0x0a, 0x06, 0x70, 0x61,
+	0x63, 0x6b, 0x65, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x06, 0x70, 0x61,
+	0x63, 0x6b, 0x65, 0x74, 0x42, 0x06, 0x0a, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x22, 0x43, 0x0a, 0x0b,
+	0x52, 0x65, 0x6c, 0x61, 0x79, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x66,
+	0x72, 0x6f, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x12,
+	0x0e, 0x0a, 0x02, 0x74, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x74, 0x6f, 0x12,
+	0x10, 0x0a, 0x03, 0x6d, 0x73, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6d, 0x73,
+	0x67, 0x2a, 0x38, 0x0a, 0x0a, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x12,
+	0x13, 0x0a, 0x0f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x5f, 0x4e, 0x6f,
+	0x6e, 0x65, 0x10, 0x00, 0x12, 0x15, 0x0a, 0x0f, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x6e,
+	0x66, 0x6f, 0x5f, 0x50, 0x6f, 0x72, 0x74, 0x10, 0x83, 0x87, 0x03, 0x2a, 0xc8, 0x01, 0x0a, 0x07,
+	0x4e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x13, 0x0a, 0x0f, 0x4e, 0x61, 0x74, 0x54, 0x79,
+	0x70, 0x65, 0x5f, 0x55, 0x6e, 0x6b, 0x6e, 0x6f, 0x77, 0x6e, 0x10, 0x00, 0x12, 0x10, 0x0a, 0x0c,
+	0x4e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x5f, 0x4f, 0x70, 0x65, 0x6e, 0x10, 0x01, 0x12, 0x14,
+	0x0a, 0x10, 0x4e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x5f, 0x46, 0x75, 0x6c, 0x6c, 0x43, 0x6f,
+	0x6e, 0x65, 0x10, 0x02, 0x12, 0x16, 0x0a, 0x12, 0x4e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x5f,
+	0x52, 0x65, 0x73, 0x74, 0x72, 0x69, 0x63, 0x74, 0x65, 0x64, 0x10, 0x03, 0x12, 0x1a, 0x0a, 0x16,
+	0x4e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x5f, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x73, 0x74,
+	0x72, 0x69, 0x63, 0x74, 0x65, 0x64, 0x10, 0x04, 0x12, 0x15, 0x0a, 0x11, 0x4e, 0x61, 0x74, 0x54,
+	0x79, 0x70, 0x65, 0x5f, 0x53, 0x79, 0x6d, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x10, 0x05, 0x12,
+	0x1d, 0x0a, 0x19, 0x4e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x5f, 0x53, 0x79, 0x6d, 0x6d, 0x65,
+	0x74, 0x72, 0x69, 0x63, 0x46, 0x69, 0x72, 0x65, 0x77, 0x61, 0x6c, 0x6c, 0x10, 0x06, 0x12, 0x16,
+	0x0a, 0x12, 0x4e, 0x61, 0x74, 0x54, 0x79, 0x70, 0x65, 0x5f, 0x55, 0x64, 0x70, 0x42, 0x6c, 0x6f,
+	0x63, 0x6b, 0x65, 0x64, 0x10, 0x07, 0x2a, 0x62, 0x0a, 0x0d, 0x43, 0x61, 0x6e, 0x64, 0x69, 0x64,
+	0x61, 0x74, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x16, 0x0a, 0x12, 0x43, 0x61, 0x6e, 0x64, 0x69,
+	0x64, 0x61, 0x74, 0x65, 0x54, 0x79, 0x70, 0x65, 0x5f, 0x48, 0x6f, 0x73, 0x74, 0x10, 0x00, 0x12,
+	0x1b, 0x0a, 0x17, 0x43, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x54, 0x79, 0x70, 0x65,
+	0x5f, 0x52, 0x65, 0x66, 0x6c, 0x65, 0x78, 0x69, 0x76, 0x65, 0x10, 0x01, 0x12, 0x1c, 0x0a, 0x18,
+	0x43, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x54, 0x79, 0x70, 0x65, 0x5f, 0x50, 0x6f,
+	0x72, 0x74, 0x4d, 0x61, 0x70, 0x70, 0x65, 0x64, 0x10, 0x02, 0x2a, 0xa7, 0x01, 0x0a, 0x0a, 0x53,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x4b, 0x69, 0x6e, 0x64, 0x12, 0x13, 0x0a, 0x0f, 0x53, 0x74, 0x72,
+	0x65, 0x61, 0x6d, 0x4b, 0x69, 0x6e, 0x64, 0x5f, 0x4f, 0x70, 0x65, 0x6e, 0x10, 0x00, 0x12, 0x15,
+	0x0a, 0x11, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x4b, 0x69, 0x6e, 0x64, 0x5f, 0x41, 0x63, 0x63,
+	0x65, 0x70, 0x74, 0x10, 0x01, 0x12, 0x13, 0x0a, 0x0f, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x4b,
+	0x69, 0x6e, 0x64, 0x5f, 0x44, 0x61, 0x74, 0x61, 0x10, 0x02, 0x12, 0x12, 0x0a, 0x0e, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x4b, 0x69, 0x6e, 0x64, 0x5f, 0x41, 0x63, 0x6b, 0x10, 0x03, 0x12, 0x14,
+	0x0a, 0x10, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x4b, 0x69, 0x6e, 0x64, 0x5f, 0x52, 0x65, 0x73,
+	0x65, 0x74, 0x10, 0x04, 0x12, 0x15, 0x0a, 0x11, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x4b, 0x69,
+	0x6e, 0x64, 0x5f, 0x4c, 0x69, 0x73, 0x74, 0x65, 0x6e, 0x10, 0x05, 0x12, 0x17, 0x0a, 0x13, 0x53,
+	0x74, 0x72, 0x65, 0x61, 0x6d, 0x4b, 0x69, 0x6e, 0x64, 0x5f, 0x55, 0x6e, 0x6c, 0x69, 0x73, 0x74,
+	0x65, 0x6e, 0x10, 0x06, 0x32, 0x97, 0x04, 0x0a, 0x03, 0x50, 0x32, 0x50, 0x12, 0x50, 0x0a, 0x11,
+	0x47, 0x65, 0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72,
+	0x74, 0x12, 0x1b, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x45, 0x78, 0x74,
+	0x65, 0x72, 0x6e, 0x61, 0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x1a, 0x1c,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e,
+	0x61, 0x6c, 0x49, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x3b,
+	0x0a, 0x0a, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x14, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52,
+	0x65, 0x71, 0x1a, 0x15, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74,
+	0x65, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x3e, 0x0a, 0x0b, 0x47,
+	0x65, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x15, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65,
+	0x71, 0x1a, 0x16, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x4e, 0x6f, 0x64,
+	0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x3e, 0x0a, 0x0b, 0x52,
+	0x65, 0x70, 0x6f, 0x72, 0x74, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x12, 0x15, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x2e, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65,
+	0x71, 0x1a, 0x16, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74,
+	0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x4a, 0x0a, 0x0f, 0x47,
+	0x65, 0x74, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x19,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72,
+	0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x65, 0x71, 0x1a, 0x1a, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69,
+	0x67, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x41, 0x0a, 0x0c, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x12, 0x16, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x71, 0x1a,
+	0x17, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x50,
+	0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x12, 0x38, 0x0a, 0x09, 0x50, 0x6f,
+	0x6c, 0x6c, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x12, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e,
+	0x50, 0x6f, 0x6c, 0x6c, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65, 0x71, 0x1a, 0x14, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x50, 0x6f, 0x6c, 0x6c, 0x50, 0x75, 0x6e, 0x63, 0x68, 0x52, 0x65,
+	0x73, 0x70, 0x22, 0x00, 0x12, 0x38, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x6c, 0x69, 0x63,
+	0x79, 0x12, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x6f, 0x6c,
+	0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x1a, 0x14, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x47,
+	0x65, 0x74, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x73, 0x70, 0x22, 0x00, 0x42, 0x0a,
+	0x5a, 0x08, 0x2e, 0x2f, 0x3b, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
+}
+
+var (
+	file_p2p_proto_rawDescOnce sync.Once
 	file_p2p_proto_rawDescData = file_p2p_proto_rawDesc
 )
 
@@ -509,34 +2633,94 @@ func file_p2p_proto_rawDescGZIP() []byte {
 	return file_p2p_proto_rawDescData
 }
 
-var file_p2p_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_p2p_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_p2p_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
+var file_p2p_proto_msgTypes = make([]protoimpl.MessageInfo, 33)
 var file_p2p_proto_goTypes = []interface{}{
 	(ServerInfo)(0),               // 0: proto.ServerInfo
-	(*GetExternalIpPortReq)(nil),  // 1: proto.GetExternalIpPortReq
-	(*GetExternalIpPortResp)(nil), // 2: proto.GetExternalIpPortResp
-	(*UDPAddr)(nil),               // 3: proto.UDPAddr
-	(*NodeInfo)(nil),              // 4: proto.NodeInfo
-	(*UpdateNodeReq)(nil),         // 5: proto.UpdateNodeReq
-	(*UpdateNodeResp)(nil),        // 6: proto.UpdateNodeResp
-	(*GetNodeInfoReq)(nil),        // 7: proto.GetNodeInfoReq
-	(*GetNodeInfoResp)(nil),       // 8: proto.GetNodeInfoResp
+	(NatType)(0),                  // 1: proto.NatType
+	(CandidateType)(0),            // 2: proto.CandidateType
+	(StreamKind)(0),               // 3: proto.StreamKind
+	(*GetExternalIpPortReq)(nil),  // 4: proto.GetExternalIpPortReq
+	(*GetExternalIpPortResp)(nil), // 5: proto.GetExternalIpPortResp
+	(*UDPAddr)(nil),               // 6: proto.UDPAddr
+	(*PortRange)(nil),             // 7: proto.PortRange
+	(*PortPrediction)(nil),        // 8: proto.PortPrediction
+	(*Candidate)(nil),             // 9: proto.Candidate
+	(*NodeInfo)(nil),              // 10: proto.NodeInfo
+	(*UpdateNodeReq)(nil),         // 11: proto.UpdateNodeReq
+	(*UpdateNodeResp)(nil),        // 12: proto.UpdateNodeResp
+	(*GetNodeInfoReq)(nil),        // 13: proto.GetNodeInfoReq
+	(*GetNodeInfoResp)(nil),       // 14: proto.GetNodeInfoResp
+	(*ReportPunchReq)(nil),        // 15: proto.ReportPunchReq
+	(*ReportPunchResp)(nil),       // 16: proto.ReportPunchResp
+	(*GetServerConfigReq)(nil),    // 17: proto.GetServerConfigReq
+	(*GetServerConfigResp)(nil),   // 18: proto.GetServerConfigResp
+	(*VirtualNetwork)(nil),        // 19: proto.VirtualNetwork
+	(*PunchRequest)(nil),          // 20: proto.PunchRequest
+	(*RequestPunchReq)(nil),       // 21: proto.RequestPunchReq
+	(*RequestPunchResp)(nil),      // 22: proto.RequestPunchResp
+	(*PollPunchReq)(nil),          // 23: proto.PollPunchReq
+	(*PollPunchResp)(nil),         // 24: proto.PollPunchResp
+	(*PolicyRule)(nil),            // 25: proto.PolicyRule
+	(*NodePolicy)(nil),            // 26: proto.NodePolicy
+	(*GetPolicyReq)(nil),          // 27: proto.GetPolicyReq
+	(*GetPolicyResp)(nil),         // 28: proto.GetPolicyResp
+	(*PeerHello)(nil),             // 29: proto.PeerHello
+	(*PeerAddrChanged)(nil),       // 30: proto.PeerAddrChanged
+	(*LanBeacon)(nil),             // 31: proto.LanBeacon
+	(*PeerPing)(nil),              // 32: proto.PeerPing
+	(*PeerChat)(nil),              // 33: proto.PeerChat
+	(*PeerStream)(nil),            // 34: proto.PeerStream
+	(*PeerMsg)(nil),               // 35: proto.PeerMsg
+	(*RelayPacket)(nil),           // 36: proto.RelayPacket
 }
 var file_p2p_proto_depIdxs = []int32{
-	3, // 0: proto.NodeInfo.udp_addr:type_name -> proto.UDPAddr
-	4, // 1: proto.UpdateNodeReq.node_info:type_name -> proto.NodeInfo
-	4, // 2: proto.GetNodeInfoResp.node_info:type_name -> proto.NodeInfo
-	1, // 3: proto.P2P.GetExternalIpPort:input_type -> proto.GetExternalIpPortReq
-	5, // 4: proto.P2P.UpdateNode:input_type -> proto.UpdateNodeReq
-	7, // 5: proto.P2P.GetNodeInfo:input_type -> proto.GetNodeInfoReq
-	2, // 6: proto.P2P.GetExternalIpPort:output_type -> proto.GetExternalIpPortResp
-	6, // 7: proto.P2P.UpdateNode:output_type -> proto.UpdateNodeResp
-	8, // 8: proto.P2P.GetNodeInfo:output_type -> proto.GetNodeInfoResp
-	6, // [6:9] is the sub-list for method output_type
-	3, // [3:6] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	7,  // 0: proto.PortPrediction.ranges:type_name -> proto.PortRange
+	6,  // 1: proto.Candidate.udp_addr:type_name -> proto.UDPAddr
+	2,  // 2: proto.Candidate.type:type_name -> proto.CandidateType
+	6,  // 3: proto.NodeInfo.udp_addr:type_name -> proto.UDPAddr
+	1,  // 4: proto.NodeInfo.nat_type:type_name -> proto.NatType
+	8,  // 5: proto.NodeInfo.port_prediction:type_name -> proto.PortPrediction
+	6,  // 6: proto.NodeInfo.tcp_addr:type_name -> proto.UDPAddr
+	9,  // 7: proto.NodeInfo.candidates:type_name -> proto.Candidate
+	10, // 8: proto.UpdateNodeReq.node_info:type_name -> proto.NodeInfo
+	10, // 9: proto.GetNodeInfoResp.node_info:type_name -> proto.NodeInfo
+	19, // 10: proto.GetServerConfigResp.virtual_nets:type_name -> proto.VirtualNetwork
+	10, // 11: proto.PunchRequest.peer:type_name -> proto.NodeInfo
+	10, // 12: proto.RequestPunchResp.peer:type_name -> proto.NodeInfo
+	20, // 13: proto.PollPunchResp.requests:type_name -> proto.PunchRequest
+	7,  // 14: proto.PolicyRule.ports:type_name -> proto.PortRange
+	25, // 15: proto.NodePolicy.inbound:type_name -> proto.PolicyRule
+	26, // 16: proto.GetPolicyResp.policy:type_name -> proto.NodePolicy
+	6,  // 17: proto.PeerAddrChanged.udp_addr:type_name -> proto.UDPAddr
+	3,  // 18: proto.PeerStream.kind:type_name -> proto.StreamKind
+	29, // 19: proto.PeerMsg.hello:type_name -> proto.PeerHello
+	30, // 20: proto.PeerMsg.addr_changed:type_name -> proto.PeerAddrChanged
+	31, // 21: proto.PeerMsg.lan_beacon:type_name -> proto.LanBeacon
+	32, // 22: proto.PeerMsg.ping:type_name -> proto.PeerPing
+	33, // 23: proto.PeerMsg.chat:type_name -> proto.PeerChat
+	34, // 24: proto.PeerMsg.stream:type_name -> proto.PeerStream
+	4,  // 25: proto.P2P.GetExternalIpPort:input_type -> proto.GetExternalIpPortReq
+	11, // 26: proto.P2P.UpdateNode:input_type -> proto.UpdateNodeReq
+	13, // 27: proto.P2P.GetNodeInfo:input_type -> proto.GetNodeInfoReq
+	15, // 28: proto.P2P.ReportPunch:input_type -> proto.ReportPunchReq
+	17, // 29: proto.P2P.GetServerConfig:input_type -> proto.GetServerConfigReq
+	21, // 30: proto.P2P.RequestPunch:input_type -> proto.RequestPunchReq
+	23, // 31: proto.P2P.PollPunch:input_type -> proto.PollPunchReq
+	27, // 32: proto.P2P.GetPolicy:input_type -> proto.GetPolicyReq
+	5,  // 33: proto.P2P.GetExternalIpPort:output_type -> proto.GetExternalIpPortResp
+	12, // 34: proto.P2P.UpdateNode:output_type -> proto.UpdateNodeResp
+	14, // 35: proto.P2P.GetNodeInfo:output_type -> proto.GetNodeInfoResp
+	16, // 36: proto.P2P.ReportPunch:output_type -> proto.ReportPunchResp
+	18, // 37: proto.P2P.GetServerConfig:output_type -> proto.GetServerConfigResp
+	22, // 38: proto.P2P.RequestPunch:output_type -> proto.RequestPunchResp
+	24, // 39: proto.P2P.PollPunch:output_type -> proto.PollPunchResp
+	28, // 40: proto.P2P.GetPolicy:output_type -> proto.GetPolicyResp
+	33, // [33:41] is the sub-list for method output_type
+	25, // [25:33] is the sub-list for method input_type
+	25, // [25:25] is the sub-list for extension type_name
+	25, // [25:25] is the sub-list for extension extendee
+	0,  // [0:25] is the sub-list for field type_name
 }
 
 func init() { file_p2p_proto_init() }
@@ -557,8 +2741,32 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
-		file_p2p_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GetExternalIpPortResp); i {
+		file_p2p_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetExternalIpPortResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UDPAddr); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PortRange); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -569,8 +2777,8 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
-		file_p2p_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*UDPAddr); i {
+		file_p2p_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PortPrediction); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -581,7 +2789,19 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
-		file_p2p_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+		file_p2p_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Candidate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*NodeInfo); i {
 			case 0:
 				return &v.state
@@ -593,7 +2813,7 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
-		file_p2p_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+		file_p2p_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*UpdateNodeReq); i {
 			case 0:
 				return &v.state
@@ -605,7 +2825,7 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
-		file_p2p_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+		file_p2p_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*UpdateNodeResp); i {
 			case 0:
 				return &v.state
@@ -617,7 +2837,7 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
-		file_p2p_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+		file_p2p_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*GetNodeInfoReq); i {
 			case 0:
 				return &v.state
@@ -629,7 +2849,7 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
-		file_p2p_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+		file_p2p_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*GetNodeInfoResp); i {
 			case 0:
 				return &v.state
@@ -641,14 +2861,287 @@ func file_p2p_proto_init() {
 				return nil
 			}
 		}
+		file_p2p_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReportPunchReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReportPunchResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetServerConfigReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetServerConfigResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VirtualNetwork); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PunchRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RequestPunchReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RequestPunchResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PollPunchReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PollPunchResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[21].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PolicyRule); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[22].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NodePolicy); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[23].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetPolicyReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[24].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetPolicyResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[25].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PeerHello); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[26].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PeerAddrChanged); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[27].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LanBeacon); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[28].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PeerPing); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[29].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PeerChat); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[30].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PeerStream); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[31].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PeerMsg); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_p2p_proto_msgTypes[32].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RelayPacket); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_p2p_proto_msgTypes[31].OneofWrappers = []interface{}{
+		(*PeerMsg_Hello)(nil),
+		(*PeerMsg_AddrChanged)(nil),
+		(*PeerMsg_LanBeacon)(nil),
+		(*PeerMsg_Ping)(nil),
+		(*PeerMsg_Chat)(nil),
+		(*PeerMsg_Stream)(nil),
+		(*PeerMsg_Packet)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_p2p_proto_rawDesc,
-			NumEnums:      1,
-			NumMessages:   8,
+			NumEnums:      4,
+			NumMessages:   33,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
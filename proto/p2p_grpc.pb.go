@@ -22,6 +22,11 @@ const (
 	P2P_GetExternalIpPort_FullMethodName = "/proto.P2P/GetExternalIpPort"
 	P2P_UpdateNode_FullMethodName        = "/proto.P2P/UpdateNode"
 	P2P_GetNodeInfo_FullMethodName       = "/proto.P2P/GetNodeInfo"
+	P2P_ReportPunch_FullMethodName       = "/proto.P2P/ReportPunch"
+	P2P_GetServerConfig_FullMethodName   = "/proto.P2P/GetServerConfig"
+	P2P_RequestPunch_FullMethodName      = "/proto.P2P/RequestPunch"
+	P2P_PollPunch_FullMethodName         = "/proto.P2P/PollPunch"
+	P2P_GetPolicy_FullMethodName         = "/proto.P2P/GetPolicy"
 )
 
 // P2PClient is the client API for P2P service.
@@ -32,6 +37,15 @@ type P2PClient interface {
 	GetExternalIpPort(ctx context.Context, in *GetExternalIpPortReq, opts ...grpc.CallOption) (*GetExternalIpPortResp, error)
 	UpdateNode(ctx context.Context, in *UpdateNodeReq, opts ...grpc.CallOption) (*UpdateNodeResp, error)
 	GetNodeInfo(ctx context.Context, in *GetNodeInfoReq, opts ...grpc.CallOption) (*GetNodeInfoResp, error)
+	// 上报打洞结果，用于统计成功率
+	ReportPunch(ctx context.Context, in *ReportPunchReq, opts ...grpc.CallOption) (*ReportPunchResp, error)
+	GetServerConfig(ctx context.Context, in *GetServerConfigReq, opts ...grpc.CallOption) (*GetServerConfigResp, error)
+	// 请求和 peer 同时打洞，服务器把请求转给 peer
+	RequestPunch(ctx context.Context, in *RequestPunchReq, opts ...grpc.CallOption) (*RequestPunchResp, error)
+	// 长轮询别人发给自己的打洞请求
+	PollPunch(ctx context.Context, in *PollPunchReq, opts ...grpc.CallOption) (*PollPunchResp, error)
+	// 长轮询本节点的访问策略，策略变化时马上返回
+	GetPolicy(ctx context.Context, in *GetPolicyReq, opts ...grpc.CallOption) (*GetPolicyResp, error)
 }
 
 type p2PClient struct {
@@ -69,6 +83,51 @@ func (c *p2PClient) GetNodeInfo(ctx context.Context, in *GetNodeInfoReq, opts ..
 	return out, nil
 }
 
+func (c *p2PClient) ReportPunch(ctx context.Context, in *ReportPunchReq, opts ...grpc.CallOption) (*ReportPunchResp, error) {
+	out := new(ReportPunchResp)
+	err := c.cc.Invoke(ctx, P2P_ReportPunch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *p2PClient) GetServerConfig(ctx context.Context, in *GetServerConfigReq, opts ...grpc.CallOption) (*GetServerConfigResp, error) {
+	out := new(GetServerConfigResp)
+	err := c.cc.Invoke(ctx, P2P_GetServerConfig_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *p2PClient) RequestPunch(ctx context.Context, in *RequestPunchReq, opts ...grpc.CallOption) (*RequestPunchResp, error) {
+	out := new(RequestPunchResp)
+	err := c.cc.Invoke(ctx, P2P_RequestPunch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *p2PClient) PollPunch(ctx context.Context, in *PollPunchReq, opts ...grpc.CallOption) (*PollPunchResp, error) {
+	out := new(PollPunchResp)
+	err := c.cc.Invoke(ctx, P2P_PollPunch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *p2PClient) GetPolicy(ctx context.Context, in *GetPolicyReq, opts ...grpc.CallOption) (*GetPolicyResp, error) {
+	out := new(GetPolicyResp)
+	err := c.cc.Invoke(ctx, P2P_GetPolicy_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // P2PServer is the server API for P2P service.
 // All implementations must embed UnimplementedP2PServer
 // for forward compatibility
@@ -77,6 +136,15 @@ type P2PServer interface {
 	GetExternalIpPort(context.Context, *GetExternalIpPortReq) (*GetExternalIpPortResp, error)
 	UpdateNode(context.Context, *UpdateNodeReq) (*UpdateNodeResp, error)
 	GetNodeInfo(context.Context, *GetNodeInfoReq) (*GetNodeInfoResp, error)
+	// 上报打洞结果，用于统计成功率
+	ReportPunch(context.Context, *ReportPunchReq) (*ReportPunchResp, error)
+	GetServerConfig(context.Context, *GetServerConfigReq) (*GetServerConfigResp, error)
+	// 请求和 peer 同时打洞，服务器把请求转给 peer
+	RequestPunch(context.Context, *RequestPunchReq) (*RequestPunchResp, error)
+	// 长轮询别人发给自己的打洞请求
+	PollPunch(context.Context, *PollPunchReq) (*PollPunchResp, error)
+	// 长轮询本节点的访问策略，策略变化时马上返回
+	GetPolicy(context.Context, *GetPolicyReq) (*GetPolicyResp, error)
 	mustEmbedUnimplementedP2PServer()
 }
 
@@ -93,6 +161,21 @@ func (UnimplementedP2PServer) UpdateNode(context.Context, *UpdateNodeReq) (*Upda
 func (UnimplementedP2PServer) GetNodeInfo(context.Context, *GetNodeInfoReq) (*GetNodeInfoResp, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetNodeInfo not implemented")
 }
+func (UnimplementedP2PServer) ReportPunch(context.Context, *ReportPunchReq) (*ReportPunchResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportPunch not implemented")
+}
+func (UnimplementedP2PServer) GetServerConfig(context.Context, *GetServerConfigReq) (*GetServerConfigResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServerConfig not implemented")
+}
+func (UnimplementedP2PServer) RequestPunch(context.Context, *RequestPunchReq) (*RequestPunchResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestPunch not implemented")
+}
+func (UnimplementedP2PServer) PollPunch(context.Context, *PollPunchReq) (*PollPunchResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PollPunch not implemented")
+}
+func (UnimplementedP2PServer) GetPolicy(context.Context, *GetPolicyReq) (*GetPolicyResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPolicy not implemented")
+}
 func (UnimplementedP2PServer) mustEmbedUnimplementedP2PServer() {}
 
 // UnsafeP2PServer may be embedded to opt out of forward compatibility for this service.
@@ -160,6 +243,96 @@ func _P2P_GetNodeInfo_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _P2P_ReportPunch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportPunchReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(P2PServer).ReportPunch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: P2P_ReportPunch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(P2PServer).ReportPunch(ctx, req.(*ReportPunchReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _P2P_GetServerConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServerConfigReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(P2PServer).GetServerConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: P2P_GetServerConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(P2PServer).GetServerConfig(ctx, req.(*GetServerConfigReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _P2P_RequestPunch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestPunchReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(P2PServer).RequestPunch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: P2P_RequestPunch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(P2PServer).RequestPunch(ctx, req.(*RequestPunchReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _P2P_PollPunch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PollPunchReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(P2PServer).PollPunch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: P2P_PollPunch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(P2PServer).PollPunch(ctx, req.(*PollPunchReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _P2P_GetPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPolicyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(P2PServer).GetPolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: P2P_GetPolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(P2PServer).GetPolicy(ctx, req.(*GetPolicyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // P2P_ServiceDesc is the grpc.ServiceDesc for P2P service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -179,6 +352,26 @@ var P2P_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetNodeInfo",
 			Handler:    _P2P_GetNodeInfo_Handler,
 		},
+		{
+			MethodName: "ReportPunch",
+			Handler:    _P2P_ReportPunch_Handler,
+		},
+		{
+			MethodName: "GetServerConfig",
+			Handler:    _P2P_GetServerConfig_Handler,
+		},
+		{
+			MethodName: "RequestPunch",
+			Handler:    _P2P_RequestPunch_Handler,
+		},
+		{
+			MethodName: "PollPunch",
+			Handler:    _P2P_PollPunch_Handler,
+		},
+		{
+			MethodName: "GetPolicy",
+			Handler:    _P2P_GetPolicy_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "p2p.proto",
@@ -0,0 +1,104 @@
+package public
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Linux 下用 recvmmsg/sendmmsg 批量收发，只有系统套接字支持
+func (u *udpSocket) readLoop() {
+	conn, ok := u.conn.(*net.UDPConn)
+	if !ok {
+		u.readEach()
+		return
+	}
+	s := u.s
+	pc := ipv4.NewPacketConn(conn)
+	msgs := make([]ipv4.Message, s.opts.BatchSize)
+	bufs := make([]*[]byte, s.opts.BatchSize)
+	for i := range msgs {
+		bufs[i] = s.getBuf()
+		msgs[i].Buffers = [][]byte{*bufs[i]}
+	}
+
+	var retry readRetry
+	for {
+		n, err := pc.ReadBatch(msgs, 0)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || !retry.failed(s, err) {
+				return
+			}
+			continue
+		}
+		retry.succeeded()
+		for i := 0; i < n; i++ {
+			addr, ok := msgs[i].Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			s.dispatch(&udpPacket{buf: bufs[i], n: msgs[i].N, addr: addr, w: u})
+			bufs[i] = s.getBuf()
+			msgs[i].Buffers[0] = *bufs[i]
+		}
+	}
+}
+
+func (u *udpSocket) writeLoop() {
+	conn, ok := u.conn.(*net.UDPConn)
+	if !ok {
+		u.writeEach()
+		return
+	}
+	s := u.s
+	pc := ipv4.NewPacketConn(conn)
+	msgs := make([]ipv4.Message, s.opts.BatchSize)
+	pkts := make([]*udpPacket, 0, s.opts.BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+
+	for {
+		pkts = pkts[:0]
+		select {
+		case p := <-u.sendq:
+			pkts = append(pkts, p)
+		case <-s.done:
+			return
+		}
+		// 把队列里已有的包一起发出去
+	drain:
+		for len(pkts) < cap(pkts) {
+			select {
+			case p := <-u.sendq:
+				pkts = append(pkts, p)
+			default:
+				break drain
+			}
+		}
+
+		for i, p := range pkts {
+			msgs[i].Buffers[0] = (*p.buf)[:p.n]
+			msgs[i].Addr = p.addr
+		}
+		for sent := 0; sent < len(pkts); {
+			n, err := pc.WriteBatch(msgs[sent:len(pkts)], 0)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				s.opts.Logger.Warn("udp write batch failed", "peer", msgs[sent].Addr.String(), "err", err)
+				// 跳过发送失败的包
+				n = 1
+				s.dropped.Add(1)
+			} else {
+				s.sent.Add(uint64(n))
+			}
+			sent += n
+		}
+		for _, p := range pkts {
+			s.putBuf(p.buf)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package public
+
+func (u *udpSocket) readLoop() {
+	u.readEach()
+}
+
+func (u *udpSocket) writeLoop() {
+	u.writeEach()
+}
@@ -0,0 +1,170 @@
+package netsim
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Conn 是模拟网络里的 UDP 套接字，接收队列满了就丢包
+type Conn struct {
+	host  *Host
+	laddr *net.UDPAddr
+	queue chan packet
+
+	mu         sync.Mutex
+	deadline   time.Time
+	deadlineCh chan struct{} // 读超时改变时关闭，唤醒正在读的协程
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// source 是发出去的包的源地址，绑定 0.0.0.0 时用主机的第一个地址
+func (c *Conn) source() *net.UDPAddr {
+	if c.laddr.IP.IsUnspecified() {
+		return &net.UDPAddr{IP: c.host.IP(), Port: c.laddr.Port}
+	}
+	return &net.UDPAddr{IP: c.laddr.IP, Port: c.laddr.Port}
+}
+
+func (c *Conn) enqueue(p packet) {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	select {
+	case c.queue <- p:
+	default:
+	}
+}
+
+func (c *Conn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.deadlineCh
+		c.mu.Unlock()
+		n, addr, again, err := c.read(b, deadline, changed)
+		if !again {
+			return n, addr, err
+		}
+	}
+}
+
+// read 等一个包，读超时在等待期间被修改时返回 again
+func (c *Conn) read(b []byte, deadline time.Time, changed chan struct{}) (n int, addr *net.UDPAddr, again bool, err error) {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, nil, false, c.opError("read", os.ErrDeadlineExceeded)
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case p := <-c.queue:
+		return copy(b, p.data), p.src, false, nil
+	case <-c.closed:
+		return 0, nil, false, c.opError("read", net.ErrClosed)
+	case <-expired:
+		return 0, nil, false, c.opError("read", os.ErrDeadlineExceeded)
+	case <-changed:
+		return 0, nil, true, nil
+	}
+}
+
+func (c *Conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.ReadFromUDP(b)
+	if addr == nil {
+		return n, nil, err
+	}
+	return n, addr, err
+}
+
+// WriteToUDP 总是成功，包到不了目的就在网络里丢掉，和真实的 UDP 一样
+func (c *Conn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.opError("write", net.ErrClosed)
+	default:
+	}
+	c.host.net.send(c, addr, b)
+	return len(b), nil
+}
+
+func (c *Conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, c.opError("write", net.InvalidAddrError("not a udp address"))
+	}
+	return c.WriteToUDP(b, udp)
+}
+
+func (c *Conn) Close() error {
+	err := c.opError("close", net.ErrClosed)
+	c.closeOnce.Do(func() {
+		err = nil
+		close(c.closed)
+		n := c.host.net
+		n.mu.Lock()
+		delete(c.host.conns, c.laddr.String())
+		n.mu.Unlock()
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: c.laddr.IP, Port: c.laddr.Port}
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	close(c.deadlineCh)
+	c.deadlineCh = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline 什么也不做，发送从不阻塞
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.LocalAddr(), Err: err}
+}
+
+// connected 是 DialUDP 返回的套接字，丢掉不是对端发来的包
+type connected struct {
+	*Conn
+	raddr *net.UDPAddr
+}
+
+func (c *connected) Read(b []byte) (int, error) {
+	for {
+		n, addr, err := c.ReadFromUDP(b)
+		if err != nil {
+			return 0, err
+		}
+		if addr.IP.Equal(c.raddr.IP) && addr.Port == c.raddr.Port {
+			return n, nil
+		}
+	}
+}
+
+func (c *connected) Write(b []byte) (int, error) {
+	return c.WriteToUDP(b, c.raddr)
+}
+
+func (c *connected) RemoteAddr() net.Addr {
+	return c.raddr
+}
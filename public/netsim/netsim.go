@@ -0,0 +1,428 @@
+// Package netsim 是内存里的模拟网络，可以设置延迟、丢包、乱序和 NAT 行为，
+// STUN、注册和打洞的逻辑不开真实套接字也能确定地测试。
+//
+// 公网主机和 NAT 的公网地址在同一个平面里互通，NAT 后面的主机只能被同一个 NAT 后面的主机直接访问。
+// 随机数用固定的种子，延迟为 0 时包在发送方的协程里同步投递，同样的操作顺序得到同样的结果
+package netsim
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+)
+
+// NatType 是 NAT 的映射和过滤行为
+type NatType int
+
+const (
+	// FullCone 映射和目的无关，任何地址都能发进来
+	FullCone NatType = iota + 1
+	// Restricted 映射和目的无关，只有内网主机发过包的 IP 能发进来
+	Restricted
+	// PortRestricted 映射和目的无关，只有内网主机发过包的 IP 和端口能发进来
+	PortRestricted
+	// Symmetric 每个目的地址一个映射，只有这个目的能发进来
+	Symmetric
+)
+
+func (t NatType) String() string {
+	switch t {
+	case FullCone:
+		return "FullCone"
+	case Restricted:
+		return "Restricted"
+	case PortRestricted:
+		return "PortRestricted"
+	case Symmetric:
+		return "Symmetric"
+	}
+	return fmt.Sprintf("NatType(%d)", int(t))
+}
+
+// LinkOptions 是所有包共用的链路特性
+type LinkOptions struct {
+	// Latency 是单向延迟，Jitter 不为 0 时延迟在 [Latency, Latency+Jitter) 里均匀分布
+	Latency time.Duration
+	Jitter  time.Duration
+	// Loss 是丢包率
+	Loss float64
+	// Reorder 比例的包再多延迟 ReorderDelay，落到后面发的包之后
+	Reorder      float64
+	ReorderDelay time.Duration
+}
+
+func (o *LinkOptions) setDefaults() {
+	if o.ReorderDelay <= 0 {
+		o.ReorderDelay = 10 * time.Millisecond
+	}
+}
+
+type NatOptions struct {
+	Type NatType
+	// PortStart 是第一个分配的外网端口，之后每次加 PortDelta，对称型 NAT 的端口预测就是猜这个规律
+	PortStart int
+	PortDelta int
+	// Timeout 内没有出去的包映射就失效，0 表示不过期
+	Timeout time.Duration
+}
+
+func (o *NatOptions) setDefaults() {
+	if o.Type == 0 {
+		o.Type = PortRestricted
+	}
+	if o.PortStart <= 0 {
+		o.PortStart = 40000
+	}
+	if o.PortDelta <= 0 {
+		o.PortDelta = 1
+	}
+}
+
+// Stats 是网络里包的计数
+type Stats struct {
+	Sent      int
+	Delivered int
+	Lost      int // 按丢包率丢掉的
+	Filtered  int // 被 NAT 过滤或者没有套接字接收的
+}
+
+type Network struct {
+	mu    sync.Mutex
+	link  LinkOptions
+	rnd   *rand.Rand
+	hosts map[string]*Host // 公网 IP -> 主机
+	nats  map[string]*NAT  // 公网 IP -> NAT
+	stats Stats
+}
+
+// New 创建模拟网络，seed 决定丢包、抖动和乱序
+func New(seed int64, link LinkOptions) *Network {
+	link.setDefaults()
+	return &Network{
+		link:  link,
+		rnd:   rand.New(rand.NewSource(seed)),
+		hosts: make(map[string]*Host),
+		nats:  make(map[string]*NAT),
+	}
+}
+
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// Host 添加一台公网主机，可以有多个地址，地址无效或者已经占用时 panic
+func (n *Network) Host(ips ...string) *Host {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	h := newHost(n, nil, ips)
+	for _, ip := range h.ips {
+		n.claim(ip)
+		n.hosts[ip.String()] = h
+	}
+	return h
+}
+
+// NAT 添加一个公网地址是 ip 的 NAT 网关
+func (n *Network) NAT(ip string, opts NatOptions) *NAT {
+	opts.setDefaults()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addr := parseIP(ip)
+	n.claim(addr)
+	t := &NAT{
+		net:      n,
+		ip:       addr,
+		opts:     opts,
+		hosts:    make(map[string]*Host),
+		mappings: make(map[mapKey]*mapping),
+		byPort:   make(map[int]*mapping),
+		nextPort: opts.PortStart,
+	}
+	n.nats[addr.String()] = t
+	return t
+}
+
+func (n *Network) claim(ip net.IP) {
+	if n.hosts[ip.String()] != nil || n.nats[ip.String()] != nil {
+		panic("netsim: address " + ip.String() + " already in use")
+	}
+}
+
+func parseIP(s string) net.IP {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		panic("netsim: invalid ipv4 address " + s)
+	}
+	return ip
+}
+
+type packet struct {
+	src  *net.UDPAddr
+	data []byte
+}
+
+// send 按路由和 NAT 规则把包交给目的套接字，调用者不持有锁
+func (n *Network) send(from *Conn, dst *net.UDPAddr, b []byte) {
+	p := packet{src: from.source(), data: append([]byte(nil), b...)}
+	n.mu.Lock()
+	n.stats.Sent++
+	to := n.route(from.host, &p, dst)
+	if to == nil {
+		n.stats.Filtered++
+		n.mu.Unlock()
+		return
+	}
+	if n.link.Loss > 0 && n.rnd.Float64() < n.link.Loss {
+		n.stats.Lost++
+		n.mu.Unlock()
+		return
+	}
+	delay := n.link.Latency
+	if n.link.Jitter > 0 {
+		delay += time.Duration(n.rnd.Int63n(int64(n.link.Jitter)))
+	}
+	if n.link.Reorder > 0 && n.rnd.Float64() < n.link.Reorder {
+		delay += n.link.ReorderDelay
+	}
+	n.stats.Delivered++
+	n.mu.Unlock()
+	if delay == 0 {
+		to.enqueue(p)
+		return
+	}
+	time.AfterFunc(delay, func() { to.enqueue(p) })
+}
+
+// route 找到接收 dst 的套接字，经过 NAT 时改写 p 的源地址
+func (n *Network) route(h *Host, p *packet, dst *net.UDPAddr) *Conn {
+	ip := dst.IP.To4()
+	if ip == nil {
+		return nil
+	}
+	if h.nat != nil {
+		// 同一个 NAT 后面的主机直接通信
+		if peer := h.nat.hosts[ip.String()]; peer != nil {
+			return peer.lookup(ip, dst.Port)
+		}
+		p.src = h.nat.outbound(p.src, dst)
+	}
+	if peer := n.hosts[ip.String()]; peer != nil {
+		return peer.lookup(ip, dst.Port)
+	}
+	if t := n.nats[ip.String()]; t != nil {
+		internal := t.inbound(p.src, dst.Port)
+		if internal == nil {
+			return nil
+		}
+		if peer := t.hosts[internal.IP.String()]; peer != nil {
+			return peer.lookup(internal.IP, internal.Port)
+		}
+	}
+	return nil
+}
+
+// NAT 是一个网关，后面的主机共用它的公网地址
+type NAT struct {
+	net  *Network
+	ip   net.IP
+	opts NatOptions
+
+	// 以下字段由 Network.mu 保护
+	hosts    map[string]*Host // 内网 IP -> 主机
+	mappings map[mapKey]*mapping
+	byPort   map[int]*mapping
+	nextPort int
+}
+
+// mapKey 是映射的查找键，映射和目的无关时 remote 为空
+type mapKey struct {
+	internal string
+	remote   string
+}
+
+type mapping struct {
+	key      mapKey
+	internal *net.UDPAddr
+	port     int
+	allowed  map[string]bool // 发过包的 IP 和 IP:端口
+	lastSeen time.Time
+}
+
+// Host 在 NAT 后面添加一台内网主机
+func (t *NAT) Host(ip string) *Host {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	h := newHost(t.net, t, []string{ip})
+	if t.hosts[h.ips[0].String()] != nil {
+		panic("netsim: address " + ip + " already in use behind " + t.ip.String())
+	}
+	t.hosts[h.ips[0].String()] = h
+	return h
+}
+
+func (t *NAT) IP() net.IP {
+	return t.ip
+}
+
+func (t *NAT) expired(m *mapping) bool {
+	return t.opts.Timeout > 0 && time.Since(m.lastSeen) > t.opts.Timeout
+}
+
+func (t *NAT) remove(m *mapping) {
+	delete(t.mappings, m.key)
+	delete(t.byPort, m.port)
+}
+
+// outbound 给出去的包分配或者复用映射，返回改写后的源地址
+func (t *NAT) outbound(src, dst *net.UDPAddr) *net.UDPAddr {
+	key := mapKey{internal: src.String()}
+	if t.opts.Type == Symmetric {
+		key.remote = dst.String()
+	}
+	m := t.mappings[key]
+	if m != nil && t.expired(m) {
+		t.remove(m)
+		m = nil
+	}
+	if m == nil {
+		m = &mapping{key: key, internal: src, port: t.allocPort(), allowed: make(map[string]bool)}
+		t.mappings[key] = m
+		t.byPort[m.port] = m
+	}
+	m.lastSeen = time.Now()
+	m.allowed[dst.IP.String()] = true
+	m.allowed[dst.String()] = true
+	return &net.UDPAddr{IP: t.ip, Port: m.port}
+}
+
+func (t *NAT) allocPort() int {
+	for {
+		port := t.nextPort
+		if t.nextPort += t.opts.PortDelta; t.nextPort > 65535 {
+			t.nextPort = t.opts.PortStart
+		}
+		if t.byPort[port] == nil {
+			return port
+		}
+	}
+}
+
+// inbound 按过滤规则找到外网端口对应的内网地址，不允许进来时返回 nil
+func (t *NAT) inbound(src *net.UDPAddr, port int) *net.UDPAddr {
+	m := t.byPort[port]
+	if m == nil || t.expired(m) {
+		return nil
+	}
+	switch t.opts.Type {
+	case FullCone:
+	case Restricted:
+		if !m.allowed[src.IP.String()] {
+			return nil
+		}
+	default:
+		if !m.allowed[src.String()] {
+			return nil
+		}
+	}
+	return m.internal
+}
+
+// Host 是一台主机，实现 public.Network，在它上面创建的套接字都在模拟网络里
+type Host struct {
+	net *Network
+	nat *NAT
+	ips []net.IP
+
+	// 以下字段由 Network.mu 保护
+	conns    map[string]*Conn // 绑定的 IP:端口 -> 套接字，IP 是 0.0.0.0 时接收所有地址
+	nextPort int
+}
+
+func newHost(n *Network, nat *NAT, ips []string) *Host {
+	if len(ips) == 0 {
+		panic("netsim: host without address")
+	}
+	h := &Host{net: n, nat: nat, conns: make(map[string]*Conn), nextPort: 30000}
+	for _, ip := range ips {
+		h.ips = append(h.ips, parseIP(ip))
+	}
+	return h
+}
+
+// IP 返回主机的第一个地址
+func (h *Host) IP() net.IP {
+	return h.ips[0]
+}
+
+func (h *Host) lookup(ip net.IP, port int) *Conn {
+	if c := h.conns[(&net.UDPAddr{IP: ip, Port: port}).String()]; c != nil {
+		return c
+	}
+	return h.conns[(&net.UDPAddr{IP: net.IPv4zero, Port: port}).String()]
+}
+
+func (h *Host) hasIP(ip net.IP) bool {
+	for _, a := range h.ips {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenUDP 绑定本机地址，laddr 的 IP 为空时只有一个地址的主机绑定这个地址，
+// 多个地址的主机接收所有地址，端口为 0 时分配一个
+func (h *Host) ListenUDP(laddr *net.UDPAddr) (public.PacketConn, error) {
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	addr := &net.UDPAddr{IP: net.IPv4zero}
+	if laddr != nil {
+		addr.Port = laddr.Port
+		if laddr.IP != nil && !laddr.IP.IsUnspecified() {
+			addr.IP = laddr.IP.To4()
+		}
+	}
+	if addr.IP.IsUnspecified() && len(h.ips) == 1 {
+		addr.IP = h.ips[0]
+	}
+	if !addr.IP.IsUnspecified() && !h.hasIP(addr.IP) {
+		return nil, &net.OpError{Op: "listen", Net: "udp", Addr: addr, Err: fmt.Errorf("cannot assign requested address")}
+	}
+	if addr.Port == 0 {
+		for h.lookup(addr.IP, h.nextPort) != nil {
+			h.nextPort++
+		}
+		addr.Port = h.nextPort
+		h.nextPort++
+	}
+	if h.conns[addr.String()] != nil {
+		return nil, &net.OpError{Op: "listen", Net: "udp", Addr: addr, Err: fmt.Errorf("address already in use")}
+	}
+	c := &Conn{
+		host:       h,
+		laddr:      addr,
+		queue:      make(chan packet, 1024),
+		deadlineCh: make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	h.conns[addr.String()] = c
+	return c, nil
+}
+
+// DialUDP 从主机的第一个地址上的一个临时端口连到 raddr
+func (h *Host) DialUDP(raddr *net.UDPAddr) (net.Conn, error) {
+	if raddr.IP.To4() == nil {
+		return nil, &net.OpError{Op: "dial", Net: "udp", Addr: raddr, Err: fmt.Errorf("network is unreachable")}
+	}
+	c, err := h.ListenUDP(&net.UDPAddr{IP: h.IP()})
+	if err != nil {
+		return nil, err
+	}
+	return &connected{Conn: c.(*Conn), raddr: &net.UDPAddr{IP: raddr.IP.To4(), Port: raddr.Port}}, nil
+}
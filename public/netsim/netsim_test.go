@@ -0,0 +1,227 @@
+package netsim
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+)
+
+func listen(t *testing.T, h *Host, port int) public.PacketConn {
+	t.Helper()
+	c, err := h.ListenUDP(&net.UDPAddr{Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// recv 读一个包，超时返回 nil
+func recv(c public.PacketConn, timeout time.Duration) *net.UDPAddr {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 64)
+	_, addr, err := c.ReadFromUDP(buf)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
+func udpAddr(s string) *net.UDPAddr {
+	a, _ := net.ResolveUDPAddr("udp4", s)
+	return a
+}
+
+func TestNatMapping(t *testing.T) {
+	for _, typ := range []NatType{FullCone, Restricted, PortRestricted, Symmetric} {
+		t.Run(typ.String(), func(t *testing.T) {
+			n := New(1, LinkOptions{})
+			server := n.Host("198.51.100.1", "198.51.100.2")
+			s1 := mustListen(t, server, udpAddr("198.51.100.1:3478"))
+			s2 := mustListen(t, server, udpAddr("198.51.100.2:3478"))
+			nat := n.NAT("203.0.113.1", NatOptions{Type: typ})
+			c := listen(t, nat.Host("10.0.0.2"), 5000)
+
+			c.WriteToUDP([]byte("a"), s1.LocalAddr().(*net.UDPAddr))
+			a1 := recv(s1, time.Second)
+			c.WriteToUDP([]byte("b"), s2.LocalAddr().(*net.UDPAddr))
+			a2 := recv(s2, time.Second)
+			if a1 == nil || a2 == nil {
+				t.Fatal("packets not delivered")
+			}
+			if !a1.IP.Equal(nat.IP()) || a1.Port != 40000 {
+				t.Fatalf("first mapping %v", a1)
+			}
+			if same := a1.Port == a2.Port; same == (typ == Symmetric) {
+				t.Fatalf("mappings %v and %v", a1, a2)
+			}
+
+			// 服务器从没联系过的地址和端口回包，看 NAT 放不放行
+			filtering := []struct {
+				from string
+				want bool
+			}{
+				{"198.51.100.1:3478", true},
+				{"198.51.100.1:3479", typ == FullCone || typ == Restricted},
+				{"198.51.100.3:3478", typ == FullCone},
+			}
+			other := n.Host("198.51.100.3")
+			for _, f := range filtering {
+				h := server
+				if f.from == "198.51.100.3:3478" {
+					h = other
+				}
+				s := s1
+				if f.from != s1.LocalAddr().String() {
+					s = mustListen(t, h, udpAddr(f.from))
+				}
+				s.WriteToUDP([]byte("r"), a1)
+				if got := recv(c, 50*time.Millisecond) != nil; got != f.want {
+					t.Fatalf("from %v: delivered %v, want %v", f.from, got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func mustListen(t *testing.T, h *Host, addr *net.UDPAddr) public.PacketConn {
+	t.Helper()
+	c, err := h.ListenUDP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestSameNat(t *testing.T) {
+	n := New(1, LinkOptions{})
+	nat := n.NAT("203.0.113.1", NatOptions{Type: Symmetric})
+	a := listen(t, nat.Host("10.0.0.2"), 5000)
+	b := listen(t, nat.Host("10.0.0.3"), 5000)
+	a.WriteToUDP([]byte("x"), udpAddr("10.0.0.3:5000"))
+	if got := recv(b, time.Second); got == nil || got.String() != "10.0.0.2:5000" {
+		t.Fatalf("lan packet from %v", got)
+	}
+}
+
+func TestNatTimeout(t *testing.T) {
+	n := New(1, LinkOptions{})
+	s := listen(t, n.Host("198.51.100.1"), 3478)
+	nat := n.NAT("203.0.113.1", NatOptions{Type: FullCone, Timeout: 20 * time.Millisecond})
+	c := listen(t, nat.Host("10.0.0.2"), 5000)
+	c.WriteToUDP([]byte("a"), s.LocalAddr().(*net.UDPAddr))
+	mapped := recv(s, time.Second)
+	time.Sleep(40 * time.Millisecond)
+	s.WriteToUDP([]byte("b"), mapped)
+	if recv(c, 20*time.Millisecond) != nil {
+		t.Fatal("expired mapping still forwards")
+	}
+	c.WriteToUDP([]byte("c"), s.LocalAddr().(*net.UDPAddr))
+	if again := recv(s, time.Second); again.Port == mapped.Port {
+		t.Fatalf("expired mapping reused port %d", again.Port)
+	}
+}
+
+func TestLossDeterministic(t *testing.T) {
+	run := func() Stats {
+		n := New(42, LinkOptions{Loss: 0.3})
+		h := n.Host("198.51.100.1")
+		a, _ := h.ListenUDP(&net.UDPAddr{Port: 1})
+		b, _ := h.ListenUDP(&net.UDPAddr{Port: 2})
+		defer a.Close()
+		defer b.Close()
+		for i := 0; i < 200; i++ {
+			a.WriteToUDP([]byte("x"), b.LocalAddr().(*net.UDPAddr))
+		}
+		return n.Stats()
+	}
+	s1, s2 := run(), run()
+	if s1 != s2 {
+		t.Fatalf("same seed, different stats: %+v %+v", s1, s2)
+	}
+	if s1.Sent != 200 || s1.Lost == 0 || s1.Lost+s1.Delivered != 200 {
+		t.Fatalf("stats %+v", s1)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	n := New(1, LinkOptions{Latency: 30 * time.Millisecond})
+	h := n.Host("198.51.100.1")
+	a := listen(t, h, 1)
+	b := listen(t, h, 2)
+	start := time.Now()
+	a.WriteToUDP([]byte("x"), b.LocalAddr().(*net.UDPAddr))
+	if recv(b, time.Second) == nil {
+		t.Fatal("packet lost")
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("delivered after %v", d)
+	}
+}
+
+func TestDeadlineAndClose(t *testing.T) {
+	n := New(1, LinkOptions{})
+	h := n.Host("198.51.100.1")
+	c, err := h.ListenUDP(&net.UDPAddr{Port: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.ListenUDP(&net.UDPAddr{Port: 1}); err == nil {
+		t.Fatal("port bound twice")
+	}
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = c.ReadFromUDP(make([]byte, 8))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read after deadline: %v", err)
+	}
+
+	// 读的时候延长超时不能提前返回
+	c.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.ReadFromUDP(make([]byte, 8))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.SetReadDeadline(time.Now().Add(time.Hour))
+	select {
+	case err := <-done:
+		t.Fatalf("read returned early: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("read after close: %v", err)
+	}
+	if c2, err := h.ListenUDP(&net.UDPAddr{Port: 1}); err != nil {
+		t.Fatalf("port not released: %v", err)
+	} else {
+		c2.Close()
+	}
+}
+
+func TestUdpServer(t *testing.T) {
+	n := New(1, LinkOptions{})
+	h := n.Host("198.51.100.1")
+	s := public.NewUdpServer("198.51.100.1:3478", func(w public.UdpWriter, buf []byte, addr *net.UDPAddr) {
+		w.WriteToUDP(buf, addr)
+	}, public.UdpServerOptions{Network: h})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	nat := n.NAT("203.0.113.1", NatOptions{})
+	c := listen(t, nat.Host("10.0.0.2"), 0)
+	c.WriteToUDP([]byte("hello"), udpAddr("198.51.100.1:3478"))
+	if from := recv(c, time.Second); from == nil || from.String() != "198.51.100.1:3478" {
+		t.Fatalf("echo from %v", from)
+	}
+}
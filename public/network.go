@@ -0,0 +1,56 @@
+package public
+
+import (
+	"net"
+)
+
+// PacketConn 是收发 UDP 包用到的套接字方法，*net.UDPConn 满足它
+type PacketConn interface {
+	net.PacketConn
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+}
+
+// Network 创建 UDP 套接字，测试时换成 netsim 的模拟网络
+type Network interface {
+	ListenUDP(laddr *net.UDPAddr) (PacketConn, error)
+	// DialUDP 创建连到 raddr 的套接字，只收 raddr 发来的包，LocalAddr 是发往 raddr 用的本机地址
+	DialUDP(raddr *net.UDPAddr) (net.Conn, error)
+}
+
+type systemNetwork struct{}
+
+func (systemNetwork) ListenUDP(laddr *net.UDPAddr) (PacketConn, error) {
+	conn, err := net.ListenUDP("udp4", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (systemNetwork) DialUDP(raddr *net.UDPAddr) (net.Conn, error) {
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// System 是操作系统的网络
+var System Network = systemNetwork{}
+
+// ListenUDP 在 n 上创建套接字，n 为 nil 时用 System
+func ListenUDP(n Network, laddr *net.UDPAddr) (PacketConn, error) {
+	if n == nil {
+		n = System
+	}
+	return n.ListenUDP(laddr)
+}
+
+// DialUDP 在 n 上创建连到 raddr 的套接字，n 为 nil 时用 System
+func DialUDP(n Network, raddr *net.UDPAddr) (net.Conn, error) {
+	if n == nil {
+		n = System
+	}
+	return n.DialUDP(raddr)
+}
@@ -1,48 +1,332 @@
 package public
 
 import (
-	"log"
+	"errors"
+	"log/slog"
 	"net"
-	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type UdpDataHandler func(*net.UDPConn, []byte, *net.UDPAddr)
+// UdpWriter 是处理函数回包用的接口，*net.UDPConn 也满足它
+type UdpWriter interface {
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+}
+
+// UdpDataHandler 在工作协程中被调用，buf 在返回后会被回收，不能在返回后继续持有
+type UdpDataHandler func(UdpWriter, []byte, *net.UDPAddr)
+
+type UdpServerOptions struct {
+	Workers   int // 处理协程数，默认 CPU 核数
+	QueueSize int // 接收队列长度，满了直接丢包
+	BatchSize int // 每次 recvmmsg/sendmmsg 的最大包数，仅 Linux 生效
+	Sockets   int // 大于 1 时用 SO_REUSEPORT 开多个套接字分流，仅 Linux 生效
+	BufSize   int // 单个包的缓冲区大小
+	// Network 不为 nil 时在它上面创建套接字，只开一个，不批量收发
+	Network Network
+	// Allow 不为 nil 时在入队之前按源地址过滤，被拒绝的包直接丢弃，不占队列也不回包
+	Allow  func(ip net.IP) bool
+	Logger *slog.Logger
+}
+
+func (o *UdpServerOptions) setDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 4096
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 32
+	}
+	if o.Sockets <= 0 {
+		o.Sockets = 1
+	}
+	if o.BufSize <= 0 {
+		o.BufSize = 2 * 1024
+	}
+	o.Logger = orDefault(o.Logger)
+}
+
+type UdpServerStats struct {
+	Received uint64
+	Sent     uint64
+	Dropped  uint64
+}
+
+type udpPacket struct {
+	buf  *[]byte
+	n    int
+	addr *net.UDPAddr
+	w    *udpSocket
+}
+
+type UdpServer struct {
+	addr   string
+	handle UdpDataHandler
+	opts   UdpServerOptions
+
+	bufPool sync.Pool
+	queue   chan *udpPacket
+	done    chan struct{}
+	socks   []*udpSocket
+	wg      sync.WaitGroup
+	closed  atomic.Bool
+
+	received atomic.Uint64
+	sent     atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+var ErrServerClosed = errors.New("udp server closed")
 
-func UdpServer(addr string, handle UdpDataHandler) {
-	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+func NewUdpServer(addr string, handle UdpDataHandler, opts UdpServerOptions) *UdpServer {
+	opts.setDefaults()
+	s := &UdpServer{
+		addr:   addr,
+		handle: handle,
+		opts:   opts,
+		queue:  make(chan *udpPacket, opts.QueueSize),
+		done:   make(chan struct{}),
+	}
+	s.bufPool.New = func() any {
+		b := make([]byte, s.opts.BufSize)
+		return &b
+	}
+	return s
+}
+
+// Listen 只创建套接字，便于在 Serve 之前拿到实际监听地址
+func (s *UdpServer) Listen() error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", s.addr)
 	if err != nil {
-		log.Println("Invalid address:", err)
-		os.Exit(1)
+		return err
 	}
 
 	// 创建UDP监听
-	log.Println("Listen udp", addr)
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		log.Println("Error listening on UDP port:", err)
-		os.Exit(1)
+	var conns []PacketConn
+	if s.opts.Network != nil {
+		conn, err := s.opts.Network.ListenUDP(udpAddr)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+	} else if conns, err = listenUdp(udpAddr, s.opts.Sockets, s.opts.Logger); err != nil {
+		return err
 	}
-	defer conn.Close()
+	for _, conn := range conns {
+		s.socks = append(s.socks, newUdpSocket(s, conn))
+	}
+	s.opts.Logger.Info("udp server listening", "addr", s.Addr().String(), "sockets", len(s.socks))
+	return nil
+}
 
-	log.Println("UDP server listening on port", addr)
+func (s *UdpServer) Serve() error {
+	if len(s.socks) == 0 {
+		return errors.New("udp server not listening")
+	}
+	for i := 0; i < s.opts.Workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
 
-	// 无限循环，等待并处理数据
-	for {
-		handleClient(conn, handle)
+	var readers sync.WaitGroup
+	for _, sock := range s.socks {
+		readers.Add(2)
+		go func(sock *udpSocket) {
+			defer readers.Done()
+			sock.readLoop()
+		}(sock)
+		go func(sock *udpSocket) {
+			defer readers.Done()
+			sock.writeLoop()
+		}(sock)
 	}
+	readers.Wait()
+	close(s.queue)
+	s.wg.Wait()
+	if s.closed.Load() {
+		return ErrServerClosed
+	}
+	return nil
 }
 
-func handleClient(conn *net.UDPConn, handle UdpDataHandler) {
-	var buf [8 * 1024]byte
+func (s *UdpServer) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
 
-	// 读取数据
-	n, addr, err := conn.ReadFromUDP(buf[0:])
-	if err != nil {
-		log.Println(err)
+func (s *UdpServer) Addr() net.Addr {
+	if len(s.socks) == 0 {
+		return nil
+	}
+	return s.socks[0].conn.LocalAddr()
+}
+
+func (s *UdpServer) Stats() UdpServerStats {
+	return UdpServerStats{
+		Received: s.received.Load(),
+		Sent:     s.sent.Load(),
+		Dropped:  s.dropped.Load(),
+	}
+}
+
+func (s *UdpServer) Close() error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(s.done)
+	var err error
+	for _, sock := range s.socks {
+		if e := sock.conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *UdpServer) getBuf() *[]byte {
+	return s.bufPool.Get().(*[]byte)
+}
+
+func (s *UdpServer) putBuf(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	s.bufPool.Put(b)
+}
+
+// dispatch 由读协程调用，队列满时丢包而不是阻塞读
+func (s *UdpServer) dispatch(p *udpPacket) {
+	s.received.Add(1)
+	if s.opts.Allow != nil && !s.opts.Allow(p.addr.IP) {
+		s.putBuf(p.buf)
 		return
 	}
+	select {
+	case s.queue <- p:
+	default:
+		s.dropped.Add(1)
+		s.putBuf(p.buf)
+	}
+}
+
+func (s *UdpServer) work() {
+	defer s.wg.Done()
+	for p := range s.queue {
+		s.handle(p.w, (*p.buf)[:p.n], p.addr)
+		s.putBuf(p.buf)
+	}
+}
+
+type udpSocket struct {
+	s     *UdpServer
+	conn  PacketConn
+	sendq chan *udpPacket
+}
+
+func newUdpSocket(s *UdpServer, conn PacketConn) *udpSocket {
+	return &udpSocket{
+		s:     s,
+		conn:  conn,
+		sendq: make(chan *udpPacket, s.opts.QueueSize),
+	}
+}
 
-	// 打印接收到的消息
-	log.Println("Received from ", addr)
-	handle(conn, buf[0:n], addr)
+// WriteToUDP 把回包拷贝进发送队列，由发送协程批量发出
+func (u *udpSocket) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	if u.s.closed.Load() {
+		return 0, ErrServerClosed
+	}
+	if len(b) > u.s.opts.BufSize {
+		return 0, errors.New("udp packet too large")
+	}
+	buf := u.s.getBuf()
+	n := copy(*buf, b)
+
+	select {
+	case u.sendq <- &udpPacket{buf: buf, n: n, addr: addr}:
+		return n, nil
+	default:
+		u.s.dropped.Add(1)
+		u.s.putBuf(buf)
+		return 0, errors.New("udp send queue full")
+	}
+}
+
+// maxReadRetry 是读一直失败时的最长重试间隔
+const maxReadRetry = time.Second
+
+// readRetry 在读一直失败时逐步拉长重试间隔，避免空转占满 CPU、刷满日志
+type readRetry struct {
+	wait time.Duration
+}
+
+// failed 记一次读失败并等待，间隔到了上限后每次等待打一条日志，s 关闭时返回 false
+func (r *readRetry) failed(s *UdpServer, err error) bool {
+	switch {
+	case r.wait == 0:
+		r.wait = time.Millisecond
+		s.opts.Logger.Warn("udp read failed", "err", err)
+	case r.wait < maxReadRetry:
+		r.wait = min(2*r.wait, maxReadRetry)
+	default:
+		s.opts.Logger.Warn("udp read still failing", "err", err, "retry", r.wait)
+	}
+	t := time.NewTimer(r.wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// succeeded 在读成功后重置间隔
+func (r *readRetry) succeeded() {
+	r.wait = 0
+}
+
+// readEach 逐个收包，不能批量收发的平台和套接字用它
+func (u *udpSocket) readEach() {
+	s := u.s
+	var retry readRetry
+	for {
+		buf := s.getBuf()
+		n, addr, err := u.conn.ReadFromUDP(*buf)
+		if err != nil {
+			s.putBuf(buf)
+			if errors.Is(err, net.ErrClosed) || !retry.failed(s, err) {
+				return
+			}
+			continue
+		}
+		retry.succeeded()
+		s.dispatch(&udpPacket{buf: buf, n: n, addr: addr, w: u})
+	}
+}
+
+func (u *udpSocket) writeEach() {
+	s := u.s
+	for {
+		select {
+		case p := <-u.sendq:
+			_, err := u.conn.WriteToUDP((*p.buf)[:p.n], p.addr)
+			s.putBuf(p.buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				s.opts.Logger.Warn("udp write failed", "peer", p.addr.String(), "err", err)
+				s.dropped.Add(1)
+				continue
+			}
+			s.sent.Add(1)
+		case <-s.done:
+			return
+		}
+	}
 }
@@ -0,0 +1,201 @@
+package public
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func echo(w UdpWriter, buf []byte, addr *net.UDPAddr) {
+	w.WriteToUDP(buf, addr)
+}
+
+func startServer(tb testing.TB, opts UdpServerOptions) *UdpServer {
+	s := NewUdpServer("127.0.0.1:0", echo, opts)
+	if err := s.Listen(); err != nil {
+		tb.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Serve()
+		close(done)
+	}()
+	tb.Cleanup(func() {
+		s.Close()
+		<-done
+	})
+	return s
+}
+
+func dial(tb testing.TB, s *UdpServer) *net.UDPConn {
+	conn, err := net.DialUDP("udp4", nil, s.Addr().(*net.UDPAddr))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func roundTrip(conn *net.UDPConn, msg, buf []byte) error {
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if string(buf[:n]) != string(msg) {
+		return fmt.Errorf("got %q want %q", buf[:n], msg)
+	}
+	return nil
+}
+
+func TestUdpServerEcho(t *testing.T) {
+	for _, opts := range []UdpServerOptions{
+		{Workers: 1, BatchSize: 1},
+		{Workers: 4, BatchSize: 32},
+		{Workers: 4, BatchSize: 32, Sockets: 4},
+	} {
+		t.Run(fmt.Sprintf("w%d_b%d_s%d", opts.Workers, opts.BatchSize, opts.Sockets), func(t *testing.T) {
+			s := startServer(t, opts)
+			var wg sync.WaitGroup
+			for c := 0; c < 8; c++ {
+				conn := dial(t, s)
+				wg.Add(1)
+				go func(c int) {
+					defer wg.Done()
+					buf := make([]byte, 64)
+					for i := 0; i < 100; i++ {
+						if err := roundTrip(conn, []byte(fmt.Sprintf("%d-%d", c, i)), buf); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}(c)
+			}
+			wg.Wait()
+
+			st := s.Stats()
+			if st.Received != 800 || st.Sent != 800 || st.Dropped != 0 {
+				t.Fatalf("unexpected stats %+v", st)
+			}
+		})
+	}
+}
+
+func TestUdpServerAllow(t *testing.T) {
+	var mu sync.Mutex
+	allowed := 1
+	s := startServer(t, UdpServerOptions{Workers: 1, Allow: func(ip net.IP) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		allowed--
+		return allowed >= 0
+	}})
+	conn := dial(t, s)
+	buf := make([]byte, 64)
+	if err := roundTrip(conn, []byte("first"), buf); err != nil {
+		t.Fatal(err)
+	}
+	// 被拒绝的包不进队列，不回包，也不算队列满丢的包
+	if err := roundTrip(conn, []byte("second"), buf); err == nil {
+		t.Fatal("rejected packet answered")
+	}
+	if st := s.Stats(); st.Received != 2 || st.Sent != 1 || st.Dropped != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestUdpServerClose(t *testing.T) {
+	s := NewUdpServer("127.0.0.1:0", echo, UdpServerOptions{})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+	s.Close()
+	select {
+	case err := <-done:
+		if err != ErrServerClosed {
+			t.Fatalf("Serve returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+}
+
+func TestReadRetry(t *testing.T) {
+	var logs bytes.Buffer
+	s := NewUdpServer("127.0.0.1:0", echo, UdpServerOptions{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	// 持续失败时间隔翻倍到上限，中间不打日志
+	var r readRetry
+	for r.wait < maxReadRetry {
+		if !r.failed(s, errors.New("boom")) {
+			t.Fatal("failed returned false before close")
+		}
+	}
+	if n := strings.Count(logs.String(), "\n"); n != 1 {
+		t.Fatalf("%d log lines while backing off:\n%s", n, logs.String())
+	}
+	r.succeeded()
+	if r.wait != 0 {
+		t.Fatalf("wait after success = %v", r.wait)
+	}
+	// 关闭后不再等待
+	s.Close()
+	start := time.Now()
+	if r.failed(s, errors.New("boom")) || time.Since(start) > 100*time.Millisecond {
+		t.Fatal("failed did not return after close")
+	}
+}
+
+func benchmarkUdpServer(b *testing.B, opts UdpServerOptions) {
+	s := startServer(b, opts)
+	msg := make([]byte, 20)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		conn, err := net.DialUDP("udp4", nil, s.Addr().(*net.UDPAddr))
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		for pb.Next() {
+			if _, err := conn.Write(msg); err != nil {
+				b.Error(err)
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Read(buf); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkUdpServerSingle(b *testing.B) {
+	benchmarkUdpServer(b, UdpServerOptions{Workers: 1, BatchSize: 1})
+}
+
+func BenchmarkUdpServerWorkers(b *testing.B) {
+	benchmarkUdpServer(b, UdpServerOptions{BatchSize: 1})
+}
+
+func BenchmarkUdpServerBatch(b *testing.B) {
+	benchmarkUdpServer(b, UdpServerOptions{BatchSize: 32})
+}
+
+func BenchmarkUdpServerReusePort(b *testing.B) {
+	benchmarkUdpServer(b, UdpServerOptions{BatchSize: 32, Sockets: 4})
+}
@@ -0,0 +1,67 @@
+package public
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenUdp 在 n>1 时用 SO_REUSEPORT 绑定多个套接字，由内核按四元组分流
+func listenUdp(addr *net.UDPAddr, n int, _ *slog.Logger) ([]PacketConn, error) {
+	if n <= 1 {
+		conn, err := net.ListenUDP("udp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []PacketConn{conn}, nil
+	}
+
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var opErr error
+			err := c.Control(func(fd uintptr) {
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return opErr
+		},
+	}
+
+	var conns []PacketConn
+	for i := 0; i < n; i++ {
+		// 端口为 0 时后面的套接字要绑定到第一个分到的端口上
+		if i == 1 {
+			addr = conns[0].LocalAddr().(*net.UDPAddr)
+		}
+		pc, err := lc.ListenPacket(context.Background(), "udp4", addr.String())
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, pc.(*net.UDPConn))
+	}
+	return conns, nil
+}
+
+// ReuseControl 设置 SO_REUSEADDR 和 SO_REUSEPORT，同一个本地 TCP 端口
+// 可以同时监听和向外连接，TCP 打洞要用
+func ReuseControl(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		if opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); opErr != nil {
+			return
+		}
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package public
+
+import (
+	"log/slog"
+	"net"
+	"syscall"
+)
+
+func listenUdp(addr *net.UDPAddr, n int, logger *slog.Logger) ([]PacketConn, error) {
+	if n > 1 {
+		logger.Warn("SO_REUSEPORT not supported on this platform, use one socket")
+	}
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	return []PacketConn{conn}, nil
+}
+
+// ReuseControl 在其他平台上不设置端口复用，TCP 打洞时监听和连接会冲突
+func ReuseControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,212 @@
+package admin
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"github.com/jinyunx/p2p/server/guard"
+	"github.com/jinyunx/p2p/server/logic"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+type Server struct {
+	pb.UnimplementedAdminServer
+
+	nodes    *logic.NodesMap
+	guard    *guard.Guard
+	udpStats func() public.UdpServerStats
+	started  time.Time
+
+	// OnDrain 在切换下线状态后调用，用来同步健康检查状态
+	OnDrain func(drain bool)
+}
+
+// NewServer 创建管理服务，udpStats 可以为 nil
+func NewServer(nodes *logic.NodesMap, g *guard.Guard, udpStats func() public.UdpServerStats) *Server {
+	return &Server{
+		nodes:    nodes,
+		guard:    g,
+		udpStats: udpStats,
+		started:  time.Now(),
+	}
+}
+
+func toAdminNode(e logic.NodeEntry) *pb.AdminNodeInfo {
+	return &pb.AdminNodeInfo{
+		NodeInfo:  e.Info,
+		SourceIp:  e.IP,
+		UpdatedAt: e.Updated.Unix(),
+	}
+}
+
+func match(in *pb.ListNodesReq, e logic.NodeEntry) bool {
+	if !strings.HasPrefix(e.Info.GetName(), in.GetNamePrefix()) {
+		return false
+	}
+	if in.GetSourceIp() != "" && in.GetSourceIp() != e.IP {
+		return false
+	}
+	if len(in.GetNatTypes()) == 0 {
+		return true
+	}
+	for _, t := range in.GetNatTypes() {
+		if t == e.Info.GetNatType() {
+			return true
+		}
+	}
+	return false
+}
+
+// ListNodes 按名字排序分页，page_token 是上一页最后一个节点名
+func (s *Server) ListNodes(ctx context.Context, in *pb.ListNodesReq) (*pb.ListNodesResp, error) {
+	pageSize := int(in.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	out := &pb.ListNodesResp{}
+	for _, e := range s.nodes.Entries() {
+		if !match(in, e) {
+			continue
+		}
+		out.Total++
+		if e.Info.GetName() <= in.GetPageToken() {
+			continue
+		}
+		if len(out.Nodes) == pageSize {
+			// 还有更多，用本页最后一个名字作为下一页起点
+			out.NextPageToken = out.Nodes[len(out.Nodes)-1].GetNodeInfo().GetName()
+			continue
+		}
+		out.Nodes = append(out.Nodes, toAdminNode(e))
+	}
+	return out, nil
+}
+
+func (s *Server) GetNode(ctx context.Context, in *pb.GetNodeReq) (*pb.GetNodeResp, error) {
+	e, ok := s.nodes.Get(in.GetName())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "node %q not found", in.GetName())
+	}
+	return &pb.GetNodeResp{Node: toAdminNode(e)}, nil
+}
+
+func (s *Server) KickNode(ctx context.Context, in *pb.KickNodeReq) (*pb.KickNodeResp, error) {
+	if !s.nodes.Remove(in.GetName()) {
+		return nil, status.Errorf(codes.NotFound, "node %q not found", in.GetName())
+	}
+	public.LoggerFromContext(ctx).Info("node kicked", "node", in.GetName())
+	return &pb.KickNodeResp{}, nil
+}
+
+func (s *Server) BanName(ctx context.Context, in *pb.BanNameReq) (*pb.BanNameResp, error) {
+	if in.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty name")
+	}
+	logger := public.LoggerFromContext(ctx).With("node", in.GetName())
+	if in.GetUnban() {
+		s.nodes.UnbanName(in.GetName())
+		logger.Info("name unbanned")
+		return &pb.BanNameResp{}, nil
+	}
+	kicked := s.nodes.BanName(in.GetName())
+	logger.Info("name banned", "kicked", kicked)
+	return &pb.BanNameResp{Kicked: int32(kicked)}, nil
+}
+
+func (s *Server) BanIP(ctx context.Context, in *pb.BanIPReq) (*pb.BanIPResp, error) {
+	list, err := guard.ParseCIDRList(in.GetCidr())
+	if err != nil || len(list) != 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid cidr %q", in.GetCidr())
+	}
+	ipNet := list[0]
+	logger := public.LoggerFromContext(ctx).With("cidr", ipNet.String())
+	if in.GetUnban() {
+		if !s.guard.Unban(ipNet) {
+			return nil, status.Errorf(codes.NotFound, "%s is not banned", ipNet)
+		}
+		logger.Info("address unbanned")
+		return &pb.BanIPResp{}, nil
+	}
+	s.guard.Ban(ipNet)
+	kicked := s.nodes.RemoveIP(ipNet)
+	logger.Info("address banned", "kicked", kicked)
+	return &pb.BanIPResp{Kicked: int32(kicked)}, nil
+}
+
+func (s *Server) DrainServer(ctx context.Context, in *pb.DrainServerReq) (*pb.DrainServerResp, error) {
+	s.nodes.SetDraining(in.GetDrain())
+	if s.OnDrain != nil {
+		s.OnDrain(in.GetDrain())
+	}
+	public.LoggerFromContext(ctx).Warn("drain mode changed", "draining", in.GetDrain())
+	return &pb.DrainServerResp{}, nil
+}
+
+func (s *Server) GetStats(ctx context.Context, in *pb.GetStatsReq) (*pb.GetStatsResp, error) {
+	g := s.guard.Stats()
+	out := &pb.GetStatsResp{
+		Nodes:                 int32(s.nodes.Len()),
+		RegistrationsRejected: s.nodes.Rejected(),
+		UdpRateDropped:        g.UdpRateDropped,
+		UdpAclDropped:         g.UdpAclDropped,
+		RpcRateDropped:        g.RpcRateDropped,
+		RpcAclDropped:         g.RpcAclDropped,
+		BannedNames:           s.nodes.BannedNames(),
+		Draining:              s.nodes.Draining(),
+		StartedAt:             s.started.Unix(),
+	}
+	for _, n := range s.guard.Bans() {
+		out.BannedCidrs = append(out.BannedCidrs, n.String())
+	}
+	if s.udpStats != nil {
+		u := s.udpStats()
+		out.UdpReceived, out.UdpSent, out.UdpDropped = u.Received, u.Sent, u.Dropped
+	}
+	return out, nil
+}
+
+func (s *Server) ListAddresses(ctx context.Context, in *pb.ListAddressesReq) (*pb.ListAddressesResp, error) {
+	addrs, err := s.nodes.Addresses(in.GetNetwork())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListAddressesResp{Addresses: addrs}, nil
+}
+
+func (s *Server) ReserveAddress(ctx context.Context, in *pb.ReserveAddressReq) (*pb.ReserveAddressResp, error) {
+	if in.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty name")
+	}
+	ip := net.ParseIP(in.GetIp())
+	if ip == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ip %q", in.GetIp())
+	}
+	addr, err := s.nodes.Reserve(in.GetNetwork(), in.GetName(), ip)
+	if err != nil {
+		return nil, err
+	}
+	public.LoggerFromContext(ctx).Info("address reserved", "network", addr.GetNetwork(), "node", in.GetName(), "ip", ip.String())
+	return &pb.ReserveAddressResp{Address: addr}, nil
+}
+
+func (s *Server) ReleaseAddress(ctx context.Context, in *pb.ReleaseAddressReq) (*pb.ReleaseAddressResp, error) {
+	if err := s.nodes.Release(in.GetNetwork(), in.GetName()); err != nil {
+		return nil, err
+	}
+	public.LoggerFromContext(ctx).Info("address released", "network", in.GetNetwork(), "node", in.GetName())
+	return &pb.ReleaseAddressResp{}, nil
+}
@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/server/guard"
+	"github.com/jinyunx/p2p/server/logic"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestServer 注册 n0..n9，偶数节点来自 192.0.2.1，奇数节点来自 198.51.100.1
+func newTestServer(t *testing.T) (*Server, *logic.NodesMap) {
+	nodes := logic.NewNodesMap()
+	for i := 0; i < 10; i++ {
+		ip := "192.0.2.1"
+		if i%2 == 1 {
+			ip = "198.51.100.1"
+		}
+		if err := nodes.Update(&pb.NodeInfo{Name: fmt.Sprintf("n%d", i)}, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewServer(nodes, guard.New(guard.Config{}), nil), nodes
+}
+
+func TestListNodesPaging(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	var names []string
+	req := &pb.ListNodesReq{PageSize: 4}
+	for pages := 0; ; pages++ {
+		resp, err := s.ListNodes(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetTotal() != 10 {
+			t.Fatalf("total = %d", resp.GetTotal())
+		}
+		for _, n := range resp.GetNodes() {
+			names = append(names, n.GetNodeInfo().GetName())
+		}
+		if resp.GetNextPageToken() == "" {
+			if pages != 2 {
+				t.Fatalf("%d pages", pages+1)
+			}
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	if got := fmt.Sprint(names); got != "[n0 n1 n2 n3 n4 n5 n6 n7 n8 n9]" {
+		t.Fatalf("names = %s", got)
+	}
+
+	// total 是过滤后的个数，不是这一页的个数
+	resp, err := s.ListNodes(ctx, &pb.ListNodesReq{SourceIp: "198.51.100.1", PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetTotal() != 5 || len(resp.GetNodes()) != 2 || resp.GetNextPageToken() != "n3" {
+		t.Fatalf("filtered page = %v", resp)
+	}
+	// 恰好一页时没有下一页
+	resp, err = s.ListNodes(ctx, &pb.ListNodesReq{PageToken: "n5", PageSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetNodes()) != 4 || resp.GetNextPageToken() != "" {
+		t.Fatalf("last page = %v", resp)
+	}
+}
+
+func TestBan(t *testing.T) {
+	s, nodes := newTestServer(t)
+	ctx := context.Background()
+
+	resp, err := s.BanIP(ctx, &pb.BanIPReq{Cidr: "198.51.100.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetKicked() != 5 || nodes.Len() != 5 {
+		t.Fatalf("kicked %d, %d left", resp.GetKicked(), nodes.Len())
+	}
+	if s.guard.AllowUdp(net.IPv4(198, 51, 100, 7)) {
+		t.Fatal("banned address allowed")
+	}
+	if _, err := s.BanIP(ctx, &pb.BanIPReq{Cidr: "203.0.113.0/24", Unban: true}); status.Code(err) != codes.NotFound {
+		t.Fatalf("unban unknown cidr: %v", err)
+	}
+	if _, err := s.BanIP(ctx, &pb.BanIPReq{Cidr: "198.51.100.0/24", Unban: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	nameResp, err := s.BanName(ctx, &pb.BanNameReq{Name: "n0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nameResp.GetKicked() != 1 {
+		t.Fatalf("kicked %d", nameResp.GetKicked())
+	}
+	if err := nodes.Update(&pb.NodeInfo{Name: "n0"}, "192.0.2.1"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("banned name registered: %v", err)
+	}
+	// 没注册的名字也能封，封的时候没有节点被踢
+	if nameResp, err = s.BanName(ctx, &pb.BanNameReq{Name: "n0"}); err != nil || nameResp.GetKicked() != 0 {
+		t.Fatalf("ban again = %v, %v", nameResp, err)
+	}
+	if _, err := s.BanName(ctx, &pb.BanNameReq{Name: "n0", Unban: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes.Update(&pb.NodeInfo{Name: "n0"}, "192.0.2.1"); err != nil {
+		t.Fatalf("unbanned name: %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s, nodes := newTestServer(t)
+	ctx := context.Background()
+	var drained []bool
+	s.OnDrain = func(drain bool) { drained = append(drained, drain) }
+
+	if _, err := s.DrainServer(ctx, &pb.DrainServerReq{Drain: true}); err != nil {
+		t.Fatal(err)
+	}
+	// 下线时已有节点还能续期，新节点被拒绝
+	if err := nodes.Update(&pb.NodeInfo{Name: "n1"}, "198.51.100.1"); err != nil {
+		t.Fatalf("existing node: %v", err)
+	}
+	if err := nodes.Update(&pb.NodeInfo{Name: "new"}, "192.0.2.1"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("new node while draining: %v", err)
+	}
+	st, err := s.GetStats(ctx, &pb.GetStatsReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.GetDraining() || st.GetRegistrationsRejected() != 1 || st.GetNodes() != 10 {
+		t.Fatalf("stats = %v", st)
+	}
+
+	if _, err := s.DrainServer(ctx, &pb.DrainServerReq{Drain: false}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes.Update(&pb.NodeInfo{Name: "new"}, "192.0.2.1"); err != nil {
+		t.Fatalf("new node after drain: %v", err)
+	}
+	if fmt.Sprint(drained) != "[true false]" {
+		t.Fatalf("OnDrain calls %v", drained)
+	}
+}
@@ -0,0 +1,308 @@
+package cluster
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/server/guard"
+	"github.com/jinyunx/p2p/server/logic"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// defaultPullPage 是每页对账记录数，一条记录几百字节，一页远小于 gRPC 默认 4MB 的消息上限
+	defaultPullPage = 1000
+	maxPullPage     = 5000
+)
+
+type Config struct {
+	ID    string   // 本服务器在集群中的名字，必须唯一
+	Peers []string // 其他服务器的集群地址
+	Token string   // 集群内部共享的凭证
+
+	PushInterval time.Duration // 本地修改攒批推送的间隔
+	SyncInterval time.Duration // 全量对账的间隔
+	TombstoneTTL time.Duration // 删除标记保留时间，要大于最长的分区时间
+	Logger       *slog.Logger
+}
+
+func (c *Config) setDefaults() {
+	if c.PushInterval <= 0 {
+		c.PushInterval = 100 * time.Millisecond
+	}
+	if c.SyncInterval <= 0 {
+		c.SyncInterval = 10 * time.Second
+	}
+	if c.TombstoneTTL <= 0 {
+		c.TombstoneTTL = 10 * time.Minute
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+}
+
+// Node 把本地节点表的修改推给所有其他服务器，并定期随机找一台拉全量记录补齐，
+// 记录按版本后写者胜合并，所以推送丢失或乱序都能最终一致
+type Node struct {
+	pb.UnimplementedClusterServer
+
+	conf    Config
+	nodes   *logic.NodesMap
+	mailbox *logic.Mailbox
+	peers   []*peer
+
+	done chan struct{}
+	once sync.Once
+}
+
+// pendingKey 区分同一个名字的注册记录和封禁记录，各自只推最新的一条
+type pendingKey struct {
+	name string
+	ban  bool
+}
+
+// peer 有自己的发送队列，一台服务器卡住不影响向其他服务器推送
+type peer struct {
+	addr    string
+	conn    *grpc.ClientConn
+	client  pb.ClusterClient
+	mu      sync.Mutex
+	pending map[pendingKey]logic.Record
+	wake    chan struct{}
+}
+
+func New(conf Config, nodes *logic.NodesMap) *Node {
+	conf.setDefaults()
+	n := &Node{
+		conf:  conf,
+		nodes: nodes,
+		done:  make(chan struct{}),
+	}
+	for _, addr := range conf.Peers {
+		// Dial 不会阻塞，对端没起来时后台重连
+		conn, err := grpc.Dial(addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithPerRPCCredentials(guard.TokenCredentials(conf.Token)))
+		if err != nil {
+			conf.Logger.Error("dial cluster peer failed", "peer", addr, "err", err)
+			continue
+		}
+		n.peers = append(n.peers, &peer{
+			addr:    addr,
+			conn:    conn,
+			client:  pb.NewClusterClient(conn),
+			pending: make(map[pendingKey]logic.Record),
+			wake:    make(chan struct{}, 1),
+		})
+	}
+	nodes.SetReplicator(conf.ID, n.enqueue)
+	return n
+}
+
+func (n *Node) enqueue(r logic.Record) {
+	for _, p := range n.peers {
+		p.mu.Lock()
+		p.pending[pendingKey{name: r.Name(), ban: r.Ban}] = r
+		p.mu.Unlock()
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Serve 在 lis 上提供集群内部接口，阻塞到 lis 关闭
+func (n *Node) Serve(lis net.Listener) error {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(guard.TokenInterceptor(n.conf.Token)))
+	pb.RegisterClusterServer(s, n)
+	go func() {
+		<-n.done
+		s.Stop()
+	}()
+	err := s.Serve(lis)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+// Run 启动推送和对账循环，直到 Close
+func (n *Node) Run() {
+	for _, p := range n.peers {
+		go n.pushLoop(p)
+	}
+
+	// 启动时先拉一次全量，尽快追上其他服务器
+	n.syncOnce()
+
+	ticker := time.NewTicker(n.conf.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.syncOnce()
+			n.nodes.PurgeTombstones(time.Now().Add(-n.conf.TombstoneTTL))
+		}
+	}
+}
+
+func (n *Node) Close() {
+	n.once.Do(func() {
+		close(n.done)
+		for _, p := range n.peers {
+			p.conn.Close()
+		}
+	})
+}
+
+func (n *Node) pushLoop(p *peer) {
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-p.wake:
+		}
+		// 等一小会儿，把这段时间的修改攒成一批
+		select {
+		case <-n.done:
+			return
+		case <-time.After(n.conf.PushInterval):
+		}
+
+		p.mu.Lock()
+		req := &pb.PushReq{From: n.conf.ID}
+		for _, r := range p.pending {
+			req.Records = append(req.Records, toPb(r))
+		}
+		p.pending = make(map[pendingKey]logic.Record)
+		p.mu.Unlock()
+		if len(req.Records) == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		// 推送失败不重试，靠对账补齐
+		if _, err := p.client.Push(ctx, req); err != nil {
+			n.conf.Logger.Warn("push to cluster peer failed", "peer", p.addr, "records", len(req.Records), "err", err)
+		}
+		cancel()
+	}
+}
+
+func (n *Node) syncOnce() {
+	if len(n.peers) == 0 {
+		return
+	}
+	p := n.peers[rand.Intn(len(n.peers))]
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.conf.SyncInterval)
+	defer cancel()
+	applied, pages := 0, 0
+	req := &pb.PullReq{From: n.conf.ID, PageSize: defaultPullPage}
+	for {
+		resp, err := p.client.Pull(ctx, req)
+		if err != nil {
+			n.conf.Logger.Warn("pull from cluster peer failed", "peer", p.addr, "pages", pages, "err", err)
+			break
+		}
+		pages++
+		applied += n.apply(resp.GetRecords())
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	if applied > 0 {
+		n.conf.Logger.Info("synced from cluster peer", "peer", p.addr, "applied", applied, "pages", pages)
+	}
+}
+
+func (n *Node) apply(records []*pb.RegistryRecord) int {
+	applied := 0
+	for _, r := range records {
+		if n.nodes.Apply(fromPb(r)) {
+			applied++
+		}
+	}
+	return applied
+}
+
+func (n *Node) Push(ctx context.Context, in *pb.PushReq) (*pb.PushResp, error) {
+	applied := n.apply(in.GetRecords())
+	n.conf.Logger.Debug("cluster push", "from", in.GetFrom(), "records", len(in.GetRecords()), "applied", applied)
+	return &pb.PushResp{}, nil
+}
+
+func (n *Node) Pull(ctx context.Context, in *pb.PullReq) (*pb.PullResp, error) {
+	limit := int(in.GetPageSize())
+	if limit <= 0 {
+		limit = defaultPullPage
+	}
+	if limit > maxPullPage {
+		limit = maxPullPage
+	}
+	records, more := n.nodes.Snapshot(in.GetPageToken(), limit)
+	out := &pb.PullResp{}
+	for _, r := range records {
+		out.Records = append(out.Records, toPb(r))
+	}
+	if more {
+		out.NextPageToken = records[len(records)-1].Name()
+	}
+	return out, nil
+}
+
+// ForwardPunches 把本地收到的打洞请求转发给其他服务器，
+// 被连接方在哪台服务器上轮询都能收到
+func (n *Node) ForwardPunches(mb *logic.Mailbox) {
+	n.mailbox = mb
+	mb.SetForwarder(func(req *pb.PunchRequest) {
+		for _, p := range n.peers {
+			go func(p *peer) {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				if _, err := p.client.DeliverPunch(ctx, req); err != nil {
+					n.conf.Logger.Warn("forward punch request failed", "peer", p.addr, "err", err)
+				}
+			}(p)
+		}
+	})
+}
+
+func (n *Node) DeliverPunch(ctx context.Context, in *pb.PunchRequest) (*pb.DeliverPunchResp, error) {
+	if n.mailbox != nil {
+		n.mailbox.Deliver(in)
+	}
+	return &pb.DeliverPunchResp{}, nil
+}
+
+func toPb(r logic.Record) *pb.RegistryRecord {
+	return &pb.RegistryRecord{
+		NodeInfo: r.Info,
+		SourceIp: r.IP,
+		Version:  r.Version,
+		Origin:   r.Origin,
+		Deleted:  r.Deleted,
+		Ban:      r.Ban,
+	}
+}
+
+func fromPb(r *pb.RegistryRecord) logic.Record {
+	return logic.Record{
+		Info:    r.GetNodeInfo(),
+		IP:      r.GetSourceIp(),
+		Version: r.GetVersion(),
+		Origin:  r.GetOrigin(),
+		Deleted: r.GetDeleted(),
+		Ban:     r.GetBan(),
+	}
+}
@@ -0,0 +1,285 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/server/logic"
+	"golang.org/x/net/context"
+)
+
+type testServer struct {
+	nodes   *logic.NodesMap
+	cluster *Node
+	lis     net.Listener
+}
+
+// startCluster 在回环地址上启动 n 台互为对端的服务器
+func startCluster(t *testing.T, n int) []*testServer {
+	var lis []net.Listener
+	var addrs []string
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis = append(lis, l)
+		addrs = append(addrs, l.Addr().String())
+	}
+
+	var servers []*testServer
+	for i := 0; i < n; i++ {
+		s := startServer(t, fmt.Sprintf("s%d", i), lis[i], addrs)
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+func startServer(t *testing.T, id string, lis net.Listener, addrs []string) *testServer {
+	var peers []string
+	for _, a := range addrs {
+		if a != lis.Addr().String() {
+			peers = append(peers, a)
+		}
+	}
+	nodes := logic.NewNodesMap()
+	c := New(Config{
+		ID:           id,
+		Peers:        peers,
+		Token:        "secret",
+		PushInterval: 10 * time.Millisecond,
+		SyncInterval: 100 * time.Millisecond,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, nodes)
+	go c.Serve(lis)
+	go c.Run()
+	t.Cleanup(c.Close)
+	return &testServer{nodes: nodes, cluster: c, lis: lis}
+}
+
+func register(t *testing.T, s *testServer, name string, port int32) {
+	err := s.nodes.Update(&pb.NodeInfo{
+		Name:    name,
+		UdpAddr: &pb.UDPAddr{Ip: "1.2.3.4", Port: port},
+	}, "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func port(s *testServer, name string) int32 {
+	e, ok := s.nodes.Get(name)
+	if !ok {
+		return 0
+	}
+	return e.Info.GetUdpAddr().GetPort()
+}
+
+func TestClusterReplication(t *testing.T) {
+	servers := startCluster(t, 3)
+	a, b, c := servers[0], servers[1], servers[2]
+
+	register(t, a, "n1", 1000)
+	waitFor(t, "n1 on b and c", func() bool {
+		return port(b, "n1") == 1000 && port(c, "n1") == 1000
+	})
+
+	// 在另一台服务器上更新同一个节点，新地址覆盖旧地址
+	register(t, c, "n1", 2000)
+	waitFor(t, "n1 updated everywhere", func() bool {
+		return port(a, "n1") == 2000 && port(b, "n1") == 2000
+	})
+
+	if !b.nodes.Remove("n1") {
+		t.Fatal("n1 should exist on b")
+	}
+	waitFor(t, "n1 removed everywhere", func() bool {
+		return port(a, "n1") == 0 && port(c, "n1") == 0
+	})
+}
+
+func TestClusterLateJoin(t *testing.T) {
+	var lis []net.Listener
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis = append(lis, l)
+		addrs = append(addrs, l.Addr().String())
+	}
+	a := startServer(t, "s0", lis[0], addrs)
+	b := startServer(t, "s1", lis[1], addrs)
+	for i := 0; i < 20; i++ {
+		register(t, a, fmt.Sprintf("n%d", i), int32(1000+i))
+	}
+	waitFor(t, "nodes on b", func() bool { return b.nodes.Len() == 20 })
+
+	// 第三台错过了所有推送，靠对账拿到全量
+	c := startServer(t, "s2", lis[2], addrs)
+	waitFor(t, "nodes on c", func() bool { return c.nodes.Len() == 20 })
+	if port(c, "n7") != 1007 {
+		t.Fatalf("n7 port = %d", port(c, "n7"))
+	}
+}
+
+func TestClusterBan(t *testing.T) {
+	servers := startCluster(t, 3)
+	a, b, c := servers[0], servers[1], servers[2]
+
+	register(t, b, "n1", 1000)
+	waitFor(t, "n1 on a and c", func() bool {
+		return port(a, "n1") == 1000 && port(c, "n1") == 1000
+	})
+	// 在一台服务器上封禁，其他服务器也踢掉并拒绝注册
+	if kicked := a.nodes.BanName("n1"); kicked != 1 {
+		t.Fatalf("kicked %d", kicked)
+	}
+	waitFor(t, "n1 banned everywhere", func() bool {
+		return len(b.nodes.BannedNames()) == 1 && len(c.nodes.BannedNames()) == 1
+	})
+	if port(b, "n1") != 0 || port(c, "n1") != 0 {
+		t.Fatal("banned node still registered")
+	}
+	if err := c.nodes.Update(&pb.NodeInfo{Name: "n1"}, "1.2.3.4"); err == nil {
+		t.Fatal("banned name registered on another server")
+	}
+
+	c.nodes.UnbanName("n1")
+	waitFor(t, "n1 unbanned everywhere", func() bool {
+		return len(a.nodes.BannedNames()) == 0 && len(b.nodes.BannedNames()) == 0
+	})
+	register(t, b, "n1", 2000)
+	waitFor(t, "n1 registered again", func() bool {
+		return port(a, "n1") == 2000 && port(c, "n1") == 2000
+	})
+}
+
+func TestApplyBan(t *testing.T) {
+	m := logic.NewNodesMap()
+	ban := func(version int64, banned bool) logic.Record {
+		return logic.Record{Info: &pb.NodeInfo{Name: "n"}, Version: version, Origin: "a", Ban: true, Deleted: !banned}
+	}
+	// 别的服务器在收到封禁之前写入的注册版本更新，封禁到了也要踢掉
+	if !m.Apply(logic.Record{Info: &pb.NodeInfo{Name: "n"}, Version: 20, Origin: "b"}) {
+		t.Fatal("register should apply")
+	}
+	if !m.Apply(ban(10, true)) {
+		t.Fatal("ban should apply")
+	}
+	if _, ok := m.Get("n"); ok {
+		t.Fatal("banned node not kicked")
+	}
+	if m.Apply(logic.Record{Info: &pb.NodeInfo{Name: "n"}, Version: 30, Origin: "b"}) {
+		t.Fatal("register of banned name should be ignored")
+	}
+	if m.Apply(ban(5, false)) {
+		t.Fatal("older unban should be ignored")
+	}
+	if !m.Apply(ban(11, false)) || len(m.BannedNames()) != 0 {
+		t.Fatal("newer unban should apply")
+	}
+	// 解封记录也在对账的快照里
+	records, _ := m.Snapshot("", 10)
+	var bans int
+	for _, r := range records {
+		if r.Ban {
+			bans++
+		}
+	}
+	if bans != 1 {
+		t.Fatalf("%d ban records in snapshot", bans)
+	}
+}
+
+func TestApplyLastWriterWins(t *testing.T) {
+	m := logic.NewNodesMap()
+	info := func(port int32) *pb.NodeInfo {
+		return &pb.NodeInfo{Name: "n", UdpAddr: &pb.UDPAddr{Ip: "1.1.1.1", Port: port}}
+	}
+	if !m.Apply(logic.Record{Info: info(1), Version: 10, Origin: "a"}) {
+		t.Fatal("first record should apply")
+	}
+	if m.Apply(logic.Record{Info: info(2), Version: 9, Origin: "z"}) {
+		t.Fatal("older record should be ignored")
+	}
+	if !m.Apply(logic.Record{Info: info(3), Version: 10, Origin: "b"}) {
+		t.Fatal("same version with larger origin should win")
+	}
+	if m.Apply(logic.Record{Info: info(4), Version: 10, Origin: "b", Deleted: false}) {
+		t.Fatal("duplicate record should be ignored")
+	}
+	if !m.Apply(logic.Record{Info: &pb.NodeInfo{Name: "n"}, Version: 11, Origin: "a", Deleted: true}) {
+		t.Fatal("newer delete should apply")
+	}
+	if m.Apply(logic.Record{Info: info(5), Version: 10, Origin: "c"}) {
+		t.Fatal("record older than tombstone should be ignored")
+	}
+	if _, ok := m.Get("n"); ok {
+		t.Fatal("n should be deleted")
+	}
+	// 本地写入的版本必须比见过的版本大
+	if err := m.Update(info(6), "1.1.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := m.Get("n"); e.Info.GetUdpAddr().GetPort() != 6 {
+		t.Fatal("local update after delete should win")
+	}
+}
+
+func TestPullPages(t *testing.T) {
+	nodes := logic.NewNodesMap()
+	n := New(Config{ID: "s0", Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}, nodes)
+	defer n.Close()
+	s := &testServer{nodes: nodes}
+	for i := 0; i < 20; i++ {
+		register(t, s, fmt.Sprintf("n%02d", i), int32(1000+i))
+	}
+	nodes.Remove("n05")
+
+	var names []string
+	req := &pb.PullReq{PageSize: 7}
+	for pages := 1; ; pages++ {
+		resp, err := n.Pull(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.GetRecords()) > 7 {
+			t.Fatalf("page of %d records", len(resp.GetRecords()))
+		}
+		for _, r := range resp.GetRecords() {
+			names = append(names, r.GetNodeInfo().GetName())
+			if r.GetNodeInfo().GetName() == "n05" && !r.GetDeleted() {
+				t.Fatal("n05 should be a tombstone")
+			}
+		}
+		if resp.GetNextPageToken() == "" {
+			if pages != 3 {
+				t.Fatalf("pages = %d, want 3", pages)
+			}
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	if len(names) != 20 || !sort.StringsAreSorted(names) {
+		t.Fatalf("pulled %v", names)
+	}
+}
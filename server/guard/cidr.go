@@ -0,0 +1,49 @@
+package guard
+
+import (
+	"net"
+	"strings"
+)
+
+type CIDRList []*net.IPNet
+
+// ParseCIDRList 解析逗号分隔的 CIDR，单个 IP 当作 /32 或 /128
+func ParseCIDRList(s string) (CIDRList, error) {
+	var list CIDRList
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ipNet)
+	}
+	return list, nil
+}
+
+func (l CIDRList) Contains(ip net.IP) bool {
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l CIDRList) String() string {
+	var items []string
+	for _, n := range l {
+		items = append(items, n.String())
+	}
+	return strings.Join(items, ",")
+}
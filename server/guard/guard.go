@@ -0,0 +1,160 @@
+package guard
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	UdpRate  float64 // 每个源 IP 每秒 UDP 请求数，0 不限
+	UdpBurst int
+	RpcRate  float64 // 每个源 IP 每秒 RPC 请求数，0 不限
+	RpcBurst int
+	Allow    CIDRList // 非空时只允许列表内的地址
+	Deny     CIDRList
+}
+
+type Stats struct {
+	UdpRateDropped uint64
+	UdpAclDropped  uint64
+	RpcRateDropped uint64
+	RpcAclDropped  uint64
+}
+
+type Guard struct {
+	allow CIDRList
+	deny  CIDRList
+	udp   *Limiter
+	rpc   *Limiter
+
+	// 运行时通过管理接口封禁的地址
+	banMu sync.RWMutex
+	bans  CIDRList
+
+	udpRateDropped atomic.Uint64
+	udpAclDropped  atomic.Uint64
+	rpcRateDropped atomic.Uint64
+	rpcAclDropped  atomic.Uint64
+}
+
+func New(c Config) *Guard {
+	return &Guard{
+		allow: c.Allow,
+		deny:  c.Deny,
+		udp:   NewLimiter(c.UdpRate, c.UdpBurst),
+		rpc:   NewLimiter(c.RpcRate, c.RpcBurst),
+	}
+}
+
+func (g *Guard) Permitted(ip net.IP) bool {
+	if g.deny.Contains(ip) {
+		return false
+	}
+	g.banMu.RLock()
+	banned := g.bans.Contains(ip)
+	g.banMu.RUnlock()
+	if banned {
+		return false
+	}
+	return len(g.allow) == 0 || g.allow.Contains(ip)
+}
+
+func (g *Guard) Ban(ipNet *net.IPNet) {
+	g.banMu.Lock()
+	defer g.banMu.Unlock()
+	for _, n := range g.bans {
+		if n.String() == ipNet.String() {
+			return
+		}
+	}
+	g.bans = append(g.bans, ipNet)
+}
+
+func (g *Guard) Unban(ipNet *net.IPNet) bool {
+	g.banMu.Lock()
+	defer g.banMu.Unlock()
+	for i, n := range g.bans {
+		if n.String() == ipNet.String() {
+			g.bans = append(g.bans[:i:i], g.bans[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Guard) Bans() CIDRList {
+	g.banMu.RLock()
+	defer g.banMu.RUnlock()
+	return append(CIDRList(nil), g.bans...)
+}
+
+func (g *Guard) AllowUdp(ip net.IP) bool {
+	if !g.Permitted(ip) {
+		g.udpAclDropped.Add(1)
+		return false
+	}
+	if !g.udp.Allow(ip.String()) {
+		g.udpRateDropped.Add(1)
+		return false
+	}
+	return true
+}
+
+func (g *Guard) AllowRpc(ip net.IP) error {
+	if !g.Permitted(ip) {
+		g.rpcAclDropped.Add(1)
+		return status.Error(codes.PermissionDenied, "address not allowed")
+	}
+	if !g.rpc.Allow(ip.String()) {
+		g.rpcRateDropped.Add(1)
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return nil
+}
+
+func (g *Guard) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ip := PeerIP(ctx)
+		if ip == nil {
+			return nil, status.Error(codes.PermissionDenied, "unknown peer")
+		}
+		if err := g.AllowRpc(ip); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (g *Guard) Stats() Stats {
+	return Stats{
+		UdpRateDropped: g.udpRateDropped.Load(),
+		UdpAclDropped:  g.udpAclDropped.Load(),
+		RpcRateDropped: g.rpcRateDropped.Load(),
+		RpcAclDropped:  g.rpcAclDropped.Load(),
+	}
+}
+
+func PeerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	switch addr := p.Addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,73 @@
+package guard
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(10, 5)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("request %d should pass within burst", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Fatal("request beyond burst should be limited")
+	}
+	if !l.Allow("b") {
+		t.Fatal("other key should not be limited")
+	}
+
+	now = now.Add(200 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("refilled request %d should pass", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Fatal("only two tokens should be refilled")
+	}
+
+	now = now.Add(time.Minute)
+	l.Allow("c")
+	if l.Len() != 1 {
+		t.Fatalf("idle buckets should be swept, got %d", l.Len())
+	}
+}
+
+func TestGuardCIDR(t *testing.T) {
+	allow, err := ParseCIDRList("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, err := ParseCIDRList("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(Config{Allow: allow, Deny: deny})
+
+	cases := map[string]bool{
+		"10.2.3.4":    true,
+		"10.1.2.3":    false,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"8.8.8.8":     false,
+	}
+	for ip, want := range cases {
+		if got := g.AllowUdp(net.ParseIP(ip)); got != want {
+			t.Errorf("AllowUdp(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if st := g.Stats(); st.UdpAclDropped != 3 {
+		t.Errorf("UdpAclDropped = %d, want 3", st.UdpAclDropped)
+	}
+
+	if _, err := ParseCIDRList("10.0.0.0/33"); err == nil {
+		t.Error("invalid CIDR should fail")
+	}
+}
@@ -3,8 +3,8 @@ package logic
 import (
 	"fmt"
 	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
 	"google.golang.org/grpc/peer"
-	"log"
 )
 import "golang.org/x/net/context"
 
@@ -16,7 +16,7 @@ func GetExternalIpPort(ctx context.Context, in *pb.GetExternalIpPortReq) (*pb.Ge
 	}
 
 	// p.Addr是net.Addr类型，包含了IP地址和端口
-	log.Printf("Client IP address: %s\n", p.Addr.String())
+	public.LoggerFromContext(ctx).Debug("external address", "addr", p.Addr.String())
 	return &pb.GetExternalIpPortResp{
 		Addr:    p.Addr.String(),
 		Network: p.Addr.Network(),
@@ -0,0 +1,653 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultNetwork 是没有指定网络的节点所在的网络
+const DefaultNetwork = "default"
+
+// VirtualNet 是一个网络的虚拟地址段，IPv4 和 IPv6 至少有一个
+type VirtualNet struct {
+	Name string
+	IPv4 *net.IPNet
+	IPv6 *net.IPNet
+}
+
+// ParseVirtualNet 解析 [network=]cidr[,cidr]，网络名省略时是 default，
+// 比如 office=10.10.0.0/16,fd00:10::/64
+func ParseVirtualNet(s string) (VirtualNet, error) {
+	v := VirtualNet{Name: DefaultNetwork}
+	cidrs := s
+	if i := strings.Index(s, "="); i >= 0 {
+		v.Name, cidrs = s[:i], s[i+1:]
+		if v.Name == "" {
+			return VirtualNet{}, fmt.Errorf("invalid virtual network %q: empty name", s)
+		}
+	}
+	for _, c := range strings.Split(cidrs, ",") {
+		_, ipnet, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return VirtualNet{}, fmt.Errorf("invalid virtual network %q: %w", s, err)
+		}
+		slot := &v.IPv6
+		if ipnet.IP.To4() != nil {
+			slot = &v.IPv4
+		}
+		if *slot != nil {
+			return VirtualNet{}, fmt.Errorf("invalid virtual network %q: two cidrs of the same family", s)
+		}
+		if _, err := newAddrPool(ipnet); err != nil {
+			return VirtualNet{}, fmt.Errorf("invalid virtual network %q: %w", s, err)
+		}
+		*slot = ipnet
+	}
+	return v, nil
+}
+
+func (v VirtualNet) String() string {
+	var cidrs []string
+	for _, n := range []*net.IPNet{v.IPv4, v.IPv6} {
+		if n != nil {
+			cidrs = append(cidrs, n.String())
+		}
+	}
+	return v.Name + "=" + strings.Join(cidrs, ",")
+}
+
+func (v VirtualNet) proto() *pb.VirtualNetwork {
+	out := &pb.VirtualNetwork{Name: v.Name}
+	if v.IPv4 != nil {
+		out.Ipv4 = v.IPv4.String()
+	}
+	if v.IPv6 != nil {
+		out.Ipv6 = v.IPv6.String()
+	}
+	return out
+}
+
+// addrPool 在一个地址段里按节点名分配地址，回收之前重新注册都拿回同一个地址
+type addrPool struct {
+	net         *net.IPNet
+	first, last uint64 // 可分配的主机号范围，去掉网络地址和 IPv4 广播地址
+	next        uint64
+	byName      map[string]string
+	byIP        map[string]string
+}
+
+func newAddrPool(ipnet *net.IPNet) (*addrPool, error) {
+	ones, bits := ipnet.Mask.Size()
+	size := uint64(1) << 62
+	if bits-ones < 62 {
+		size = uint64(1) << (bits - ones)
+	}
+	last := size - 1
+	if bits == 8*net.IPv4len {
+		last--
+	}
+	if last < 2 {
+		return nil, fmt.Errorf("%s is too small", ipnet)
+	}
+	return &addrPool{
+		net:    ipnet,
+		first:  1,
+		last:   last,
+		next:   1,
+		byName: make(map[string]string),
+		byIP:   make(map[string]string),
+	}, nil
+}
+
+// host 返回网段里第 n 个地址
+func (p *addrPool) host(n uint64) net.IP {
+	ip := make(net.IP, len(p.net.IP))
+	copy(ip, p.net.IP)
+	for i := len(ip) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(ip[i]) + n&0xff
+		ip[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	return ip
+}
+
+// assign 返回 name 的地址，没有时从上次的位置往后找一个空闲的，地址用完时返回空
+func (p *addrPool) assign(name string) string {
+	if ip, ok := p.byName[name]; ok {
+		return ip
+	}
+	for i := p.first; i <= p.last; i++ {
+		n := p.next
+		if p.next++; p.next > p.last {
+			p.next = p.first
+		}
+		ip := p.host(n).String()
+		if _, used := p.byIP[ip]; !used {
+			p.set(name, ip)
+			return ip
+		}
+	}
+	return ""
+}
+
+// take 把指定的地址分给 name，替换它原来的地址
+func (p *addrPool) take(name string, ip net.IP) error {
+	if len(p.net.IP) == net.IPv4len {
+		ip = ip.To4()
+	}
+	if ip == nil || !p.net.Contains(ip) {
+		return fmt.Errorf("%s is outside %s", ip, p.net)
+	}
+	if ip.Equal(p.host(0)) || (len(ip) == net.IPv4len && ip.Equal(p.host(p.last+1))) {
+		return fmt.Errorf("%s is not a host address", ip)
+	}
+	s := ip.String()
+	if owner, used := p.byIP[s]; used && owner != name {
+		return fmt.Errorf("%s is assigned to %q", s, owner)
+	}
+	p.release(name)
+	p.set(name, s)
+	return nil
+}
+
+func (p *addrPool) set(name, ip string) {
+	p.byName[name] = ip
+	p.byIP[ip] = name
+}
+
+func (p *addrPool) release(name string) {
+	if ip, ok := p.byName[name]; ok {
+		delete(p.byIP, ip)
+		delete(p.byName, name)
+	}
+}
+
+// vnet 是一个网络的地址分配
+type vnet struct {
+	conf   VirtualNet
+	v4, v6 *addrPool
+	static map[string]bool
+	seen   map[string]time.Time // 节点最近一次注册的时间，用来回收长期不用的地址
+}
+
+func newVnet(conf VirtualNet) *vnet {
+	v := &vnet{conf: conf, static: make(map[string]bool), seen: make(map[string]time.Time)}
+	if conf.IPv4 != nil {
+		v.v4, _ = newAddrPool(conf.IPv4)
+	}
+	if conf.IPv6 != nil {
+		v.v6, _ = newAddrPool(conf.IPv6)
+	}
+	return v
+}
+
+func (v *vnet) pool(ip net.IP) *addrPool {
+	if ip.To4() != nil {
+		return v.v4
+	}
+	return v.v6
+}
+
+func (v *vnet) lookup(name string) (v4, v6 string) {
+	if v.v4 != nil {
+		v4 = v.v4.byName[name]
+	}
+	if v.v6 != nil {
+		v6 = v.v6.byName[name]
+	}
+	return v4, v6
+}
+
+// names 返回有地址的节点
+func (v *vnet) names() map[string]bool {
+	names := make(map[string]bool)
+	for _, pool := range []*addrPool{v.v4, v.v6} {
+		if pool != nil {
+			for name := range pool.byName {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// release 释放 name 的地址和预留
+func (v *vnet) release(name string) {
+	for _, pool := range []*addrPool{v.v4, v.v6} {
+		if pool != nil {
+			pool.release(name)
+		}
+	}
+	delete(v.static, name)
+	delete(v.seen, name)
+}
+
+// wins 决定 a 和 b 争同一个地址时 a 是否胜出：固定分配优先，其次名字小的，
+// 各副本按同样的规则判断，结果一致
+func (v *vnet) wins(a, b string) bool {
+	if v.static[a] != v.static[b] {
+		return v.static[a]
+	}
+	return a < b
+}
+
+func (v *vnet) address(name string) *pb.VirtualAddress {
+	v4, v6 := v.lookup(name)
+	return &pb.VirtualAddress{Network: v.conf.Name, Name: name, Ipv4: v4, Ipv6: v6, Static: v.static[name]}
+}
+
+// addrState 是保存到文件的分配结果，网络名 -> 节点名 -> 地址
+type addrState struct {
+	Networks map[string]map[string]savedAddr `json:"networks"`
+}
+
+type savedAddr struct {
+	IPv4   string `json:"ipv4,omitempty"`
+	IPv6   string `json:"ipv6,omitempty"`
+	Static bool   `json:"static,omitempty"`
+}
+
+// SetVirtualNets 设置各个网络的虚拟地址段，path 不为空时先从文件恢复之前的分配，
+// 之后每次分配变化都写回文件。文件里不在新地址段内的分配会被丢掉，
+// 恢复的分配从现在开始计算空闲时间
+func (m *NodesMap) SetVirtualNets(nets []VirtualNet, path string) error {
+	vnets := make(map[string]*vnet)
+	for _, conf := range nets {
+		if _, ok := vnets[conf.Name]; ok {
+			return fmt.Errorf("duplicate virtual network %q", conf.Name)
+		}
+		vnets[conf.Name] = newVnet(conf)
+	}
+	if path != "" {
+		if err := loadAddresses(vnets, path); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.vnets, m.addrFile = vnets, path
+	for _, e := range m.nodes {
+		m.setVirtualIP(e.info)
+	}
+	m.addrDirty = true
+	m.mu.Unlock()
+	return m.saveAddresses()
+}
+
+// SetAddressIdle 设置地址的空闲回收时间：不是固定分配、节点不在线、
+// 并且超过 idle 没有注册的地址可以回收，0 表示一直保留
+func (m *NodesMap) SetAddressIdle(idle time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addrIdle = idle
+}
+
+// ReleaseIdleAddresses 回收所有网络里空闲超时的地址，返回回收个数
+func (m *NodesMap) ReleaseIdleAddresses() int {
+	m.mu.Lock()
+	n := 0
+	for _, v := range m.vnets {
+		n += m.releaseIdle(v, time.Now())
+	}
+	m.mu.Unlock()
+	if n > 0 {
+		if err := m.saveAddresses(); err != nil {
+			slog.Warn("save virtual addresses failed", "err", err)
+		}
+	}
+	return n
+}
+
+// releaseIdle 需要持有锁，回收 v 里空闲超时的地址
+func (m *NodesMap) releaseIdle(v *vnet, now time.Time) int {
+	if m.addrIdle <= 0 {
+		return 0
+	}
+	n := 0
+	for name := range v.names() {
+		if v.static[name] || now.Sub(v.seen[name]) < m.addrIdle {
+			continue
+		}
+		if e, ok := m.nodes[name]; ok && e.info.GetNetwork() == v.conf.Name {
+			continue
+		}
+		v4, v6 := v.lookup(name)
+		slog.Info("release idle virtual address", "network", v.conf.Name, "node", name, "ipv4", v4, "ipv6", v6)
+		v.release(name)
+		n++
+	}
+	if n > 0 {
+		m.addrDirty = true
+	}
+	return n
+}
+
+// VirtualNets 返回按名字排序的网络配置，用于告诉客户端
+func (m *NodesMap) VirtualNets() []*pb.VirtualNetwork {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*pb.VirtualNetwork
+	for _, v := range m.vnets {
+		out = append(out, v.conf.proto())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out
+}
+
+func loadAddresses(vnets map[string]*vnet, path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state addrState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for network, addrs := range state.Networks {
+		v, ok := vnets[network]
+		if !ok {
+			slog.Warn("drop addresses of unknown virtual network", "network", network, "count", len(addrs))
+			continue
+		}
+		for name, a := range addrs {
+			for _, s := range []string{a.IPv4, a.IPv6} {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					continue
+				}
+				if pool := v.pool(ip); pool == nil || pool.take(name, ip) != nil {
+					slog.Warn("drop saved virtual address", "network", network, "node", name, "ip", s)
+				}
+			}
+			if a.Static {
+				v.static[name] = true
+			}
+			v.seen[name] = time.Now()
+		}
+	}
+	return nil
+}
+
+// addrState 需要持有锁，返回要保存的分配结果
+func (m *NodesMap) addrState() addrState {
+	state := addrState{Networks: make(map[string]map[string]savedAddr)}
+	for network, v := range m.vnets {
+		addrs := make(map[string]savedAddr)
+		for name := range v.names() {
+			v4, v6 := v.lookup(name)
+			addrs[name] = savedAddr{IPv4: v4, IPv6: v6, Static: v.static[name]}
+		}
+		state.Networks[network] = addrs
+	}
+	return state
+}
+
+// saveAddresses 在分配有变化时写文件，调用时不能持有锁：在锁里取快照，
+// 释放锁之后再写，saveMu 保证快照按顺序写入
+func (m *NodesMap) saveAddresses() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	m.mu.Lock()
+	if !m.addrDirty || m.addrFile == "" {
+		m.mu.Unlock()
+		return nil
+	}
+	path, state := m.addrFile, m.addrState()
+	m.addrDirty = false
+	m.mu.Unlock()
+
+	if err := writeAddresses(path, state); err != nil {
+		// 下次有变化时重写
+		m.mu.Lock()
+		m.addrDirty = true
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// logSaveAddresses 用于注册和复制这些不能因为写文件失败而失败的地方
+func (m *NodesMap) logSaveAddresses() {
+	if err := m.saveAddresses(); err != nil {
+		slog.Warn("save virtual addresses failed", "err", err)
+	}
+}
+
+// writeAddresses 先写临时文件再改名，避免写一半时崩溃丢掉所有分配
+func writeAddresses(path string, state addrState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// setVirtualIP 需要持有锁，用服务器分配的地址覆盖节点自己填的，没有网络的节点不分配
+func (m *NodesMap) setVirtualIP(node *pb.NodeInfo) {
+	if node.GetNetwork() == "" {
+		node.Network = DefaultNetwork
+	}
+	node.VirtualIp, node.VirtualIp6 = "", ""
+	v, ok := m.vnets[node.GetNetwork()]
+	if !ok {
+		return
+	}
+	name := node.GetName()
+	v.seen[name] = time.Now()
+	old4, old6 := v.lookup(name)
+	node.VirtualIp = m.assign(v, v.v4, name)
+	node.VirtualIp6 = m.assign(v, v.v6, name)
+	if node.VirtualIp != old4 || node.VirtualIp6 != old6 {
+		m.addrDirty = true
+	}
+}
+
+// assign 需要持有锁，从 pool 给 name 分配地址，用完时先回收空闲的地址再试一次
+func (m *NodesMap) assign(v *vnet, pool *addrPool, name string) string {
+	if pool == nil {
+		return ""
+	}
+	ip := pool.assign(name)
+	if ip == "" && m.releaseIdle(v, time.Now()) > 0 {
+		ip = pool.assign(name)
+	}
+	if ip == "" {
+		slog.Warn("virtual address pool exhausted", "network", v.conf.Name, "cidr", pool.net.String(), "node", name)
+	}
+	return ip
+}
+
+// adoptVirtualIP 需要持有锁，记下其他服务器分配的地址。两台服务器把同一个地址
+// 分给了不同节点时按 vnet.wins 决定归属：复制来的节点输了就去掉这个地址，
+// 本地的节点输了就换一个地址，本服务器注册的节点还要把新地址同步出去
+func (m *NodesMap) adoptVirtualIP(node *pb.NodeInfo) {
+	network := node.GetNetwork()
+	if network == "" {
+		network = DefaultNetwork
+	}
+	v, ok := m.vnets[network]
+	if !ok {
+		return
+	}
+	name := node.GetName()
+	v.seen[name] = time.Now()
+	var losers []string
+	for _, field := range []*string{&node.VirtualIp, &node.VirtualIp6} {
+		ip := net.ParseIP(*field)
+		if ip == nil {
+			continue
+		}
+		pool := v.pool(ip)
+		if pool == nil || pool.byName[name] == *field {
+			continue
+		}
+		if owner, used := pool.byIP[*field]; used && owner != name {
+			if !v.wins(name, owner) {
+				slog.Warn("drop conflicting virtual address", "network", network, "node", name, "ip", *field, "owner", owner)
+				*field = ""
+				continue
+			}
+			slog.Warn("virtual address taken over", "network", network, "node", name, "ip", *field, "owner", owner)
+			pool.release(owner)
+			losers = append(losers, owner)
+		}
+		if err := pool.take(name, ip); err != nil {
+			slog.Warn("drop replicated virtual address", "network", network, "node", name, "err", err)
+			*field = ""
+			continue
+		}
+		m.addrDirty = true
+	}
+	for _, owner := range losers {
+		e, ok := m.nodes[owner]
+		if !ok || e.info.GetNetwork() != network {
+			continue
+		}
+		if e.origin == m.origin {
+			m.readdress(v, owner)
+			continue
+		}
+		// 别的服务器注册的节点由那台服务器重新分配，这里先去掉被拿走的地址
+		e.info.VirtualIp, e.info.VirtualIp6 = v.lookup(owner)
+	}
+}
+
+func (m *NodesMap) lookupNet(network string) (*vnet, error) {
+	if network == "" {
+		network = DefaultNetwork
+	}
+	v, ok := m.vnets[network]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "virtual network %q not found", network)
+	}
+	return v, nil
+}
+
+// Addresses 返回 network 里所有分配的地址，network 为空时返回所有网络的
+func (m *NodesMap) Addresses(network string) ([]*pb.VirtualAddress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var nets []*vnet
+	if network == "" {
+		for _, v := range m.vnets {
+			nets = append(nets, v)
+		}
+	} else {
+		v, err := m.lookupNet(network)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, v)
+	}
+	var out []*pb.VirtualAddress
+	for _, v := range nets {
+		for name := range v.names() {
+			out = append(out, v.address(name))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].GetNetwork() != out[j].GetNetwork() {
+			return out[i].GetNetwork() < out[j].GetNetwork()
+		}
+		return out[i].GetName() < out[j].GetName()
+	})
+	return out, nil
+}
+
+// Reserve 把 ip 固定分给 name，在线的节点马上改用这个地址。
+// 预留只保存在本服务器，集群里要在每台服务器上做同样的预留
+func (m *NodesMap) Reserve(network, name string, ip net.IP) (*pb.VirtualAddress, error) {
+	defer m.flush()
+	addr, err := m.reserve(network, name, ip)
+	if err != nil {
+		return nil, err
+	}
+	return addr, m.saveAddresses()
+}
+
+func (m *NodesMap) reserve(network, name string, ip net.IP) (*pb.VirtualAddress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookupNet(network)
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid ip")
+	}
+	pool := v.pool(ip)
+	if pool == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "virtual network %q has no cidr for %s", v.conf.Name, ip)
+	}
+	if err := pool.take(name, ip); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	v.static[name] = true
+	m.addrDirty = true
+	m.readdress(v, name)
+	return v.address(name), nil
+}
+
+// Release 释放 name 的地址和预留，在线的节点马上换一个新地址
+func (m *NodesMap) Release(network, name string) error {
+	defer m.flush()
+	if err := m.releaseAddress(network, name); err != nil {
+		return err
+	}
+	return m.saveAddresses()
+}
+
+func (m *NodesMap) releaseAddress(network, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookupNet(network)
+	if err != nil {
+		return err
+	}
+	v4, v6 := v.lookup(name)
+	if v4 == "" && v6 == "" {
+		return status.Errorf(codes.NotFound, "%q has no address in %q", name, v.conf.Name)
+	}
+	v.release(name)
+	m.addrDirty = true
+	m.readdress(v, name)
+	return nil
+}
+
+// readdress 需要持有锁，分配变化后更新在线节点的地址并同步给集群
+func (m *NodesMap) readdress(v *vnet, name string) {
+	e, ok := m.nodes[name]
+	if !ok || e.info.GetNetwork() != v.conf.Name {
+		return
+	}
+	m.setVirtualIP(e.info)
+	e.version, e.origin = m.tick(), m.origin
+	m.changed(name)
+}
@@ -0,0 +1,235 @@
+package logic
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+func mustVnets(t *testing.T, specs ...string) []VirtualNet {
+	t.Helper()
+	var out []VirtualNet
+	for _, s := range specs {
+		v, err := ParseVirtualNet(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+func register(t *testing.T, m *NodesMap, name, network string) *pb.NodeInfo {
+	t.Helper()
+	if err := m.Update(&pb.NodeInfo{Name: name, Network: network, VirtualIp: "10.9.0.6"}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := m.Get(name)
+	return e.Info
+}
+
+func TestParseVirtualNet(t *testing.T) {
+	for _, s := range []string{"", "=10.0.0.0/8", "10.0.0.0/31", "10.0.0.0/8,10.1.0.0/16", "x=fd00::/127", "bad"} {
+		if _, err := ParseVirtualNet(s); err == nil {
+			t.Errorf("ParseVirtualNet(%q) accepted", s)
+		}
+	}
+	v, err := ParseVirtualNet("office=10.10.0.0/16, fd00:10::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.String(); got != "office=10.10.0.0/16,fd00:10::/64" {
+		t.Fatalf("String() = %s", got)
+	}
+}
+
+func TestVirtualIP(t *testing.T) {
+	m := NewNodesMap()
+	if err := m.SetVirtualNets(mustVnets(t, "10.9.0.0/29,fd00:9::/125", "office=10.10.0.0/24"), ""); err != nil {
+		t.Fatal(err)
+	}
+	a := register(t, m, "a", "")
+	if a.GetNetwork() != DefaultNetwork || a.GetVirtualIp() != "10.9.0.1" || a.GetVirtualIp6() != "fd00:9::1" {
+		t.Fatalf("a = %v", a)
+	}
+	if b := register(t, m, "b", "office"); b.GetVirtualIp() != "10.10.0.1" || b.GetVirtualIp6() != "" {
+		t.Fatalf("b = %v", b)
+	}
+	if c := register(t, m, "c", "unknown"); c.GetVirtualIp() != "" {
+		t.Fatalf("node in unknown network got %s", c.GetVirtualIp())
+	}
+	// 下线再注册拿回原来的地址
+	m.Remove("a")
+	if got := register(t, m, "a", ""); got.GetVirtualIp() != "10.9.0.1" {
+		t.Fatalf("a re-registered with %s", got.GetVirtualIp())
+	}
+	// 其他服务器分配的地址被记下，不会再分给别人
+	m.Apply(Record{Info: &pb.NodeInfo{Name: "r", Network: DefaultNetwork, VirtualIp: "10.9.0.2"}, Version: m.clock + 1, Origin: "other"})
+	if got := register(t, m, "d", ""); got.GetVirtualIp() != "10.9.0.3" {
+		t.Fatalf("d = %s, want 10.9.0.3", got.GetVirtualIp())
+	}
+	// /29 只有 6 个地址，用完以后不再分配
+	for _, name := range []string{"e", "f", "g"} {
+		register(t, m, name, "")
+	}
+	if got := register(t, m, "h", ""); got.GetVirtualIp() != "" || got.GetVirtualIp6() != "fd00:9::6" {
+		t.Fatalf("h = %v", got)
+	}
+}
+
+func TestReserveAddress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vnet.json")
+	vnets := mustVnets(t, "10.9.0.0/24,fd00:9::/64")
+	m := NewNodesMap()
+	if err := m.SetVirtualNets(vnets, path); err != nil {
+		t.Fatal(err)
+	}
+	register(t, m, "a", "")
+	register(t, m, "b", "")
+
+	if _, err := m.Reserve("", "a", net.ParseIP("10.9.0.2")); err == nil {
+		t.Fatal("reserved an address in use")
+	}
+	for _, ip := range []string{"10.9.0.0", "10.9.0.255", "10.8.0.1"} {
+		if _, err := m.Reserve("", "a", net.ParseIP(ip)); err == nil {
+			t.Fatalf("reserved %s", ip)
+		}
+	}
+	if _, err := m.Reserve("lab", "a", net.ParseIP("10.9.0.9")); err == nil {
+		t.Fatal("reserved in unknown network")
+	}
+	// 预留马上生效，旧地址可以分给别人
+	addr, err := m.Reserve("", "a", net.ParseIP("10.9.0.100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !addr.GetStatic() || addr.GetIpv4() != "10.9.0.100" || addr.GetIpv6() != "fd00:9::1" {
+		t.Fatalf("reserved %v", addr)
+	}
+	if e, _ := m.Get("a"); e.Info.GetVirtualIp() != "10.9.0.100" {
+		t.Fatalf("online node still uses %s", e.Info.GetVirtualIp())
+	}
+	if _, err := m.Reserve("", "offline", net.ParseIP("fd00:9::100")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后从文件恢复
+	m2 := NewNodesMap()
+	if err := m2.SetVirtualNets(vnets, path); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := m2.Addresses("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*pb.VirtualAddress{
+		{Network: DefaultNetwork, Name: "a", Ipv4: "10.9.0.100", Ipv6: "fd00:9::1", Static: true},
+		{Network: DefaultNetwork, Name: "b", Ipv4: "10.9.0.2", Ipv6: "fd00:9::2"},
+		{Network: DefaultNetwork, Name: "offline", Ipv6: "fd00:9::100", Static: true},
+	}
+	if len(addrs) != len(want) {
+		t.Fatalf("Addresses = %v", addrs)
+	}
+	for i := range want {
+		if addrs[i].String() != want[i].String() {
+			t.Fatalf("Addresses[%d] = %v, want %v", i, addrs[i], want[i])
+		}
+	}
+	if got := register(t, m2, "offline", ""); got.GetVirtualIp6() != "fd00:9::100" {
+		t.Fatalf("offline got %s", got.GetVirtualIp6())
+	}
+
+	// 释放后在线节点换新地址
+	if err := m2.Release("", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.Release("", "a"); err == nil {
+		t.Fatal("released twice")
+	}
+	register(t, m2, "a", "")
+	addrs, _ = m2.Addresses(DefaultNetwork)
+	if addrs[0].GetName() != "a" || addrs[0].GetStatic() || addrs[0].GetIpv4() == "10.9.0.100" {
+		t.Fatalf("a after release = %v", addrs[0])
+	}
+}
+
+func TestReleaseIdleAddress(t *testing.T) {
+	m := NewNodesMap()
+	if err := m.SetVirtualNets(mustVnets(t, "10.9.0.0/29"), ""); err != nil {
+		t.Fatal(err)
+	}
+	m.SetAddressIdle(time.Hour)
+	if _, err := m.Reserve("", "s", net.ParseIP("10.9.0.6")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		register(t, m, name, "")
+	}
+	m.Remove("a")
+	backdate := func() {
+		m.mu.Lock()
+		v := m.vnets[DefaultNetwork]
+		for name := range v.seen {
+			v.seen[name] = time.Now().Add(-2 * time.Hour)
+		}
+		m.mu.Unlock()
+	}
+	backdate()
+	// 地址用完时回收离线的 a，在线节点和固定分配不动
+	if got := register(t, m, "f", ""); got.GetVirtualIp() != "10.9.0.1" {
+		t.Fatalf("f = %s, want a's old 10.9.0.1", got.GetVirtualIp())
+	}
+	if got := register(t, m, "g", ""); got.GetVirtualIp() != "" {
+		t.Fatalf("g = %s in an exhausted pool", got.GetVirtualIp())
+	}
+	addrs, _ := m.Addresses("")
+	if len(addrs) != 6 || addrs[len(addrs)-1].GetName() != "s" || !addrs[len(addrs)-1].GetStatic() {
+		t.Fatalf("Addresses = %v", addrs)
+	}
+
+	register(t, m, "b", "")
+	m.Remove("b")
+	if n := m.ReleaseIdleAddresses(); n != 0 {
+		t.Fatalf("released %d addresses of recently seen nodes", n)
+	}
+	backdate()
+	if n := m.ReleaseIdleAddresses(); n != 1 {
+		t.Fatalf("released %d, want only b", n)
+	}
+}
+
+func TestAdoptConflict(t *testing.T) {
+	// 两台服务器同时把 10.9.0.1 分给了不同的节点，复制之后名字小的 a 保留这个地址
+	var out1, out2 []Record
+	m1, m2 := NewNodesMap(), NewNodesMap()
+	for _, m := range []*NodesMap{m1, m2} {
+		if err := m.SetVirtualNets(mustVnets(t, "10.9.0.0/24"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m1.SetReplicator("s1", func(r Record) { out1 = append(out1, r) })
+	m2.SetReplicator("s2", func(r Record) { out2 = append(out2, r) })
+	register(t, m1, "b", "")
+	register(t, m2, "a", "")
+
+	m1.Apply(out2[0])
+	if len(out1) != 2 {
+		t.Fatalf("s1 did not replicate b's new address: %v", out1)
+	}
+	// 先到的旧记录里冲突的地址被去掉，不会存下两个节点同一个地址
+	m2.Apply(out1[0])
+	if e, _ := m2.Get("b"); e.Info.GetVirtualIp() != "" {
+		t.Fatalf("s2 stored conflicting %s for b", e.Info.GetVirtualIp())
+	}
+	m2.Apply(out1[1])
+	for _, m := range []*NodesMap{m1, m2} {
+		a, _ := m.Get("a")
+		b, _ := m.Get("b")
+		if a.Info.GetVirtualIp() != "10.9.0.1" || b.Info.GetVirtualIp() != "10.9.0.2" {
+			t.Fatalf("a = %s, b = %s", a.Info.GetVirtualIp(), b.Info.GetVirtualIp())
+		}
+	}
+}
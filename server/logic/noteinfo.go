@@ -1,40 +1,224 @@
 package logic
 
 import (
+	"github.com/golang/protobuf/proto"
 	pb "github.com/jinyunx/p2p/proto"
-	"log"
+	"github.com/jinyunx/p2p/public"
+	"github.com/jinyunx/p2p/server/guard"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 import "golang.org/x/net/context"
 
+type Limits struct {
+	MaxNodes      int // 注册节点总数上限，0 不限
+	MaxNodesPerIP int // 单个源 IP 可注册的节点数上限，0 不限
+}
+
+type nodeEntry struct {
+	info    *pb.NodeInfo
+	ip      string // 注册时的源 IP
+	updated time.Time
+	version int64  // 集群内按 (version, origin) 后写者胜
+	origin  string // 最后写入这条记录的服务器
+}
+
 type NodesMap struct {
-	mu    sync.Mutex
-	nodes map[string]pb.NodeInfo
+	mu          sync.Mutex
+	nodes       map[string]*nodeEntry
+	perIP       map[string]int
+	limits      Limits
+	bannedNames map[string]nameBan // 见 replica.go
+	draining    bool
+	rejected    atomic.Uint64
+	vnets       map[string]*vnet // 各网络的虚拟地址分配，见 ipam.go
+	addrFile    string
+	addrIdle    time.Duration
+	addrDirty   bool       // 分配有变化还没写文件
+	saveMu      sync.Mutex // 按顺序写地址文件，写文件时不持有 mu
+
+	// 主端口最近收到过包的源地址，见 relay.go
+	seenMu    sync.Mutex
+	seen      map[string]time.Time
+	seenSwept time.Time
+
+	// 集群复制相关，见 replica.go
+	origin     string
+	clock      int64
+	tombstones map[string]tombstone
+	onChange   func(Record)
+	pending    []Record
+}
+
+func NewNodesMap() *NodesMap {
+	return &NodesMap{
+		nodes:       make(map[string]*nodeEntry),
+		perIP:       make(map[string]int),
+		bannedNames: make(map[string]nameBan),
+		tombstones:  make(map[string]tombstone),
+		seen:        make(map[string]time.Time),
+	}
 }
 
-var nodeInfo = NodesMap{nodes: make(map[string]pb.NodeInfo)}
+var nodeInfo = NewNodesMap()
 
-func UpdateNode(ctx context.Context, in *pb.UpdateNodeReq) (*pb.UpdateNodeResp, error) {
-	log.Println("UpdateNode req", in)
+// Registry 返回服务使用的节点表
+func Registry() *NodesMap {
+	return nodeInfo
+}
+
+func SetLimits(l Limits) {
 	nodeInfo.mu.Lock()
-	nodeInfo.nodes[in.GetNodeInfo().GetName()] = *in.GetNodeInfo()
+	nodeInfo.limits = l
 	nodeInfo.mu.Unlock()
-	log.Println(nodeInfo.nodes)
-	return &pb.UpdateNodeResp{}, nil
 }
 
-func GetNodeInfo(ctx context.Context, in *pb.GetNodeInfoReq) (*pb.GetNodeInfoResp, error) {
-	log.Println("GetNodeInfo req", in)
-	var out = &pb.GetNodeInfoResp{}
+// Rejected 返回因超过注册上限被拒绝的次数
+func Rejected() uint64 {
+	return nodeInfo.rejected.Load()
+}
+
+func NodeCount() int {
 	nodeInfo.mu.Lock()
-	nodesMap := nodeInfo.nodes
-	nodeInfo.mu.Unlock()
+	defer nodeInfo.mu.Unlock()
+	return len(nodeInfo.nodes)
+}
+
+func (m *NodesMap) Update(node *pb.NodeInfo, ip string) error {
+	defer m.logSaveAddresses()
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := node.GetName()
+	if m.bannedNames[name].banned {
+		m.rejected.Add(1)
+		return status.Error(codes.PermissionDenied, "node name is banned")
+	}
+	// 注册的 UDP 地址要和发起注册的源 IP 一致，否则可以让服务器把中继包打到任意地址
+	if a := node.GetUdpAddr(); a != nil && ip != "" && !net.ParseIP(a.GetIp()).Equal(net.ParseIP(ip)) {
+		m.rejected.Add(1)
+		return status.Error(codes.InvalidArgument, "udp address does not match the source address")
+	}
+	old, exist := m.nodes[name]
+	// 名字归先注册的 IP 所有，记录过期之前别的 IP 不能抢注
+	if exist && ip != "" && old.ip != ip && m.live(old) {
+		m.rejected.Add(1)
+		return status.Error(codes.AlreadyExists, "node name is registered from another address")
+	}
+	// 下线前只保留已有节点，新节点去别的服务器注册
+	if !exist && m.draining {
+		m.rejected.Add(1)
+		return status.Error(codes.Unavailable, "server is draining")
+	}
+	if !exist && m.limits.MaxNodes > 0 && len(m.nodes) >= m.limits.MaxNodes {
+		m.rejected.Add(1)
+		return status.Error(codes.ResourceExhausted, "too many registered nodes")
+	}
+	// 同一个节点换了出口 IP 也算新 IP 的名额
+	if (!exist || old.ip != ip) && m.limits.MaxNodesPerIP > 0 && m.perIP[ip] >= m.limits.MaxNodesPerIP {
+		m.rejected.Add(1)
+		return status.Error(codes.ResourceExhausted, "too many nodes registered from this address")
+	}
+
+	m.setVirtualIP(node)
+	m.put(&nodeEntry{info: node, ip: ip, updated: time.Now(), version: m.tick(), origin: m.origin})
+	m.changed(name)
+	return nil
+}
 
-	for k, _ := range nodesMap {
-		out.NodeInfo = append(out.NodeInfo, &pb.NodeInfo{
-			Name:    nodesMap[k].Name,
-			UdpAddr: nodesMap[k].UdpAddr,
-		})
+// live 判断记录是否还在用：udpSeenTTL 内注册过，或者本服务器收到过它注册地址发来的保活包。
+// 节点只在地址变化时重新注册，保活包才是它还在线的依据
+func (m *NodesMap) live(e *nodeEntry) bool {
+	if time.Since(e.updated) <= udpSeenTTL {
+		return true
 	}
-	return out, nil
+	a := udpAddr(e.info.GetUdpAddr())
+	return a != nil && m.sawRecently(a)
+}
+
+func (m *NodesMap) put(e *nodeEntry) {
+	name := e.info.GetName()
+	if old, ok := m.nodes[name]; ok {
+		m.release(old.ip)
+	}
+	delete(m.tombstones, name)
+	m.nodes[name] = e
+	m.perIP[e.ip]++
+}
+
+func (m *NodesMap) release(ip string) {
+	m.perIP[ip]--
+	if m.perIP[ip] <= 0 {
+		delete(m.perIP, ip)
+	}
+}
+
+func (m *NodesMap) List() []*pb.NodeInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*pb.NodeInfo
+	for _, e := range m.nodes {
+		out = append(out, proto.Clone(e.info).(*pb.NodeInfo))
+	}
+	return out
+}
+
+func (m *NodesMap) NatTypeCounts() map[pb.NatType]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[pb.NatType]int)
+	for _, e := range m.nodes {
+		counts[e.info.GetNatType()]++
+	}
+	return counts
+}
+
+func NatTypeCounts() map[pb.NatType]int {
+	return nodeInfo.NatTypeCounts()
+}
+
+func UpdateNode(ctx context.Context, in *pb.UpdateNodeReq) (*pb.UpdateNodeResp, error) {
+	node := in.GetNodeInfo()
+	logger := public.LoggerFromContext(ctx).With("node", node.GetName())
+	if node.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty node name")
+	}
+	var ip string
+	if peerIP := guard.PeerIP(ctx); peerIP != nil {
+		ip = peerIP.String()
+	}
+	if err := nodeInfo.Update(node, ip); err != nil {
+		logger.Warn("register rejected", "err", err)
+		return nil, err
+	}
+	logger.Info("node registered",
+		"udp_addr", net.JoinHostPort(node.GetUdpAddr().GetIp(), strconv.Itoa(int(node.GetUdpAddr().GetPort()))),
+		"tcp_addr", net.JoinHostPort(node.GetTcpAddr().GetIp(), strconv.Itoa(int(node.GetTcpAddr().GetPort()))),
+		"nat_type", node.GetNatType().String())
+	return &pb.UpdateNodeResp{}, nil
+}
+
+// GetNodeInfo 在配置了策略时要求调用方声明节点名，只返回它能连的节点
+func GetNodeInfo(ctx context.Context, in *pb.GetNodeInfoReq) (*pb.GetNodeInfoResp, error) {
+	p, _ := policies.get()
+	if p != nil {
+		if err := checkCaller(ctx, in.GetName()); err != nil {
+			return nil, err
+		}
+	}
+	nodes := visible(p, in.GetName(), nodeInfo.List())
+	public.LoggerFromContext(ctx).Debug("node lookup", "nodes", len(nodes))
+	return &pb.GetNodeInfoResp{NodeInfo: nodes}, nil
+}
+
+func ReportPunch(ctx context.Context, in *pb.ReportPunchReq) (*pb.ReportPunchResp, error) {
+	public.LoggerFromContext(ctx).Info("punch result", "node", in.GetName(), "target", in.GetPeer(),
+		"success", in.GetSuccess(), "elapsed_ms", in.GetElapsedMs())
+	return &pb.ReportPunchResp{}, nil
 }
@@ -0,0 +1,59 @@
+package logic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUpdateUdpAddr(t *testing.T) {
+	m := NewNodesMap()
+	// UDP 地址不是注册请求的源 IP 时拒绝，否则中继可以打到任意地址
+	addr := &pb.UDPAddr{Ip: "203.0.113.9", Port: 40001}
+	if err := m.Update(&pb.NodeInfo{Name: "a", UdpAddr: addr}, "192.0.2.1"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("mismatched udp address: %v", err)
+	}
+	if err := m.Update(&pb.NodeInfo{Name: "a", UdpAddr: addr}, "203.0.113.9"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateNameBinding(t *testing.T) {
+	m := NewNodesMap()
+	addr := &pb.UDPAddr{Ip: "192.0.2.1", Port: 40001}
+	if err := m.Update(&pb.NodeInfo{Name: "a", UdpAddr: addr}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	other := &pb.UDPAddr{Ip: "198.51.100.1", Port: 40001}
+	if err := m.Update(&pb.NodeInfo{Name: "a", UdpAddr: other}, "198.51.100.1"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("takeover from another address: %v", err)
+	}
+	// 原来的 IP 可以续期
+	if err := m.Update(&pb.NodeInfo{Name: "a", UdpAddr: addr}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 注册已经过期，但还在收到保活包时仍然是原来的节点
+	m.mu.Lock()
+	m.nodes["a"].updated = time.Now().Add(-2 * udpSeenTTL)
+	m.mu.Unlock()
+	m.SawUDP(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40001})
+	if err := m.Update(&pb.NodeInfo{Name: "a", UdpAddr: other}, "198.51.100.1"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("takeover of a node sending keepalives: %v", err)
+	}
+
+	// 保活也停了才能换 IP 注册，比如节点换了网络
+	m.seenMu.Lock()
+	m.seen = map[string]time.Time{}
+	m.seenMu.Unlock()
+	if err := m.Update(&pb.NodeInfo{Name: "a", UdpAddr: other}, "198.51.100.1"); err != nil {
+		t.Fatalf("register after expiry: %v", err)
+	}
+	if e, _ := m.Get("a"); e.IP != "198.51.100.1" {
+		t.Fatalf("ip = %s", e.IP)
+	}
+}
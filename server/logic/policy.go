@@ -0,0 +1,113 @@
+package logic
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"github.com/jinyunx/p2p/server/guard"
+	"github.com/jinyunx/p2p/server/policy"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// policyHolder 保存当前的访问策略，策略变化时唤醒等待的 GetPolicy
+type policyHolder struct {
+	mu      sync.Mutex
+	p       *policy.Policy // 为 nil 表示没有配置策略，全部允许
+	changed chan struct{}
+}
+
+var policies = &policyHolder{changed: make(chan struct{})}
+
+// SetPolicy 替换访问策略，p 为 nil 时取消限制
+func SetPolicy(p *policy.Policy) {
+	policies.mu.Lock()
+	policies.p = p
+	close(policies.changed)
+	policies.changed = make(chan struct{})
+	policies.mu.Unlock()
+}
+
+func (h *policyHolder) get() (*policy.Policy, chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.p, h.changed
+}
+
+// noPolicy 是没有配置策略时的版本号，客户端带着它也能长轮询
+const noPolicy = "none"
+
+func version(p *policy.Policy) string {
+	if p == nil {
+		return noPolicy
+	}
+	return p.Version()
+}
+
+// reachable 判断服务器是否替 a 和 b 协调打洞
+func reachable(a, b string) bool {
+	p, _ := policies.get()
+	return p == nil || p.Reachable(a, b)
+}
+
+// checkCaller 核对请求里声明的节点名：节点要已经注册，并且请求来自注册时的源 IP。
+// 节点名由客户端自己声明，这只能防止别的地址冒用名字查看别人的策略和节点列表
+func checkCaller(ctx context.Context, name string) error {
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "empty node name")
+	}
+	e, ok := nodeInfo.Get(name)
+	if !ok {
+		return status.Error(codes.FailedPrecondition, "node is not registered")
+	}
+	if ip := guard.PeerIP(ctx); ip != nil && ip.String() != e.IP {
+		return status.Error(codes.PermissionDenied, "node is registered from another address")
+	}
+	return nil
+}
+
+// visible 返回 name 能看到的节点：没有策略时是全部，有策略时是自己和策略允许互通的节点
+func visible(p *policy.Policy, name string, nodes []*pb.NodeInfo) []*pb.NodeInfo {
+	if p == nil {
+		return nodes
+	}
+	out := nodes[:0]
+	for _, info := range nodes {
+		if info.GetName() == name || p.Reachable(name, info.GetName()) {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// GetPolicy 只返回调用方自己的入站规则
+func GetPolicy(ctx context.Context, in *pb.GetPolicyReq) (*pb.GetPolicyResp, error) {
+	if err := checkCaller(ctx, in.GetName()); err != nil {
+		return nil, err
+	}
+	wait := time.Duration(in.GetWaitMs()) * time.Millisecond
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	p, changed := policies.get()
+	// 客户端已经是最新版本时等策略变化
+	if in.GetVersion() == version(p) {
+		select {
+		case <-changed:
+			p, _ = policies.get()
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	resp := &pb.GetPolicyResp{Version: version(p), Policy: &pb.NodePolicy{}}
+	if p != nil {
+		resp.Policy = p.ForNode(in.GetName())
+	}
+	public.LoggerFromContext(ctx).Debug("policy fetched", "node", in.GetName(), "version", resp.Version)
+	return resp, nil
+}
@@ -0,0 +1,224 @@
+package logic
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// punchDelay 留给被连接方收到请求的时间，双方同时开始打洞
+	punchDelay = time.Second
+	// punchTTL 之后还没被取走的请求丢弃，发起方会重新请求
+	punchTTL    = 10 * time.Second
+	maxPollWait = 30 * time.Second
+	maxPending  = 32
+)
+
+type pendingPunch struct {
+	req     *pb.PunchRequest
+	expires time.Time
+}
+
+// Mailbox 暂存发给各节点的打洞请求，节点用长轮询取走
+type Mailbox struct {
+	mu      sync.Mutex
+	pending map[string][]pendingPunch
+	waiters map[string]chan struct{}
+	forward func(*pb.PunchRequest)
+	// lastSweep 是上次清理所有过期请求的时间，见 sweep
+	lastSweep time.Time
+}
+
+func NewMailbox() *Mailbox {
+	return &Mailbox{
+		pending: make(map[string][]pendingPunch),
+		waiters: make(map[string]chan struct{}),
+	}
+}
+
+var mailbox = NewMailbox()
+
+// Punches 返回服务使用的打洞请求信箱
+func Punches() *Mailbox {
+	return mailbox
+}
+
+// SetForwarder 设置转发函数，集群模式下把请求转给其他服务器
+func (mb *Mailbox) SetForwarder(fn func(*pb.PunchRequest)) {
+	mb.mu.Lock()
+	mb.forward = fn
+	mb.mu.Unlock()
+}
+
+// Deliver 把请求放进本地信箱，不转发
+func (mb *Mailbox) Deliver(req *pb.PunchRequest) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	now := time.Now()
+	mb.sweep(now)
+	q := mb.pending[req.GetTo()][:0:0]
+	for _, p := range mb.pending[req.GetTo()] {
+		// 同一个发起方的 UDP 和 TCP 请求各保留最新的一个
+		if now.Before(p.expires) && (p.req.GetFrom() != req.GetFrom() || p.req.GetTcp() != req.GetTcp()) {
+			q = append(q, p)
+		}
+	}
+	if len(q) >= maxPending {
+		q = q[1:]
+	}
+	mb.pending[req.GetTo()] = append(q, pendingPunch{req: req, expires: now.Add(punchTTL)})
+	if ch, ok := mb.waiters[req.GetTo()]; ok {
+		close(ch)
+		delete(mb.waiters, req.GetTo())
+	}
+}
+
+// sweep 需要持有锁，每隔 punchTTL 清理一次所有节点的过期请求，
+// 不再轮询的节点的请求不会一直留在内存里
+func (mb *Mailbox) sweep(now time.Time) {
+	if now.Sub(mb.lastSweep) < punchTTL {
+		return
+	}
+	mb.lastSweep = now
+	for name, q := range mb.pending {
+		live := q[:0]
+		for _, p := range q {
+			if now.Before(p.expires) {
+				live = append(live, p)
+			}
+		}
+		if len(live) == 0 {
+			delete(mb.pending, name)
+		} else {
+			mb.pending[name] = live
+		}
+	}
+}
+
+// Send 投递到本地并转发给集群
+func (mb *Mailbox) Send(req *pb.PunchRequest) {
+	mb.Deliver(req)
+	mb.mu.Lock()
+	forward := mb.forward
+	mb.mu.Unlock()
+	if forward != nil {
+		forward(req)
+	}
+}
+
+// take 取走 name 的所有未过期请求，没有时返回等待通道
+func (mb *Mailbox) take(name string) ([]*pb.PunchRequest, chan struct{}) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	now := time.Now()
+	var out []*pb.PunchRequest
+	for _, p := range mb.pending[name] {
+		if now.Before(p.expires) {
+			out = append(out, p.req)
+		}
+	}
+	delete(mb.pending, name)
+	if len(out) > 0 {
+		return out, nil
+	}
+	ch, ok := mb.waiters[name]
+	if !ok {
+		ch = make(chan struct{})
+		mb.waiters[name] = ch
+	}
+	return nil, ch
+}
+
+// Poll 取走 name 的请求，没有时最多等 wait
+func (mb *Mailbox) Poll(ctx context.Context, name string, wait time.Duration) []*pb.PunchRequest {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		reqs, ch := mb.take(name)
+		if reqs != nil {
+			return reqs
+		}
+		select {
+		case <-ch:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func RequestPunch(ctx context.Context, in *pb.RequestPunchReq) (*pb.RequestPunchResp, error) {
+	logger := public.LoggerFromContext(ctx).With("node", in.GetName(), "target", in.GetPeer())
+	if in.GetName() == "" || in.GetPeer() == "" || in.GetName() == in.GetPeer() {
+		return nil, status.Error(codes.InvalidArgument, "invalid node or peer name")
+	}
+	// 有策略时要求调用方就是 name，否则可以冒用别人的名字发起打洞
+	if p, _ := policies.get(); p != nil {
+		if err := checkCaller(ctx, in.GetName()); err != nil {
+			logger.Warn("punch caller rejected", "err", err)
+			return nil, err
+		}
+	}
+	if !reachable(in.GetName(), in.GetPeer()) {
+		logger.Warn("punch denied by policy")
+		return nil, status.Error(codes.PermissionDenied, "connection not allowed by policy")
+	}
+	from, ok := nodeInfo.Get(in.GetName())
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "node is not registered")
+	}
+	to, ok := nodeInfo.Get(in.GetPeer())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "peer is not registered")
+	}
+	if in.GetTcp() && (from.Info.GetTcpAddr() == nil || to.Info.GetTcpAddr() == nil) {
+		return nil, status.Error(codes.FailedPrecondition, "tcp address is not registered")
+	}
+	mailbox.Send(&pb.PunchRequest{
+		From:    in.GetName(),
+		To:      in.GetPeer(),
+		Peer:    from.Info,
+		DelayMs: punchDelay.Milliseconds(),
+		Tcp:     in.GetTcp(),
+	})
+	logger.Info("punch requested", "tcp", in.GetTcp())
+	return &pb.RequestPunchResp{
+		Peer:    to.Info,
+		DelayMs: punchDelay.Milliseconds(),
+	}, nil
+}
+
+func PollPunch(ctx context.Context, in *pb.PollPunchReq) (*pb.PollPunchResp, error) {
+	if in.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty node name")
+	}
+	// 有策略时不能替别的节点收打洞请求，请求里带着发起方的地址
+	if p, _ := policies.get(); p != nil {
+		if err := checkCaller(ctx, in.GetName()); err != nil {
+			return nil, err
+		}
+	}
+	wait := time.Duration(in.GetWaitMs()) * time.Millisecond
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	return &pb.PollPunchResp{Requests: mailbox.Poll(ctx, in.GetName(), wait)}, nil
+}
+
+var serverConfig = &pb.GetServerConfigResp{}
+
+// SetServerConfig 设置告诉客户端的端口配置
+func SetServerConfig(c *pb.GetServerConfigResp) {
+	serverConfig = c
+}
+
+func GetServerConfig(ctx context.Context, in *pb.GetServerConfigReq) (*pb.GetServerConfigResp, error) {
+	return serverConfig, nil
+}
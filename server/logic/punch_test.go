@@ -0,0 +1,164 @@
+package logic
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/server/policy"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestMailboxPollWakeup(t *testing.T) {
+	mb := NewMailbox()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		mb.Deliver(&pb.PunchRequest{From: "a", To: "b"})
+	}()
+	start := time.Now()
+	reqs := mb.Poll(context.Background(), "b", 5*time.Second)
+	if len(reqs) != 1 || reqs[0].GetFrom() != "a" {
+		t.Fatalf("Poll = %v", reqs)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("poll was not woken up")
+	}
+	if reqs := mb.Poll(context.Background(), "b", 10*time.Millisecond); reqs != nil {
+		t.Fatalf("requests should be taken once, got %v", reqs)
+	}
+}
+
+func TestMailboxLatestPerSender(t *testing.T) {
+	mb := NewMailbox()
+	mb.Deliver(&pb.PunchRequest{From: "a", To: "c", DelayMs: 1})
+	mb.Deliver(&pb.PunchRequest{From: "b", To: "c"})
+	mb.Deliver(&pb.PunchRequest{From: "a", To: "c", Tcp: true})
+	mb.Deliver(&pb.PunchRequest{From: "a", To: "c", DelayMs: 2})
+	reqs := mb.Poll(context.Background(), "c", 0)
+	if len(reqs) != 3 || reqs[0].GetFrom() != "b" || !reqs[1].GetTcp() || reqs[2].GetDelayMs() != 2 {
+		t.Fatalf("Poll = %v", reqs)
+	}
+}
+
+func TestMailboxSweep(t *testing.T) {
+	mb := NewMailbox()
+	mb.Deliver(&pb.PunchRequest{From: "a", To: "gone"})
+	mb.Deliver(&pb.PunchRequest{From: "a", To: "b"})
+	// gone 不再轮询，请求过期以后投递给别的节点时一起清理掉
+	mb.mu.Lock()
+	mb.pending["gone"][0].expires = time.Now().Add(-time.Second)
+	mb.lastSweep = time.Time{}
+	mb.mu.Unlock()
+	mb.Deliver(&pb.PunchRequest{From: "a", To: "c"})
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if _, ok := mb.pending["gone"]; ok || len(mb.pending) != 2 {
+		t.Fatalf("pending after sweep: %v", mb.pending)
+	}
+}
+
+func TestMailboxForward(t *testing.T) {
+	mb := NewMailbox()
+	var forwarded []*pb.PunchRequest
+	mb.SetForwarder(func(req *pb.PunchRequest) { forwarded = append(forwarded, req) })
+	mb.Send(&pb.PunchRequest{From: "a", To: "b"})
+	if len(forwarded) != 1 {
+		t.Fatalf("forwarded %d requests", len(forwarded))
+	}
+	if reqs := mb.Poll(context.Background(), "b", 0); len(reqs) != 1 {
+		t.Fatalf("Poll = %v", reqs)
+	}
+}
+
+func TestGetPolicyWakeup(t *testing.T) {
+	defer SetPolicy(nil)
+	ctx := context.Background()
+	nodeInfo.Update(&pb.NodeInfo{Name: "a"}, "")
+	defer nodeInfo.Remove("a")
+	resp, err := GetPolicy(ctx, &pb.GetPolicyReq{Name: "a"})
+	if err != nil || resp.GetPolicy().GetEnforce() || resp.GetVersion() != noPolicy {
+		t.Fatalf("no policy: %v, %v", resp, err)
+	}
+	p, err := policy.Parse([]byte(`{"acls": [{"src": ["b"], "dst": ["a"], "ports": ["22"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	if !reachable("a", "b") || reachable("a", "c") {
+		t.Fatal("reachable does not follow the policy")
+	}
+	// 版本不同时马上返回
+	resp, err = GetPolicy(ctx, &pb.GetPolicyReq{Name: "a", Version: "old", WaitMs: 5000})
+	if err != nil || resp.GetVersion() != p.Version() || len(resp.GetPolicy().GetInbound()) != 1 {
+		t.Fatalf("GetPolicy = %v, %v", resp, err)
+	}
+	// 版本相同时等到策略变化
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		SetPolicy(nil)
+	}()
+	start := time.Now()
+	resp, err = GetPolicy(ctx, &pb.GetPolicyReq{Name: "a", Version: p.Version(), WaitMs: 5000})
+	if err != nil || resp.GetVersion() != noPolicy || resp.GetPolicy().GetEnforce() {
+		t.Fatalf("GetPolicy after removal = %v, %v", resp, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("poll was not woken up")
+	}
+}
+
+func TestPolicyCaller(t *testing.T) {
+	defer SetPolicy(nil)
+	for _, name := range []string{"a", "b", "c"} {
+		nodeInfo.Update(&pb.NodeInfo{Name: name}, "192.0.2.1")
+		defer nodeInfo.Remove(name)
+	}
+	p, err := policy.Parse([]byte(`{"acls": [{"src": ["b"], "dst": ["a"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	from := func(ip string) context.Context {
+		return grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}})
+	}
+
+	// 别的地址不能冒用 a 的名字查策略和节点
+	if _, err := GetPolicy(from("198.51.100.1"), &pb.GetPolicyReq{Name: "a"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("GetPolicy from another address: %v", err)
+	}
+	if _, err := GetPolicy(from("192.0.2.1"), &pb.GetPolicyReq{Name: "x"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("GetPolicy of unregistered node: %v", err)
+	}
+	if _, err := GetNodeInfo(from("192.0.2.1"), &pb.GetNodeInfoReq{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("GetNodeInfo without name: %v", err)
+	}
+	// 也不能冒用 b 的名字发起打洞或者收 b 的打洞请求
+	if _, err := RequestPunch(from("198.51.100.1"), &pb.RequestPunchReq{Name: "b", Peer: "a"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("RequestPunch from another address: %v", err)
+	}
+	if _, err := PollPunch(from("198.51.100.1"), &pb.PollPunchReq{Name: "b"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PollPunch from another address: %v", err)
+	}
+
+	// 只返回策略允许互通的节点
+	for name, want := range map[string]string{"a": "a,b", "b": "a,b", "c": "c"} {
+		resp, err := GetNodeInfo(from("192.0.2.1"), &pb.GetNodeInfoReq{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, info := range resp.GetNodeInfo() {
+			names = append(names, info.GetName())
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != want {
+			t.Fatalf("%s sees %s, want %s", name, got, want)
+		}
+	}
+}
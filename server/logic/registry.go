@@ -0,0 +1,137 @@
+package logic
+
+import (
+	"github.com/golang/protobuf/proto"
+	pb "github.com/jinyunx/p2p/proto"
+	"net"
+	"sort"
+	"time"
+)
+
+// NodeEntry 是节点表中一条记录的快照
+type NodeEntry struct {
+	Info    *pb.NodeInfo
+	IP      string
+	Updated time.Time
+}
+
+func (e *nodeEntry) snapshot() NodeEntry {
+	return NodeEntry{
+		Info:    proto.Clone(e.info).(*pb.NodeInfo),
+		IP:      e.ip,
+		Updated: e.updated,
+	}
+}
+
+// Entries 返回按名字排序的全部节点
+func (m *NodesMap) Entries() []NodeEntry {
+	m.mu.Lock()
+	out := make([]NodeEntry, 0, len(m.nodes))
+	for _, e := range m.nodes {
+		out = append(out, e.snapshot())
+	}
+	m.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Info.GetName() < out[j].Info.GetName()
+	})
+	return out
+}
+
+func (m *NodesMap) Get(name string) (NodeEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.nodes[name]
+	if !ok {
+		return NodeEntry{}, false
+	}
+	return e.snapshot(), true
+}
+
+func (m *NodesMap) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.nodes)
+}
+
+func (m *NodesMap) Remove(name string) bool {
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.remove(name)
+}
+
+func (m *NodesMap) remove(name string) bool {
+	e, ok := m.nodes[name]
+	if !ok {
+		return false
+	}
+	m.release(e.ip)
+	delete(m.nodes, name)
+	m.tombstones[name] = tombstone{version: m.tick(), origin: m.origin, at: time.Now()}
+	m.changed(name)
+	return true
+}
+
+// RemoveIP 删除源 IP 落在 ipNet 内的所有节点，返回删除个数
+func (m *NodesMap) RemoveIP(ipNet *net.IPNet) int {
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for name, e := range m.nodes {
+		if ip := net.ParseIP(e.ip); ip != nil && ipNet.Contains(ip) {
+			m.remove(name)
+			n++
+		}
+	}
+	return n
+}
+
+// BanName 禁止该名字注册，已注册的会被踢掉，封禁会复制到集群里的其他服务器
+func (m *NodesMap) BanName(name string) int {
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setBan(name, true)
+	if m.remove(name) {
+		return 1
+	}
+	return 0
+}
+
+func (m *NodesMap) UnbanName(name string) {
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setBan(name, false)
+}
+
+func (m *NodesMap) BannedNames() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name, b := range m.bannedNames {
+		if b.banned {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *NodesMap) SetDraining(drain bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.draining = drain
+}
+
+func (m *NodesMap) Draining() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.draining
+}
+
+func (m *NodesMap) Rejected() uint64 {
+	return m.rejected.Load()
+}
@@ -0,0 +1,77 @@
+package logic
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+var (
+	ErrRelaySource = errors.New("relay source is not the registered address")
+	ErrRelayTarget = errors.New("relay target is not reachable")
+)
+
+// udpSeenTTL 内没再收到某个地址的包就认为它不用了，节点默认 20 秒保活一次
+const udpSeenTTL = time.Minute
+
+// SawUDP 记录主端口收到的包的源地址
+func (m *NodesMap) SawUDP(addr *net.UDPAddr) {
+	now := time.Now()
+	m.seenMu.Lock()
+	defer m.seenMu.Unlock()
+	m.seen[addr.String()] = now
+	if now.Sub(m.seenSwept) < udpSeenTTL {
+		return
+	}
+	m.seenSwept = now
+	for k, t := range m.seen {
+		if now.Sub(t) > udpSeenTTL {
+			delete(m.seen, k)
+		}
+	}
+}
+
+// sawRecently 判断 udpSeenTTL 内是否从 addr 收到过包
+func (m *NodesMap) sawRecently(addr *net.UDPAddr) bool {
+	m.seenMu.Lock()
+	defer m.seenMu.Unlock()
+	t, ok := m.seen[addr.String()]
+	return ok && time.Since(t) <= udpSeenTTL
+}
+
+// RelayTarget 核对一个中继包：from 要从它注册的 UDP 地址发出，to 要已经注册、
+// 和 from 在同一个网络里，并且策略允许两者互通，返回 to 注册的 UDP 地址。
+// 注册地址是节点自己报的，只有本服务器最近从这个地址收到过保活包时才转发，
+// 否则服务器会被当成反射器，把包打到任意地址
+func (m *NodesMap) RelayTarget(from, to string, src *net.UDPAddr) (*net.UDPAddr, error) {
+	m.mu.Lock()
+	fe, fok := m.nodes[from]
+	te, tok := m.nodes[to]
+	var fa, ta *net.UDPAddr
+	if fok {
+		fa = udpAddr(fe.info.GetUdpAddr())
+	}
+	if tok {
+		ta = udpAddr(te.info.GetUdpAddr())
+		tok = fok && te.info.GetNetwork() == fe.info.GetNetwork()
+	}
+	m.mu.Unlock()
+
+	if !fok || fa == nil || !fa.IP.Equal(src.IP) || fa.Port != src.Port {
+		return nil, ErrRelaySource
+	}
+	if !tok || ta == nil || from == to || !reachable(from, to) || !m.sawRecently(ta) {
+		return nil, ErrRelayTarget
+	}
+	return ta, nil
+}
+
+func udpAddr(a *pb.UDPAddr) *net.UDPAddr {
+	ip := net.ParseIP(a.GetIp())
+	if ip == nil || a.GetPort() == 0 {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip, Port: int(a.GetPort())}
+}
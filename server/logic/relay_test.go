@@ -0,0 +1,58 @@
+package logic
+
+import (
+	"net"
+	"testing"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/server/policy"
+)
+
+func TestRelayTarget(t *testing.T) {
+	defer SetPolicy(nil)
+	m := NewNodesMap()
+	for i, name := range []string{"a", "b", "c", "d"} {
+		network := "home"
+		if name == "d" {
+			network = "work"
+		}
+		addr := &pb.UDPAddr{Ip: "192.0.2.1", Port: int32(40001 + i)}
+		if err := m.Update(&pb.NodeInfo{Name: name, Network: network, UdpAddr: addr}, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40001}
+	// 没收到过 b 的保活包时不转发，注册地址是 b 自己报的
+	if _, err := m.RelayTarget("a", "b", src); err != ErrRelayTarget {
+		t.Fatalf("relay to unseen address: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		m.SawUDP(&net.UDPAddr{IP: src.IP, Port: 40001 + i})
+	}
+
+	to, err := m.RelayTarget("a", "b", src)
+	if err != nil || to.String() != "192.0.2.1:40002" {
+		t.Fatalf("RelayTarget = %v, %v", to, err)
+	}
+	// 不是 a 注册的地址发来的包不转发，防止冒用名字
+	if _, err := m.RelayTarget("a", "b", &net.UDPAddr{IP: src.IP, Port: 40009}); err != ErrRelaySource {
+		t.Fatalf("spoofed source: %v", err)
+	}
+	// 不同网络、没注册和发给自己的都不转发
+	for _, to := range []string{"d", "x", "a"} {
+		if _, err := m.RelayTarget("a", to, src); err != ErrRelayTarget {
+			t.Fatalf("relay to %s: %v", to, err)
+		}
+	}
+	p, err := policy.Parse([]byte(`{"acls": [{"src": ["c"], "dst": ["a"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	if _, err := m.RelayTarget("a", "b", src); err != ErrRelayTarget {
+		t.Fatalf("relay denied by policy: %v", err)
+	}
+	if _, err := m.RelayTarget("a", "c", src); err != nil {
+		t.Fatalf("relay allowed by policy: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package logic
+
+import (
+	"github.com/golang/protobuf/proto"
+	pb "github.com/jinyunx/p2p/proto"
+	"sort"
+	"time"
+)
+
+// Record 是在服务器之间复制的一条注册信息，Deleted 表示删除。
+// Ban 为 true 时是名字的封禁记录，Deleted 表示解封
+type Record struct {
+	Info    *pb.NodeInfo
+	IP      string
+	Version int64
+	Origin  string
+	Deleted bool
+	Ban     bool
+}
+
+func (r Record) Name() string {
+	return r.Info.GetName()
+}
+
+type tombstone struct {
+	version int64
+	origin  string
+	at      time.Time
+}
+
+// nameBan 是一个名字的封禁状态，解封后留到 PurgeTombstones 清理。
+// 封禁和注册记录分开比较版本：别的服务器在收到封禁之前写入的更新的注册也要被踢掉
+type nameBan struct {
+	banned  bool
+	version int64
+	origin  string
+	at      time.Time
+}
+
+// newer 比较两个版本，版本相同时按服务器名决胜，保证各副本结果一致
+func newer(version int64, origin string, thanVersion int64, thanOrigin string) bool {
+	if version != thanVersion {
+		return version > thanVersion
+	}
+	return origin > thanOrigin
+}
+
+// SetReplicator 设置本服务器在集群中的名字，本地的每次修改都会通过 fn 通知出去
+func (m *NodesMap) SetReplicator(origin string, fn func(Record)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.origin = origin
+	m.onChange = fn
+}
+
+// tick 生成本地写入的版本号，基于时间并且大于见过的所有版本
+func (m *NodesMap) tick() int64 {
+	v := time.Now().UnixNano()
+	if v <= m.clock {
+		v = m.clock + 1
+	}
+	m.clock = v
+	return v
+}
+
+// changed 需要持有锁，记下待通知的修改
+func (m *NodesMap) changed(name string) {
+	if m.onChange == nil {
+		return
+	}
+	m.pending = append(m.pending, m.record(name))
+}
+
+// flush 在释放锁之后调用回调，避免回调里再访问节点表时死锁
+func (m *NodesMap) flush() {
+	m.mu.Lock()
+	pending, fn := m.pending, m.onChange
+	m.pending = nil
+	m.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	for _, r := range pending {
+		fn(r)
+	}
+}
+
+// setBan 需要持有锁，记下封禁状态并通知出去
+func (m *NodesMap) setBan(name string, banned bool) {
+	m.bannedNames[name] = nameBan{banned: banned, version: m.tick(), origin: m.origin, at: time.Now()}
+	if m.onChange != nil {
+		m.pending = append(m.pending, m.banRecord(name))
+	}
+}
+
+func (m *NodesMap) banRecord(name string) Record {
+	b := m.bannedNames[name]
+	return Record{
+		Info:    &pb.NodeInfo{Name: name},
+		Version: b.version,
+		Origin:  b.origin,
+		Deleted: !b.banned,
+		Ban:     true,
+	}
+}
+
+func (m *NodesMap) record(name string) Record {
+	if e, ok := m.nodes[name]; ok {
+		return Record{
+			Info:    proto.Clone(e.info).(*pb.NodeInfo),
+			IP:      e.ip,
+			Version: e.version,
+			Origin:  e.origin,
+		}
+	}
+	t := m.tombstones[name]
+	return Record{
+		Info:    &pb.NodeInfo{Name: name},
+		Version: t.version,
+		Origin:  t.origin,
+		Deleted: true,
+	}
+}
+
+// Apply 合并其他服务器复制过来的记录，旧记录被忽略，返回是否有变化
+func (m *NodesMap) Apply(r Record) bool {
+	// 地址冲突时本地节点换了地址，要把新地址同步出去
+	defer m.logSaveAddresses()
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r.Version > m.clock {
+		m.clock = r.Version
+	}
+	name := r.Name()
+	if name == "" {
+		return false
+	}
+	if r.Ban {
+		return m.applyBan(r)
+	}
+	if m.bannedNames[name].banned {
+		return false
+	}
+	if e, ok := m.nodes[name]; ok && !newer(r.Version, r.Origin, e.version, e.origin) {
+		return false
+	}
+	if t, ok := m.tombstones[name]; ok && !newer(r.Version, r.Origin, t.version, t.origin) {
+		return false
+	}
+
+	if r.Deleted {
+		if e, ok := m.nodes[name]; ok {
+			m.release(e.ip)
+			delete(m.nodes, name)
+		}
+		m.tombstones[name] = tombstone{version: r.Version, origin: r.Origin, at: time.Now()}
+		return true
+	}
+	info := proto.Clone(r.Info).(*pb.NodeInfo)
+	m.adoptVirtualIP(info)
+	m.put(&nodeEntry{
+		info:    info,
+		ip:      r.IP,
+		updated: time.Now(),
+		version: r.Version,
+		origin:  r.Origin,
+	})
+	return true
+}
+
+// applyBan 需要持有锁，合并封禁记录，封禁时踢掉本地的注册
+func (m *NodesMap) applyBan(r Record) bool {
+	name := r.Name()
+	if b, ok := m.bannedNames[name]; ok && !newer(r.Version, r.Origin, b.version, b.origin) {
+		return false
+	}
+	m.bannedNames[name] = nameBan{banned: !r.Deleted, version: r.Version, origin: r.Origin, at: time.Now()}
+	if e, ok := m.nodes[name]; ok && !r.Deleted {
+		m.release(e.ip)
+		delete(m.nodes, name)
+		m.tombstones[name] = tombstone{version: e.version, origin: e.origin, at: time.Now()}
+	}
+	return true
+}
+
+// Snapshot 按节点名顺序返回名字在 after 之后的至多 limit 个名字的记录（包括删除标记和封禁记录），
+// 用于副本之间分页对账，more 表示后面还有记录
+func (m *NodesMap) Snapshot(after string, limit int) (records []Record, more bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if name > after && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range m.nodes {
+		add(name)
+	}
+	for name := range m.tombstones {
+		add(name)
+	}
+	for name := range m.bannedNames {
+		add(name)
+	}
+	sort.Strings(names)
+	if len(names) > limit {
+		names, more = names[:limit], true
+	}
+	records = make([]Record, 0, len(names))
+	for _, name := range names {
+		_, node := m.nodes[name]
+		if _, dead := m.tombstones[name]; node || dead {
+			records = append(records, m.record(name))
+		}
+		if _, ok := m.bannedNames[name]; ok {
+			records = append(records, m.banRecord(name))
+		}
+	}
+	return records, more
+}
+
+// PurgeTombstones 清理早于 before 的删除标记和解封记录，封禁一直保留
+func (m *NodesMap) PurgeTombstones(before time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for name, t := range m.tombstones {
+		if t.at.Before(before) {
+			delete(m.tombstones, name)
+			n++
+		}
+	}
+	for name, b := range m.bannedNames {
+		if !b.banned && b.at.Before(before) {
+			delete(m.bannedNames, name)
+			n++
+		}
+	}
+	return n
+}
@@ -1,50 +1,382 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"github.com/jinyunx/p2p/server/admin"
+	"github.com/jinyunx/p2p/server/cluster"
+	"github.com/jinyunx/p2p/server/guard"
 	"github.com/jinyunx/p2p/server/logic"
+	"github.com/jinyunx/p2p/server/metrics"
+	"github.com/jinyunx/p2p/server/policy"
+	"github.com/jinyunx/p2p/stun"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
-	"log"
+	"google.golang.org/grpc/status"
+	"log/slog"
 	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// defaultVnet 是没有指定 -vnet 时 default 网络的地址段
+const defaultVnet = "100.64.0.0/10,fd7a:7032:7000::/48"
+
 type server struct {
 	pb.UnimplementedP2PServer
 }
 
 func (s *server) GetExternalIpPort(ctx context.Context, in *pb.GetExternalIpPortReq) (*pb.GetExternalIpPortResp, error) {
-	log.Println("GetExternalIpPort req", in)
 	return logic.GetExternalIpPort(ctx, in)
 }
 
 func (s *server) UpdateNode(ctx context.Context, in *pb.UpdateNodeReq) (*pb.UpdateNodeResp, error) {
-	log.Println("UpdateNode req", in)
-	return logic.UpdateNode(ctx, in)
+	resp, err := logic.UpdateNode(ctx, in)
+	if err == nil {
+		metrics.Registrations.Inc()
+	}
+	return resp, err
 }
 
 func (s *server) GetNodeInfo(ctx context.Context, in *pb.GetNodeInfoReq) (*pb.GetNodeInfoResp, error) {
-	log.Println("GetNodeInfo req", in)
+	metrics.Lookups.Inc()
 	return logic.GetNodeInfo(ctx, in)
 }
 
+func (s *server) GetServerConfig(ctx context.Context, in *pb.GetServerConfigReq) (*pb.GetServerConfigResp, error) {
+	return logic.GetServerConfig(ctx, in)
+}
+
+func (s *server) RequestPunch(ctx context.Context, in *pb.RequestPunchReq) (*pb.RequestPunchResp, error) {
+	return logic.RequestPunch(ctx, in)
+}
+
+func (s *server) PollPunch(ctx context.Context, in *pb.PollPunchReq) (*pb.PollPunchResp, error) {
+	return logic.PollPunch(ctx, in)
+}
+
+func (s *server) GetPolicy(ctx context.Context, in *pb.GetPolicyReq) (*pb.GetPolicyResp, error) {
+	return logic.GetPolicy(ctx, in)
+}
+
+func (s *server) ReportPunch(ctx context.Context, in *pb.ReportPunchReq) (*pb.ReportPunchResp, error) {
+	if in.GetSuccess() {
+		metrics.Punches.WithLabelValues("success").Inc()
+		metrics.PunchDuration.Observe(float64(in.GetElapsedMs()) / 1000)
+	} else {
+		metrics.Punches.WithLabelValues("failure").Inc()
+	}
+	return logic.ReportPunch(ctx, in)
+}
+
+// reportDropped 定期打印被限流或拦截的请求数
+func reportDropped(logger *slog.Logger, g *guard.Guard, interval time.Duration) {
+	var last guard.Stats
+	var lastRejected uint64
+	for range time.Tick(interval) {
+		st := g.Stats()
+		rejected := logic.Rejected()
+		if st != last || rejected != lastRejected {
+			logger.Warn("requests dropped",
+				"udp_rate", st.UdpRateDropped, "udp_acl", st.UdpAclDropped,
+				"rpc_rate", st.RpcRateDropped, "rpc_acl", st.RpcAclDropped,
+				"rejected_registrations", rejected)
+		}
+		last, lastRejected = st, rejected
+	}
+}
+
+// releaseIdleAddresses 定期回收空闲超时的虚拟地址
+func releaseIdleAddresses(logger *slog.Logger, interval time.Duration) {
+	for range time.Tick(interval) {
+		if n := logic.Registry().ReleaseIdleAddresses(); n > 0 {
+			logger.Info("released idle virtual addresses", "count", n)
+		}
+	}
+}
+
+// loggingInterceptor 给每个请求带上方法名和对端地址，处理函数从 ctx 取 logger
+func loggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		l := logger.With("method", info.FullMethod)
+		if ip := guard.PeerIP(ctx); ip != nil {
+			l = l.With("peer", ip.String())
+		}
+		start := time.Now()
+		resp, err := handler(public.ContextWithLogger(ctx, l), req)
+		if err != nil {
+			l.Warn("rpc failed", "code", status.Code(err), "err", err, "elapsed", time.Since(start))
+		} else {
+			l.Debug("rpc done", "elapsed", time.Since(start))
+		}
+		return resp, err
+	}
+}
+
+// readToken 优先读文件，没有指定文件时读环境变量
+func readToken(env string, file string) (string, error) {
+	token := os.Getenv(env)
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		token = string(bytes.TrimSpace(b))
+	}
+	if token == "" {
+		return "", errors.New("token is empty, set the token file or " + env)
+	}
+	return token, nil
+}
+
+func serveCluster(conf cluster.Config, addr string) {
+	conf.Logger.Info("listen cluster rpc", "addr", addr, "id", conf.ID, "peers", conf.Peers)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(conf.Logger, "failed to listen", "err", err)
+	}
+	node := cluster.New(conf, logic.Registry())
+	node.ForwardPunches(logic.Punches())
+	go node.Run()
+	if err := node.Serve(lis); err != nil {
+		fatal(conf.Logger, "failed to serve", "err", err)
+	}
+}
+
+func serveAdmin(logger *slog.Logger, addr string, token string, srv pb.AdminServer) {
+	logger.Info("listen admin rpc", "addr", addr)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(logger, "failed to listen", "err", err)
+	}
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		guard.TokenInterceptor(token),
+		loggingInterceptor(logger),
+	))
+	pb.RegisterAdminServer(s, srv)
+	if err := s.Serve(lis); err != nil {
+		fatal(logger, "failed to serve", "err", err)
+	}
+}
+
+func serveStun(opts stun.ServerOptions) {
+	s, err := stun.NewServer(opts)
+	if err != nil {
+		fatal(opts.Logger, "failed to start stun server", "err", err)
+	}
+	s.Serve()
+}
+
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
 func main() {
-	log.SetFlags(log.Lshortfile | log.LstdFlags)
+	var udpOpts public.UdpServerOptions
+	flag.IntVar(&udpOpts.Workers, "udp-workers", 0, "udp handler goroutines, 0 means NumCPU")
+	flag.IntVar(&udpOpts.Sockets, "udp-sockets", 1, "udp sockets bound with SO_REUSEPORT (linux only)")
+	flag.IntVar(&udpOpts.BatchSize, "udp-batch", 32, "max datagrams per recvmmsg/sendmmsg (linux only)")
+	flag.IntVar(&udpOpts.QueueSize, "udp-queue", 4096, "udp receive queue length, packets beyond it are dropped")
+	var guardConf guard.Config
+	var limits logic.Limits
+	flag.Float64Var(&guardConf.UdpRate, "udp-rate", 20, "udp packets per second per source ip, 0 means unlimited; relayed packets count too, raise it with -relay")
+	flag.IntVar(&guardConf.UdpBurst, "udp-burst", 40, "udp packet burst per source ip, used with -udp-rate")
+	flag.Float64Var(&guardConf.RpcRate, "rpc-rate", 10, "rpc requests per second per source ip, 0 means unlimited")
+	flag.IntVar(&guardConf.RpcBurst, "rpc-burst", 20, "rpc request burst per source ip")
+	relay := flag.Bool("relay", false, "relay vpn packets between nodes that cannot punch through, over the main udp port; costs server bandwidth")
+	relayRate := flag.Float64("relay-rate", 2000, "relayed packets per second per source ip, 0 means unlimited")
+	relayBurst := flag.Int("relay-burst", 4000, "relayed packet burst per source ip")
+	flag.IntVar(&limits.MaxNodes, "max-nodes", 100000, "max registered nodes, 0 means unlimited")
+	flag.IntVar(&limits.MaxNodesPerIP, "max-nodes-per-ip", 64, "max registered nodes per source ip, 0 means unlimited; nodes behind one nat share a source ip")
+	allow := flag.String("allow", "", "comma separated CIDRs allowed to use the server, empty allows all")
+	deny := flag.String("deny", "", "comma separated CIDRs denied to use the server")
+	metricsAddr := flag.String("metrics", "", "serve prometheus /metrics on this address, e.g. :9100")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	logJson := flag.Bool("log-json", false, "log in JSON format")
+	adminAddr := flag.String("admin-addr", "", "serve the admin api on this address, e.g. 127.0.0.1:50052")
+	adminTokenFile := flag.String("admin-token-file", "", "file holding the admin token, P2P_ADMIN_TOKEN is used if empty")
+	clusterAddr := flag.String("cluster-addr", "", "serve registry replication on this address, e.g. :50053")
+	clusterID := flag.String("cluster-id", "", "unique name of this server in the cluster, defaults to hostname")
+	clusterPeers := flag.String("cluster-peers", "", "comma separated cluster addresses of the other servers")
+	clusterTokenFile := flag.String("cluster-token-file", "", "file holding the cluster token, P2P_CLUSTER_TOKEN is used if empty")
+	var vnets []logic.VirtualNet
+	flag.Func("vnet", "virtual network for vpn mode as [network=]cidr[,cidr], can be repeated, off disables virtual ips (default "+defaultVnet+")", func(s string) error {
+		if s == "off" {
+			vnets = []logic.VirtualNet{}
+			return nil
+		}
+		v, err := logic.ParseVirtualNet(s)
+		if err == nil {
+			vnets = append(vnets, v)
+		}
+		return err
+	})
+	policyFile := flag.String("policy", "", "json access policy between nodes, reloaded on SIGHUP, empty allows all")
+	vnetFile := flag.String("vnet-file", "", "file keeping virtual ip assignments across restarts")
+	vnetIdle := flag.Duration("vnet-idle", 7*24*time.Hour, "release a virtual ip after its node has not registered for this long, static reservations are kept, 0 keeps all")
+	probePorts := flag.String("probe-ports", "50061-50064", "extra udp ports echoing the source address, used for symmetric nat port prediction")
+	var stunOpts stun.ServerOptions
+	flag.IntVar(&stunOpts.Port, "stun-port", 3478, "stun server port used by nat behavior probes, 0 disables it")
+	flag.IntVar(&stunOpts.AltPort, "stun-alt-port", 3479, "secondary stun port, needed for binding lifetime measurement")
+	flag.StringVar(&stunOpts.Ip, "stun-ip", "", "stun primary ip, required when -stun-alt-ip is set")
+	flag.StringVar(&stunOpts.AltIp, "stun-alt-ip", "", "secondary ip for full RFC 5780 mapping and filtering tests")
+	flag.Parse()
+
+	logger, err := public.NewLogger(os.Stderr, *logLevel, *logJson)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	udpOpts.Logger = logger.With("component", "udp")
+
+	if guardConf.Allow, err = guard.ParseCIDRList(*allow); err != nil {
+		fatal(logger, "invalid -allow", "err", err)
+	}
+	if guardConf.Deny, err = guard.ParseCIDRList(*deny); err != nil {
+		fatal(logger, "invalid -deny", "err", err)
+	}
+	g := guard.New(guardConf)
+	logic.SetLimits(limits)
+	if vnets == nil {
+		v, _ := logic.ParseVirtualNet(defaultVnet)
+		vnets = append(vnets, v)
+	}
+	logic.Registry().SetAddressIdle(*vnetIdle)
+	if err := logic.Registry().SetVirtualNets(vnets, *vnetFile); err != nil {
+		fatal(logger, "virtual networks", "err", err)
+	}
+	if *vnetIdle > 0 {
+		go releaseIdleAddresses(logger, time.Minute)
+	}
+	if *policyFile != "" {
+		p, err := policy.Load(*policyFile)
+		if err != nil {
+			fatal(logger, "load policy failed", "err", err)
+		}
+		logic.SetPolicy(p)
+		logger.Info("policy loaded", "file", *policyFile, "version", p.Version())
+		go reloadPolicy(logger, *policyFile)
+	}
+	if *metricsAddr != "" {
+		metrics.RegisterGuard(g)
+		go metrics.Serve(logger, *metricsAddr)
+	}
+
+	hs := health.NewServer()
 	port := fmt.Sprintf(":%d", pb.ServerInfo_ServerInfo_Port)
-	go udpServer(port)
+	var relayLimiter *guard.Limiter
+	if *relay {
+		relayLimiter = guard.NewLimiter(*relayRate, *relayBurst)
+	}
+	udpOpts.Allow = g.AllowUdp
+	udp := newUdpServer(port, udpOpts, relayLimiter, *metricsAddr != "")
+	go serveUdp(udp, udpOpts.Logger)
+	ports, err := parsePorts(*probePorts)
+	if err != nil {
+		fatal(logger, "invalid -probe-ports", "err", err)
+	}
+	serveProbePorts(ports, udpOpts.Logger, g, *metricsAddr != "")
+	conf := &pb.GetServerConfigResp{StunPort: int32(stunOpts.Port), StunAltPort: int32(stunOpts.AltPort), VirtualNets: logic.Registry().VirtualNets(), Relay: *relay}
+	for _, p := range ports {
+		conf.ProbePorts = append(conf.ProbePorts, int32(p))
+	}
+	if stunOpts.Port == 0 {
+		conf.StunAltPort = 0
+	}
+	logic.SetServerConfig(conf)
+	if stunOpts.Port != 0 {
+		stunOpts.Allow = g.AllowUdp
+		stunOpts.Logger = logger.With("component", "stun")
+		go serveStun(stunOpts)
+	}
+	if *clusterAddr != "" {
+		token, err := readToken("P2P_CLUSTER_TOKEN", *clusterTokenFile)
+		if err != nil {
+			fatal(logger, "cluster token", "err", err)
+		}
+		conf := cluster.Config{
+			ID:     *clusterID,
+			Token:  token,
+			Logger: logger.With("component", "cluster"),
+		}
+		if conf.ID == "" {
+			conf.ID, _ = os.Hostname()
+		}
+		for _, p := range strings.Split(*clusterPeers, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				conf.Peers = append(conf.Peers, p)
+			}
+		}
+		go serveCluster(conf, *clusterAddr)
+	}
+	if *adminAddr != "" {
+		token, err := readToken("P2P_ADMIN_TOKEN", *adminTokenFile)
+		if err != nil {
+			fatal(logger, "admin token", "err", err)
+		}
+		adminServer := admin.NewServer(logic.Registry(), g, udp.Stats)
+		// 下线时健康检查返回 NOT_SERVING，客户端会切到其他服务器
+		adminServer.OnDrain = func(drain bool) {
+			if drain {
+				hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			} else {
+				hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+			}
+		}
+		go serveAdmin(logger.With("component", "admin"), *adminAddr, token, adminServer)
+	}
+	go reportDropped(logger, g, time.Minute)
 
-	log.Println("Listen tcp rpc", port)
+	logger.Info("listen tcp rpc", "addr", port)
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		fatal(logger, "failed to listen", "err", err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			metrics.UnaryInterceptor(),
+			g.UnaryInterceptor(),
+			loggingInterceptor(logger.With("component", "rpc")),
+		),
+		// 客户端保持长连接，每 30 秒发一次 keepalive ping
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             15 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
 	pb.RegisterP2PServer(s, &server{})
+	healthpb.RegisterHealthServer(s, hs)
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
 	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+		fatal(logger, "failed to serve", "err", err)
+	}
+}
+
+// reloadPolicy 收到 SIGHUP 时重新读策略文件，文件有错时保留原来的策略
+func reloadPolicy(logger *slog.Logger, path string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		p, err := policy.Load(path)
+		if err != nil {
+			logger.Error("reload policy failed, keeping the old one", "err", err)
+			continue
+		}
+		logic.SetPolicy(p)
+		logger.Info("policy reloaded", "file", path, "version", p.Version())
 	}
 }
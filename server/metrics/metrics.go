@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"github.com/jinyunx/p2p/server/guard"
+	"github.com/jinyunx/p2p/server/logic"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "p2p"
+
+var (
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "UpdateNode requests accepted.",
+	})
+	Lookups = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lookups_total",
+		Help:      "GetNodeInfo requests served.",
+	})
+	Punches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "punch_results_total",
+		Help:      "Hole punching results reported by clients.",
+	}, []string{"result"})
+	PunchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "punch_duration_seconds",
+		Help:      "Time from first probe to first peer packet for successful punches.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+
+	udpProbes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "udp_probes_total",
+		Help:      "UDP reflector requests handled.",
+	})
+	udpProbeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "udp_probe_duration_seconds",
+		Help:      "Time spent handling one UDP reflector request.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 8),
+	})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "gRPC method latency.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(Registrations, Lookups, Punches, PunchDuration,
+		udpProbes, udpProbeDuration, rpcDuration)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "registered_nodes",
+		Help:      "Nodes currently registered.",
+	}, func() float64 { return float64(logic.NodeCount()) }))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_rejected_total",
+		Help:      "Registrations rejected by node limits.",
+	}, func() float64 { return float64(logic.Rejected()) }))
+	prometheus.MustRegister(natTypeCollector{})
+}
+
+// natTypeDesc 按节点注册时上报的 NAT 类型计数，服务器自己不探测。
+// 客户端在服务器开了 STUN 时上报 RFC 5780 探测的结果，否则只能认出公网直连和
+// 端口预测发现的对称型，其余都是 unknown
+var natTypeDesc = prometheus.NewDesc(namespace+"_nodes_by_nat_type",
+	"Registered nodes by the NAT type they reported.", []string{"nat_type"}, nil)
+
+type natTypeCollector struct{}
+
+func (natTypeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- natTypeDesc
+}
+
+func (natTypeCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := logic.NatTypeCounts()
+	for v, name := range pb.NatType_name {
+		t := pb.NatType(v)
+		label := strings.ToLower(strings.TrimPrefix(name, "NatType_"))
+		ch <- prometheus.MustNewConstMetric(natTypeDesc, prometheus.GaugeValue, float64(counts[t]), label)
+	}
+}
+
+// RegisterGuard 导出限流和访问控制的丢弃计数
+func RegisterGuard(g *guard.Guard) {
+	dropped := prometheus.NewDesc(namespace+"_dropped_requests_total",
+		"Requests dropped by rate limits or address filters.", []string{"path", "reason"}, nil)
+	prometheus.MustRegister(collectorFunc{dropped, func(ch chan<- prometheus.Metric) {
+		st := g.Stats()
+		ch <- prometheus.MustNewConstMetric(dropped, prometheus.CounterValue, float64(st.UdpRateDropped), "udp", "rate")
+		ch <- prometheus.MustNewConstMetric(dropped, prometheus.CounterValue, float64(st.UdpAclDropped), "udp", "acl")
+		ch <- prometheus.MustNewConstMetric(dropped, prometheus.CounterValue, float64(st.RpcRateDropped), "rpc", "rate")
+		ch <- prometheus.MustNewConstMetric(dropped, prometheus.CounterValue, float64(st.RpcAclDropped), "rpc", "acl")
+	}})
+}
+
+// RegisterUdpServer 导出 UDP 服务的收发和队列丢包计数
+func RegisterUdpServer(s *public.UdpServer) {
+	packets := prometheus.NewDesc(namespace+"_udp_packets_total",
+		"UDP packets processed by the reflector sockets.", []string{"direction"}, nil)
+	prometheus.MustRegister(collectorFunc{packets, func(ch chan<- prometheus.Metric) {
+		st := s.Stats()
+		ch <- prometheus.MustNewConstMetric(packets, prometheus.CounterValue, float64(st.Received), "received")
+		ch <- prometheus.MustNewConstMetric(packets, prometheus.CounterValue, float64(st.Sent), "sent")
+		ch <- prometheus.MustNewConstMetric(packets, prometheus.CounterValue, float64(st.Dropped), "dropped")
+	}})
+}
+
+type collectorFunc struct {
+	desc    *prometheus.Desc
+	collect func(chan<- prometheus.Metric)
+}
+
+func (c collectorFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+}
+
+func UdpHandler(next public.UdpDataHandler) public.UdpDataHandler {
+	return func(w public.UdpWriter, buf []byte, addr *net.UDPAddr) {
+		start := time.Now()
+		next(w, buf, addr)
+		udpProbes.Inc()
+		udpProbeDuration.Observe(time.Since(start).Seconds())
+	}
+}
+
+func UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		rpcDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
+
+// Serve 在 addr 上提供 /metrics，阻塞运行
+func Serve(logger *slog.Logger, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	logger.Info("listen metrics http", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("metrics server stopped", "err", err)
+	}
+}
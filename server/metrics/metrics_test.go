@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+	"github.com/jinyunx/p2p/server/guard"
+	"github.com/jinyunx/p2p/server/logic"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeGauges(t *testing.T) {
+	for name, nat := range map[string]pb.NatType{
+		"a": pb.NatType_NatType_FullCone,
+		"b": pb.NatType_NatType_Symmetric,
+		"c": pb.NatType_NatType_Symmetric,
+	} {
+		if err := logic.Registry().Update(&pb.NodeInfo{Name: name, NatType: nat}, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+		defer logic.Registry().Remove(name)
+	}
+	want := `
+# HELP p2p_registered_nodes Nodes currently registered.
+# TYPE p2p_registered_nodes gauge
+p2p_registered_nodes 3
+# HELP p2p_nodes_by_nat_type Registered nodes by the NAT type they reported.
+# TYPE p2p_nodes_by_nat_type gauge
+p2p_nodes_by_nat_type{nat_type="fullcone"} 1
+p2p_nodes_by_nat_type{nat_type="open"} 0
+p2p_nodes_by_nat_type{nat_type="portrestricted"} 0
+p2p_nodes_by_nat_type{nat_type="restricted"} 0
+p2p_nodes_by_nat_type{nat_type="symmetric"} 2
+p2p_nodes_by_nat_type{nat_type="symmetricfirewall"} 0
+p2p_nodes_by_nat_type{nat_type="udpblocked"} 0
+p2p_nodes_by_nat_type{nat_type="unknown"} 0
+`
+	if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(want),
+		"p2p_registered_nodes", "p2p_nodes_by_nat_type"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterGuard(t *testing.T) {
+	deny, err := guard.ParseCIDRList("198.51.100.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := guard.New(guard.Config{UdpRate: 1, UdpBurst: 1, Deny: deny})
+	RegisterGuard(g)
+	g.AllowUdp(net.IPv4(198, 51, 100, 1))
+	g.AllowUdp(net.IPv4(192, 0, 2, 1))
+	g.AllowUdp(net.IPv4(192, 0, 2, 1))
+	want := `
+# HELP p2p_dropped_requests_total Requests dropped by rate limits or address filters.
+# TYPE p2p_dropped_requests_total counter
+p2p_dropped_requests_total{path="rpc",reason="acl"} 0
+p2p_dropped_requests_total{path="rpc",reason="rate"} 0
+p2p_dropped_requests_total{path="udp",reason="acl"} 1
+p2p_dropped_requests_total{path="udp",reason="rate"} 1
+`
+	if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(want), "p2p_dropped_requests_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUdpHandler(t *testing.T) {
+	before := testutil.ToFloat64(udpProbes)
+	called := 0
+	handle := UdpHandler(func(w public.UdpWriter, buf []byte, addr *net.UDPAddr) { called++ })
+	handle(nil, nil, &net.UDPAddr{})
+	handle(nil, nil, &net.UDPAddr{})
+	if called != 2 {
+		t.Fatalf("next called %d times", called)
+	}
+	if got := testutil.ToFloat64(udpProbes) - before; got != 2 {
+		t.Fatalf("udp_probes_total increased by %v", got)
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	intercept := UnaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/p2p.P2P/Test"}
+	fail := func(ctx context.Context, req any) (any, error) { return nil, status.Error(codes.NotFound, "x") }
+	ok := func(ctx context.Context, req any) (any, error) { return "resp", nil }
+	if _, err := intercept(context.Background(), nil, info, fail); status.Code(err) != codes.NotFound {
+		t.Fatalf("error not passed through: %v", err)
+	}
+	if resp, err := intercept(context.Background(), nil, info, ok); resp != "resp" || err != nil {
+		t.Fatalf("interceptor returned %v, %v", resp, err)
+	}
+	// 每个方法和状态码一组
+	if n := testutil.CollectAndCount(rpcDuration); n != 2 {
+		t.Fatalf("rpc_duration_seconds has %d series", n)
+	}
+}
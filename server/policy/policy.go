@@ -0,0 +1,214 @@
+// Package policy 是节点之间的访问控制策略。策略按节点名匹配，节点名由客户端自己声明，
+// 策略只限制遵守协议的客户端，不能代替认证
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	pb "github.com/jinyunx/p2p/proto"
+)
+
+// File 是策略文件的格式，比如
+//
+//	{
+//	  "groups": {"admins": ["alice", "bob"]},
+//	  "tags": {"web1": ["server"], "db1": ["server", "db"]},
+//	  "acls": [
+//	    {"src": ["group:admins"], "dst": ["tag:server"]},
+//	    {"src": ["*"], "dst": ["web1"], "ports": ["80", "443", "8000-8100"]}
+//	  ]
+//	}
+//
+// src 和 dst 可以是节点名、group:组名、tag:标签或者表示所有节点的 *，ports 为空表示所有端口。
+// 没有规则允许的连接都被拒绝
+type File struct {
+	Groups map[string][]string `json:"groups"`
+	Tags   map[string][]string `json:"tags"` // 节点名 -> 标签
+	ACLs   []ACL               `json:"acls"`
+}
+
+// ACL 允许 Src 里的节点连 Dst 里节点的 Ports
+type ACL struct {
+	Src   []string `json:"src"`
+	Dst   []string `json:"dst"`
+	Ports []string `json:"ports,omitempty"`
+}
+
+// selector 是展开后的节点集合
+type selector struct {
+	any   bool
+	names map[string]bool
+}
+
+func (s selector) match(name string) bool {
+	return s.any || s.names[name]
+}
+
+type rule struct {
+	src, dst selector
+	ports    []*pb.PortRange
+}
+
+func (r *rule) allowPort(port int) bool {
+	if len(r.ports) == 0 {
+		return true
+	}
+	for _, p := range r.ports {
+		if int32(port) >= p.GetFirst() && int32(port) <= p.GetLast() {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy 是编译后的策略
+type Policy struct {
+	rules   []*rule
+	version string
+}
+
+// Load 读取并编译策略文件
+func Load(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse 编译 JSON 格式的策略，版本号是内容的哈希，集群里用同一个文件的服务器版本号一致
+func Parse(b []byte) (*Policy, error) {
+	var f File
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	tagged := make(map[string][]string) // 标签 -> 节点名
+	for name, tags := range f.Tags {
+		for _, tag := range tags {
+			tagged[tag] = append(tagged[tag], name)
+		}
+	}
+	p := &Policy{}
+	for i, acl := range f.ACLs {
+		r := &rule{}
+		var err error
+		if r.src, err = compileSelector(acl.Src, f.Groups, tagged); err != nil {
+			return nil, fmt.Errorf("acl %d src: %w", i, err)
+		}
+		if r.dst, err = compileSelector(acl.Dst, f.Groups, tagged); err != nil {
+			return nil, fmt.Errorf("acl %d dst: %w", i, err)
+		}
+		for _, s := range acl.Ports {
+			pr, err := parsePorts(s)
+			if err != nil {
+				return nil, fmt.Errorf("acl %d: %w", i, err)
+			}
+			r.ports = append(r.ports, pr)
+		}
+		p.rules = append(p.rules, r)
+	}
+	sum := sha256.Sum256(b)
+	p.version = hex.EncodeToString(sum[:8])
+	return p, nil
+}
+
+func compileSelector(items []string, groups map[string][]string, tagged map[string][]string) (selector, error) {
+	s := selector{names: make(map[string]bool)}
+	if len(items) == 0 {
+		return s, fmt.Errorf("empty selector")
+	}
+	for _, item := range items {
+		var names []string
+		switch {
+		case item == "*":
+			s.any = true
+		case strings.HasPrefix(item, "group:"):
+			members, ok := groups[strings.TrimPrefix(item, "group:")]
+			if !ok {
+				return s, fmt.Errorf("unknown %s", item)
+			}
+			names = members
+		case strings.HasPrefix(item, "tag:"):
+			// 没有节点带这个标签时匹配不到任何节点，不算错
+			names = tagged[strings.TrimPrefix(item, "tag:")]
+		case item == "" || strings.Contains(item, ":"):
+			return s, fmt.Errorf("invalid selector %q", item)
+		default:
+			names = []string{item}
+		}
+		for _, name := range names {
+			s.names[name] = true
+		}
+	}
+	return s, nil
+}
+
+// parsePorts 解析 port 或 first-last
+func parsePorts(s string) (*pb.PortRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+	a, err1 := strconv.Atoi(first)
+	b, err2 := strconv.Atoi(last)
+	if err1 != nil || err2 != nil || a < 1 || b > 65535 || a > b {
+		return nil, fmt.Errorf("invalid ports %q", s)
+	}
+	return &pb.PortRange{First: int32(a), Last: int32(b)}, nil
+}
+
+func (p *Policy) Version() string {
+	return p.version
+}
+
+// Allow 判断 src 能不能连 dst 的 port
+func (p *Policy) Allow(src, dst string, port int) bool {
+	for _, r := range p.rules {
+		if r.src.match(src) && r.dst.match(dst) && r.allowPort(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reachable 判断两个节点之间是否有任何一个方向、任何端口被允许，
+// 打洞建立的通道是双向的，只要有一个方向能用就值得打通
+func (p *Policy) Reachable(a, b string) bool {
+	for _, r := range p.rules {
+		if (r.src.match(a) && r.dst.match(b)) || (r.src.match(b) && r.dst.match(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForNode 返回 dst 作为目的时适用的规则，客户端用它检查连进来的连接
+func (p *Policy) ForNode(dst string) *pb.NodePolicy {
+	out := &pb.NodePolicy{Enforce: true}
+	for _, r := range p.rules {
+		if !r.dst.match(dst) {
+			continue
+		}
+		pr := &pb.PolicyRule{AnySrc: r.src.any, Ports: r.ports}
+		for name := range r.src.names {
+			pr.Src = append(pr.Src, name)
+		}
+		sort.Strings(pr.Src)
+		out.Inbound = append(out.Inbound, pr)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"testing"
+)
+
+const testPolicy = `{
+  "groups": {"admins": ["alice", "bob"]},
+  "tags": {"web1": ["server"], "db1": ["server", "db"]},
+  "acls": [
+    {"src": ["group:admins"], "dst": ["tag:server"]},
+    {"src": ["*"], "dst": ["web1"], "ports": ["80", "8000-8100"]},
+    {"src": ["web1"], "dst": ["tag:db"], "ports": ["5432"]}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{
+		`{"acls": [{"src": ["group:nobody"], "dst": ["*"]}]}`,
+		`{"acls": [{"src": [], "dst": ["*"]}]}`,
+		`{"acls": [{"src": ["*"], "dst": ["x:y"]}]}`,
+		`{"acls": [{"src": ["*"], "dst": ["*"], "ports": ["0"]}]}`,
+		`{"acls": [{"src": ["*"], "dst": ["*"], "ports": ["90-80"]}]}`,
+		`{"acl": []}`,
+	} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("Parse(%s) accepted", s)
+		}
+	}
+	a, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Parse([]byte(testPolicy + "\n"))
+	if a.Version() == "" || a.Version() == b.Version() {
+		t.Fatalf("versions %q %q", a.Version(), b.Version())
+	}
+}
+
+func TestAllow(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		src, dst string
+		port     int
+		want     bool
+	}{
+		{"alice", "db1", 22, true},
+		{"bob", "web1", 0, true},
+		{"carol", "web1", 80, true},
+		{"carol", "web1", 8050, true},
+		{"carol", "web1", 443, false},
+		{"carol", "db1", 5432, false},
+		{"web1", "db1", 5432, true},
+		{"web1", "db1", 22, false},
+		{"db1", "alice", 22, false},
+	}
+	for _, tt := range tests {
+		if got := p.Allow(tt.src, tt.dst, tt.port); got != tt.want {
+			t.Errorf("Allow(%s, %s, %d) = %v", tt.src, tt.dst, tt.port, got)
+		}
+	}
+	// 反方向有规则也能打洞，两个方向都没有才拒绝
+	if !p.Reachable("db1", "alice") || !p.Reachable("carol", "web1") || p.Reachable("carol", "db1") || p.Reachable("alice", "bob") {
+		t.Fatal("Reachable")
+	}
+
+	np := p.ForNode("db1")
+	if !np.GetEnforce() || len(np.GetInbound()) != 2 {
+		t.Fatalf("ForNode(db1) = %v", np)
+	}
+	if r := np.GetInbound()[0]; r.GetAnySrc() || len(r.GetSrc()) != 2 || r.GetSrc()[0] != "alice" || len(r.GetPorts()) != 0 {
+		t.Fatalf("rule 0 = %v", r)
+	}
+	if r := p.ForNode("web1").GetInbound()[1]; !r.GetAnySrc() || len(r.GetPorts()) != 2 {
+		t.Fatalf("web1 rule 1 = %v", r)
+	}
+	if np := p.ForNode("alice"); !np.GetEnforce() || len(np.GetInbound()) != 0 {
+		t.Fatalf("ForNode(alice) = %v", np)
+	}
+}
@@ -1,20 +1,95 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/golang/protobuf/proto"
 	pb "github.com/jinyunx/p2p/proto"
 	"github.com/jinyunx/p2p/public"
-	"log"
+	"github.com/jinyunx/p2p/server/guard"
+	"github.com/jinyunx/p2p/server/logic"
+	"github.com/jinyunx/p2p/server/metrics"
+	"log/slog"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 )
 
-func udpServer(port string) {
-	public.UdpServer(port, handleData)
+// newUdpServer 创建主端口的 UDP 服务，relay 不为 nil 时同时转发节点之间的中继包
+func newUdpServer(port string, opts public.UdpServerOptions, relay *guard.Limiter, withMetrics bool) *public.UdpServer {
+	handle := newReflector(opts.Logger)
+	if relay != nil {
+		handle = newRelay(opts.Logger, logic.Registry(), relay, handle)
+	}
+	handle = observe(logic.Registry(), handle)
+	if withMetrics {
+		handle = metrics.UdpHandler(handle)
+	}
+	s := public.NewUdpServer(port, handle, opts)
+	if withMetrics {
+		metrics.RegisterUdpServer(s)
+	}
+	return s
+}
+
+// serveProbePorts 在额外的端口上回显地址，客户端从同一个本地端口
+// 依次探测这些端口，推算对称型 NAT 的端口分配规律
+func serveProbePorts(ports []int, logger *slog.Logger, g *guard.Guard, withMetrics bool) {
+	handle := newReflector(logger)
+	if withMetrics {
+		handle = metrics.UdpHandler(handle)
+	}
+	for _, port := range ports {
+		s := public.NewUdpServer(":"+strconv.Itoa(port), handle, public.UdpServerOptions{Workers: 1, Allow: g.AllowUdp, Logger: logger})
+		go serveUdp(s, logger)
+	}
+}
+
+// parsePorts 解析 "50061-50064" 或 "50061,50063" 形式的端口列表
+func parsePorts(spec string) ([]int, error) {
+	var ports []int
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(item, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid port range %q", item)
+			}
+		}
+		if lo <= 0 || hi > 65535 || hi-lo >= 64 {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+		for p := lo; p <= hi; p++ {
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+func serveUdp(s *public.UdpServer, logger *slog.Logger) {
+	if err := s.ListenAndServe(); err != nil {
+		logger.Error("udp server stopped", "err", err)
+		os.Exit(1)
+	}
 }
 
-func handleData(conn *net.UDPConn, buf []byte, addr *net.UDPAddr) {
-	log.Println("Received ", string(buf), " from ", addr)
+// newReflector 把请求的源地址回给客户端，客户端由此得知自己的外网地址
+func newReflector(logger *slog.Logger) public.UdpDataHandler {
+	return func(w public.UdpWriter, buf []byte, addr *net.UDPAddr) {
+		handleData(logger, w, buf, addr)
+	}
+}
 
+func handleData(logger *slog.Logger, w public.UdpWriter, buf []byte, addr *net.UDPAddr) {
 	udpAddr := &pb.UDPAddr{
 		Ip:   addr.IP.String(),
 		Port: int32(addr.Port),
@@ -23,13 +98,53 @@ func handleData(conn *net.UDPConn, buf []byte, addr *net.UDPAddr) {
 
 	marshalAddr, err := proto.Marshal(udpAddr)
 	if err != nil {
-		log.Println(udpAddr, err)
+		logger.Warn("marshal udp addr failed", "peer", addr.String(), "err", err)
 		return
 	}
 
 	// 发送响应
-	_, err = conn.WriteToUDP(marshalAddr, addr)
+	_, err = w.WriteToUDP(marshalAddr, addr)
 	if err != nil {
-		log.Println("Error sending response:", err)
+		logger.Warn("udp reply failed", "peer", addr.String(), "err", err)
+	}
+}
+
+// observe 记下主端口收到的每个包的源地址，节点的保活包从它注册的地址发出，
+// 中继只转发给这样确认过的地址
+func observe(reg *logic.NodesMap, next public.UdpDataHandler) public.UdpDataHandler {
+	return func(w public.UdpWriter, buf []byte, addr *net.UDPAddr) {
+		reg.SawUDP(addr)
+		next(w, buf, addr)
+	}
+}
+
+// relayMagic 是中继包的前缀，见 pb.RelayPacket
+var relayMagic = []byte("P2PR")
+
+// newRelay 把节点发来的中继包原样转给目标节点，其他包交给 next。
+// 中继占用服务器的带宽，limiter 按源 IP 限速，换节点名绕不过去
+func newRelay(logger *slog.Logger, reg *logic.NodesMap, limiter *guard.Limiter, next public.UdpDataHandler) public.UdpDataHandler {
+	return func(w public.UdpWriter, buf []byte, addr *net.UDPAddr) {
+		if !bytes.HasPrefix(buf, relayMagic) {
+			next(w, buf, addr)
+			return
+		}
+		var pkt pb.RelayPacket
+		if err := proto.Unmarshal(buf[len(relayMagic):], &pkt); err != nil {
+			logger.Debug("invalid relay packet", "peer", addr.String(), "err", err)
+			return
+		}
+		to, err := reg.RelayTarget(pkt.GetFrom(), pkt.GetTo(), addr)
+		if err != nil {
+			logger.Debug("relay dropped", "from", pkt.GetFrom(), "to", pkt.GetTo(), "peer", addr.String(), "err", err)
+			return
+		}
+		if !limiter.Allow(addr.IP.String()) {
+			logger.Debug("relay rate limited", "from", pkt.GetFrom(), "to", pkt.GetTo(), "peer", addr.String())
+			return
+		}
+		if _, err := w.WriteToUDP(buf, to); err != nil {
+			logger.Warn("relay failed", "from", pkt.GetFrom(), "to", pkt.GetTo(), "err", err)
+		}
 	}
 }
@@ -0,0 +1,250 @@
+package stun
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public"
+)
+
+// Behavior 是 RFC 5780 定义的映射和过滤行为
+type Behavior int
+
+const (
+	BehaviorUnknown Behavior = iota
+	EndpointIndependent
+	AddressDependent
+	AddressAndPortDependent
+)
+
+func (b Behavior) String() string {
+	switch b {
+	case EndpointIndependent:
+		return "endpoint-independent"
+	case AddressDependent:
+		return "address-dependent"
+	case AddressAndPortDependent:
+		return "address-and-port-dependent"
+	default:
+		return "unknown"
+	}
+}
+
+func (b Behavior) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+type NatBehavior struct {
+	Local     *net.UDPAddr
+	Mapped    *net.UDPAddr // 为 nil 表示 UDP 不通
+	Mapping   Behavior
+	Filtering Behavior
+	// Complete 为 false 表示服务器没有备用 IP，只按端口区分，
+	// 映射和过滤的 address-dependent 与 endpoint-independent 分不出来
+	Complete bool
+}
+
+// MarshalJSON 地址输出成 ip:port 字符串
+func (b *NatBehavior) MarshalJSON() ([]byte, error) {
+	str := func(a *net.UDPAddr) string {
+		if a == nil {
+			return ""
+		}
+		return a.String()
+	}
+	return json.Marshal(struct {
+		Local     string   `json:"local"`
+		Mapped    string   `json:"mapped,omitempty"`
+		Mapping   Behavior `json:"mapping"`
+		Filtering Behavior `json:"filtering"`
+		Complete  bool     `json:"complete"`
+	}{str(b.Local), str(b.Mapped), b.Mapping, b.Filtering, b.Complete})
+}
+
+// NatType 换算成经典的 RFC 3489 NAT 类型，注册到服务器用
+func (b *NatBehavior) NatType() pb.NatType {
+	if b.Mapped == nil {
+		return pb.NatType_NatType_UdpBlocked
+	}
+	if b.Mapped.String() == b.Local.String() {
+		if b.Filtering == EndpointIndependent {
+			return pb.NatType_NatType_Open
+		}
+		return pb.NatType_NatType_SymmetricFirewall
+	}
+	switch b.Mapping {
+	case EndpointIndependent:
+		switch b.Filtering {
+		case EndpointIndependent:
+			return pb.NatType_NatType_FullCone
+		case AddressDependent:
+			return pb.NatType_NatType_Restricted
+		case AddressAndPortDependent:
+			return pb.NatType_NatType_PortRestricted
+		}
+	case AddressDependent, AddressAndPortDependent:
+		return pb.NatType_NatType_Symmetric
+	}
+	return pb.NatType_NatType_Unknown
+}
+
+// otherAddr 返回服务器的备用地址，服务器绑定在 0.0.0.0 上时备用 IP 换成请求用的 IP
+func otherAddr(server *net.UDPAddr, resp *Response) (*net.UDPAddr, error) {
+	if resp.Other == nil {
+		return nil, errors.New("stun server does not support RFC 5780")
+	}
+	other := *resp.Other
+	if other.IP.IsUnspecified() {
+		other.IP = server.IP
+	}
+	return &other, nil
+}
+
+type BehaviorOptions struct {
+	// Conn 为 nil 时在 Network 上使用一个临时端口，Network 为 nil 时用系统的套接字
+	Network public.Network
+	Conn    net.PacketConn
+	Timeout time.Duration // 单次请求超时，默认 2 秒
+	Logger  *slog.Logger
+}
+
+func (o *BehaviorOptions) setDefaults() {
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+// DiscoverBehavior 按 RFC 5780 第 4 节探测本地 NAT 的映射和过滤行为
+func DiscoverBehavior(server string, opts BehaviorOptions) (*NatBehavior, error) {
+	opts.setDefaults()
+	saddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	conn := opts.Conn
+	if conn == nil {
+		c, err := public.ListenUDP(opts.Network, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		conn = c
+	}
+	b := &NatBehavior{Local: localAddr(opts.Network, conn, saddr)}
+	timeout := opts.Timeout
+
+	// Test I
+	r1, err := Binding(conn, saddr, Request{Logger: opts.Logger}, timeout)
+	if errors.Is(err, ErrTimeout) {
+		// UDP 不通
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.Mapped = r1.Mapped
+	other, err := otherAddr(saddr, r1)
+	if err != nil {
+		return b, err
+	}
+	b.Complete = !other.IP.Equal(saddr.IP)
+
+	// 先测过滤，映射测试会往备用地址发包，之后只按地址过滤的 NAT 就会放行备用 IP 的回包
+	if err := discoverFiltering(conn, saddr, b, timeout, opts.Logger); err != nil {
+		return b, err
+	}
+	if err := discoverMapping(conn, saddr, other, b, timeout, opts.Logger); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func discoverMapping(conn net.PacketConn, saddr, other *net.UDPAddr, b *NatBehavior, timeout time.Duration, l *slog.Logger) error {
+	if b.Mapped.String() == b.Local.String() {
+		b.Mapping = EndpointIndependent
+		return nil
+	}
+	if !b.Complete {
+		// 只有备用端口，发到主 IP 备用端口
+		r, err := Binding(conn, &net.UDPAddr{IP: saddr.IP, Port: other.Port}, Request{Logger: l}, timeout)
+		if err != nil {
+			return err
+		}
+		if r.Mapped.String() == b.Mapped.String() {
+			b.Mapping = EndpointIndependent
+		} else {
+			b.Mapping = AddressAndPortDependent
+		}
+		return nil
+	}
+
+	// Test II: 备用 IP 主端口
+	r2, err := Binding(conn, &net.UDPAddr{IP: other.IP, Port: saddr.Port}, Request{Logger: l}, timeout)
+	if err != nil {
+		return err
+	}
+	if r2.Mapped.String() == b.Mapped.String() {
+		b.Mapping = EndpointIndependent
+		return nil
+	}
+	// Test III: 备用 IP 备用端口
+	r3, err := Binding(conn, other, Request{Logger: l}, timeout)
+	if err != nil {
+		return err
+	}
+	if r3.Mapped.String() == r2.Mapped.String() {
+		b.Mapping = AddressDependent
+	} else {
+		b.Mapping = AddressAndPortDependent
+	}
+	return nil
+}
+
+func discoverFiltering(conn net.PacketConn, saddr *net.UDPAddr, b *NatBehavior, timeout time.Duration, l *slog.Logger) error {
+	if b.Complete {
+		// Test II: 要求从备用 IP 备用端口回包
+		_, err := Binding(conn, saddr, Request{ChangeIp: true, ChangePort: true, Logger: l}, timeout)
+		if err == nil {
+			b.Filtering = EndpointIndependent
+			return nil
+		}
+		if !errors.Is(err, ErrTimeout) {
+			return err
+		}
+	}
+	// Test III: 要求从主 IP 备用端口回包
+	_, err := Binding(conn, saddr, Request{ChangePort: true, Logger: l}, timeout)
+	switch {
+	case err == nil && b.Complete:
+		b.Filtering = AddressDependent
+	case err == nil:
+		// 没有备用 IP 时分不清是否只按地址过滤，按更宽松的算
+		b.Filtering = EndpointIndependent
+	case errors.Is(err, ErrTimeout):
+		b.Filtering = AddressAndPortDependent
+	default:
+		return err
+	}
+	return nil
+}
+
+// localAddr 返回发往 server 时实际使用的本地地址，conn 绑定在 0.0.0.0 上时用路由查出来
+func localAddr(network public.Network, conn net.PacketConn, server *net.UDPAddr) *net.UDPAddr {
+	laddr, _ := conn.LocalAddr().(*net.UDPAddr)
+	if laddr == nil || !laddr.IP.IsUnspecified() {
+		return laddr
+	}
+	c, err := public.DialUDP(network, server)
+	if err != nil {
+		return laddr
+	}
+	defer c.Close()
+	return &net.UDPAddr{IP: c.LocalAddr().(*net.UDPAddr).IP, Port: laddr.Port}
+}
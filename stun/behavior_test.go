@@ -0,0 +1,193 @@
+package stun
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/jinyunx/p2p/proto"
+	"github.com/jinyunx/p2p/public/netsim"
+)
+
+func startServer(t *testing.T, altIp string) *Server {
+	// 找两个空闲端口
+	var ports []int
+	for i := 0; i < 2; i++ {
+		c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports = append(ports, c.LocalAddr().(*net.UDPAddr).Port)
+		c.Close()
+	}
+	s, err := NewServer(ServerOptions{
+		Ip:      "127.0.0.1",
+		Port:    ports[0],
+		AltIp:   altIp,
+		AltPort: ports[1],
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Skip("cannot start stun server:", err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestDiscoverBehaviorNoNat(t *testing.T) {
+	for _, altIp := range []string{"", "127.0.0.2"} {
+		s := startServer(t, altIp)
+		b, err := DiscoverBehavior(s.Addr().String(), BehaviorOptions{Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Complete != (altIp != "") {
+			t.Fatalf("alt ip %q: complete = %v", altIp, b.Complete)
+		}
+		if b.Mapping != EndpointIndependent || b.Filtering != EndpointIndependent {
+			t.Fatalf("alt ip %q: mapping %v, filtering %v", altIp, b.Mapping, b.Filtering)
+		}
+		if b.NatType() != pb.NatType_NatType_Open {
+			t.Fatalf("alt ip %q: nat type %v", altIp, b.NatType())
+		}
+	}
+}
+
+func TestNatType(t *testing.T) {
+	local := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1000}
+	mapped := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 2000}
+	cases := []struct {
+		mapping, filtering Behavior
+		want               pb.NatType
+	}{
+		{EndpointIndependent, EndpointIndependent, pb.NatType_NatType_FullCone},
+		{EndpointIndependent, AddressDependent, pb.NatType_NatType_Restricted},
+		{EndpointIndependent, AddressAndPortDependent, pb.NatType_NatType_PortRestricted},
+		{AddressAndPortDependent, AddressAndPortDependent, pb.NatType_NatType_Symmetric},
+	}
+	for _, c := range cases {
+		b := &NatBehavior{Local: local, Mapped: mapped, Mapping: c.mapping, Filtering: c.filtering}
+		if got := b.NatType(); got != c.want {
+			t.Fatalf("%v/%v: got %v, want %v", c.mapping, c.filtering, got, c.want)
+		}
+	}
+	if got := (&NatBehavior{Local: local}).NatType(); got != pb.NatType_NatType_UdpBlocked {
+		t.Fatalf("no response: got %v", got)
+	}
+}
+
+func TestSearchLifetime(t *testing.T) {
+	const actual = 47 * time.Second
+	var tried []time.Duration
+	alive := func(idle time.Duration) (bool, error) {
+		tried = append(tried, idle)
+		return idle < actual, nil
+	}
+	res, err := searchLifetime(5*time.Second, 120*time.Second, 2*time.Second, alive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Alive >= actual || res.Expired < actual || res.Expired-res.Alive > 2*time.Second {
+		t.Fatalf("result = %+v", res)
+	}
+	if res.Trials != len(tried) || res.Trials > 10 {
+		t.Fatalf("trials = %d, tried %v", res.Trials, tried)
+	}
+
+	res, _ = searchLifetime(5*time.Second, 120*time.Second, 2*time.Second, func(time.Duration) (bool, error) { return true, nil })
+	if res.Alive != 120*time.Second || res.Expired != 0 {
+		t.Fatalf("never expiring: %+v", res)
+	}
+}
+
+func TestMeasureLifetimeLoopback(t *testing.T) {
+	s := startServer(t, "")
+	var trials int
+	res, err := MeasureLifetime(s.Addr().String(), LifetimeOptions{
+		Min:     10 * time.Millisecond,
+		Max:     50 * time.Millisecond,
+		Timeout: 500 * time.Millisecond,
+		OnTrial: func(time.Duration, bool) { trials++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 回环上没有 NAT，映射永远不会过期
+	if res.Alive != 50*time.Millisecond || res.Expired != 0 || trials != 2 {
+		t.Fatalf("result = %+v, trials %d", res, trials)
+	}
+}
+
+// startSimServer 在模拟网络的 198.51.100.1 和 198.51.100.2 上启动服务器
+func startSimServer(t *testing.T, n *netsim.Network) *Server {
+	s, err := NewServer(ServerOptions{
+		Ip:      "198.51.100.1",
+		Port:    3478,
+		AltIp:   "198.51.100.2",
+		AltPort: 3479,
+		Network: n.Host("198.51.100.1", "198.51.100.2"),
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMeasureLifetimeSimulated(t *testing.T) {
+	const timeout = 150 * time.Millisecond
+	n := netsim.New(1, netsim.LinkOptions{Latency: time.Millisecond})
+	s := startSimServer(t, n)
+	nat := n.NAT("203.0.113.1", netsim.NatOptions{Type: netsim.PortRestricted, Timeout: timeout})
+	res, err := MeasureLifetime(s.Addr().String(), LifetimeOptions{
+		Min:       50 * time.Millisecond,
+		Max:       400 * time.Millisecond,
+		Precision: 20 * time.Millisecond,
+		Timeout:   50 * time.Millisecond,
+		Network:   nat.Host("10.0.0.2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Alive >= timeout || res.Expired < timeout-20*time.Millisecond || res.Expired-res.Alive > 20*time.Millisecond {
+		t.Fatalf("result = %+v, nat timeout %v", res, timeout)
+	}
+}
+
+func TestDiscoverBehaviorSimulated(t *testing.T) {
+	cases := []struct {
+		typ       netsim.NatType
+		mapping   Behavior
+		filtering Behavior
+		want      pb.NatType
+	}{
+		{netsim.FullCone, EndpointIndependent, EndpointIndependent, pb.NatType_NatType_FullCone},
+		// 映射测试会往备用 IP 发包，过滤测试放在后面时这里会被误判成全锥型
+		{netsim.Restricted, EndpointIndependent, AddressDependent, pb.NatType_NatType_Restricted},
+		{netsim.PortRestricted, EndpointIndependent, AddressAndPortDependent, pb.NatType_NatType_PortRestricted},
+		{netsim.Symmetric, AddressAndPortDependent, AddressAndPortDependent, pb.NatType_NatType_Symmetric},
+	}
+	for _, c := range cases {
+		t.Run(c.typ.String(), func(t *testing.T) {
+			n := netsim.New(1, netsim.LinkOptions{Latency: time.Millisecond})
+			s := startSimServer(t, n)
+			conn, err := n.NAT("203.0.113.1", netsim.NatOptions{Type: c.typ}).Host("10.0.0.2").ListenUDP(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			b, err := DiscoverBehavior(s.Addr().String(), BehaviorOptions{Conn: conn, Timeout: 200 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !b.Complete || b.Mapping != c.mapping || b.Filtering != c.filtering || b.NatType() != c.want {
+				t.Fatalf("got %v/%v (%v), want %v/%v (%v)", b.Mapping, b.Filtering, b.NatType(), c.mapping, c.filtering, c.want)
+			}
+		})
+	}
+}
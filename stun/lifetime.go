@@ -0,0 +1,168 @@
+package stun
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/jinyunx/p2p/public"
+)
+
+type LifetimeOptions struct {
+	Min       time.Duration // 从这个空闲时间开始测，默认 5 秒
+	Max       time.Duration // 测到这个空闲时间为止，默认 10 分钟
+	Precision time.Duration // 二分到上下界相差小于它为止，默认 5 秒
+	Timeout   time.Duration // 单次请求超时，默认 2 秒
+	// OnTrial 每测完一个空闲时间回调一次，可以用来显示进度
+	OnTrial func(idle time.Duration, alive bool)
+	// Network 为 nil 时用系统的套接字
+	Network public.Network
+	Logger  *slog.Logger
+}
+
+func (o *LifetimeOptions) setDefaults() {
+	if o.Min <= 0 {
+		o.Min = 5 * time.Second
+	}
+	if o.Max <= o.Min {
+		o.Max = 10 * time.Minute
+	}
+	if o.Precision <= 0 {
+		o.Precision = 5 * time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+}
+
+type Lifetime struct {
+	Alive   time.Duration `json:"alive"`   // 确认空闲这么久映射还在
+	Expired time.Duration `json:"expired"` // 确认空闲这么久映射已经没了，0 表示直到 Max 都还在
+	Trials  int           `json:"trials"`
+}
+
+// MeasureLifetime 测量 UDP 映射空闲多久会过期。
+// 每次测试用新端口 X 向服务器主端口发请求建立映射，空闲一段时间后
+// 从另一个端口 Y 向服务器备用端口发请求，带 CHANGE-REQUEST(change port)
+// 和 RESPONSE-PORT 让服务器从主端口回包到 X 的映射。Y 的请求不经过 X 的映射，
+// 不会刷新它；响应从 X 访问过的地址发出，端口受限的 NAT 也能放行。
+// 空闲时间用二分查找，总耗时大约是 Max 的几倍。
+func MeasureLifetime(server string, opts LifetimeOptions) (*Lifetime, error) {
+	opts.setDefaults()
+	saddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先确认服务器支持备用端口
+	conn, err := public.ListenUDP(opts.Network, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Binding(conn, saddr, Request{Logger: opts.Logger}, opts.Timeout)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	other, err := otherAddr(saddr, r)
+	if err != nil {
+		return nil, err
+	}
+	alt := &net.UDPAddr{IP: saddr.IP, Port: other.Port}
+
+	trial := func(idle time.Duration) (bool, error) {
+		alive, err := bindingAlive(opts.Network, saddr, alt, idle, opts.Timeout, opts.Logger)
+		if err == nil && opts.OnTrial != nil {
+			opts.OnTrial(idle, alive)
+		}
+		return alive, err
+	}
+	return searchLifetime(opts.Min, opts.Max, opts.Precision, trial)
+}
+
+// searchLifetime 在 [min, max] 上二分查找映射过期的空闲时间
+func searchLifetime(min, max, precision time.Duration, alive func(time.Duration) (bool, error)) (*Lifetime, error) {
+	res := &Lifetime{}
+	check := func(idle time.Duration) (bool, error) {
+		res.Trials++
+		return alive(idle)
+	}
+
+	ok, err := check(min)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		res.Expired = min
+		return res, nil
+	}
+	res.Alive = min
+	if ok, err = check(max); err != nil {
+		return nil, err
+	} else if ok {
+		res.Alive = max
+		return res, nil
+	}
+	res.Expired = max
+
+	for res.Expired-res.Alive > precision {
+		mid := (res.Alive + res.Expired) / 2
+		ok, err := check(mid)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res.Alive = mid
+		} else {
+			res.Expired = mid
+		}
+	}
+	return res, nil
+}
+
+// bindingAlive 建立一个映射，空闲 idle 之后检查它是否还在
+func bindingAlive(network public.Network, primary, alt *net.UDPAddr, idle, timeout time.Duration, l *slog.Logger) (bool, error) {
+	x, err := public.ListenUDP(network, nil)
+	if err != nil {
+		return false, err
+	}
+	defer x.Close()
+	r, err := Binding(x, primary, Request{Logger: l}, timeout)
+	if err != nil {
+		return false, err
+	}
+
+	time.Sleep(idle)
+
+	y, err := public.ListenUDP(network, nil)
+	if err != nil {
+		return false, err
+	}
+	defer y.Close()
+	msg, err := NewBindingRequest(Request{ChangePort: true, ResponsePort: r.Mapped.Port})
+	if err != nil {
+		return false, err
+	}
+	bin, err := msg.Marshal()
+	if err != nil {
+		return false, err
+	}
+	start := time.Now()
+	for _, wait := range []time.Duration{timeout / 2, timeout} {
+		if _, err := y.WriteToUDP(bin, alt); err != nil {
+			return false, err
+		}
+		_, err = AwaitResponse(x, msg.TransactionID, start.Add(wait))
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, ErrTimeout) {
+			return false, err
+		}
+	}
+	return false, nil
+}
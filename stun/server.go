@@ -0,0 +1,186 @@
+package stun
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/jinyunx/p2p/public"
+)
+
+type ServerOptions struct {
+	Ip      string // 主 IP，配置了 AltIp 时必须是具体地址
+	Port    int
+	AltIp   string // 备用 IP，为空时不支持 change IP，只能做部分 RFC 5780 探测
+	AltPort int    // 备用端口，测绑定存活时间要用
+	// Allow 为 nil 时不限制，可以接服务器的限速
+	Allow func(ip net.IP) bool
+	// Network 为 nil 时用系统的套接字
+	Network public.Network
+	Logger  *slog.Logger
+}
+
+// Server 是只支持绑定请求的 STUN 服务器，支持 RFC 5780 的
+// CHANGE-REQUEST、RESPONSE-PORT、RESPONSE-ORIGIN 和 OTHER-ADDRESS
+type Server struct {
+	opts ServerOptions
+	// socks[i][j]，i 为 0 是主 IP、1 是备用 IP，j 为 0 是主端口、1 是备用端口
+	socks [2][2]public.PacketConn
+	wg    sync.WaitGroup
+}
+
+func NewServer(opts ServerOptions) (*Server, error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.AltPort == 0 || opts.AltPort == opts.Port {
+		return nil, errors.New("stun server needs a distinct alternate port")
+	}
+	ips := []string{opts.Ip}
+	if opts.AltIp != "" {
+		if ip := net.ParseIP(opts.Ip); ip == nil || ip.IsUnspecified() {
+			return nil, errors.New("stun server needs an explicit primary ip when the alternate ip is set")
+		}
+		ips = append(ips, opts.AltIp)
+	}
+	s := &Server{opts: opts}
+	for i, ip := range ips {
+		for j, port := range []int{opts.Port, opts.AltPort} {
+			addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(ip, strconv.Itoa(port)))
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			conn, err := public.ListenUDP(opts.Network, addr)
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			s.socks[i][j] = conn
+		}
+	}
+	return s, nil
+}
+
+// Addr 返回主 IP 主端口的监听地址
+func (s *Server) Addr() *net.UDPAddr {
+	return s.socks[0][0].LocalAddr().(*net.UDPAddr)
+}
+
+// AltAddr 返回备用 IP 备用端口的地址，没有备用 IP 时是主 IP 备用端口
+func (s *Server) AltAddr() *net.UDPAddr {
+	if s.socks[1][1] != nil {
+		return s.socks[1][1].LocalAddr().(*net.UDPAddr)
+	}
+	return s.socks[0][1].LocalAddr().(*net.UDPAddr)
+}
+
+func (s *Server) Serve() error {
+	s.opts.Logger.Info("stun server listening", "addr", s.Addr().String(), "alt_addr", s.AltAddr().String())
+	for i := range s.socks {
+		for j := range s.socks[i] {
+			if s.socks[i][j] == nil {
+				continue
+			}
+			s.wg.Add(1)
+			go func(i, j int) {
+				defer s.wg.Done()
+				s.readLoop(i, j)
+			}(i, j)
+		}
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) Close() error {
+	for i := range s.socks {
+		for j := range s.socks[i] {
+			if s.socks[i][j] != nil {
+				s.socks[i][j].Close()
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) readLoop(i, j int) {
+	buf := make([]byte, 1500)
+	conn := s.socks[i][j]
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.opts.Logger.Warn("stun read failed", "err", err)
+			}
+			return
+		}
+		if s.opts.Allow != nil && !s.opts.Allow(addr.IP) {
+			continue
+		}
+		s.handle(i, j, buf[:n], addr)
+	}
+}
+
+func (s *Server) handle(i, j int, data []byte, addr *net.UDPAddr) {
+	var req StunMsg
+	if err := req.UnMarshal(data); err != nil || req.StunMsgType != StunMsgType_BindingRequest {
+		return
+	}
+	l := s.opts.Logger.With("peer_addr", addr.String())
+
+	// 按 CHANGE-REQUEST 选择发送响应的套接字
+	si, sj := i, j
+	if cr, ok := req.GetAttr(AttrType_ChangeRequest).(*ChangeRequest); ok {
+		if cr.IsChangeIp() {
+			if s.socks[1-i][sj] == nil {
+				l.Debug("change ip requested without alternate ip")
+				return
+			}
+			si = 1 - i
+		}
+		if cr.IsChangePort() {
+			sj = 1 - j
+		}
+	}
+	dst := addr
+	if rp, ok := req.GetAttr(AttrType_ResponsePort).(*ResponsePort); ok && rp.Port != 0 {
+		dst = &net.UDPAddr{IP: addr.IP, Port: int(rp.Port)}
+	}
+
+	var xor XorMappedAddress
+	xor.Init()
+	if err := xor.SetAddr(addr); err != nil {
+		return
+	}
+	mapped, _ := NewAddressAttr(AttrType_MappedAddress, addr)
+	attrs := []Attr{&xor, mapped}
+	sock := s.socks[si][sj]
+	if origin, err := NewAddressAttr(AttrType_ResponseOrigin, sock.LocalAddr().(*net.UDPAddr)); err == nil {
+		attrs = append(attrs, origin)
+	}
+	// 备用地址是和收到请求的地址 IP、端口都不同的那个
+	oi := 1 - i
+	if s.socks[oi][0] == nil {
+		oi = i
+	}
+	if other, err := NewAddressAttr(AttrType_OtherAddress, s.socks[oi][1-j].LocalAddr().(*net.UDPAddr)); err == nil {
+		attrs = append(attrs, other)
+	}
+
+	resp, err := InitStunMsg(StunMsgType_BindingSuccessResponse, attrs)
+	if err != nil {
+		return
+	}
+	resp.TransactionID = req.TransactionID
+	bin, err := resp.Marshal()
+	if err != nil {
+		l.Warn("marshal stun response failed", "err", err)
+		return
+	}
+	if _, err := sock.WriteToUDP(bin, dst); err != nil {
+		l.Debug("send stun response failed", "dst", dst.String(), "err", err)
+	}
+}
@@ -1,8 +1,12 @@
 package stun
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"github.com/jinyunx/p2p/client/comm"
-	"log"
+	"log/slog"
+	"net"
 	"time"
 )
 
@@ -60,40 +64,165 @@ import (
                                   +------>Restricted
 */
 
-func BindingRequest(addr string) error {
+// BindingRequest 发一个带 change ip 和 change port 的绑定请求，logger 为 nil 时用 slog.Default()
+func BindingRequest(addr string, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
 	var attrs []Attr
 	var changeRequest ChangeRequest
 	changeRequest.Init(true, true)
 	attrs = append(attrs, &changeRequest)
 	stunMsg, err := InitStunMsg(StunMsgType_BindingRequest, attrs)
 	if err != nil {
-		log.Println(err)
+		logger.Error("init stun message failed", "err", err)
 		return err
 	}
-	log.Println(stunMsg)
+	l := logger.With("server", addr, "txid", hex.EncodeToString(stunMsg.TransactionID[:]))
+	l.Debug("binding request", "msg", stunMsg.String())
 
 	bin, err := stunMsg.Marshal()
 	if err != nil {
-		log.Println(err)
+		l.Error("marshal stun message failed", "err", err)
 		return err
 	}
-	log.Printf("%x\n", bin)
 
 	var resp [1024]byte
-	n, err := comm.UdpWriteAndRead(addr, 12345, time.Second, bin, resp[:])
+	n, err := comm.UdpWriteAndRead(addr, 0, time.Second, bin, resp[:])
 	if err != nil {
-		log.Println(err)
+		l.Warn("binding request failed", "err", err)
 		return err
 	}
-	log.Println(n, resp[:n])
 
 	var respStunMsg StunMsg
 	err = respStunMsg.UnMarshal(resp[:n])
 	if err != nil {
-		log.Println(err)
+		l.Warn("invalid binding response", "len", n, "err", err)
 		return err
 	}
-	log.Println(respStunMsg.String())
-	log.Println(respStunMsg.Attrs)
+	l.Debug("binding response", "msg", respStunMsg.String())
 	return nil
 }
+
+var ErrTimeout = errors.New("stun request timed out")
+
+type Request struct {
+	ChangeIp     bool
+	ChangePort   bool
+	ResponsePort int // 非 0 时服务器把响应发到这个端口
+	// Logger 只给 Binding 记录请求和响应，为 nil 时用 slog.Default()
+	Logger *slog.Logger
+}
+
+type Response struct {
+	Mapped *net.UDPAddr // 服务器看到的请求源地址
+	Origin *net.UDPAddr // 服务器发出响应用的地址
+	Other  *net.UDPAddr // 服务器的备用地址，不支持 RFC 5780 时为 nil
+	From   *net.UDPAddr // 实际收到响应的来源地址
+}
+
+// NewBindingRequest 构造一个绑定请求
+func NewBindingRequest(req Request) (*StunMsg, error) {
+	var attrs []Attr
+	if req.ChangeIp || req.ChangePort {
+		var changeRequest ChangeRequest
+		changeRequest.Init(req.ChangeIp, req.ChangePort)
+		attrs = append(attrs, &changeRequest)
+	}
+	if req.ResponsePort != 0 {
+		var responsePort ResponsePort
+		responsePort.Init(req.ResponsePort)
+		attrs = append(attrs, &responsePort)
+	}
+	return InitStunMsg(StunMsgType_BindingRequest, attrs)
+}
+
+// Binding 用 conn 向 server 发绑定请求并等待响应，超时前会重发一次
+func Binding(conn net.PacketConn, server *net.UDPAddr, req Request, timeout time.Duration) (*Response, error) {
+	msg, err := NewBindingRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	bin, err := msg.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	l := req.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	l = l.With("server", server.String(), "txid", hex.EncodeToString(msg.TransactionID[:]))
+	l.Debug("binding request", "change_ip", req.ChangeIp, "change_port", req.ChangePort, "response_port", req.ResponsePort)
+
+	start := time.Now()
+	for _, wait := range []time.Duration{timeout / 2, timeout} {
+		if _, err := conn.WriteTo(bin, server); err != nil {
+			return nil, err
+		}
+		resp, err := AwaitResponse(conn, msg.TransactionID, start.Add(wait))
+		if err == nil {
+			l.Debug("binding response", "mapped", resp.Mapped, "from", resp.From)
+			return resp, nil
+		}
+		if !errors.Is(err, ErrTimeout) {
+			return nil, err
+		}
+	}
+	return nil, ErrTimeout
+}
+
+// AwaitResponse 在 conn 上等待指定事务的响应，其他包直接丢掉
+func AwaitResponse(conn net.PacketConn, txid [12]byte, deadline time.Time) (*Response, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return nil, ErrTimeout
+			}
+			return nil, err
+		}
+		var msg StunMsg
+		if err := msg.UnMarshal(buf[:n]); err != nil {
+			continue
+		}
+		if msg.StunMsgType != StunMsgType_BindingSuccessResponse || !bytes.Equal(msg.TransactionID[:], txid[:]) {
+			continue
+		}
+		resp := parseResponse(&msg)
+		resp.From, _ = from.(*net.UDPAddr)
+		if resp.Mapped == nil {
+			return nil, errors.New("stun response without mapped address")
+		}
+		return resp, nil
+	}
+}
+
+func parseResponse(msg *StunMsg) *Response {
+	var resp Response
+	for _, a := range msg.Attrs {
+		switch v := a.(type) {
+		case *XorMappedAddress:
+			resp.Mapped = v.GetAddr()
+		case *AddressAttr:
+			switch v.Type {
+			case AttrType_MappedAddress:
+				// XOR-MAPPED-ADDRESS 优先
+				if resp.Mapped == nil {
+					resp.Mapped = v.GetAddr()
+				}
+			case AttrType_ResponseOrigin:
+				resp.Origin = v.GetAddr()
+			case AttrType_OtherAddress, AttrType_ChangedAddress:
+				resp.Other = v.GetAddr()
+			}
+		}
+	}
+	return &resp
+}
@@ -1,11 +1,11 @@
 package stun
 
 import (
-	"log"
+	"log/slog"
+	"os"
 	"testing"
 )
 
 func TestBindingRequest(t *testing.T) {
-	log.SetFlags(log.Lshortfile | log.LstdFlags)
-	BindingRequest("stun.l.google.com:19302")
+	BindingRequest("stun.l.google.com:19302", slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
 }
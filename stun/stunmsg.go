@@ -4,8 +4,8 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"net"
+	"path/filepath"
 	"reflect"
 	"runtime"
 )
@@ -13,10 +13,9 @@ import (
 func FmtErrorF(format string, a ...any) error {
 	_, file, line, ok := runtime.Caller(1) // 获取调用者的文件名和行号
 	if !ok {
-		return FmtErrorF(format, a...)
+		return fmt.Errorf(format, a...)
 	}
-	format = "(%s:%d)" + format
-	return FmtErrorF(format, file, line, a)
+	return fmt.Errorf("(%s:%d)"+format, append([]any{filepath.Base(file), line}, a...)...)
 }
 
 const (
@@ -76,7 +75,6 @@ func InitStunMsg(stunMsgType uint16, attrs []Attr) (*StunMsg, error) {
 	}
 	_, err := rand.Read(s.TransactionID[:])
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
 	for _, v := range s.Attrs {
@@ -99,7 +97,13 @@ func (s *StunMsg) UnMarshal(bin []byte) error {
 	copy(s.TransactionID[:], bin[index:index+len(s.TransactionID)])
 	index += len(s.TransactionID)
 
-	attrs, err := UnMarshalAttrs(bin[index:])
+	if s.MagicCookie != StunMsgMagicCookie {
+		return FmtErrorF("invalid magic cookie:%x", s.MagicCookie)
+	}
+	if int(s.MsgLength) > len(bin)-index {
+		return FmtErrorF("MsgLength > len(bin)-index:%v>%v", s.MsgLength, len(bin)-index)
+	}
+	attrs, err := UnMarshalAttrs(bin[index : index+int(s.MsgLength)])
 	if err != nil {
 		return err
 	}
@@ -174,45 +178,58 @@ func UnMarshalAttrs(bin []byte) ([]Attr, error) {
 
 		t := binary.BigEndian.Uint16(bin[index:])
 		l := binary.BigEndian.Uint16(bin[index+2:])
-		length := int(l + 4)
+		length := int(l) + 4
 
 		if length > len(bin)-index {
 			return nil, FmtErrorF("length > len(bin)-index:%v>%v", length, len(bin)-index)
 		}
 
+		var a Attr
 		switch t {
 		case AttrType_XorMappedAddress:
-			var x XorMappedAddress
-			x.Init()
-			err := x.UnMarshal(bin[index : index+length])
+			if l == 8 {
+				var x XorMappedAddress
+				x.Init()
+				a = &x
+			}
+		case AttrType_MappedAddress, AttrType_ChangedAddress, AttrType_ResponseOrigin, AttrType_OtherAddress:
+			if l == 8 {
+				a = &AddressAttr{Type: t}
+			}
+		case AttrType_ChangeRequest:
+			a = &ChangeRequest{}
+		case AttrType_ResponsePort:
+			a = &ResponsePort{}
+		}
+		// 不认识的属性和 IPv6 地址直接跳过
+		if a != nil {
+			err := a.UnMarshal(bin[index : index+length])
 			if err != nil {
 				return nil, err
 			}
-			attrs = append(attrs, &x)
-			index += length
-		default:
-			return nil, FmtErrorF("unknow type(%v)", t)
+			attrs = append(attrs, a)
+		}
+		// 属性按 4 字节对齐
+		index += 4 + (int(l)+3)&^3
+		if index > len(bin) {
+			index = len(bin)
 		}
 	}
 	return attrs, nil
 }
 
 func MarshalAttrs(attrs []Attr, bin []byte) error {
+	index := 0
 	for _, a := range attrs {
-		switch a.GetType() {
-		case AttrType_XorMappedAddress:
-		case AttrType_ChangeRequest:
-			b, err := a.Marshal()
-			if err != nil {
-				return err
-			}
-			if len(b) > len(bin) {
-				return FmtErrorF("len(b)%v > len(bin)%v", len(b), len(bin))
-			}
-			copy(bin, b)
-		default:
-			return FmtErrorF("unknow type(%v)", a.GetType())
+		b, err := a.Marshal()
+		if err != nil {
+			return err
 		}
+		if len(b) > len(bin)-index {
+			return FmtErrorF("len(b)%v > len(bin)-index%v", len(b), len(bin)-index)
+		}
+		copy(bin[index:], b)
+		index += len(b)
 	}
 	return nil
 }
@@ -276,12 +293,18 @@ func FiledUnMarshal(bin []byte, x interface{}) (err error) {
 		// 例如，你可以检查字段的类型，并根据类型执行不同的操作
 		switch valueField.Kind() {
 		case reflect.Uint16:
+			if index+2 > len(bin) {
+				return FmtErrorF("index+2 > len(bin):%v>%v", index+2, len(bin))
+			}
 			if valueField.CanSet() {
 				tmp := binary.BigEndian.Uint16(bin[index:])
 				index += 2
 				valueField.SetUint(uint64(tmp))
 			}
 		case reflect.Uint32:
+			if index+4 > len(bin) {
+				return FmtErrorF("index+4 > len(bin):%v>%v", index+4, len(bin))
+			}
 			if valueField.CanSet() {
 				tmp := binary.BigEndian.Uint32(bin[index:])
 				index += 4
@@ -308,12 +331,33 @@ func FiledUnMarshal(bin []byte, x interface{}) (err error) {
 const AttrType_XorMappedAddress uint16 = 0x0020
 const AttrType_ChangeRequest uint16 = 0x0003
 
+// RFC 3489 和 RFC 5780 里用到的属性
+const (
+	AttrType_MappedAddress  uint16 = 0x0001
+	AttrType_ChangedAddress uint16 = 0x0005
+	AttrType_ResponsePort   uint16 = 0x0027
+	AttrType_ResponseOrigin uint16 = 0x802b
+	AttrType_OtherAddress   uint16 = 0x802c
+)
+
+const addrFamilyIPv4 uint16 = 0x0001
+
 func GetAttrTypeString(t uint16) string {
 	switch t {
 	case AttrType_XorMappedAddress:
 		return "AttrType_XorMappedAddress"
 	case AttrType_ChangeRequest:
 		return "AttrType_ChangeRequest"
+	case AttrType_MappedAddress:
+		return "AttrType_MappedAddress"
+	case AttrType_ChangedAddress:
+		return "AttrType_ChangedAddress"
+	case AttrType_ResponsePort:
+		return "AttrType_ResponsePort"
+	case AttrType_ResponseOrigin:
+		return "AttrType_ResponseOrigin"
+	case AttrType_OtherAddress:
+		return "AttrType_OtherAddress"
 	default:
 		return "unknown stun message type"
 	}
@@ -348,6 +392,22 @@ func (x *XorMappedAddress) UnMarshal(bin []byte) (err error) {
 	return FiledUnMarshal(bin, x)
 }
 
+// SetAddr 只支持 IPv4
+func (x *XorMappedAddress) SetAddr(addr *net.UDPAddr) error {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return FmtErrorF("not an ipv4 address:%v", addr.IP)
+	}
+	x.Family = addrFamilyIPv4
+	x.XPort = uint16(addr.Port) ^ uint16(StunMsgMagicCookie>>16)
+	x.XAddress = binary.BigEndian.Uint32(ip) ^ StunMsgMagicCookie
+	return nil
+}
+
+func (x *XorMappedAddress) GetAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: x.GetIp(), Port: int(x.GetPort())}
+}
+
 func (x *XorMappedAddress) GetIp() net.IP {
 	originalIP := make(net.IP, 4)
 	binary.BigEndian.PutUint32(originalIP, x.XAddress^StunMsgMagicCookie)
@@ -419,3 +479,112 @@ func (c *ChangeRequest) String() string {
 	str += fmt.Sprintf(",IsChangePort(%v)", c.IsChangePort())
 	return str
 }
+
+/*
+MAPPED-ADDRESS、CHANGED-ADDRESS、RESPONSE-ORIGIN、OTHER-ADDRESS 格式相同，不做异或
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|0 0 0 0 0 0 0 0|    Family     |           Port                |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                 Address (32 bits or 128 bits)                 |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+*/
+
+type AddressAttr struct {
+	Type    uint16
+	Length  uint16
+	Family  uint16
+	Port    uint16
+	Address uint32
+}
+
+func NewAddressAttr(t uint16, addr *net.UDPAddr) (*AddressAttr, error) {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return nil, FmtErrorF("not an ipv4 address:%v", addr.IP)
+	}
+	return &AddressAttr{
+		Type:    t,
+		Length:  8,
+		Family:  addrFamilyIPv4,
+		Port:    uint16(addr.Port),
+		Address: binary.BigEndian.Uint32(ip),
+	}, nil
+}
+
+func (a *AddressAttr) GetType() uint16 {
+	return a.Type
+}
+
+func (a *AddressAttr) GetLength() uint16 {
+	return a.Length
+}
+
+func (a *AddressAttr) Marshal() ([]byte, error) {
+	return FiledMarshal(a)
+}
+
+func (a *AddressAttr) UnMarshal(bin []byte) (err error) {
+	return FiledUnMarshal(bin, a)
+}
+
+func (a *AddressAttr) GetAddr() *net.UDPAddr {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, a.Address)
+	return &net.UDPAddr{IP: ip, Port: int(a.Port)}
+}
+
+func (a *AddressAttr) String() string {
+	var str string
+	str = fmt.Sprintf("attrType(%v)%s", a.Type, GetAttrTypeString(a.Type))
+	str += fmt.Sprintf(",attrLength(%v)", a.Length)
+	str += fmt.Sprintf(",addr(%v)", a.GetAddr())
+	return str
+}
+
+// ResponsePort 让服务器把响应发到请求源 IP 的另一个端口，RFC 5780 7.5
+type ResponsePort struct {
+	Type    uint16
+	Length  uint16
+	Port    uint16
+	Padding uint16
+}
+
+func (r *ResponsePort) Init(port int) {
+	r.Type = AttrType_ResponsePort
+	r.Length = 4
+	r.Port = uint16(port)
+}
+
+func (r *ResponsePort) GetType() uint16 {
+	return r.Type
+}
+
+func (r *ResponsePort) GetLength() uint16 {
+	return r.Length
+}
+
+func (r *ResponsePort) Marshal() ([]byte, error) {
+	return FiledMarshal(r)
+}
+
+func (r *ResponsePort) UnMarshal(bin []byte) (err error) {
+	return FiledUnMarshal(bin, r)
+}
+
+func (r *ResponsePort) String() string {
+	var str string
+	str = fmt.Sprintf("attrType(%v)%s", r.Type, GetAttrTypeString(r.Type))
+	str += fmt.Sprintf(",attrLength(%v)", r.Length)
+	str += fmt.Sprintf(",port(%v)", r.Port)
+	return str
+}
+
+// GetAttr 返回第一个指定类型的属性
+func (s *StunMsg) GetAttr(t uint16) Attr {
+	for _, a := range s.Attrs {
+		if a.GetType() == t {
+			return a
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFmtErrorF(t *testing.T) {
+	err := FmtErrorF("bad value %d", 7)
+	if !strings.Contains(err.Error(), "stunmsg_test.go") || !strings.HasSuffix(err.Error(), "bad value 7") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 40000}
+	var xor XorMappedAddress
+	xor.Init()
+	if err := xor.SetAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewAddressAttr(AttrType_OtherAddress, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 3479})
+	var cr ChangeRequest
+	cr.Init(false, true)
+	var rp ResponsePort
+	rp.Init(5000)
+
+	msg, err := InitStunMsg(StunMsgType_BindingSuccessResponse, []Attr{&xor, other, &cr, &rp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got StunMsg
+	if err := got.UnMarshal(bin); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Attrs) != 4 {
+		t.Fatalf("attrs = %d", len(got.Attrs))
+	}
+	resp := parseResponse(&got)
+	if resp.Mapped.String() != addr.String() || resp.Other.String() != "198.51.100.1:3479" {
+		t.Fatalf("mapped = %v, other = %v", resp.Mapped, resp.Other)
+	}
+	if c := got.GetAttr(AttrType_ChangeRequest).(*ChangeRequest); c.IsChangeIp() || !c.IsChangePort() {
+		t.Fatalf("change request = %v", c)
+	}
+	if p := got.GetAttr(AttrType_ResponsePort).(*ResponsePort); p.Port != 5000 {
+		t.Fatalf("response port = %d", p.Port)
+	}
+}
+
+func TestUnMarshalSkipsUnknownAttrs(t *testing.T) {
+	var xor XorMappedAddress
+	xor.Init()
+	xor.SetAddr(&net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5})
+	x, _ := xor.Marshal()
+
+	// SOFTWARE 属性长度 5，要补齐到 8
+	software := []byte{0x80, 0x22, 0, 5, 'h', 'e', 'l', 'l', 'o', 0, 0, 0}
+	body := append(software, x...)
+	bin := make([]byte, StunMsgHeaderLength, StunMsgHeaderLength+len(body))
+	binary.BigEndian.PutUint16(bin, StunMsgType_BindingSuccessResponse)
+	binary.BigEndian.PutUint16(bin[2:], uint16(len(body)))
+	binary.BigEndian.PutUint32(bin[4:], StunMsgMagicCookie)
+	bin = append(bin, body...)
+
+	var msg StunMsg
+	if err := msg.UnMarshal(bin); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Attrs) != 1 || parseResponse(&msg).Mapped.String() != "1.2.3.4:5" {
+		t.Fatalf("attrs = %v", msg.Attrs)
+	}
+
+	if err := msg.UnMarshal(bin[:len(bin)-4]); err == nil {
+		t.Fatal("truncated message should fail")
+	}
+}